@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/natefinch/lumberjack"
+)
+
+// Access log writer; nil when the access log is disabled
+var (
+	accessLogWriter io.Writer
+	accessLogMu     sync.Mutex
+	accessLogStop   chan struct{} // Stops the rotation timer of the open log
+)
+
+// statusRecorder captures the status code and body size written to the client. It passes
+// Flush and Hijack through so streaming responses and WebSocket upgrades keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. for write deadlines)
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	if s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// initAccessLog opens the rotating access log file and, if configured, rotates it on a timer.
+// On a reload the previous file is closed first.
+func initAccessLog() {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	if closer, ok := accessLogWriter.(io.Closer); ok {
+		closer.Close()
+	}
+	if accessLogStop != nil {
+		close(accessLogStop)
+	}
+	accessLogWriter, accessLogStop = nil, nil
+
+	cfg := currentConfig().Logging.AccessLog
+	if !cfg.Enabled {
+		return
+	}
+	logger := &lumberjack.Logger{
+		Filename:   cfg.FilePath,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+		Compress:   cfg.Compress,
+	}
+	accessLogWriter = logger
+
+	if cfg.Rotation != "" {
+		interval, err := time.ParseDuration(cfg.Rotation)
+		if err != nil || interval <= 0 {
+			log.Printf("Invalid access log rotation %q, rotating by size only", cfg.Rotation)
+		} else {
+			stop := make(chan struct{})
+			accessLogStop = stop
+			go func() {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+					case <-stop:
+						return
+					}
+					accessLogMu.Lock()
+					if err := logger.Rotate(); err != nil {
+						log.Printf("Error rotating access log: %v", err)
+					}
+					accessLogMu.Unlock()
+				}
+			}()
+		}
+	}
+	log.Printf("Access log enabled at %s", cfg.FilePath)
+}
+
+// combinedLogValue renders empty values as "-" like Apache does
+func combinedLogValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// AccessLogMiddleware writes one Apache Combined Log Format line per inbound request
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessLogMu.Lock()
+		enabled := accessLogWriter != nil
+		accessLogMu.Unlock()
+		if !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		// Capture the request line before downstream middleware rewrites the URL
+		requestLine := fmt.Sprintf("%s %s %s", r.Method, redactTokenParam(r.RequestURI), r.Proto)
+		next.ServeHTTP(recorder, r)
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user, _, _ := r.BasicAuth()
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		size := "-"
+		if recorder.bytes > 0 {
+			size = strconv.FormatInt(recorder.bytes, 10)
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q\n",
+			host,
+			combinedLogValue(user),
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			requestLine,
+			status,
+			size,
+			combinedLogValue(r.Referer()),
+			combinedLogValue(r.UserAgent()),
+		)
+
+		accessLogMu.Lock()
+		defer accessLogMu.Unlock()
+		if accessLogWriter == nil {
+			return // Disabled by a reload while the request was served
+		}
+		if _, err := io.WriteString(accessLogWriter, line); err != nil {
+			log.Printf("Error writing access log: %v", err)
+		}
+	})
+}
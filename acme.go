@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager creates the autocert manager that obtains and renews certificates
+// for the configured domains and stores them in the cache directory
+func newACMEManager() *autocert.Manager {
+	cfg := currentConfig()
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.App.TLS.ACME.Domains...),
+		Cache:      autocert.DirCache(cfg.App.TLS.ACME.CacheDir),
+		Email:      cfg.App.TLS.ACME.Email,
+	}
+	if cfg.App.TLS.ACME.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.App.TLS.ACME.DirectoryURL}
+	}
+	return m
+}
+
+// applyACMEToTLSConfig lets the autocert manager supply certificates (and answer
+// TLS-ALPN-01 challenges) while keeping the configured version and cipher settings
+func applyACMEToTLSConfig(m *autocert.Manager, tlsConfig *tls.Config) {
+	acmeConfig := m.TLSConfig()
+	tlsConfig.GetCertificate = acmeConfig.GetCertificate
+	tlsConfig.NextProtos = acmeConfig.NextProtos
+}
+
+// startACMEChallengeServer serves HTTP-01 challenges on the challenge port. Other requests
+// are redirected to HTTPS, or passed to the fallback handler when the plain listener is shared.
+func startACMEChallengeServer(m *autocert.Manager, fallback http.Handler) *http.Server {
+	cfg := currentConfig()
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", cfg.App.Host, cfg.App.TLS.ACME.HTTPPort),
+		Handler: m.HTTPHandler(fallback),
+	}
+	go func() {
+		log.Printf("Starting ACME HTTP-01 challenge listener on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start ACME challenge server: %v", err)
+		}
+	}()
+	return srv
+}
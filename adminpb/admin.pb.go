@@ -0,0 +1,2420 @@
+// The hopper's admin API over gRPC. Every call is served by the REST route it mirrors, so
+// authorization, validation, the audit log and errors behave the same; see docs/grpc.md.
+//
+// Regenerate the Go code with "make proto" after changing this file.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: adminpb/admin.proto
+
+package adminpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	fieldmaskpb "google.golang.org/protobuf/types/known/fieldmaskpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Destination mirrors the JSON destination of the REST API field by field.
+type Destination struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Url            string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	Methods        []string               `protobuf:"bytes,3,rep,name=methods,proto3" json:"methods,omitempty"` // Methods the destination receives; all when empty
+	ExcludeMethods []string               `protobuf:"bytes,4,rep,name=exclude_methods,json=excludeMethods,proto3" json:"exclude_methods,omitempty"`
+	IsActive       *bool                  `protobuf:"varint,5,opt,name=is_active,json=isActive,proto3,oneof" json:"is_active,omitempty"`
+	IsDefault      bool                   `protobuf:"varint,6,opt,name=is_default,json=isDefault,proto3" json:"is_default,omitempty"` // Read-only; see SetDestinationDefault
+	Tls            *DestinationTLS        `protobuf:"bytes,7,opt,name=tls,proto3" json:"tls,omitempty"`
+	Proxy          string                 `protobuf:"bytes,8,opt,name=proxy,proto3" json:"proxy,omitempty"`
+	AllowedClients []string               `protobuf:"bytes,9,rep,name=allowed_clients,json=allowedClients,proto3" json:"allowed_clients,omitempty"`
+	Limits         *DestinationLimits     `protobuf:"bytes,10,opt,name=limits,proto3" json:"limits,omitempty"`
+	Group          string                 `protobuf:"bytes,11,opt,name=group,proto3" json:"group,omitempty"`
+	Tags           []string               `protobuf:"bytes,12,rep,name=tags,proto3" json:"tags,omitempty"`
+	Priority       *int32                 `protobuf:"varint,13,opt,name=priority,proto3,oneof" json:"priority,omitempty"` // Failover order; 0 removes the destination from failover
+	Schedule       *DestinationSchedule   `protobuf:"bytes,14,opt,name=schedule,proto3" json:"schedule,omitempty"`
+	Rules          []*RoutingRule         `protobuf:"bytes,15,rep,name=rules,proto3" json:"rules,omitempty"`
+	RuleMatch      string                 `protobuf:"bytes,16,opt,name=rule_match,json=ruleMatch,proto3" json:"rule_match,omitempty"` // "all" (default) or "any"
+	Bucket         string                 `protobuf:"bytes,17,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Discovery      *DestinationDiscovery  `protobuf:"bytes,18,opt,name=discovery,proto3" json:"discovery,omitempty"`                     // Read-only
+	Archived       bool                   `protobuf:"varint,19,opt,name=archived,proto3" json:"archived,omitempty"`                      // Read-only
+	ArchivedAt     *timestamppb.Timestamp `protobuf:"bytes,20,opt,name=archived_at,json=archivedAt,proto3" json:"archived_at,omitempty"` // Read-only
+	Version        int64                  `protobuf:"varint,21,opt,name=version,proto3" json:"version,omitempty"`                        // Read-only
+	Namespace      string                 `protobuf:"bytes,22,opt,name=namespace,proto3" json:"namespace,omitempty"`                     // The default namespace when empty
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Destination) Reset() {
+	*x = Destination{}
+	mi := &file_adminpb_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Destination) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Destination) ProtoMessage() {}
+
+func (x *Destination) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Destination.ProtoReflect.Descriptor instead.
+func (*Destination) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Destination) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Destination) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Destination) GetMethods() []string {
+	if x != nil {
+		return x.Methods
+	}
+	return nil
+}
+
+func (x *Destination) GetExcludeMethods() []string {
+	if x != nil {
+		return x.ExcludeMethods
+	}
+	return nil
+}
+
+func (x *Destination) GetIsActive() bool {
+	if x != nil && x.IsActive != nil {
+		return *x.IsActive
+	}
+	return false
+}
+
+func (x *Destination) GetIsDefault() bool {
+	if x != nil {
+		return x.IsDefault
+	}
+	return false
+}
+
+func (x *Destination) GetTls() *DestinationTLS {
+	if x != nil {
+		return x.Tls
+	}
+	return nil
+}
+
+func (x *Destination) GetProxy() string {
+	if x != nil {
+		return x.Proxy
+	}
+	return ""
+}
+
+func (x *Destination) GetAllowedClients() []string {
+	if x != nil {
+		return x.AllowedClients
+	}
+	return nil
+}
+
+func (x *Destination) GetLimits() *DestinationLimits {
+	if x != nil {
+		return x.Limits
+	}
+	return nil
+}
+
+func (x *Destination) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Destination) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Destination) GetPriority() int32 {
+	if x != nil && x.Priority != nil {
+		return *x.Priority
+	}
+	return 0
+}
+
+func (x *Destination) GetSchedule() *DestinationSchedule {
+	if x != nil {
+		return x.Schedule
+	}
+	return nil
+}
+
+func (x *Destination) GetRules() []*RoutingRule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+func (x *Destination) GetRuleMatch() string {
+	if x != nil {
+		return x.RuleMatch
+	}
+	return ""
+}
+
+func (x *Destination) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *Destination) GetDiscovery() *DestinationDiscovery {
+	if x != nil {
+		return x.Discovery
+	}
+	return nil
+}
+
+func (x *Destination) GetArchived() bool {
+	if x != nil {
+		return x.Archived
+	}
+	return false
+}
+
+func (x *Destination) GetArchivedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ArchivedAt
+	}
+	return nil
+}
+
+func (x *Destination) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Destination) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type DestinationTLS struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	ClientCertFile     string                 `protobuf:"bytes,1,opt,name=client_cert_file,json=clientCertFile,proto3" json:"client_cert_file,omitempty"`
+	ClientKeyFile      string                 `protobuf:"bytes,2,opt,name=client_key_file,json=clientKeyFile,proto3" json:"client_key_file,omitempty"`
+	CaFile             string                 `protobuf:"bytes,3,opt,name=ca_file,json=caFile,proto3" json:"ca_file,omitempty"`
+	Ca                 string                 `protobuf:"bytes,4,opt,name=ca,proto3" json:"ca,omitempty"`
+	ServerName         string                 `protobuf:"bytes,5,opt,name=server_name,json=serverName,proto3" json:"server_name,omitempty"`
+	InsecureSkipVerify bool                   `protobuf:"varint,6,opt,name=insecure_skip_verify,json=insecureSkipVerify,proto3" json:"insecure_skip_verify,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *DestinationTLS) Reset() {
+	*x = DestinationTLS{}
+	mi := &file_adminpb_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestinationTLS) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestinationTLS) ProtoMessage() {}
+
+func (x *DestinationTLS) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestinationTLS.ProtoReflect.Descriptor instead.
+func (*DestinationTLS) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DestinationTLS) GetClientCertFile() string {
+	if x != nil {
+		return x.ClientCertFile
+	}
+	return ""
+}
+
+func (x *DestinationTLS) GetClientKeyFile() string {
+	if x != nil {
+		return x.ClientKeyFile
+	}
+	return ""
+}
+
+func (x *DestinationTLS) GetCaFile() string {
+	if x != nil {
+		return x.CaFile
+	}
+	return ""
+}
+
+func (x *DestinationTLS) GetCa() string {
+	if x != nil {
+		return x.Ca
+	}
+	return ""
+}
+
+func (x *DestinationTLS) GetServerName() string {
+	if x != nil {
+		return x.ServerName
+	}
+	return ""
+}
+
+func (x *DestinationTLS) GetInsecureSkipVerify() bool {
+	if x != nil {
+		return x.InsecureSkipVerify
+	}
+	return false
+}
+
+type DestinationLimits struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MaxConcurrent int32                  `protobuf:"varint,1,opt,name=max_concurrent,json=maxConcurrent,proto3" json:"max_concurrent,omitempty"`
+	MaxRps        float64                `protobuf:"fixed64,2,opt,name=max_rps,json=maxRps,proto3" json:"max_rps,omitempty"`
+	Overflow      string                 `protobuf:"bytes,3,opt,name=overflow,proto3" json:"overflow,omitempty"` // "skip" (default) or "queue"
+	MaxQueued     int32                  `protobuf:"varint,4,opt,name=max_queued,json=maxQueued,proto3" json:"max_queued,omitempty"`
+	Routes        []*RouteLimit          `protobuf:"bytes,5,rep,name=routes,proto3" json:"routes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DestinationLimits) Reset() {
+	*x = DestinationLimits{}
+	mi := &file_adminpb_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestinationLimits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestinationLimits) ProtoMessage() {}
+
+func (x *DestinationLimits) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestinationLimits.ProtoReflect.Descriptor instead.
+func (*DestinationLimits) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DestinationLimits) GetMaxConcurrent() int32 {
+	if x != nil {
+		return x.MaxConcurrent
+	}
+	return 0
+}
+
+func (x *DestinationLimits) GetMaxRps() float64 {
+	if x != nil {
+		return x.MaxRps
+	}
+	return 0
+}
+
+func (x *DestinationLimits) GetOverflow() string {
+	if x != nil {
+		return x.Overflow
+	}
+	return ""
+}
+
+func (x *DestinationLimits) GetMaxQueued() int32 {
+	if x != nil {
+		return x.MaxQueued
+	}
+	return 0
+}
+
+func (x *DestinationLimits) GetRoutes() []*RouteLimit {
+	if x != nil {
+		return x.Routes
+	}
+	return nil
+}
+
+type RouteLimit struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PathPrefix    string                 `protobuf:"bytes,1,opt,name=path_prefix,json=pathPrefix,proto3" json:"path_prefix,omitempty"`
+	MaxRps        float64                `protobuf:"fixed64,2,opt,name=max_rps,json=maxRps,proto3" json:"max_rps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RouteLimit) Reset() {
+	*x = RouteLimit{}
+	mi := &file_adminpb_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RouteLimit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouteLimit) ProtoMessage() {}
+
+func (x *RouteLimit) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouteLimit.ProtoReflect.Descriptor instead.
+func (*RouteLimit) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RouteLimit) GetPathPrefix() string {
+	if x != nil {
+		return x.PathPrefix
+	}
+	return ""
+}
+
+func (x *RouteLimit) GetMaxRps() float64 {
+	if x != nil {
+		return x.MaxRps
+	}
+	return 0
+}
+
+type DestinationSchedule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Start         *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	Windows       []*ScheduleWindow      `protobuf:"bytes,3,rep,name=windows,proto3" json:"windows,omitempty"`
+	Timezone      string                 `protobuf:"bytes,4,opt,name=timezone,proto3" json:"timezone,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DestinationSchedule) Reset() {
+	*x = DestinationSchedule{}
+	mi := &file_adminpb_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestinationSchedule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestinationSchedule) ProtoMessage() {}
+
+func (x *DestinationSchedule) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestinationSchedule.ProtoReflect.Descriptor instead.
+func (*DestinationSchedule) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DestinationSchedule) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *DestinationSchedule) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *DestinationSchedule) GetWindows() []*ScheduleWindow {
+	if x != nil {
+		return x.Windows
+	}
+	return nil
+}
+
+func (x *DestinationSchedule) GetTimezone() string {
+	if x != nil {
+		return x.Timezone
+	}
+	return ""
+}
+
+type ScheduleWindow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Days          []string               `protobuf:"bytes,1,rep,name=days,proto3" json:"days,omitempty"`
+	From          string                 `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"` // HH:MM, inclusive
+	To            string                 `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`     // HH:MM, exclusive
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleWindow) Reset() {
+	*x = ScheduleWindow{}
+	mi := &file_adminpb_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleWindow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleWindow) ProtoMessage() {}
+
+func (x *ScheduleWindow) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleWindow.ProtoReflect.Descriptor instead.
+func (*ScheduleWindow) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ScheduleWindow) GetDays() []string {
+	if x != nil {
+		return x.Days
+	}
+	return nil
+}
+
+func (x *ScheduleWindow) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *ScheduleWindow) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+type RoutingRule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Header        string                 `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	BodyField     string                 `protobuf:"bytes,2,opt,name=body_field,json=bodyField,proto3" json:"body_field,omitempty"`
+	BodyPath      string                 `protobuf:"bytes,3,opt,name=body_path,json=bodyPath,proto3" json:"body_path,omitempty"`
+	Query         string                 `protobuf:"bytes,4,opt,name=query,proto3" json:"query,omitempty"`
+	Path          bool                   `protobuf:"varint,5,opt,name=path,proto3" json:"path,omitempty"`
+	Strip         bool                   `protobuf:"varint,6,opt,name=strip,proto3" json:"strip,omitempty"`
+	Op            string                 `protobuf:"bytes,7,opt,name=op,proto3" json:"op,omitempty"`
+	Value         string                 `protobuf:"bytes,8,opt,name=value,proto3" json:"value,omitempty"`
+	Values        []string               `protobuf:"bytes,9,rep,name=values,proto3" json:"values,omitempty"`
+	ResponseMode  string                 `protobuf:"bytes,10,opt,name=response_mode,json=responseMode,proto3" json:"response_mode,omitempty"`
+	Quorum        int32                  `protobuf:"varint,11,opt,name=quorum,proto3" json:"quorum,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RoutingRule) Reset() {
+	*x = RoutingRule{}
+	mi := &file_adminpb_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RoutingRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoutingRule) ProtoMessage() {}
+
+func (x *RoutingRule) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoutingRule.ProtoReflect.Descriptor instead.
+func (*RoutingRule) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RoutingRule) GetHeader() string {
+	if x != nil {
+		return x.Header
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetBodyField() string {
+	if x != nil {
+		return x.BodyField
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetBodyPath() string {
+	if x != nil {
+		return x.BodyPath
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetPath() bool {
+	if x != nil {
+		return x.Path
+	}
+	return false
+}
+
+func (x *RoutingRule) GetStrip() bool {
+	if x != nil {
+		return x.Strip
+	}
+	return false
+}
+
+func (x *RoutingRule) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+func (x *RoutingRule) GetResponseMode() string {
+	if x != nil {
+		return x.ResponseMode
+	}
+	return ""
+}
+
+func (x *RoutingRule) GetQuorum() int32 {
+	if x != nil {
+		return x.Quorum
+	}
+	return 0
+}
+
+type DestinationDiscovery struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Provider      string                 `protobuf:"bytes,1,opt,name=provider,proto3" json:"provider,omitempty"`
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DestinationDiscovery) Reset() {
+	*x = DestinationDiscovery{}
+	mi := &file_adminpb_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestinationDiscovery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestinationDiscovery) ProtoMessage() {}
+
+func (x *DestinationDiscovery) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestinationDiscovery.ProtoReflect.Descriptor instead.
+func (*DestinationDiscovery) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DestinationDiscovery) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+func (x *DestinationDiscovery) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type ListDestinationsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Method        string                 `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Group         string                 `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty"`
+	Tag           string                 `protobuf:"bytes,3,opt,name=tag,proto3" json:"tag,omitempty"`
+	Query         string                 `protobuf:"bytes,4,opt,name=query,proto3" json:"query,omitempty"` // Text in the URL, like ?q=
+	IsActive      *bool                  `protobuf:"varint,5,opt,name=is_active,json=isActive,proto3,oneof" json:"is_active,omitempty"`
+	Archived      bool                   `protobuf:"varint,6,opt,name=archived,proto3" json:"archived,omitempty"` // List archived destinations instead
+	Sort          string                 `protobuf:"bytes,7,opt,name=sort,proto3" json:"sort,omitempty"`
+	Page          int32                  `protobuf:"varint,8,opt,name=page,proto3" json:"page,omitempty"` // With page or limit, one page of destinations is returned
+	Limit         int32                  `protobuf:"varint,9,opt,name=limit,proto3" json:"limit,omitempty"`
+	Namespace     string                 `protobuf:"bytes,10,opt,name=namespace,proto3" json:"namespace,omitempty"` // "default" for destinations without a namespace
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDestinationsRequest) Reset() {
+	*x = ListDestinationsRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDestinationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDestinationsRequest) ProtoMessage() {}
+
+func (x *ListDestinationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDestinationsRequest.ProtoReflect.Descriptor instead.
+func (*ListDestinationsRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListDestinationsRequest) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *ListDestinationsRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *ListDestinationsRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ListDestinationsRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *ListDestinationsRequest) GetIsActive() bool {
+	if x != nil && x.IsActive != nil {
+		return *x.IsActive
+	}
+	return false
+}
+
+func (x *ListDestinationsRequest) GetArchived() bool {
+	if x != nil {
+		return x.Archived
+	}
+	return false
+}
+
+func (x *ListDestinationsRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *ListDestinationsRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListDestinationsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListDestinationsRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type ListDestinationsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Destinations  []*Destination         `protobuf:"bytes,1,rep,name=destinations,proto3" json:"destinations,omitempty"`
+	Total         int64                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"` // Matching destinations across all pages
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDestinationsResponse) Reset() {
+	*x = ListDestinationsResponse{}
+	mi := &file_adminpb_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDestinationsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDestinationsResponse) ProtoMessage() {}
+
+func (x *ListDestinationsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDestinationsResponse.ProtoReflect.Descriptor instead.
+func (*ListDestinationsResponse) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListDestinationsResponse) GetDestinations() []*Destination {
+	if x != nil {
+		return x.Destinations
+	}
+	return nil
+}
+
+func (x *ListDestinationsResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type GetDestinationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDestinationRequest) Reset() {
+	*x = GetDestinationRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDestinationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDestinationRequest) ProtoMessage() {}
+
+func (x *GetDestinationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDestinationRequest.ProtoReflect.Descriptor instead.
+func (*GetDestinationRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetDestinationRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type CreateDestinationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Destination   *Destination           `protobuf:"bytes,1,opt,name=destination,proto3" json:"destination,omitempty"`
+	Probe         bool                   `protobuf:"varint,2,opt,name=probe,proto3" json:"probe,omitempty"` // Refuse a destination that can't be reached
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateDestinationRequest) Reset() {
+	*x = CreateDestinationRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateDestinationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateDestinationRequest) ProtoMessage() {}
+
+func (x *CreateDestinationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateDestinationRequest.ProtoReflect.Descriptor instead.
+func (*CreateDestinationRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *CreateDestinationRequest) GetDestination() *Destination {
+	if x != nil {
+		return x.Destination
+	}
+	return nil
+}
+
+func (x *CreateDestinationRequest) GetProbe() bool {
+	if x != nil {
+		return x.Probe
+	}
+	return false
+}
+
+// UpdateDestinationRequest changes the fields named in update_mask, or every populated field
+// of destination without one. A field in the mask that is unset clears it. is_active keeps its
+// current value unless it is set.
+type UpdateDestinationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Destination   *Destination           `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	UpdateMask    *fieldmaskpb.FieldMask `protobuf:"bytes,3,opt,name=update_mask,json=updateMask,proto3" json:"update_mask,omitempty"`
+	Version       *int64                 `protobuf:"varint,4,opt,name=version,proto3,oneof" json:"version,omitempty"` // Version the change is based on; the call fails with ABORTED if it changed since
+	Force         bool                   `protobuf:"varint,5,opt,name=force,proto3" json:"force,omitempty"`           // Skip the version check
+	Probe         bool                   `protobuf:"varint,6,opt,name=probe,proto3" json:"probe,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateDestinationRequest) Reset() {
+	*x = UpdateDestinationRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateDestinationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateDestinationRequest) ProtoMessage() {}
+
+func (x *UpdateDestinationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateDestinationRequest.ProtoReflect.Descriptor instead.
+func (*UpdateDestinationRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *UpdateDestinationRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UpdateDestinationRequest) GetDestination() *Destination {
+	if x != nil {
+		return x.Destination
+	}
+	return nil
+}
+
+func (x *UpdateDestinationRequest) GetUpdateMask() *fieldmaskpb.FieldMask {
+	if x != nil {
+		return x.UpdateMask
+	}
+	return nil
+}
+
+func (x *UpdateDestinationRequest) GetVersion() int64 {
+	if x != nil && x.Version != nil {
+		return *x.Version
+	}
+	return 0
+}
+
+func (x *UpdateDestinationRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *UpdateDestinationRequest) GetProbe() bool {
+	if x != nil {
+		return x.Probe
+	}
+	return false
+}
+
+type DeleteDestinationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Purge         bool                   `protobuf:"varint,2,opt,name=purge,proto3" json:"purge,omitempty"` // Delete for good instead of archiving
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteDestinationRequest) Reset() {
+	*x = DeleteDestinationRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDestinationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDestinationRequest) ProtoMessage() {}
+
+func (x *DeleteDestinationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDestinationRequest.ProtoReflect.Descriptor instead.
+func (*DeleteDestinationRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *DeleteDestinationRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeleteDestinationRequest) GetPurge() bool {
+	if x != nil {
+		return x.Purge
+	}
+	return false
+}
+
+type DeleteDestinationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteDestinationResponse) Reset() {
+	*x = DeleteDestinationResponse{}
+	mi := &file_adminpb_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDestinationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDestinationResponse) ProtoMessage() {}
+
+func (x *DeleteDestinationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDestinationResponse.ProtoReflect.Descriptor instead.
+func (*DeleteDestinationResponse) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *DeleteDestinationResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type RestoreDestinationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreDestinationRequest) Reset() {
+	*x = RestoreDestinationRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreDestinationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreDestinationRequest) ProtoMessage() {}
+
+func (x *RestoreDestinationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreDestinationRequest.ProtoReflect.Descriptor instead.
+func (*RestoreDestinationRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *RestoreDestinationRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type SetDestinationDefaultRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	IsDefault     bool                   `protobuf:"varint,2,opt,name=is_default,json=isDefault,proto3" json:"is_default,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetDestinationDefaultRequest) Reset() {
+	*x = SetDestinationDefaultRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetDestinationDefaultRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetDestinationDefaultRequest) ProtoMessage() {}
+
+func (x *SetDestinationDefaultRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetDestinationDefaultRequest.ProtoReflect.Descriptor instead.
+func (*SetDestinationDefaultRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *SetDestinationDefaultRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *SetDestinationDefaultRequest) GetIsDefault() bool {
+	if x != nil {
+		return x.IsDefault
+	}
+	return false
+}
+
+type GetConnectionStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetConnectionStatsRequest) Reset() {
+	*x = GetConnectionStatsRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetConnectionStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetConnectionStatsRequest) ProtoMessage() {}
+
+func (x *GetConnectionStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetConnectionStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetConnectionStatsRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{17}
+}
+
+type ConnectionStats struct {
+	state         protoimpl.MessageState    `protogen:"open.v1"`
+	Http2         bool                      `protobuf:"varint,1,opt,name=http2,proto3" json:"http2,omitempty"`
+	Destinations  []*DestinationConnections `protobuf:"bytes,2,rep,name=destinations,proto3" json:"destinations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectionStats) Reset() {
+	*x = ConnectionStats{}
+	mi := &file_adminpb_admin_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectionStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectionStats) ProtoMessage() {}
+
+func (x *ConnectionStats) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectionStats.ProtoReflect.Descriptor instead.
+func (*ConnectionStats) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ConnectionStats) GetHttp2() bool {
+	if x != nil {
+		return x.Http2
+	}
+	return false
+}
+
+func (x *ConnectionStats) GetDestinations() []*DestinationConnections {
+	if x != nil {
+		return x.Destinations
+	}
+	return nil
+}
+
+type DestinationConnections struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	DestinationId     string                 `protobuf:"bytes,1,opt,name=destination_id,json=destinationId,proto3" json:"destination_id,omitempty"`
+	Destination       string                 `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	Requests          uint64                 `protobuf:"varint,3,opt,name=requests,proto3" json:"requests,omitempty"`
+	ReusedConnections uint64                 `protobuf:"varint,4,opt,name=reused_connections,json=reusedConnections,proto3" json:"reused_connections,omitempty"`
+	NewConnections    uint64                 `protobuf:"varint,5,opt,name=new_connections,json=newConnections,proto3" json:"new_connections,omitempty"`
+	ReuseRatio        float64                `protobuf:"fixed64,6,opt,name=reuse_ratio,json=reuseRatio,proto3" json:"reuse_ratio,omitempty"`
+	AvgConnectMs      float64                `protobuf:"fixed64,7,opt,name=avg_connect_ms,json=avgConnectMs,proto3" json:"avg_connect_ms,omitempty"`
+	Protocols         map[string]uint64      `protobuf:"bytes,8,rep,name=protocols,proto3" json:"protocols,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *DestinationConnections) Reset() {
+	*x = DestinationConnections{}
+	mi := &file_adminpb_admin_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DestinationConnections) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DestinationConnections) ProtoMessage() {}
+
+func (x *DestinationConnections) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DestinationConnections.ProtoReflect.Descriptor instead.
+func (*DestinationConnections) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *DestinationConnections) GetDestinationId() string {
+	if x != nil {
+		return x.DestinationId
+	}
+	return ""
+}
+
+func (x *DestinationConnections) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *DestinationConnections) GetRequests() uint64 {
+	if x != nil {
+		return x.Requests
+	}
+	return 0
+}
+
+func (x *DestinationConnections) GetReusedConnections() uint64 {
+	if x != nil {
+		return x.ReusedConnections
+	}
+	return 0
+}
+
+func (x *DestinationConnections) GetNewConnections() uint64 {
+	if x != nil {
+		return x.NewConnections
+	}
+	return 0
+}
+
+func (x *DestinationConnections) GetReuseRatio() float64 {
+	if x != nil {
+		return x.ReuseRatio
+	}
+	return 0
+}
+
+func (x *DestinationConnections) GetAvgConnectMs() float64 {
+	if x != nil {
+		return x.AvgConnectMs
+	}
+	return 0
+}
+
+func (x *DestinationConnections) GetProtocols() map[string]uint64 {
+	if x != nil {
+		return x.Protocols
+	}
+	return nil
+}
+
+type GetWorkerPoolStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWorkerPoolStatsRequest) Reset() {
+	*x = GetWorkerPoolStatsRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWorkerPoolStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWorkerPoolStatsRequest) ProtoMessage() {}
+
+func (x *GetWorkerPoolStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWorkerPoolStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetWorkerPoolStatsRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{20}
+}
+
+type WorkerPoolStats struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Workers        int32                  `protobuf:"varint,1,opt,name=workers,proto3" json:"workers,omitempty"`
+	Busy           int64                  `protobuf:"varint,2,opt,name=busy,proto3" json:"busy,omitempty"`
+	Utilization    float64                `protobuf:"fixed64,3,opt,name=utilization,proto3" json:"utilization,omitempty"`
+	Queued         int32                  `protobuf:"varint,4,opt,name=queued,proto3" json:"queued,omitempty"`
+	QueueCapacity  int32                  `protobuf:"varint,5,opt,name=queue_capacity,json=queueCapacity,proto3" json:"queue_capacity,omitempty"`
+	PeakQueued     int64                  `protobuf:"varint,6,opt,name=peak_queued,json=peakQueued,proto3" json:"peak_queued,omitempty"`
+	Submitted      uint64                 `protobuf:"varint,7,opt,name=submitted,proto3" json:"submitted,omitempty"`
+	Completed      uint64                 `protobuf:"varint,8,opt,name=completed,proto3" json:"completed,omitempty"`
+	Rejected       uint64                 `protobuf:"varint,9,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	Direct         uint64                 `protobuf:"varint,10,opt,name=direct,proto3" json:"direct,omitempty"`
+	AvgQueueWaitMs float64                `protobuf:"fixed64,11,opt,name=avg_queue_wait_ms,json=avgQueueWaitMs,proto3" json:"avg_queue_wait_ms,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WorkerPoolStats) Reset() {
+	*x = WorkerPoolStats{}
+	mi := &file_adminpb_admin_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkerPoolStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerPoolStats) ProtoMessage() {}
+
+func (x *WorkerPoolStats) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerPoolStats.ProtoReflect.Descriptor instead.
+func (*WorkerPoolStats) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *WorkerPoolStats) GetWorkers() int32 {
+	if x != nil {
+		return x.Workers
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetBusy() int64 {
+	if x != nil {
+		return x.Busy
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetUtilization() float64 {
+	if x != nil {
+		return x.Utilization
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetQueueCapacity() int32 {
+	if x != nil {
+		return x.QueueCapacity
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetPeakQueued() int64 {
+	if x != nil {
+		return x.PeakQueued
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetSubmitted() uint64 {
+	if x != nil {
+		return x.Submitted
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetCompleted() uint64 {
+	if x != nil {
+		return x.Completed
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetRejected() uint64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetDirect() uint64 {
+	if x != nil {
+		return x.Direct
+	}
+	return 0
+}
+
+func (x *WorkerPoolStats) GetAvgQueueWaitMs() float64 {
+	if x != nil {
+		return x.AvgQueueWaitMs
+	}
+	return 0
+}
+
+type GetDrainStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDrainStatusRequest) Reset() {
+	*x = GetDrainStatusRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDrainStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDrainStatusRequest) ProtoMessage() {}
+
+func (x *GetDrainStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDrainStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetDrainStatusRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{22}
+}
+
+type DrainStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Draining      bool                   `protobuf:"varint,1,opt,name=draining,proto3" json:"draining,omitempty"`
+	Since         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=since,proto3" json:"since,omitempty"`
+	InFlight      int64                  `protobuf:"varint,3,opt,name=in_flight,json=inFlight,proto3" json:"in_flight,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainStatus) Reset() {
+	*x = DrainStatus{}
+	mi := &file_adminpb_admin_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainStatus) ProtoMessage() {}
+
+func (x *DrainStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainStatus.ProtoReflect.Descriptor instead.
+func (*DrainStatus) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *DrainStatus) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *DrainStatus) GetSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+func (x *DrainStatus) GetInFlight() int64 {
+	if x != nil {
+		return x.InFlight
+	}
+	return 0
+}
+
+type GetTrafficStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrafficStatsRequest) Reset() {
+	*x = GetTrafficStatsRequest{}
+	mi := &file_adminpb_admin_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrafficStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrafficStatsRequest) ProtoMessage() {}
+
+func (x *GetTrafficStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrafficStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetTrafficStatsRequest) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{24}
+}
+
+type TrafficStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Clients       []*TrafficClientStats  `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	Dropped       uint64                 `protobuf:"varint,2,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	Kafka         *KafkaSinkStats        `protobuf:"bytes,3,opt,name=kafka,proto3" json:"kafka,omitempty"` // Unset without traffic.kafka
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrafficStats) Reset() {
+	*x = TrafficStats{}
+	mi := &file_adminpb_admin_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrafficStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrafficStats) ProtoMessage() {}
+
+func (x *TrafficStats) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrafficStats.ProtoReflect.Descriptor instead.
+func (*TrafficStats) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *TrafficStats) GetClients() []*TrafficClientStats {
+	if x != nil {
+		return x.Clients
+	}
+	return nil
+}
+
+func (x *TrafficStats) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+func (x *TrafficStats) GetKafka() *KafkaSinkStats {
+	if x != nil {
+		return x.Kafka
+	}
+	return nil
+}
+
+type TrafficClientStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RemoteAddr    string                 `protobuf:"bytes,1,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	ConnectedAt   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=connected_at,json=connectedAt,proto3" json:"connected_at,omitempty"`
+	Filter        *TrafficFilter         `protobuf:"bytes,4,opt,name=filter,proto3" json:"filter,omitempty"`
+	Queued        int32                  `protobuf:"varint,5,opt,name=queued,proto3" json:"queued,omitempty"`
+	Sent          uint64                 `protobuf:"varint,6,opt,name=sent,proto3" json:"sent,omitempty"`
+	Dropped       uint64                 `protobuf:"varint,7,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrafficClientStats) Reset() {
+	*x = TrafficClientStats{}
+	mi := &file_adminpb_admin_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrafficClientStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrafficClientStats) ProtoMessage() {}
+
+func (x *TrafficClientStats) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrafficClientStats.ProtoReflect.Descriptor instead.
+func (*TrafficClientStats) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *TrafficClientStats) GetRemoteAddr() string {
+	if x != nil {
+		return x.RemoteAddr
+	}
+	return ""
+}
+
+func (x *TrafficClientStats) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *TrafficClientStats) GetConnectedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ConnectedAt
+	}
+	return nil
+}
+
+func (x *TrafficClientStats) GetFilter() *TrafficFilter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *TrafficClientStats) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+func (x *TrafficClientStats) GetSent() uint64 {
+	if x != nil {
+		return x.Sent
+	}
+	return 0
+}
+
+func (x *TrafficClientStats) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+type TrafficFilter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DestinationId string                 `protobuf:"bytes,1,opt,name=destination_id,json=destinationId,proto3" json:"destination_id,omitempty"`
+	Methods       []string               `protobuf:"bytes,2,rep,name=methods,proto3" json:"methods,omitempty"`
+	PathPrefix    string                 `protobuf:"bytes,3,opt,name=path_prefix,json=pathPrefix,proto3" json:"path_prefix,omitempty"`
+	StatusClass   string                 `protobuf:"bytes,4,opt,name=status_class,json=statusClass,proto3" json:"status_class,omitempty"`
+	Types         []string               `protobuf:"bytes,5,rep,name=types,proto3" json:"types,omitempty"`
+	Namespace     string                 `protobuf:"bytes,6,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrafficFilter) Reset() {
+	*x = TrafficFilter{}
+	mi := &file_adminpb_admin_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrafficFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrafficFilter) ProtoMessage() {}
+
+func (x *TrafficFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrafficFilter.ProtoReflect.Descriptor instead.
+func (*TrafficFilter) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *TrafficFilter) GetDestinationId() string {
+	if x != nil {
+		return x.DestinationId
+	}
+	return ""
+}
+
+func (x *TrafficFilter) GetMethods() []string {
+	if x != nil {
+		return x.Methods
+	}
+	return nil
+}
+
+func (x *TrafficFilter) GetPathPrefix() string {
+	if x != nil {
+		return x.PathPrefix
+	}
+	return ""
+}
+
+func (x *TrafficFilter) GetStatusClass() string {
+	if x != nil {
+		return x.StatusClass
+	}
+	return ""
+}
+
+func (x *TrafficFilter) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+func (x *TrafficFilter) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type KafkaSinkStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topic         string                 `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
+	Queued        int32                  `protobuf:"varint,2,opt,name=queued,proto3" json:"queued,omitempty"`
+	Published     uint64                 `protobuf:"varint,3,opt,name=published,proto3" json:"published,omitempty"`
+	Dropped       uint64                 `protobuf:"varint,4,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	Failed        uint64                 `protobuf:"varint,5,opt,name=failed,proto3" json:"failed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KafkaSinkStats) Reset() {
+	*x = KafkaSinkStats{}
+	mi := &file_adminpb_admin_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KafkaSinkStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KafkaSinkStats) ProtoMessage() {}
+
+func (x *KafkaSinkStats) ProtoReflect() protoreflect.Message {
+	mi := &file_adminpb_admin_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KafkaSinkStats.ProtoReflect.Descriptor instead.
+func (*KafkaSinkStats) Descriptor() ([]byte, []int) {
+	return file_adminpb_admin_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *KafkaSinkStats) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *KafkaSinkStats) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+func (x *KafkaSinkStats) GetPublished() uint64 {
+	if x != nil {
+		return x.Published
+	}
+	return 0
+}
+
+func (x *KafkaSinkStats) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+func (x *KafkaSinkStats) GetFailed() uint64 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+var File_adminpb_admin_proto protoreflect.FileDescriptor
+
+const file_adminpb_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x13adminpb/admin.proto\x12\x0fhopper.admin.v1\x1a google/protobuf/field_mask.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xca\x06\n" +
+	"\vDestination\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x18\n" +
+	"\amethods\x18\x03 \x03(\tR\amethods\x12'\n" +
+	"\x0fexclude_methods\x18\x04 \x03(\tR\x0eexcludeMethods\x12 \n" +
+	"\tis_active\x18\x05 \x01(\bH\x00R\bisActive\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"is_default\x18\x06 \x01(\bR\tisDefault\x121\n" +
+	"\x03tls\x18\a \x01(\v2\x1f.hopper.admin.v1.DestinationTLSR\x03tls\x12\x14\n" +
+	"\x05proxy\x18\b \x01(\tR\x05proxy\x12'\n" +
+	"\x0fallowed_clients\x18\t \x03(\tR\x0eallowedClients\x12:\n" +
+	"\x06limits\x18\n" +
+	" \x01(\v2\".hopper.admin.v1.DestinationLimitsR\x06limits\x12\x14\n" +
+	"\x05group\x18\v \x01(\tR\x05group\x12\x12\n" +
+	"\x04tags\x18\f \x03(\tR\x04tags\x12\x1f\n" +
+	"\bpriority\x18\r \x01(\x05H\x01R\bpriority\x88\x01\x01\x12@\n" +
+	"\bschedule\x18\x0e \x01(\v2$.hopper.admin.v1.DestinationScheduleR\bschedule\x122\n" +
+	"\x05rules\x18\x0f \x03(\v2\x1c.hopper.admin.v1.RoutingRuleR\x05rules\x12\x1d\n" +
+	"\n" +
+	"rule_match\x18\x10 \x01(\tR\truleMatch\x12\x16\n" +
+	"\x06bucket\x18\x11 \x01(\tR\x06bucket\x12C\n" +
+	"\tdiscovery\x18\x12 \x01(\v2%.hopper.admin.v1.DestinationDiscoveryR\tdiscovery\x12\x1a\n" +
+	"\barchived\x18\x13 \x01(\bR\barchived\x12;\n" +
+	"\varchived_at\x18\x14 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"archivedAt\x12\x18\n" +
+	"\aversion\x18\x15 \x01(\x03R\aversion\x12\x1c\n" +
+	"\tnamespace\x18\x16 \x01(\tR\tnamespaceB\f\n" +
+	"\n" +
+	"_is_activeB\v\n" +
+	"\t_priority\"\xde\x01\n" +
+	"\x0eDestinationTLS\x12(\n" +
+	"\x10client_cert_file\x18\x01 \x01(\tR\x0eclientCertFile\x12&\n" +
+	"\x0fclient_key_file\x18\x02 \x01(\tR\rclientKeyFile\x12\x17\n" +
+	"\aca_file\x18\x03 \x01(\tR\x06caFile\x12\x0e\n" +
+	"\x02ca\x18\x04 \x01(\tR\x02ca\x12\x1f\n" +
+	"\vserver_name\x18\x05 \x01(\tR\n" +
+	"serverName\x120\n" +
+	"\x14insecure_skip_verify\x18\x06 \x01(\bR\x12insecureSkipVerify\"\xc3\x01\n" +
+	"\x11DestinationLimits\x12%\n" +
+	"\x0emax_concurrent\x18\x01 \x01(\x05R\rmaxConcurrent\x12\x17\n" +
+	"\amax_rps\x18\x02 \x01(\x01R\x06maxRps\x12\x1a\n" +
+	"\boverflow\x18\x03 \x01(\tR\boverflow\x12\x1d\n" +
+	"\n" +
+	"max_queued\x18\x04 \x01(\x05R\tmaxQueued\x123\n" +
+	"\x06routes\x18\x05 \x03(\v2\x1b.hopper.admin.v1.RouteLimitR\x06routes\"F\n" +
+	"\n" +
+	"RouteLimit\x12\x1f\n" +
+	"\vpath_prefix\x18\x01 \x01(\tR\n" +
+	"pathPrefix\x12\x17\n" +
+	"\amax_rps\x18\x02 \x01(\x01R\x06maxRps\"\xcc\x01\n" +
+	"\x13DestinationSchedule\x120\n" +
+	"\x05start\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x129\n" +
+	"\awindows\x18\x03 \x03(\v2\x1f.hopper.admin.v1.ScheduleWindowR\awindows\x12\x1a\n" +
+	"\btimezone\x18\x04 \x01(\tR\btimezone\"H\n" +
+	"\x0eScheduleWindow\x12\x12\n" +
+	"\x04days\x18\x01 \x03(\tR\x04days\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\tR\x02to\"\x9c\x02\n" +
+	"\vRoutingRule\x12\x16\n" +
+	"\x06header\x18\x01 \x01(\tR\x06header\x12\x1d\n" +
+	"\n" +
+	"body_field\x18\x02 \x01(\tR\tbodyField\x12\x1b\n" +
+	"\tbody_path\x18\x03 \x01(\tR\bbodyPath\x12\x14\n" +
+	"\x05query\x18\x04 \x01(\tR\x05query\x12\x12\n" +
+	"\x04path\x18\x05 \x01(\bR\x04path\x12\x14\n" +
+	"\x05strip\x18\x06 \x01(\bR\x05strip\x12\x0e\n" +
+	"\x02op\x18\a \x01(\tR\x02op\x12\x14\n" +
+	"\x05value\x18\b \x01(\tR\x05value\x12\x16\n" +
+	"\x06values\x18\t \x03(\tR\x06values\x12#\n" +
+	"\rresponse_mode\x18\n" +
+	" \x01(\tR\fresponseMode\x12\x16\n" +
+	"\x06quorum\x18\v \x01(\x05R\x06quorum\"D\n" +
+	"\x14DestinationDiscovery\x12\x1a\n" +
+	"\bprovider\x18\x01 \x01(\tR\bprovider\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\"\x97\x02\n" +
+	"\x17ListDestinationsRequest\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x14\n" +
+	"\x05group\x18\x02 \x01(\tR\x05group\x12\x10\n" +
+	"\x03tag\x18\x03 \x01(\tR\x03tag\x12\x14\n" +
+	"\x05query\x18\x04 \x01(\tR\x05query\x12 \n" +
+	"\tis_active\x18\x05 \x01(\bH\x00R\bisActive\x88\x01\x01\x12\x1a\n" +
+	"\barchived\x18\x06 \x01(\bR\barchived\x12\x12\n" +
+	"\x04sort\x18\a \x01(\tR\x04sort\x12\x12\n" +
+	"\x04page\x18\b \x01(\x05R\x04page\x12\x14\n" +
+	"\x05limit\x18\t \x01(\x05R\x05limit\x12\x1c\n" +
+	"\tnamespace\x18\n" +
+	" \x01(\tR\tnamespaceB\f\n" +
+	"\n" +
+	"_is_active\"r\n" +
+	"\x18ListDestinationsResponse\x12@\n" +
+	"\fdestinations\x18\x01 \x03(\v2\x1c.hopper.admin.v1.DestinationR\fdestinations\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\"'\n" +
+	"\x15GetDestinationRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"p\n" +
+	"\x18CreateDestinationRequest\x12>\n" +
+	"\vdestination\x18\x01 \x01(\v2\x1c.hopper.admin.v1.DestinationR\vdestination\x12\x14\n" +
+	"\x05probe\x18\x02 \x01(\bR\x05probe\"\xfe\x01\n" +
+	"\x18UpdateDestinationRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12>\n" +
+	"\vdestination\x18\x02 \x01(\v2\x1c.hopper.admin.v1.DestinationR\vdestination\x12;\n" +
+	"\vupdate_mask\x18\x03 \x01(\v2\x1a.google.protobuf.FieldMaskR\n" +
+	"updateMask\x12\x1d\n" +
+	"\aversion\x18\x04 \x01(\x03H\x00R\aversion\x88\x01\x01\x12\x14\n" +
+	"\x05force\x18\x05 \x01(\bR\x05force\x12\x14\n" +
+	"\x05probe\x18\x06 \x01(\bR\x05probeB\n" +
+	"\n" +
+	"\b_version\"@\n" +
+	"\x18DeleteDestinationRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05purge\x18\x02 \x01(\bR\x05purge\"5\n" +
+	"\x19DeleteDestinationResponse\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\"+\n" +
+	"\x19RestoreDestinationRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"M\n" +
+	"\x1cSetDestinationDefaultRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1d\n" +
+	"\n" +
+	"is_default\x18\x02 \x01(\bR\tisDefault\"\x1b\n" +
+	"\x19GetConnectionStatsRequest\"t\n" +
+	"\x0fConnectionStats\x12\x14\n" +
+	"\x05http2\x18\x01 \x01(\bR\x05http2\x12K\n" +
+	"\fdestinations\x18\x02 \x03(\v2'.hopper.admin.v1.DestinationConnectionsR\fdestinations\"\xb0\x03\n" +
+	"\x16DestinationConnections\x12%\n" +
+	"\x0edestination_id\x18\x01 \x01(\tR\rdestinationId\x12 \n" +
+	"\vdestination\x18\x02 \x01(\tR\vdestination\x12\x1a\n" +
+	"\brequests\x18\x03 \x01(\x04R\brequests\x12-\n" +
+	"\x12reused_connections\x18\x04 \x01(\x04R\x11reusedConnections\x12'\n" +
+	"\x0fnew_connections\x18\x05 \x01(\x04R\x0enewConnections\x12\x1f\n" +
+	"\vreuse_ratio\x18\x06 \x01(\x01R\n" +
+	"reuseRatio\x12$\n" +
+	"\x0eavg_connect_ms\x18\a \x01(\x01R\favgConnectMs\x12T\n" +
+	"\tprotocols\x18\b \x03(\v26.hopper.admin.v1.DestinationConnections.ProtocolsEntryR\tprotocols\x1a<\n" +
+	"\x0eProtocolsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x04R\x05value:\x028\x01\"\x1b\n" +
+	"\x19GetWorkerPoolStatsRequest\"\xdc\x02\n" +
+	"\x0fWorkerPoolStats\x12\x18\n" +
+	"\aworkers\x18\x01 \x01(\x05R\aworkers\x12\x12\n" +
+	"\x04busy\x18\x02 \x01(\x03R\x04busy\x12 \n" +
+	"\vutilization\x18\x03 \x01(\x01R\vutilization\x12\x16\n" +
+	"\x06queued\x18\x04 \x01(\x05R\x06queued\x12%\n" +
+	"\x0equeue_capacity\x18\x05 \x01(\x05R\rqueueCapacity\x12\x1f\n" +
+	"\vpeak_queued\x18\x06 \x01(\x03R\n" +
+	"peakQueued\x12\x1c\n" +
+	"\tsubmitted\x18\a \x01(\x04R\tsubmitted\x12\x1c\n" +
+	"\tcompleted\x18\b \x01(\x04R\tcompleted\x12\x1a\n" +
+	"\brejected\x18\t \x01(\x04R\brejected\x12\x16\n" +
+	"\x06direct\x18\n" +
+	" \x01(\x04R\x06direct\x12)\n" +
+	"\x11avg_queue_wait_ms\x18\v \x01(\x01R\x0eavgQueueWaitMs\"\x17\n" +
+	"\x15GetDrainStatusRequest\"x\n" +
+	"\vDrainStatus\x12\x1a\n" +
+	"\bdraining\x18\x01 \x01(\bR\bdraining\x120\n" +
+	"\x05since\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x05since\x12\x1b\n" +
+	"\tin_flight\x18\x03 \x01(\x03R\binFlight\"\x18\n" +
+	"\x16GetTrafficStatsRequest\"\x9e\x01\n" +
+	"\fTrafficStats\x12=\n" +
+	"\aclients\x18\x01 \x03(\v2#.hopper.admin.v1.TrafficClientStatsR\aclients\x12\x18\n" +
+	"\adropped\x18\x02 \x01(\x04R\adropped\x125\n" +
+	"\x05kafka\x18\x03 \x01(\v2\x1f.hopper.admin.v1.KafkaSinkStatsR\x05kafka\"\x88\x02\n" +
+	"\x12TrafficClientStats\x12\x1f\n" +
+	"\vremote_addr\x18\x01 \x01(\tR\n" +
+	"remoteAddr\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\tR\x05token\x12=\n" +
+	"\fconnected_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vconnectedAt\x126\n" +
+	"\x06filter\x18\x04 \x01(\v2\x1e.hopper.admin.v1.TrafficFilterR\x06filter\x12\x16\n" +
+	"\x06queued\x18\x05 \x01(\x05R\x06queued\x12\x12\n" +
+	"\x04sent\x18\x06 \x01(\x04R\x04sent\x12\x18\n" +
+	"\adropped\x18\a \x01(\x04R\adropped\"\xc8\x01\n" +
+	"\rTrafficFilter\x12%\n" +
+	"\x0edestination_id\x18\x01 \x01(\tR\rdestinationId\x12\x18\n" +
+	"\amethods\x18\x02 \x03(\tR\amethods\x12\x1f\n" +
+	"\vpath_prefix\x18\x03 \x01(\tR\n" +
+	"pathPrefix\x12!\n" +
+	"\fstatus_class\x18\x04 \x01(\tR\vstatusClass\x12\x14\n" +
+	"\x05types\x18\x05 \x03(\tR\x05types\x12\x1c\n" +
+	"\tnamespace\x18\x06 \x01(\tR\tnamespace\"\x8e\x01\n" +
+	"\x0eKafkaSinkStats\x12\x14\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\x12\x16\n" +
+	"\x06queued\x18\x02 \x01(\x05R\x06queued\x12\x1c\n" +
+	"\tpublished\x18\x03 \x01(\x04R\tpublished\x12\x18\n" +
+	"\adropped\x18\x04 \x01(\x04R\adropped\x12\x16\n" +
+	"\x06failed\x18\x05 \x01(\x04R\x06failed2\xb7\b\n" +
+	"\vHopperAdmin\x12g\n" +
+	"\x10ListDestinations\x12(.hopper.admin.v1.ListDestinationsRequest\x1a).hopper.admin.v1.ListDestinationsResponse\x12V\n" +
+	"\x0eGetDestination\x12&.hopper.admin.v1.GetDestinationRequest\x1a\x1c.hopper.admin.v1.Destination\x12\\\n" +
+	"\x11CreateDestination\x12).hopper.admin.v1.CreateDestinationRequest\x1a\x1c.hopper.admin.v1.Destination\x12\\\n" +
+	"\x11UpdateDestination\x12).hopper.admin.v1.UpdateDestinationRequest\x1a\x1c.hopper.admin.v1.Destination\x12j\n" +
+	"\x11DeleteDestination\x12).hopper.admin.v1.DeleteDestinationRequest\x1a*.hopper.admin.v1.DeleteDestinationResponse\x12^\n" +
+	"\x12RestoreDestination\x12*.hopper.admin.v1.RestoreDestinationRequest\x1a\x1c.hopper.admin.v1.Destination\x12d\n" +
+	"\x15SetDestinationDefault\x12-.hopper.admin.v1.SetDestinationDefaultRequest\x1a\x1c.hopper.admin.v1.Destination\x12b\n" +
+	"\x12GetConnectionStats\x12*.hopper.admin.v1.GetConnectionStatsRequest\x1a .hopper.admin.v1.ConnectionStats\x12b\n" +
+	"\x12GetWorkerPoolStats\x12*.hopper.admin.v1.GetWorkerPoolStatsRequest\x1a .hopper.admin.v1.WorkerPoolStats\x12V\n" +
+	"\x0eGetDrainStatus\x12&.hopper.admin.v1.GetDrainStatusRequest\x1a\x1c.hopper.admin.v1.DrainStatus\x12Y\n" +
+	"\x0fGetTrafficStats\x12'.hopper.admin.v1.GetTrafficStatsRequest\x1a\x1d.hopper.admin.v1.TrafficStatsB.Z,github.com/your-username/http-hopper/adminpbb\x06proto3"
+
+var (
+	file_adminpb_admin_proto_rawDescOnce sync.Once
+	file_adminpb_admin_proto_rawDescData []byte
+)
+
+func file_adminpb_admin_proto_rawDescGZIP() []byte {
+	file_adminpb_admin_proto_rawDescOnce.Do(func() {
+		file_adminpb_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_adminpb_admin_proto_rawDesc), len(file_adminpb_admin_proto_rawDesc)))
+	})
+	return file_adminpb_admin_proto_rawDescData
+}
+
+var file_adminpb_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 30)
+var file_adminpb_admin_proto_goTypes = []any{
+	(*Destination)(nil),                  // 0: hopper.admin.v1.Destination
+	(*DestinationTLS)(nil),               // 1: hopper.admin.v1.DestinationTLS
+	(*DestinationLimits)(nil),            // 2: hopper.admin.v1.DestinationLimits
+	(*RouteLimit)(nil),                   // 3: hopper.admin.v1.RouteLimit
+	(*DestinationSchedule)(nil),          // 4: hopper.admin.v1.DestinationSchedule
+	(*ScheduleWindow)(nil),               // 5: hopper.admin.v1.ScheduleWindow
+	(*RoutingRule)(nil),                  // 6: hopper.admin.v1.RoutingRule
+	(*DestinationDiscovery)(nil),         // 7: hopper.admin.v1.DestinationDiscovery
+	(*ListDestinationsRequest)(nil),      // 8: hopper.admin.v1.ListDestinationsRequest
+	(*ListDestinationsResponse)(nil),     // 9: hopper.admin.v1.ListDestinationsResponse
+	(*GetDestinationRequest)(nil),        // 10: hopper.admin.v1.GetDestinationRequest
+	(*CreateDestinationRequest)(nil),     // 11: hopper.admin.v1.CreateDestinationRequest
+	(*UpdateDestinationRequest)(nil),     // 12: hopper.admin.v1.UpdateDestinationRequest
+	(*DeleteDestinationRequest)(nil),     // 13: hopper.admin.v1.DeleteDestinationRequest
+	(*DeleteDestinationResponse)(nil),    // 14: hopper.admin.v1.DeleteDestinationResponse
+	(*RestoreDestinationRequest)(nil),    // 15: hopper.admin.v1.RestoreDestinationRequest
+	(*SetDestinationDefaultRequest)(nil), // 16: hopper.admin.v1.SetDestinationDefaultRequest
+	(*GetConnectionStatsRequest)(nil),    // 17: hopper.admin.v1.GetConnectionStatsRequest
+	(*ConnectionStats)(nil),              // 18: hopper.admin.v1.ConnectionStats
+	(*DestinationConnections)(nil),       // 19: hopper.admin.v1.DestinationConnections
+	(*GetWorkerPoolStatsRequest)(nil),    // 20: hopper.admin.v1.GetWorkerPoolStatsRequest
+	(*WorkerPoolStats)(nil),              // 21: hopper.admin.v1.WorkerPoolStats
+	(*GetDrainStatusRequest)(nil),        // 22: hopper.admin.v1.GetDrainStatusRequest
+	(*DrainStatus)(nil),                  // 23: hopper.admin.v1.DrainStatus
+	(*GetTrafficStatsRequest)(nil),       // 24: hopper.admin.v1.GetTrafficStatsRequest
+	(*TrafficStats)(nil),                 // 25: hopper.admin.v1.TrafficStats
+	(*TrafficClientStats)(nil),           // 26: hopper.admin.v1.TrafficClientStats
+	(*TrafficFilter)(nil),                // 27: hopper.admin.v1.TrafficFilter
+	(*KafkaSinkStats)(nil),               // 28: hopper.admin.v1.KafkaSinkStats
+	nil,                                  // 29: hopper.admin.v1.DestinationConnections.ProtocolsEntry
+	(*timestamppb.Timestamp)(nil),        // 30: google.protobuf.Timestamp
+	(*fieldmaskpb.FieldMask)(nil),        // 31: google.protobuf.FieldMask
+}
+var file_adminpb_admin_proto_depIdxs = []int32{
+	1,  // 0: hopper.admin.v1.Destination.tls:type_name -> hopper.admin.v1.DestinationTLS
+	2,  // 1: hopper.admin.v1.Destination.limits:type_name -> hopper.admin.v1.DestinationLimits
+	4,  // 2: hopper.admin.v1.Destination.schedule:type_name -> hopper.admin.v1.DestinationSchedule
+	6,  // 3: hopper.admin.v1.Destination.rules:type_name -> hopper.admin.v1.RoutingRule
+	7,  // 4: hopper.admin.v1.Destination.discovery:type_name -> hopper.admin.v1.DestinationDiscovery
+	30, // 5: hopper.admin.v1.Destination.archived_at:type_name -> google.protobuf.Timestamp
+	3,  // 6: hopper.admin.v1.DestinationLimits.routes:type_name -> hopper.admin.v1.RouteLimit
+	30, // 7: hopper.admin.v1.DestinationSchedule.start:type_name -> google.protobuf.Timestamp
+	30, // 8: hopper.admin.v1.DestinationSchedule.end:type_name -> google.protobuf.Timestamp
+	5,  // 9: hopper.admin.v1.DestinationSchedule.windows:type_name -> hopper.admin.v1.ScheduleWindow
+	0,  // 10: hopper.admin.v1.ListDestinationsResponse.destinations:type_name -> hopper.admin.v1.Destination
+	0,  // 11: hopper.admin.v1.CreateDestinationRequest.destination:type_name -> hopper.admin.v1.Destination
+	0,  // 12: hopper.admin.v1.UpdateDestinationRequest.destination:type_name -> hopper.admin.v1.Destination
+	31, // 13: hopper.admin.v1.UpdateDestinationRequest.update_mask:type_name -> google.protobuf.FieldMask
+	19, // 14: hopper.admin.v1.ConnectionStats.destinations:type_name -> hopper.admin.v1.DestinationConnections
+	29, // 15: hopper.admin.v1.DestinationConnections.protocols:type_name -> hopper.admin.v1.DestinationConnections.ProtocolsEntry
+	30, // 16: hopper.admin.v1.DrainStatus.since:type_name -> google.protobuf.Timestamp
+	26, // 17: hopper.admin.v1.TrafficStats.clients:type_name -> hopper.admin.v1.TrafficClientStats
+	28, // 18: hopper.admin.v1.TrafficStats.kafka:type_name -> hopper.admin.v1.KafkaSinkStats
+	30, // 19: hopper.admin.v1.TrafficClientStats.connected_at:type_name -> google.protobuf.Timestamp
+	27, // 20: hopper.admin.v1.TrafficClientStats.filter:type_name -> hopper.admin.v1.TrafficFilter
+	8,  // 21: hopper.admin.v1.HopperAdmin.ListDestinations:input_type -> hopper.admin.v1.ListDestinationsRequest
+	10, // 22: hopper.admin.v1.HopperAdmin.GetDestination:input_type -> hopper.admin.v1.GetDestinationRequest
+	11, // 23: hopper.admin.v1.HopperAdmin.CreateDestination:input_type -> hopper.admin.v1.CreateDestinationRequest
+	12, // 24: hopper.admin.v1.HopperAdmin.UpdateDestination:input_type -> hopper.admin.v1.UpdateDestinationRequest
+	13, // 25: hopper.admin.v1.HopperAdmin.DeleteDestination:input_type -> hopper.admin.v1.DeleteDestinationRequest
+	15, // 26: hopper.admin.v1.HopperAdmin.RestoreDestination:input_type -> hopper.admin.v1.RestoreDestinationRequest
+	16, // 27: hopper.admin.v1.HopperAdmin.SetDestinationDefault:input_type -> hopper.admin.v1.SetDestinationDefaultRequest
+	17, // 28: hopper.admin.v1.HopperAdmin.GetConnectionStats:input_type -> hopper.admin.v1.GetConnectionStatsRequest
+	20, // 29: hopper.admin.v1.HopperAdmin.GetWorkerPoolStats:input_type -> hopper.admin.v1.GetWorkerPoolStatsRequest
+	22, // 30: hopper.admin.v1.HopperAdmin.GetDrainStatus:input_type -> hopper.admin.v1.GetDrainStatusRequest
+	24, // 31: hopper.admin.v1.HopperAdmin.GetTrafficStats:input_type -> hopper.admin.v1.GetTrafficStatsRequest
+	9,  // 32: hopper.admin.v1.HopperAdmin.ListDestinations:output_type -> hopper.admin.v1.ListDestinationsResponse
+	0,  // 33: hopper.admin.v1.HopperAdmin.GetDestination:output_type -> hopper.admin.v1.Destination
+	0,  // 34: hopper.admin.v1.HopperAdmin.CreateDestination:output_type -> hopper.admin.v1.Destination
+	0,  // 35: hopper.admin.v1.HopperAdmin.UpdateDestination:output_type -> hopper.admin.v1.Destination
+	14, // 36: hopper.admin.v1.HopperAdmin.DeleteDestination:output_type -> hopper.admin.v1.DeleteDestinationResponse
+	0,  // 37: hopper.admin.v1.HopperAdmin.RestoreDestination:output_type -> hopper.admin.v1.Destination
+	0,  // 38: hopper.admin.v1.HopperAdmin.SetDestinationDefault:output_type -> hopper.admin.v1.Destination
+	18, // 39: hopper.admin.v1.HopperAdmin.GetConnectionStats:output_type -> hopper.admin.v1.ConnectionStats
+	21, // 40: hopper.admin.v1.HopperAdmin.GetWorkerPoolStats:output_type -> hopper.admin.v1.WorkerPoolStats
+	23, // 41: hopper.admin.v1.HopperAdmin.GetDrainStatus:output_type -> hopper.admin.v1.DrainStatus
+	25, // 42: hopper.admin.v1.HopperAdmin.GetTrafficStats:output_type -> hopper.admin.v1.TrafficStats
+	32, // [32:43] is the sub-list for method output_type
+	21, // [21:32] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
+}
+
+func init() { file_adminpb_admin_proto_init() }
+func file_adminpb_admin_proto_init() {
+	if File_adminpb_admin_proto != nil {
+		return
+	}
+	file_adminpb_admin_proto_msgTypes[0].OneofWrappers = []any{}
+	file_adminpb_admin_proto_msgTypes[8].OneofWrappers = []any{}
+	file_adminpb_admin_proto_msgTypes[12].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_adminpb_admin_proto_rawDesc), len(file_adminpb_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   30,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_adminpb_admin_proto_goTypes,
+		DependencyIndexes: file_adminpb_admin_proto_depIdxs,
+		MessageInfos:      file_adminpb_admin_proto_msgTypes,
+	}.Build()
+	File_adminpb_admin_proto = out.File
+	file_adminpb_admin_proto_goTypes = nil
+	file_adminpb_admin_proto_depIdxs = nil
+}
@@ -0,0 +1,536 @@
+// The hopper's admin API over gRPC. Every call is served by the REST route it mirrors, so
+// authorization, validation, the audit log and errors behave the same; see docs/grpc.md.
+//
+// Regenerate the Go code with "make proto" after changing this file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: adminpb/admin.proto
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	HopperAdmin_ListDestinations_FullMethodName      = "/hopper.admin.v1.HopperAdmin/ListDestinations"
+	HopperAdmin_GetDestination_FullMethodName        = "/hopper.admin.v1.HopperAdmin/GetDestination"
+	HopperAdmin_CreateDestination_FullMethodName     = "/hopper.admin.v1.HopperAdmin/CreateDestination"
+	HopperAdmin_UpdateDestination_FullMethodName     = "/hopper.admin.v1.HopperAdmin/UpdateDestination"
+	HopperAdmin_DeleteDestination_FullMethodName     = "/hopper.admin.v1.HopperAdmin/DeleteDestination"
+	HopperAdmin_RestoreDestination_FullMethodName    = "/hopper.admin.v1.HopperAdmin/RestoreDestination"
+	HopperAdmin_SetDestinationDefault_FullMethodName = "/hopper.admin.v1.HopperAdmin/SetDestinationDefault"
+	HopperAdmin_GetConnectionStats_FullMethodName    = "/hopper.admin.v1.HopperAdmin/GetConnectionStats"
+	HopperAdmin_GetWorkerPoolStats_FullMethodName    = "/hopper.admin.v1.HopperAdmin/GetWorkerPoolStats"
+	HopperAdmin_GetDrainStatus_FullMethodName        = "/hopper.admin.v1.HopperAdmin/GetDrainStatus"
+	HopperAdmin_GetTrafficStats_FullMethodName       = "/hopper.admin.v1.HopperAdmin/GetTrafficStats"
+)
+
+// HopperAdminClient is the client API for HopperAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// HopperAdmin manages destinations and reads the hopper's statistics. Send the API key as
+// "x-api-key" metadata (or "authorization: Bearer <key>"); GetTrafficStats takes a traffic
+// token as "authorization: Bearer <token>".
+type HopperAdminClient interface {
+	// GET /destinations
+	ListDestinations(ctx context.Context, in *ListDestinationsRequest, opts ...grpc.CallOption) (*ListDestinationsResponse, error)
+	// GET /destinations/{id}
+	GetDestination(ctx context.Context, in *GetDestinationRequest, opts ...grpc.CallOption) (*Destination, error)
+	// POST /destinations
+	CreateDestination(ctx context.Context, in *CreateDestinationRequest, opts ...grpc.CallOption) (*Destination, error)
+	// PUT /destinations/{id}
+	UpdateDestination(ctx context.Context, in *UpdateDestinationRequest, opts ...grpc.CallOption) (*Destination, error)
+	// DELETE /destinations/{id}
+	DeleteDestination(ctx context.Context, in *DeleteDestinationRequest, opts ...grpc.CallOption) (*DeleteDestinationResponse, error)
+	// POST /destinations/{id}/restore
+	RestoreDestination(ctx context.Context, in *RestoreDestinationRequest, opts ...grpc.CallOption) (*Destination, error)
+	// POST or DELETE /destinations/{id}/default
+	SetDestinationDefault(ctx context.Context, in *SetDestinationDefaultRequest, opts ...grpc.CallOption) (*Destination, error)
+	// GET /admin/connections
+	GetConnectionStats(ctx context.Context, in *GetConnectionStatsRequest, opts ...grpc.CallOption) (*ConnectionStats, error)
+	// GET /admin/workers
+	GetWorkerPoolStats(ctx context.Context, in *GetWorkerPoolStatsRequest, opts ...grpc.CallOption) (*WorkerPoolStats, error)
+	// GET /admin/drain
+	GetDrainStatus(ctx context.Context, in *GetDrainStatusRequest, opts ...grpc.CallOption) (*DrainStatus, error)
+	// GET /traffic/stats
+	GetTrafficStats(ctx context.Context, in *GetTrafficStatsRequest, opts ...grpc.CallOption) (*TrafficStats, error)
+}
+
+type hopperAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHopperAdminClient(cc grpc.ClientConnInterface) HopperAdminClient {
+	return &hopperAdminClient{cc}
+}
+
+func (c *hopperAdminClient) ListDestinations(ctx context.Context, in *ListDestinationsRequest, opts ...grpc.CallOption) (*ListDestinationsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDestinationsResponse)
+	err := c.cc.Invoke(ctx, HopperAdmin_ListDestinations_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) GetDestination(ctx context.Context, in *GetDestinationRequest, opts ...grpc.CallOption) (*Destination, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Destination)
+	err := c.cc.Invoke(ctx, HopperAdmin_GetDestination_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) CreateDestination(ctx context.Context, in *CreateDestinationRequest, opts ...grpc.CallOption) (*Destination, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Destination)
+	err := c.cc.Invoke(ctx, HopperAdmin_CreateDestination_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) UpdateDestination(ctx context.Context, in *UpdateDestinationRequest, opts ...grpc.CallOption) (*Destination, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Destination)
+	err := c.cc.Invoke(ctx, HopperAdmin_UpdateDestination_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) DeleteDestination(ctx context.Context, in *DeleteDestinationRequest, opts ...grpc.CallOption) (*DeleteDestinationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteDestinationResponse)
+	err := c.cc.Invoke(ctx, HopperAdmin_DeleteDestination_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) RestoreDestination(ctx context.Context, in *RestoreDestinationRequest, opts ...grpc.CallOption) (*Destination, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Destination)
+	err := c.cc.Invoke(ctx, HopperAdmin_RestoreDestination_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) SetDestinationDefault(ctx context.Context, in *SetDestinationDefaultRequest, opts ...grpc.CallOption) (*Destination, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Destination)
+	err := c.cc.Invoke(ctx, HopperAdmin_SetDestinationDefault_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) GetConnectionStats(ctx context.Context, in *GetConnectionStatsRequest, opts ...grpc.CallOption) (*ConnectionStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConnectionStats)
+	err := c.cc.Invoke(ctx, HopperAdmin_GetConnectionStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) GetWorkerPoolStats(ctx context.Context, in *GetWorkerPoolStatsRequest, opts ...grpc.CallOption) (*WorkerPoolStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WorkerPoolStats)
+	err := c.cc.Invoke(ctx, HopperAdmin_GetWorkerPoolStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) GetDrainStatus(ctx context.Context, in *GetDrainStatusRequest, opts ...grpc.CallOption) (*DrainStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DrainStatus)
+	err := c.cc.Invoke(ctx, HopperAdmin_GetDrainStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hopperAdminClient) GetTrafficStats(ctx context.Context, in *GetTrafficStatsRequest, opts ...grpc.CallOption) (*TrafficStats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrafficStats)
+	err := c.cc.Invoke(ctx, HopperAdmin_GetTrafficStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HopperAdminServer is the server API for HopperAdmin service.
+// All implementations must embed UnimplementedHopperAdminServer
+// for forward compatibility.
+//
+// HopperAdmin manages destinations and reads the hopper's statistics. Send the API key as
+// "x-api-key" metadata (or "authorization: Bearer <key>"); GetTrafficStats takes a traffic
+// token as "authorization: Bearer <token>".
+type HopperAdminServer interface {
+	// GET /destinations
+	ListDestinations(context.Context, *ListDestinationsRequest) (*ListDestinationsResponse, error)
+	// GET /destinations/{id}
+	GetDestination(context.Context, *GetDestinationRequest) (*Destination, error)
+	// POST /destinations
+	CreateDestination(context.Context, *CreateDestinationRequest) (*Destination, error)
+	// PUT /destinations/{id}
+	UpdateDestination(context.Context, *UpdateDestinationRequest) (*Destination, error)
+	// DELETE /destinations/{id}
+	DeleteDestination(context.Context, *DeleteDestinationRequest) (*DeleteDestinationResponse, error)
+	// POST /destinations/{id}/restore
+	RestoreDestination(context.Context, *RestoreDestinationRequest) (*Destination, error)
+	// POST or DELETE /destinations/{id}/default
+	SetDestinationDefault(context.Context, *SetDestinationDefaultRequest) (*Destination, error)
+	// GET /admin/connections
+	GetConnectionStats(context.Context, *GetConnectionStatsRequest) (*ConnectionStats, error)
+	// GET /admin/workers
+	GetWorkerPoolStats(context.Context, *GetWorkerPoolStatsRequest) (*WorkerPoolStats, error)
+	// GET /admin/drain
+	GetDrainStatus(context.Context, *GetDrainStatusRequest) (*DrainStatus, error)
+	// GET /traffic/stats
+	GetTrafficStats(context.Context, *GetTrafficStatsRequest) (*TrafficStats, error)
+	mustEmbedUnimplementedHopperAdminServer()
+}
+
+// UnimplementedHopperAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedHopperAdminServer struct{}
+
+func (UnimplementedHopperAdminServer) ListDestinations(context.Context, *ListDestinationsRequest) (*ListDestinationsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListDestinations not implemented")
+}
+func (UnimplementedHopperAdminServer) GetDestination(context.Context, *GetDestinationRequest) (*Destination, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetDestination not implemented")
+}
+func (UnimplementedHopperAdminServer) CreateDestination(context.Context, *CreateDestinationRequest) (*Destination, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateDestination not implemented")
+}
+func (UnimplementedHopperAdminServer) UpdateDestination(context.Context, *UpdateDestinationRequest) (*Destination, error) {
+	return nil, status.Error(codes.Unimplemented, "method UpdateDestination not implemented")
+}
+func (UnimplementedHopperAdminServer) DeleteDestination(context.Context, *DeleteDestinationRequest) (*DeleteDestinationResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeleteDestination not implemented")
+}
+func (UnimplementedHopperAdminServer) RestoreDestination(context.Context, *RestoreDestinationRequest) (*Destination, error) {
+	return nil, status.Error(codes.Unimplemented, "method RestoreDestination not implemented")
+}
+func (UnimplementedHopperAdminServer) SetDestinationDefault(context.Context, *SetDestinationDefaultRequest) (*Destination, error) {
+	return nil, status.Error(codes.Unimplemented, "method SetDestinationDefault not implemented")
+}
+func (UnimplementedHopperAdminServer) GetConnectionStats(context.Context, *GetConnectionStatsRequest) (*ConnectionStats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetConnectionStats not implemented")
+}
+func (UnimplementedHopperAdminServer) GetWorkerPoolStats(context.Context, *GetWorkerPoolStatsRequest) (*WorkerPoolStats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetWorkerPoolStats not implemented")
+}
+func (UnimplementedHopperAdminServer) GetDrainStatus(context.Context, *GetDrainStatusRequest) (*DrainStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetDrainStatus not implemented")
+}
+func (UnimplementedHopperAdminServer) GetTrafficStats(context.Context, *GetTrafficStatsRequest) (*TrafficStats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTrafficStats not implemented")
+}
+func (UnimplementedHopperAdminServer) mustEmbedUnimplementedHopperAdminServer() {}
+func (UnimplementedHopperAdminServer) testEmbeddedByValue()                     {}
+
+// UnsafeHopperAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HopperAdminServer will
+// result in compilation errors.
+type UnsafeHopperAdminServer interface {
+	mustEmbedUnimplementedHopperAdminServer()
+}
+
+func RegisterHopperAdminServer(s grpc.ServiceRegistrar, srv HopperAdminServer) {
+	// If the following call panics, it indicates UnimplementedHopperAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&HopperAdmin_ServiceDesc, srv)
+}
+
+func _HopperAdmin_ListDestinations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDestinationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).ListDestinations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_ListDestinations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).ListDestinations(ctx, req.(*ListDestinationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_GetDestination_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDestinationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).GetDestination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_GetDestination_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).GetDestination(ctx, req.(*GetDestinationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_CreateDestination_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateDestinationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).CreateDestination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_CreateDestination_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).CreateDestination(ctx, req.(*CreateDestinationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_UpdateDestination_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateDestinationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).UpdateDestination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_UpdateDestination_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).UpdateDestination(ctx, req.(*UpdateDestinationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_DeleteDestination_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDestinationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).DeleteDestination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_DeleteDestination_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).DeleteDestination(ctx, req.(*DeleteDestinationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_RestoreDestination_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreDestinationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).RestoreDestination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_RestoreDestination_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).RestoreDestination(ctx, req.(*RestoreDestinationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_SetDestinationDefault_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetDestinationDefaultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).SetDestinationDefault(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_SetDestinationDefault_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).SetDestinationDefault(ctx, req.(*SetDestinationDefaultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_GetConnectionStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConnectionStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).GetConnectionStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_GetConnectionStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).GetConnectionStats(ctx, req.(*GetConnectionStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_GetWorkerPoolStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWorkerPoolStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).GetWorkerPoolStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_GetWorkerPoolStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).GetWorkerPoolStats(ctx, req.(*GetWorkerPoolStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_GetDrainStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDrainStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).GetDrainStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_GetDrainStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).GetDrainStatus(ctx, req.(*GetDrainStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HopperAdmin_GetTrafficStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTrafficStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HopperAdminServer).GetTrafficStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HopperAdmin_GetTrafficStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HopperAdminServer).GetTrafficStats(ctx, req.(*GetTrafficStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// HopperAdmin_ServiceDesc is the grpc.ServiceDesc for HopperAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HopperAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hopper.admin.v1.HopperAdmin",
+	HandlerType: (*HopperAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDestinations",
+			Handler:    _HopperAdmin_ListDestinations_Handler,
+		},
+		{
+			MethodName: "GetDestination",
+			Handler:    _HopperAdmin_GetDestination_Handler,
+		},
+		{
+			MethodName: "CreateDestination",
+			Handler:    _HopperAdmin_CreateDestination_Handler,
+		},
+		{
+			MethodName: "UpdateDestination",
+			Handler:    _HopperAdmin_UpdateDestination_Handler,
+		},
+		{
+			MethodName: "DeleteDestination",
+			Handler:    _HopperAdmin_DeleteDestination_Handler,
+		},
+		{
+			MethodName: "RestoreDestination",
+			Handler:    _HopperAdmin_RestoreDestination_Handler,
+		},
+		{
+			MethodName: "SetDestinationDefault",
+			Handler:    _HopperAdmin_SetDestinationDefault_Handler,
+		},
+		{
+			MethodName: "GetConnectionStats",
+			Handler:    _HopperAdmin_GetConnectionStats_Handler,
+		},
+		{
+			MethodName: "GetWorkerPoolStats",
+			Handler:    _HopperAdmin_GetWorkerPoolStats_Handler,
+		},
+		{
+			MethodName: "GetDrainStatus",
+			Handler:    _HopperAdmin_GetDrainStatus_Handler,
+		},
+		{
+			MethodName: "GetTrafficStats",
+			Handler:    _HopperAdmin_GetTrafficStats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "adminpb/admin.proto",
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Response modes decide which answer the client gets from a fan-out
+const (
+	ResponseModeDefault   = "default"   // The default destination's response (or a failover's)
+	ResponseModeAggregate = "aggregate" // One JSON document with every destination's response
+	ResponseModeFastest   = "fastest"   // The first successful response of any destination
+	ResponseModeQuorum    = "quorum"    // Success only when enough destinations answered 2xx
+)
+
+// responseStrategy is how a fan-out answers the client
+type responseStrategy struct {
+	mode   string
+	quorum int // 2xx answers required in quorum mode; forwarding.quorum when 0
+}
+
+// requestedResponseMode returns the mode the client picked with forwarding.response_mode_header,
+// or "" when it picked none
+func requestedResponseMode(r *http.Request) string {
+	if header := requestConfig(r.Context()).Forwarding.ResponseModeHeader; header != "" {
+		if mode := r.Header.Get(header); validResponseMode(mode) {
+			return mode
+		}
+	}
+	return ""
+}
+
+// responseStrategyFor returns the strategy for a request to the selected destinations: the mode
+// the client picked, else that of the first matching routing rule with a responseMode, else
+// forwarding.response_mode
+func responseStrategyFor(r *http.Request, destinations []Destination) responseStrategy {
+	if mode := requestedResponseMode(r); mode != "" {
+		return responseStrategy{mode: mode}
+	}
+	var input *routingInput
+	for _, dest := range destinations {
+		for _, rule := range dest.Rules {
+			if rule.ResponseMode == "" {
+				continue
+			}
+			if input == nil {
+				input = newRoutingInput(r)
+			}
+			if rule.matches(input) {
+				return responseStrategy{mode: rule.ResponseMode, quorum: rule.Quorum}
+			}
+		}
+	}
+	return responseStrategy{mode: requestConfig(r.Context()).Forwarding.ResponseMode}
+}
+
+func validResponseMode(mode string) bool {
+	switch mode {
+	case ResponseModeDefault, ResponseModeAggregate, ResponseModeFastest, ResponseModeQuorum:
+		return true
+	}
+	return false
+}
+
+// AggregatedResponse is one destination's answer in an aggregated response
+type AggregatedResponse struct {
+	DestinationID string              `json:"destinationId,omitempty"`
+	Destination   string              `json:"destination"` // Full URL the request was forwarded to
+	IsDefault     bool                `json:"isDefault,omitempty"`
+	Status        int                 `json:"status,omitempty"`
+	LatencyMs     float64             `json:"latencyMs"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Body          json.RawMessage     `json:"body,omitempty"`         // JSON bodies as is, anything else as a string
+	BodyEncoding  string              `json:"bodyEncoding,omitempty"` // "base64" for binary bodies
+	BodyTruncated bool                `json:"bodyTruncated,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	raw           []byte              // The body as received, up to the limit
+}
+
+// AggregatedResponses is the document returned in aggregate mode
+type AggregatedResponses struct {
+	Count     int                  `json:"count"`
+	Succeeded int                  `json:"succeeded"` // Destinations that answered below 500
+	Responses []AggregatedResponse `json:"responses"` // In the order of the destinations
+}
+
+// newAggregatedResponse describes a destination that could not be reached
+func newAggregatedResponse(destination Destination, forwardURL string, isDefault bool, latency time.Duration, err error) AggregatedResponse {
+	entry := AggregatedResponse{Destination: forwardURL, IsDefault: isDefault, LatencyMs: millis(latency)}
+	if forwardURL == "" {
+		entry.Destination = destination.URL
+	}
+	if !destination.ID.IsZero() {
+		entry.DestinationID = destination.ID.Hex()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// readBody records a destination's response, reading up to forwarding.max_buffered_body_bytes
+// of its body; the body is copied to sink when capturing and closed
+func (entry *AggregatedResponse) readBody(resp *http.Response, sink io.Writer) {
+	defer resp.Body.Close()
+	limit := currentConfig().Forwarding.MaxBufferedBodyBytes
+	var body io.Reader = resp.Body
+	if sink != nil {
+		body = io.TeeReader(body, sink)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		entry.Error = fmt.Sprintf("error reading response body: %v", err)
+	}
+	io.Copy(ioutil.Discard, body) // Let the capture see the rest and the connection be reused
+	entry.Status = resp.StatusCode
+	entry.Headers = resp.Header
+	if int64(len(data)) > limit {
+		data, entry.BodyTruncated = data[:limit], true
+	}
+	entry.raw = data
+	if !entry.BodyTruncated {
+		data = readableBody(resp.Header, data) // Compressed bodies are embedded decoded
+	}
+	if len(data) == 0 {
+		return
+	}
+	switch {
+	case !entry.BodyTruncated && json.Valid(data):
+		entry.Body = json.RawMessage(data)
+	case utf8.Valid(data):
+		entry.Body, _ = json.Marshal(string(data))
+	default:
+		text, encoding := harBody(data)
+		entry.Body, _ = json.Marshal(text)
+		entry.BodyEncoding = encoding
+	}
+}
+
+// aggregateResponse turns the collected answers into the client's response: 200 when any
+// destination answered below 500, otherwise 502
+func aggregateResponse(entries []AggregatedResponse) (*http.Response, error) {
+	doc := AggregatedResponses{Count: len(entries), Responses: entries}
+	for _, entry := range entries {
+		if entry.Error == "" && entry.Status > 0 && entry.Status < http.StatusInternalServerError {
+			doc.Succeeded++
+		}
+	}
+	status := http.StatusOK
+	if doc.Succeeded == 0 {
+		status = http.StatusBadGateway
+	}
+	return aggregatedDocument(doc, status, ResponseModeAggregate)
+}
+
+// aggregatedDocument returns the document as a response with the given status
+func aggregatedDocument(doc AggregatedResponses, status int, mode string) (*http.Response, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding aggregated response: %v", err)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":           {"application/json"},
+			"Content-Length":         {strconv.Itoa(len(data))},
+			"X-Hopper-Response-Mode": {mode},
+		},
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}, nil
+}
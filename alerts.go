@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Alert types; see docs/alerts.md
+const (
+	AlertDestinationFailing   = "destination.failing"   // A destination failed alerts.failure_threshold times in a row
+	AlertDestinationRecovered = "destination.recovered" // A failing destination answered again
+	AlertCircuitOpened        = "circuit.opened"        // A destination's circuit opened (forwarding.circuit_breaker)
+	AlertCircuitClosed        = "circuit.closed"        // A destination's circuit closed again
+	AlertHealthChanged        = "health.changed"        // The hopper became ready or unavailable (see /readyz)
+)
+
+var alertTypes = []string{AlertDestinationFailing, AlertDestinationRecovered, AlertCircuitOpened, AlertCircuitClosed, AlertHealthChanged}
+
+// AlertsConfig sends notifications when destinations fail, circuits open or close, or the
+// hopper's readiness changes
+type AlertsConfig struct {
+	FailureThreshold    int               `yaml:"failure_threshold"`     // Failures in a row (errors or 5xx) that make a destination failing; defaults to 5
+	HealthCheckInterval string            `yaml:"health_check_interval"` // How often readiness is checked for health.changed; defaults to 30s
+	Retry               AlertRetryConfig  `yaml:"retry"`
+	StatsURL            string            `yaml:"stats_url"` // Template of the link in chat messages, e.g. to a dashboard; see docs/alerts.md
+	Webhooks            []WebhookConfig   `yaml:"webhooks"`
+	Slack               []ChatConfig      `yaml:"slack"`
+	Teams               []ChatConfig      `yaml:"teams"`
+	Email               EmailAlertsConfig `yaml:"email"`
+	healthCheckInterval time.Duration
+}
+
+// AlertRetryConfig controls how often a failed notification is sent again
+type AlertRetryConfig struct {
+	MaxAttempts    int    `yaml:"max_attempts"`    // Defaults to 5
+	InitialBackoff string `yaml:"initial_backoff"` // Doubled after every attempt; defaults to 1s
+	MaxBackoff     string `yaml:"max_backoff"`     // Defaults to 1m
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// WebhookConfig is an endpoint that receives alerts as JSON POST requests
+type WebhookConfig struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Events  []string          `yaml:"events"`  // Alert types sent to the webhook; all when empty
+	Headers map[string]string `yaml:"headers"` // e.g. an Authorization header the receiver expects
+	Secret  string            `yaml:"secret"`  // Signs the body with HMAC-SHA256 in X-Hopper-Signature
+	Timeout string            `yaml:"timeout"` // For one attempt; defaults to 10s
+	timeout time.Duration
+}
+
+// validateAlerts fills in the defaults of the alerts section and checks it
+func validateAlerts(cfg *AlertsConfig) error {
+	if cfg.FailureThreshold < 0 {
+		return fmt.Errorf("failure_threshold %d must not be negative", cfg.FailureThreshold)
+	}
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = 5
+	}
+	var err error
+	if cfg.healthCheckInterval, err = parseAlertDuration("health_check_interval", &cfg.HealthCheckInterval, "30s"); err != nil {
+		return err
+	}
+	if cfg.Retry.initialBackoff, err = parseAlertDuration("retry.initial_backoff", &cfg.Retry.InitialBackoff, "1s"); err != nil {
+		return err
+	}
+	if cfg.Retry.maxBackoff, err = parseAlertDuration("retry.max_backoff", &cfg.Retry.MaxBackoff, "1m"); err != nil {
+		return err
+	}
+	if cfg.Retry.MaxAttempts < 0 {
+		return fmt.Errorf("retry.max_attempts %d must not be negative", cfg.Retry.MaxAttempts)
+	}
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry.MaxAttempts = 5
+	}
+
+	for i := range cfg.Webhooks {
+		hook := &cfg.Webhooks[i]
+		if hook.timeout, err = validateAlertReceiver("webhook", i, &hook.Name, hook.URL, hook.Events, &hook.Timeout); err != nil {
+			return err
+		}
+	}
+	if _, err := parseAlertTemplate("stats_url", cfg.StatsURL); err != nil {
+		return fmt.Errorf("invalid stats_url: %v", err)
+	}
+	for _, receivers := range []struct {
+		kind string
+		list []ChatConfig
+	}{{"slack", cfg.Slack}, {"teams", cfg.Teams}} {
+		kind := receivers.kind
+		for i := range receivers.list {
+			chat := &receivers.list[i]
+			if chat.timeout, err = validateAlertReceiver(kind, i, &chat.Name, chat.WebhookURL, chat.Events, &chat.Timeout); err != nil {
+				return err
+			}
+			if _, err := parseAlertTemplate(chat.Name, chat.Template); err != nil {
+				return fmt.Errorf("%s %q has an invalid template: %v", kind, chat.Name, err)
+			}
+		}
+	}
+	if err := validateEmailAlerts(&cfg.Email); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateAlertReceiver checks the settings every kind of receiver has and returns its timeout.
+// Unnamed receivers are named after their kind and position, e.g. slack-2.
+func validateAlertReceiver(kind string, index int, name *string, target string, events []string, timeout *string) (time.Duration, error) {
+	if *name == "" {
+		*name = fmt.Sprintf("%s-%d", kind, index+1)
+	}
+	if u, err := url.Parse(target); !isSecretRef(target) && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		return 0, fmt.Errorf("%s %q needs an http or https url", kind, *name)
+	}
+	for _, event := range events {
+		if !contains(alertTypes, event) {
+			return 0, fmt.Errorf("%s %q has unknown event %q", kind, *name, event)
+		}
+	}
+	return parseAlertDuration(fmt.Sprintf("timeout of %s %q", kind, *name), timeout, "10s")
+}
+
+// parseAlertDuration parses a positive duration, setting it to def when empty
+func parseAlertDuration(name string, value *string, def string) (time.Duration, error) {
+	if *value == "" {
+		*value = def
+	}
+	d, err := time.ParseDuration(*value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, *value)
+	}
+	return d, nil
+}
+
+// AlertEvent is the JSON document sent to webhooks, and what chat message templates render
+type AlertEvent struct {
+	ID                  string            `json:"id"`
+	Type                string            `json:"type"`
+	Timestamp           time.Time         `json:"timestamp"`
+	Hopper              string            `json:"hopper"` // Host name of the hopper that raised the alert
+	Message             string            `json:"message"`
+	Destination         *AlertDestination `json:"destination,omitempty"`
+	ConsecutiveFailures int               `json:"consecutiveFailures,omitempty"`
+	ErrorRate           float64           `json:"errorRate"`        // Share of failures among the destination's last 100 requests
+	Error               string            `json:"error,omitempty"`  // The last error, e.g. "status 503"
+	Health              *HealthStatus     `json:"health,omitempty"` // Readiness checks, for health.changed
+}
+
+// AlertDestination identifies the destination an alert is about
+type AlertDestination struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"` // Host of the URL, how chat messages name the destination
+	URL       string `json:"url"`
+	Namespace string `json:"namespace,omitempty"`
+	Group     string `json:"group,omitempty"`
+}
+
+func alertDestinationOf(d Destination) *AlertDestination {
+	alert := &AlertDestination{Name: d.URL, URL: d.URL, Namespace: d.Namespace, Group: d.Group}
+	if u, err := url.Parse(d.URL); err == nil && u.Host != "" {
+		alert.Name = u.Host
+	}
+	if !d.ID.IsZero() {
+		alert.ID = d.ID.Hex()
+	}
+	return alert
+}
+
+// alertNotifier delivers alerts to one receiver
+type alertNotifier interface {
+	name() string
+	accepts(alertType string) bool
+	send(ctx context.Context, alert AlertEvent) error
+}
+
+// permanentAlertError is a failure that sending again won't fix, e.g. a 400 from the receiver
+type permanentAlertError struct {
+	error
+}
+
+// alertNotifiers returns the receivers in the current configuration
+func alertNotifiers() []alertNotifier {
+	cfg := currentConfig().Alerts
+	var notifiers []alertNotifier
+	for _, hook := range cfg.Webhooks {
+		notifiers = append(notifiers, webhookNotifier{hook})
+	}
+	for _, chat := range cfg.Slack {
+		notifiers = append(notifiers, chatNotifier{kind: "slack", cfg: chat, payload: slackPayload})
+	}
+	for _, chat := range cfg.Teams {
+		notifiers = append(notifiers, chatNotifier{kind: "teams", cfg: chat, payload: teamsPayload})
+	}
+	return notifiers
+}
+
+// alertsHaveReceivers reports whether alerts are sent anywhere besides the log
+func alertsHaveReceivers() bool {
+	return len(alertNotifiers()) > 0 || currentConfig().Alerts.Email.enabled()
+}
+
+// alertQueueSize bounds the alerts waiting to be handed to the notifiers
+const alertQueueSize = 1000
+
+var alertQueue = make(chan AlertEvent, alertQueueSize)
+
+// hopperName identifies this hopper in alerts
+var hopperName, _ = os.Hostname()
+
+// raiseAlert logs an alert and queues it for the notifiers without blocking
+func raiseAlert(alert AlertEvent) {
+	alert.ID = primitive.NewObjectID().Hex()
+	alert.Timestamp = time.Now().UTC()
+	alert.Hopper = hopperName
+	log.Printf("Alert %s: %s", alert.Type, alert.Message)
+	if !alertsHaveReceivers() {
+		return
+	}
+	select {
+	case alertQueue <- alert:
+	default:
+		log.Printf("Alert queue is full, not sending %s alert %s", alert.Type, alert.ID)
+	}
+}
+
+// startAlerting hands queued alerts to the notifiers and watches the hopper's readiness
+func startAlerting() {
+	go func() {
+		for alert := range alertQueue {
+			for _, notifier := range alertNotifiers() {
+				if notifier.accepts(alert.Type) {
+					go deliverAlert(notifier, alert)
+				}
+			}
+			alertEmails.add(alert)
+		}
+	}()
+	go watchReadiness()
+}
+
+// deliverAlert sends an alert to one notifier
+func deliverAlert(notifier alertNotifier, alert AlertEvent) {
+	retryAlert(fmt.Sprintf("%s alert %s to %s", alert.Type, alert.ID, notifier.name()), func() error {
+		return notifier.send(context.Background(), alert)
+	})
+}
+
+// retryAlert calls send with exponential backoff until it succeeds, fails permanently or
+// alerts.retry.max_attempts is reached; what names the delivery in log lines
+func retryAlert(what string, send func() error) {
+	retry := currentConfig().Alerts.Retry
+	backoff := retry.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			log.Printf("Sent %s", what)
+			return
+		}
+		var permanent permanentAlertError
+		if errors.As(err, &permanent) || attempt >= retry.MaxAttempts {
+			log.Printf("Giving up sending %s after %d attempts: %v", what, attempt, err)
+			return
+		}
+		log.Printf("Error sending %s (attempt %d, retrying in %s): %v", what, attempt, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > retry.maxBackoff {
+			backoff = retry.maxBackoff
+		}
+	}
+}
+
+// watchReadiness raises health.changed when the result of the readiness checks changes. Checks
+// only run while alerts have receivers; the first result is not reported.
+func watchReadiness() {
+	last := ""
+	for {
+		cfg := currentConfig()
+		time.Sleep(cfg.Alerts.healthCheckInterval)
+		if !alertsHaveReceivers() {
+			last = ""
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Health.timeout)
+		status := checkReadiness(ctx)
+		cancel()
+		if last != "" && status.Status != last {
+			message := "Hopper is ready again"
+			if status.Status != "ok" {
+				message = "Hopper is unavailable: " + failedChecks(status)
+			}
+			raiseAlert(AlertEvent{Type: AlertHealthChanged, Message: message, Health: &status})
+		}
+		last = status.Status
+	}
+}
+
+// webhookNotifier POSTs the alert as JSON
+type webhookNotifier struct {
+	cfg WebhookConfig
+}
+
+var webhookClient = &http.Client{}
+
+func (n webhookNotifier) name() string {
+	return "webhook " + n.cfg.Name
+}
+
+func (n webhookNotifier) accepts(alertType string) bool {
+	return len(n.cfg.Events) == 0 || contains(n.cfg.Events, alertType)
+}
+
+func (n webhookNotifier) send(ctx context.Context, alert AlertEvent) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return permanentAlertError{err}
+	}
+	return postAlert(ctx, n.cfg.URL, n.cfg.timeout, body, func(h http.Header) {
+		h.Set("X-Hopper-Event", alert.Type)
+		h.Set("X-Hopper-Delivery", alert.ID)
+		for name, value := range n.cfg.Headers {
+			h.Set(name, value)
+		}
+		if n.cfg.Secret != "" {
+			mac := hmac.New(sha256.New, []byte(n.cfg.Secret))
+			mac.Write(body)
+			h.Set("X-Hopper-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+	})
+}
+
+// postAlert POSTs a JSON body. Network errors, 408, 429 and 5xx answers are worth retrying;
+// other statuses above 299 are permanent.
+func postAlert(ctx context.Context, target string, timeout time.Duration, body []byte, setHeaders func(http.Header)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return permanentAlertError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "http-hopper")
+	if setHeaders != nil {
+		setHeaders(req.Header)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("receiver answered %s", resp.Status)
+	if resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return permanentAlertError{err}
+}
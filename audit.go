@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"  // Soft delete (archive)
+	AuditPurge      = "purge"   // Permanent delete
+	AuditRestore    = "restore" // An archived destination was brought back
+	AuditActivate   = "activate"
+	AuditDeactivate = "deactivate"
+	AuditReload     = "reload" // The configuration file was re-read
+	AuditRetry      = "retry"  // A dead letter was queued again
+)
+
+// Audited resource types
+const (
+	AuditDestination   = "destination"
+	AuditGroup         = "group"
+	AuditConfiguration = "config"
+	AuditDeadLetter    = "deadletter"
+	AuditFault         = "fault"
+	AuditDNSCache      = "dnscache"
+)
+
+// AuditEntry records one change made through the management API
+type AuditEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Timestamp  time.Time          `bson:"timestamp" json:"timestamp"`
+	Principal  string             `bson:"principal" json:"principal"` // API key name or JWT user; "anonymous" when auth is disabled
+	RequestID  string             `bson:"requestId,omitempty" json:"requestId,omitempty"`
+	Action     string             `bson:"action" json:"action"`
+	Resource   string             `bson:"resource" json:"resource"`
+	ResourceID string             `bson:"resourceId" json:"resourceId"` // Destination ID or group name
+	Before     *Destination       `bson:"before,omitempty" json:"before,omitempty"`
+	After      *Destination       `bson:"after,omitempty" json:"after,omitempty"`
+	Details    string             `bson:"details,omitempty" json:"details,omitempty"`
+}
+
+// AuditFilter narrows the entries returned by findAuditEntriesInDB
+type AuditFilter struct {
+	Resource   string
+	ResourceID string
+	Action     string
+	Principal  string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// recordAudit stores an entry for a change made by the request. The change has already been
+// applied, so a failure to record it is logged rather than returned to the caller.
+func recordAudit(r *http.Request, entry AuditEntry) {
+	if mongoClient == nil {
+		return // The audit log requires the mongodb storage driver
+	}
+	entry.Timestamp = time.Now().UTC()
+	entry.RequestID = requestIDFromContext(r.Context())
+	entry.Principal = principalOrAnonymous(r)
+	// The change is done, so record it even if the client has disconnected
+	if err := insertAuditEntryToDB(context.WithoutCancel(r.Context()), entry); err != nil {
+		log.Printf("[%s] Error recording audit entry (%s %s %s by %s): %v", entry.RequestID, entry.Action, entry.Resource, entry.ResourceID, entry.Principal, err)
+	}
+}
+
+// auditDestination records a change to a single destination in the audit log and its
+// history; before or after is nil for creations and deletions
+func auditDestination(r *http.Request, action string, id primitive.ObjectID, before, after *Destination) {
+	recordAudit(r, AuditEntry{Action: action, Resource: AuditDestination, ResourceID: id.Hex(), Before: before, After: after})
+	recordHistory(r, action, before, after)
+}
+
+// GetAudit lists audit entries, newest first, filtered by resource, resourceId, action,
+// principal, since and until
+func GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Resource:   query.Get("resource"),
+		ResourceID: query.Get("resourceId"),
+		Action:     query.Get("action"),
+		Principal:  query.Get("principal"),
+		Limit:      100,
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			http.Error(w, "Invalid until parameter: must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		filter.Until = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "Invalid limit parameter (1-1000)", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := findAuditEntriesInDB(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting audit entries: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding audit entries: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
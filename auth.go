@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiKeyHeader carries the API key on admin requests; "Authorization: Bearer <key>" works too
+const apiKeyHeader = "X-API-Key"
+
+// APIKeyConfig is an API key defined in the configuration file
+type APIKeyConfig struct {
+	Name       string   `yaml:"name"`
+	Key        string   `yaml:"key"`
+	Namespaces []string `yaml:"namespaces"` // Limits the key to these namespaces' destinations; unlimited when empty
+}
+
+// APIKey is an API key stored in MongoDB. Only the SHA-256 hash of the key is kept;
+// the key itself is returned once, when it is created.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // First characters of the key, to tell keys apart
+	Namespaces []string           `bson:"namespaces,omitempty" json:"namespaces,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// createdAPIKey is returned by CreateAPIKey and is the only response that includes the key
+type createdAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type principalKey struct{}
+
+// principalFromContext returns who authenticated the request: the API key's name or the
+// JWT's user
+func principalFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(principalKey{}).(string)
+	return name
+}
+
+// principalOrAnonymous is the caller for audit records and logs
+func principalOrAnonymous(r *http.Request) string {
+	if principal := principalFromContext(r.Context()); principal != "" {
+		return principal
+	}
+	return "anonymous"
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 of a key as stored in MongoDB
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey generates a random key
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "hop_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// apiKeyFromRequest extracts the key from the X-API-Key or Authorization header
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// authenticateAPIKey looks the key up in the configuration and then in MongoDB and returns
+// the key's name and the namespaces it is limited to
+func authenticateAPIKey(ctx context.Context, key string) (string, []string, bool) {
+	if k, ok := configuredAPIKey(ctx, key); ok {
+		return k.Name, k.Namespaces, true
+	}
+	if mongoClient == nil {
+		return "", nil, false
+	}
+	stored, err := findAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		if err != errNotFound {
+			log.Printf("Error looking up API key: %v", err)
+		}
+		return "", nil, false
+	}
+	return stored.Name, stored.Namespaces, true
+}
+
+// configuredAPIKey looks the key up in auth.api_keys only
+func configuredAPIKey(ctx context.Context, key string) (APIKeyConfig, bool) {
+	for _, k := range requestConfig(ctx).Auth.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k, true
+		}
+	}
+	return APIKeyConfig{}, false
+}
+
+// requireAuth protects an admin handler when auth or OIDC is enabled. Callers present an
+// API key or, with OIDC enabled, a JWT from the configured issuer.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg := requestConfig(r.Context()); !cfg.Auth.Enabled && !cfg.Auth.OIDC.Enabled {
+			next(w, r)
+			return
+		}
+		credential := apiKeyFromRequest(r)
+		if credential == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		var namespaces []string
+		principal, ok := authenticateJWT(credential)
+		if !ok {
+			principal, namespaces, ok = authenticateAPIKey(r.Context(), credential)
+		}
+		if !ok {
+			log.Printf("[%s] Rejected invalid credentials for %s %s", requestIDFromContext(r.Context()), r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin", error="invalid_token"`)
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		if len(namespaces) > 0 {
+			ctx = context.WithValue(ctx, namespaceScopeKey{}, namespaces)
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// List the API keys stored in the database (keys from the configuration file are not listed)
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := getAPIKeysFromDB(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting API keys: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// Create a new API key; the key is only ever shown in this response
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name       string   `json:"name"`
+		Namespaces []string `json:"namespaces"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		http.Error(w, "Invalid request body: a name is required", http.StatusBadRequest)
+		return
+	}
+	for _, name := range body.Namespaces {
+		if _, ok := findNamespace(name); !ok {
+			http.Error(w, fmt.Sprintf("Invalid request body: namespace %q is not declared in namespaces.list", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error generating API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	apiKey := APIKey{
+		Name:       body.Name,
+		KeyHash:    hashAPIKey(key),
+		Prefix:     key[:8],
+		Namespaces: body.Namespaces,
+		CreatedAt:  time.Now().UTC(),
+	}
+	apiKey.ID, err = insertAPIKeyToDB(r.Context(), apiKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("API key %q created by %q", apiKey.Name, principalFromContext(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdAPIKey{APIKey: apiKey, Key: key})
+}
+
+// Revoke an API key stored in the database
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := revokeAPIKeyInDB(r.Context(), id)
+	if err == errInvalidID {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+	if err == errNotFound {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error revoking API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("API key %s revoked by %q", id, principalFromContext(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "API key revoked"})
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// destinationsBucket holds one BSON document per destination, keyed by its hex ID
+var destinationsBucket = []byte("destinations")
+
+// boltStore keeps destinations in an embedded bbolt file (storage.driver "bolt"). The file is
+// locked while the hopper runs, so nothing else can change it.
+type boltStore struct {
+	db *bolt.DB
+	storeWatchers
+}
+
+// openBoltStore opens (or creates) the database file
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(destinationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating bucket in %s: %v", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+// Ping always succeeds: the file stays open and locked while the hopper runs
+func (s *boltStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func decodeBoltDestination(data []byte) (Destination, error) {
+	var destination Destination
+	if err := bson.Unmarshal(data, &destination); err != nil {
+		return destination, fmt.Errorf("Bolt Decode Error: %v", err)
+	}
+	return destination, nil
+}
+
+func putBoltDestination(b *bolt.Bucket, destination Destination) error {
+	data, err := bson.Marshal(destination)
+	if err != nil {
+		return fmt.Errorf("Bolt Encode Error: %v", err)
+	}
+	return b.Put([]byte(destination.ID.Hex()), data)
+}
+
+// destinations returns the live or the archived destinations in ID order
+func (s *boltStore) destinations(archived bool) ([]Destination, error) {
+	destinations := []Destination{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(destinationsBucket).ForEach(func(_, data []byte) error {
+			destination, err := decodeBoltDestination(data)
+			if err != nil {
+				return err
+			}
+			if destination.Archived == archived {
+				destinations = append(destinations, destination)
+			}
+			return nil
+		})
+	})
+	return destinations, err
+}
+
+func (s *boltStore) All(ctx context.Context) ([]Destination, error) {
+	return s.destinations(false)
+}
+
+func (s *boltStore) Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error) {
+	all, err := s.destinations(q.Archived)
+	if err != nil {
+		return nil, 0, err
+	}
+	destinations := []Destination{}
+	for _, d := range all {
+		if matchesDestinationQuery(d, q) {
+			destinations = append(destinations, d)
+		}
+	}
+	return pageDestinations(destinations, q)
+}
+
+func (s *boltStore) Get(ctx context.Context, id string) (Destination, error) {
+	var destination Destination
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return destination, errInvalidID
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(destinationsBucket).Get([]byte(objectID.Hex()))
+		if data == nil {
+			return errNotFound
+		}
+		destination, err = decodeBoltDestination(data)
+		return err
+	})
+	return destination, err
+}
+
+func (s *boltStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	destination.ID = primitive.NewObjectID()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDestination(tx.Bucket(destinationsBucket), destination)
+	})
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("Bolt Insert Error: %v", err)
+	}
+	return destination.ID, nil
+}
+
+// modify runs change against the stored document inside one transaction and
+// saves the result with its version bumped
+func (s *boltStore) modify(id string, change func(current Destination, doc bson.M) error) (Destination, error) {
+	var updated Destination
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return updated, errInvalidID
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(destinationsBucket)
+		data := b.Get([]byte(objectID.Hex()))
+		if data == nil {
+			return errNotFound
+		}
+		current, err := decodeBoltDestination(data)
+		if err != nil {
+			return err
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("Bolt Decode Error: %v", err)
+		}
+		migrateDestinationDoc(doc)
+		if err := change(current, doc); err != nil {
+			return err
+		}
+		doc["version"] = current.Version + 1
+		encoded, err := bson.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("Bolt Encode Error: %v", err)
+		}
+		if updated, err = decodeBoltDestination(encoded); err != nil {
+			return err
+		}
+		return b.Put([]byte(objectID.Hex()), encoded)
+	})
+	return updated, err
+}
+
+func (s *boltStore) Update(ctx context.Context, id string, updatedDestination Destination, version int64) error {
+	_, err := s.modify(id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		if version != anyVersion && current.Version != version {
+			return errConflict
+		}
+		for key, value := range destinationUpdate(updatedDestination) {
+			doc[key] = value
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Updated document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *boltStore) Archive(ctx context.Context, id string) error {
+	_, err := s.modify(id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		doc["archived"] = true
+		doc["archivedAt"] = time.Now().UTC()
+		return nil
+	})
+	if err == nil {
+		log.Printf("Archived document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *boltStore) Restore(ctx context.Context, id string) (Destination, error) {
+	destination, err := s.modify(id, func(current Destination, doc bson.M) error {
+		if !current.Archived {
+			return errNotFound
+		}
+		delete(doc, "archived")
+		delete(doc, "archivedAt")
+		return nil
+	})
+	if err == nil {
+		log.Printf("Restored document with ID: %s", id)
+	}
+	return destination, err
+}
+
+func (s *boltStore) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(destinationsBucket)
+		if b.Get([]byte(objectID.Hex())) == nil {
+			return errNotFound
+		}
+		return b.Delete([]byte(objectID.Hex()))
+	})
+	if err == nil {
+		log.Printf("Deleted document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *boltStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(destinationsBucket)
+		data := b.Get([]byte(destination.ID.Hex()))
+		if data == nil {
+			return errConflict
+		}
+		current, err := decodeBoltDestination(data)
+		if err != nil {
+			return err
+		}
+		if current.Archived || current.Version != version {
+			return errConflict
+		}
+		return putBoltDestination(b, destination)
+	})
+}
+
+// ApplyImport applies an import in a single transaction, so unlike with
+// MongoDB it is all or nothing
+func (s *boltStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(destinationsBucket)
+		for _, d := range creates {
+			if err := putBoltDestination(b, d); err != nil {
+				return fmt.Errorf("Bolt Insert Error for %s: %v", d.URL, err)
+			}
+		}
+		for _, d := range updates {
+			data := b.Get([]byte(d.ID.Hex()))
+			if data == nil {
+				return fmt.Errorf("%s was modified while the import was applied", d.URL)
+			}
+			current, err := decodeBoltDestination(data)
+			if err != nil {
+				return err
+			}
+			if current.Version != d.Version-1 {
+				return fmt.Errorf("%s was modified while the import was applied", d.URL)
+			}
+			if err := putBoltDestination(b, d); err != nil {
+				return fmt.Errorf("Bolt Replace Error for %s: %v", d.URL, err)
+			}
+		}
+		archivedAt := time.Now().UTC()
+		for _, d := range deletes {
+			data := b.Get([]byte(d.ID.Hex()))
+			if data == nil {
+				continue // Already gone
+			}
+			current, err := decodeBoltDestination(data)
+			if err != nil {
+				return err
+			}
+			current.Archived, current.ArchivedAt = true, &archivedAt
+			current.Version++
+			if err := putBoltDestination(b, current); err != nil {
+				return fmt.Errorf("Bolt Update Error for %s: %v", d.URL, err)
+			}
+		}
+		return nil
+	})
+}
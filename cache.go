@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// cachedStore keeps the destination list that every forwarded request needs in memory for
+// storage.cache_ttl. Writes through the store and change notifications from the backend drop
+// the list; other hoppers' changes are picked up when it expires. When reloading fails the
+// previous list keeps being served, so a database outage does not stop traffic.
+type cachedStore struct {
+	DestinationStore
+	ttl time.Duration
+
+	mu           sync.Mutex
+	destinations []Destination
+	loadedAt     time.Time
+	valid        bool
+}
+
+func newCachedStore(s DestinationStore, ttl time.Duration) *cachedStore {
+	c := &cachedStore{DestinationStore: s, ttl: ttl}
+	s.Watch(c.invalidate)
+	return c
+}
+
+// All returns the cached list; the lock is held while reloading so concurrent requests
+// wait for one query instead of each sending their own
+func (c *cachedStore) All(ctx context.Context) ([]Destination, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.valid || time.Since(c.loadedAt) >= c.ttl {
+		destinations, err := c.DestinationStore.All(ctx)
+		if err != nil {
+			if c.destinations == nil {
+				return nil, err
+			}
+			log.Printf("Error reloading destinations, serving the cached list: %v", err)
+		} else {
+			c.destinations = destinations
+		}
+		c.loadedAt, c.valid = time.Now(), true
+	}
+	return append([]Destination(nil), c.destinations...), nil
+}
+
+// invalidate makes the next All reload; the old list is kept as the fallback
+func (c *cachedStore) invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.mu.Unlock()
+}
+
+func (c *cachedStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	defer c.invalidate()
+	return c.DestinationStore.Add(ctx, destination)
+}
+
+func (c *cachedStore) Update(ctx context.Context, id string, destination Destination, version int64) error {
+	defer c.invalidate()
+	return c.DestinationStore.Update(ctx, id, destination, version)
+}
+
+func (c *cachedStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	defer c.invalidate()
+	return c.DestinationStore.Replace(ctx, destination, version)
+}
+
+func (c *cachedStore) Archive(ctx context.Context, id string) error {
+	defer c.invalidate()
+	return c.DestinationStore.Archive(ctx, id)
+}
+
+func (c *cachedStore) Restore(ctx context.Context, id string) (Destination, error) {
+	defer c.invalidate()
+	return c.DestinationStore.Restore(ctx, id)
+}
+
+func (c *cachedStore) Delete(ctx context.Context, id string) error {
+	defer c.invalidate()
+	return c.DestinationStore.Delete(ctx, id)
+}
+
+func (c *cachedStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	defer c.invalidate()
+	return c.DestinationStore.ApplyImport(ctx, creates, updates, deletes)
+}
+
+// invalidateDestinationCache is for changes made to destinations without going through the
+// store (group operations update MongoDB directly)
+func invalidateDestinationCache() {
+	if c, ok := store.(*cachedStore); ok {
+		c.invalidate()
+	}
+}
+
+// groupCache holds the groups for the forwarding path, with the same TTL and fallback as
+// the destination cache
+var groupCache struct {
+	sync.Mutex
+	groups   []Group
+	loadedAt time.Time
+	valid    bool
+}
+
+// getCachedGroups returns the groups, querying MongoDB at most once per storage.cache_ttl
+func getCachedGroups(ctx context.Context) ([]Group, error) {
+	cfg := currentConfig()
+	if cfg.Storage.cacheTTL <= 0 {
+		return getGroupsFromDB(ctx)
+	}
+	groupCache.Lock()
+	defer groupCache.Unlock()
+	if !groupCache.valid || time.Since(groupCache.loadedAt) >= cfg.Storage.cacheTTL {
+		groups, err := getGroupsFromDB(ctx)
+		if err != nil {
+			if groupCache.groups == nil {
+				return nil, err
+			}
+			log.Printf("Error reloading groups, serving the cached list: %v", err)
+		} else {
+			groupCache.groups = groups
+		}
+		groupCache.loadedAt, groupCache.valid = time.Now(), true
+	}
+	return append([]Group(nil), groupCache.groups...), nil
+}
+
+func invalidateGroupCache() {
+	groupCache.Lock()
+	groupCache.valid = false
+	groupCache.Unlock()
+}
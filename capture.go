@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Capture is a stored record of a forwarded request and every destination's response
+type Capture struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	RequestID     string              `bson:"requestId" json:"requestId"`
+	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
+	Method        string              `bson:"method" json:"method"`
+	Path          string              `bson:"path" json:"path"` // As forwarded, without a stripped namespace prefix
+	RawQuery      string              `bson:"rawQuery,omitempty" json:"rawQuery,omitempty"`
+	Host          string              `bson:"host" json:"host"`
+	Namespace     string              `bson:"namespace,omitempty" json:"namespace,omitempty"`
+	RemoteAddr    string              `bson:"remoteAddr" json:"remoteAddr"`
+	Headers       map[string][]string `bson:"headers" json:"headers"`
+	Body          []byte              `bson:"body,omitempty" json:"body,omitempty"`
+	BodyTruncated bool                `bson:"bodyTruncated,omitempty" json:"bodyTruncated,omitempty"`
+	Status        int                 `bson:"status" json:"status"` // Status returned to the client
+	Responses     []CapturedResponse  `bson:"responses" json:"responses"`
+}
+
+// captureRecorder assembles a Capture while the request is fanned out; a nil recorder
+// (capturing disabled) ignores every call
+type captureRecorder struct {
+	mu           sync.Mutex
+	capture      Capture
+	requestBody  *cappedBuffer
+	responseBody map[int]*cappedBuffer
+}
+
+// CapturedResponse is one destination's answer to a captured request
+type CapturedResponse struct {
+	DestinationID primitive.ObjectID  `bson:"destinationId,omitempty" json:"destinationId,omitempty"`
+	URL           string              `bson:"url" json:"url"`
+	IsDefault     bool                `bson:"isDefault" json:"isDefault"`
+	Status        int                 `bson:"status,omitempty" json:"status,omitempty"`
+	Headers       map[string][]string `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body          []byte              `bson:"body,omitempty" json:"body,omitempty"`
+	BodyTruncated bool                `bson:"bodyTruncated,omitempty" json:"bodyTruncated,omitempty"`
+	LatencyMs     int64               `bson:"latencyMs" json:"latencyMs"`
+	Error         string              `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// cappedBuffer keeps at most limit bytes and remembers whether anything was dropped.
+// Writes never fail so it can sit next to the real destination in a MultiWriter.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	limit     int64
+	data      []byte
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	room := c.limit - int64(len(c.data))
+	if room < int64(len(p)) {
+		c.truncated = true
+		if room > 0 {
+			c.data = append(c.data, p[:room]...)
+		}
+		return len(p), nil
+	}
+	c.data = append(c.data, p...)
+	return len(p), nil
+}
+
+func (c *cappedBuffer) snapshot() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.data, c.truncated
+}
+
+// newCaptureRecorder starts a capture for an inbound request, or returns nil when capturing is disabled
+func newCaptureRecorder(r *http.Request) *captureRecorder {
+	if !requestConfig(r.Context()).Capture.Enabled {
+		return nil
+	}
+	return startCaptureRecorder(r)
+}
+
+// startCaptureRecorder records a request regardless of the capture setting (used by replays)
+func startCaptureRecorder(r *http.Request) *captureRecorder {
+	return &captureRecorder{
+		capture: Capture{
+			RequestID:  requestIDFromContext(r.Context()),
+			CreatedAt:  time.Now().UTC(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RawQuery:   r.URL.RawQuery,
+			Host:       r.Host,
+			Namespace:  requestNamespace(r.Context()),
+			RemoteAddr: r.RemoteAddr,
+			Headers:    redactCredentials(r.Header),
+		},
+		requestBody:  &cappedBuffer{limit: requestConfig(r.Context()).Capture.MaxBodyBytes},
+		responseBody: make(map[int]*cappedBuffer),
+	}
+}
+
+// requestBodyWriter returns the sink that records the request body
+func (c *captureRecorder) requestBodyWriter() *cappedBuffer {
+	if c == nil {
+		return nil
+	}
+	return c.requestBody
+}
+
+// addResponse records a destination's outcome and returns the sink for its response body
+func (c *captureRecorder) addResponse(destination Destination, isDefault bool, resp *http.Response, latency time.Duration, err error) *cappedBuffer {
+	if c == nil {
+		return nil
+	}
+	captured := CapturedResponse{
+		DestinationID: destination.ID,
+		URL:           destination.URL,
+		IsDefault:     isDefault,
+		LatencyMs:     latency.Milliseconds(),
+	}
+	if err != nil {
+		captured.Error = err.Error()
+	}
+	if resp != nil {
+		captured.Status = resp.StatusCode
+		captured.Headers = redactCredentials(resp.Header)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capture.Responses = append(c.capture.Responses, captured)
+	body := &cappedBuffer{limit: currentConfig().Capture.MaxBodyBytes}
+	c.responseBody[len(c.capture.Responses)-1] = body
+	return body
+}
+
+// defaultResponseBodyWriter returns the sink for the default destination's response body
+func (c *captureRecorder) defaultResponseBodyWriter() *cappedBuffer {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, resp := range c.capture.Responses {
+		if resp.IsDefault {
+			return c.responseBody[i]
+		}
+	}
+	return nil
+}
+
+// snapshot copies the capture assembled so far, including the recorded bodies
+func (c *captureRecorder) snapshot(status int) Capture {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	capture := c.capture
+	capture.Status = status
+	capture.Body, capture.BodyTruncated = c.requestBody.snapshot()
+	capture.Responses = append([]CapturedResponse(nil), c.capture.Responses...)
+	for i := range capture.Responses {
+		if body, ok := c.responseBody[i]; ok {
+			capture.Responses[i].Body, capture.Responses[i].BodyTruncated = body.snapshot()
+		}
+	}
+	return capture
+}
+
+// save stores the capture once every destination has finished
+func (c *captureRecorder) save(status int) {
+	if c == nil {
+		return
+	}
+	capture := c.snapshot(status)
+	if err := insertCaptureToDB(context.Background(), capture); err != nil {
+		log.Printf("[%s] Error storing capture: %v", capture.RequestID, err)
+	}
+}
+
+// parseSince accepts either an RFC 3339 timestamp or a duration relative to now (e.g. "15m")
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be an RFC 3339 timestamp or a duration")
+	}
+	return time.Now().Add(-d), nil
+}
+
+// GetCaptures lists captured requests filtered by path prefix, status and age
+func GetCaptures(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := CaptureFilter{PathPrefix: query.Get("path"), Limit: 100}
+
+	if status := query.Get("status"); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			http.Error(w, "Invalid status parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Status = code
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "Invalid limit parameter (1-1000)", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	if !strings.HasPrefix(filter.PathPrefix, "/") && filter.PathPrefix != "" {
+		filter.PathPrefix = "/" + filter.PathPrefix
+	}
+
+	captures, err := findCapturesInDB(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting captures: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(captures); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding captures: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetCapture returns a single captured request
+func GetCapture(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	capture, err := getCaptureFromDB(r.Context(), params["id"])
+	if err == errInvalidID {
+		http.Error(w, "Invalid capture ID", http.StatusBadRequest)
+		return
+	}
+	if err == errNotFound {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting capture: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(capture); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding capture: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
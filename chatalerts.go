@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ChatConfig is a Slack or Microsoft Teams incoming webhook that receives alerts as messages
+type ChatConfig struct {
+	Name       string   `yaml:"name"`
+	WebhookURL string   `yaml:"webhook_url"`
+	Events     []string `yaml:"events"`   // Alert types sent to the channel; all when empty
+	Template   string   `yaml:"template"` // Text of the message, a Go template of the alert; see docs/alerts.md
+	Timeout    string   `yaml:"timeout"`  // For one attempt; defaults to 10s
+	timeout    time.Duration
+}
+
+// defaultChatTemplate names the destination and its error rate under the alert's message
+const defaultChatTemplate = `{{.Message}}{{with .Destination}}
+Destination: {{.Name}}{{with .Namespace}} (namespace {{.}}){{end}}, error rate {{percent $.ErrorRate}}{{end}}`
+
+var chatTemplateFuncs = template.FuncMap{
+	"percent": func(rate float64) string { return fmt.Sprintf("%.0f%%", rate*100) },
+}
+
+// alertTitles head chat messages, and alertColors mark them by severity
+var (
+	alertTitles = map[string]string{
+		AlertDestinationFailing:   "Destination failing",
+		AlertDestinationRecovered: "Destination recovered",
+		AlertCircuitOpened:        "Circuit opened",
+		AlertCircuitClosed:        "Circuit closed",
+		AlertHealthChanged:        "Readiness changed",
+	}
+	alertColors = map[string]string{
+		AlertDestinationFailing:   "#d9383a",
+		AlertDestinationRecovered: "#2eb67d",
+		AlertCircuitOpened:        "#d9383a",
+		AlertCircuitClosed:        "#2eb67d",
+	}
+)
+
+// alertColor is red or green for health.changed, depending on the new readiness
+func alertColor(alert AlertEvent) string {
+	if alert.Health != nil {
+		if alert.Health.Status == "ok" {
+			return alertColors[AlertDestinationRecovered]
+		}
+		return alertColors[AlertDestinationFailing]
+	}
+	return alertColors[alert.Type]
+}
+
+// chatMessage is what a chat payload is built from
+type chatMessage struct {
+	Title    string
+	Text     string
+	Color    string
+	StatsURL string // Empty without alerts.stats_url
+	Alert    AlertEvent
+}
+
+// chatNotifier posts alerts to a Slack or Teams incoming webhook
+type chatNotifier struct {
+	kind    string
+	cfg     ChatConfig
+	payload func(chatMessage) any
+}
+
+func (n chatNotifier) name() string {
+	return n.kind + " " + n.cfg.Name
+}
+
+func (n chatNotifier) accepts(alertType string) bool {
+	return len(n.cfg.Events) == 0 || contains(n.cfg.Events, alertType)
+}
+
+func (n chatNotifier) send(ctx context.Context, alert AlertEvent) error {
+	text := n.cfg.Template
+	if text == "" {
+		text = defaultChatTemplate
+	}
+	rendered, err := renderAlertTemplate(n.cfg.Name, text, alert)
+	if err != nil {
+		return permanentAlertError{err}
+	}
+	message := chatMessage{
+		Title:    alertTitles[alert.Type],
+		Text:     rendered,
+		Color:    alertColor(alert),
+		StatsURL: alertStatsURL(alert),
+		Alert:    alert,
+	}
+	body, err := json.Marshal(n.payload(message))
+	if err != nil {
+		return permanentAlertError{err}
+	}
+	return postAlert(ctx, n.cfg.WebhookURL, n.cfg.timeout, body, nil)
+}
+
+// parseAlertTemplate parses a chat message or stats_url template
+func parseAlertTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(chatTemplateFuncs).Parse(text)
+}
+
+func renderAlertTemplate(name, text string, alert AlertEvent) (string, error) {
+	tmpl, err := parseAlertTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, alert); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// alertStatsURL renders alerts.stats_url. A template that can't be rendered for the alert (e.g.
+// one using .Destination.ID for a health.changed alert) leaves the link out.
+func alertStatsURL(alert AlertEvent) string {
+	statsURL := currentConfig().Alerts.StatsURL
+	if statsURL == "" {
+		return ""
+	}
+	link, err := renderAlertTemplate("stats_url", statsURL, alert)
+	if err != nil {
+		log.Printf("No stats link for %s alert %s: %v", alert.Type, alert.ID, err)
+		return ""
+	}
+	return link
+}
+
+// slackPayload builds a Block Kit message with the stats link as a button
+func slackPayload(m chatMessage) any {
+	blocks := []map[string]any{
+		{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": "*" + m.Title + "*\n" + m.Text}},
+		{"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": fmt.Sprintf("%s · %s", m.Alert.Hopper, m.Alert.Timestamp.Format(time.RFC1123))}}},
+	}
+	if m.StatsURL != "" {
+		blocks = append(blocks, map[string]any{"type": "actions", "elements": []map[string]any{{
+			"type": "button",
+			"text": map[string]string{"type": "plain_text", "text": "View stats"},
+			"url":  m.StatsURL,
+		}}})
+	}
+	return map[string]any{
+		"text":        m.Title + ": " + m.Alert.Message, // Shown in notifications
+		"attachments": []map[string]any{{"color": m.Color, "blocks": blocks}},
+	}
+}
+
+// teamsPayload builds a MessageCard with the stats link as an action
+func teamsPayload(m chatMessage) any {
+	card := map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    m.Title + ": " + m.Alert.Message,
+		"themeColor": strings.TrimPrefix(m.Color, "#"),
+		"title":      m.Title,
+		"text":       strings.ReplaceAll(m.Text, "\n", "\n\n"), // Teams joins single line breaks
+		"sections": []map[string]any{{
+			"facts": []map[string]string{
+				{"name": "Hopper", "value": m.Alert.Hopper},
+				{"name": "Time", "value": m.Alert.Timestamp.Format(time.RFC1123)},
+			},
+		}},
+	}
+	if m.StatsURL != "" {
+		card["potentialAction"] = []map[string]any{{
+			"@type":   "OpenUri",
+			"name":    "View stats",
+			"targets": []map[string]string{{"os": "default", "uri": m.StatsURL}},
+		}}
+	}
+	return card
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Every response of a destination, or the error instead of one, is recorded here. After
+// alerts.failure_threshold failures in a row (errors or 5xx answers) the destination is failing
+// until it answers again, which raises destination.failing and destination.recovered alerts.
+// With forwarding.circuit_breaker.enabled the destination's circuit also opens after
+// failure_threshold failures in a row: the destination is skipped (unless it is the default
+// destination, which still answers the client) for open_duration, then one request tries it
+// again (half-open) and closes the circuit if it succeeds.
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// outcomeWindow is the number of recent requests the error rate is computed over
+const outcomeWindow = 100
+
+// destinationOutcomes tracks the recent outcomes of one destination
+type destinationOutcomes struct {
+	mu          sync.Mutex
+	destination Destination
+	consecutive int // Failures in a row
+	failing     bool
+	lastError   string
+	recent      [outcomeWindow]bool // Ring buffer, true for a failure
+	next        int
+	count       int
+	circuit     string
+	changedAt   time.Time // When the circuit opened, or its half-open trial started
+}
+
+// outcomesByDestination holds a *destinationOutcomes per destination ID (URL for destinations
+// without one)
+var outcomesByDestination sync.Map
+
+func outcomesKey(destination Destination) string {
+	if destination.ID.IsZero() {
+		return destination.URL
+	}
+	return destination.ID.Hex()
+}
+
+func outcomesOf(destination Destination) *destinationOutcomes {
+	value, _ := outcomesByDestination.LoadOrStore(outcomesKey(destination), &destinationOutcomes{destination: destination, circuit: CircuitClosed})
+	return value.(*destinationOutcomes)
+}
+
+// errorRate is the share of failures among the recent requests; the caller holds the lock
+func (o *destinationOutcomes) errorRate() float64 {
+	if o.count == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range o.recent[:o.count] {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(o.count)
+}
+
+// recordDestinationOutcome records the answer of a destination (status 0 with err when there was
+// none) and raises the alerts its state changes call for
+func recordDestinationOutcome(destination Destination, status int, err error) {
+	cfg := currentConfig()
+	failed := err != nil || status >= http.StatusInternalServerError
+	o := outcomesOf(destination)
+	o.mu.Lock()
+	o.destination = destination
+	o.recent[o.next] = failed
+	o.next = (o.next + 1) % outcomeWindow
+	if o.count < outcomeWindow {
+		o.count++
+	}
+
+	var alerts []AlertEvent
+	alert := func(alertType, message string) {
+		alerts = append(alerts, AlertEvent{
+			Type:                alertType,
+			Message:             message,
+			Destination:         alertDestinationOf(o.destination),
+			ConsecutiveFailures: o.consecutive,
+			ErrorRate:           o.errorRate(),
+			Error:               o.lastError,
+		})
+	}
+	breaker := cfg.Forwarding.CircuitBreaker
+	if failed {
+		o.consecutive++
+		o.lastError = fmt.Sprintf("status %d", status)
+		if err != nil {
+			o.lastError = err.Error()
+		}
+		if !o.failing && o.consecutive >= cfg.Alerts.FailureThreshold {
+			o.failing = true
+			alert(AlertDestinationFailing, fmt.Sprintf("Destination %s is failing: %d failures in a row, last: %s", destination.URL, o.consecutive, o.lastError))
+		}
+		switch {
+		case o.circuit == CircuitHalfOpen:
+			o.circuit, o.changedAt = CircuitOpen, time.Now()
+			log.Printf("Circuit of destination %s opened again: the trial request failed (%s)", destination.URL, o.lastError)
+		case o.circuit == CircuitClosed && breaker.Enabled && o.consecutive >= breaker.FailureThreshold:
+			o.circuit, o.changedAt = CircuitOpen, time.Now()
+			alert(AlertCircuitOpened, fmt.Sprintf("Circuit of destination %s opened after %d failures in a row, last: %s; retrying in %s", destination.URL, o.consecutive, o.lastError, breaker.OpenDuration))
+		}
+	} else {
+		if o.failing {
+			o.failing = false
+			alert(AlertDestinationRecovered, fmt.Sprintf("Destination %s recovered after %d failures in a row", destination.URL, o.consecutive))
+		}
+		o.consecutive = 0
+		if o.circuit != CircuitClosed {
+			o.circuit = CircuitClosed
+			alert(AlertCircuitClosed, fmt.Sprintf("Circuit of destination %s closed: it answered again", destination.URL))
+		}
+	}
+	o.mu.Unlock()
+
+	for _, a := range alerts {
+		raiseAlert(a)
+	}
+}
+
+// circuitAllows reports whether a request may be sent to the destination: always with a closed
+// circuit or the breaker disabled, never while the circuit is open, and once when open_duration
+// has passed (the half-open trial). A trial that never reports back is retried after another
+// open_duration.
+func circuitAllows(destination Destination) bool {
+	breaker := currentConfig().Forwarding.CircuitBreaker
+	if !breaker.Enabled {
+		return true
+	}
+	value, ok := outcomesByDestination.Load(outcomesKey(destination))
+	if !ok {
+		return true
+	}
+	o := value.(*destinationOutcomes)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.circuit == CircuitClosed || time.Since(o.changedAt) < breaker.openDuration {
+		return o.circuit == CircuitClosed
+	}
+	o.circuit, o.changedAt = CircuitHalfOpen, time.Now()
+	log.Printf("Circuit of destination %s is half-open: trying one request", destination.URL)
+	return true
+}
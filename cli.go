@@ -0,0 +1,832 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// cliCommands are the subcommands that talk to a running hopper's admin API instead of
+// starting one; see docs/cli.md
+var cliCommands = map[string]func(args []string) error{
+	"destinations": cliDestinations,
+	"traffic":      cliTraffic,
+	"replay":       cliReplay,
+	"profile":      cliProfile,
+}
+
+// runCLI runs a subcommand and returns the process exit code
+func runCLI(args []string) int {
+	command := cliCommands[args[0]]
+	if err := command(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// isCLICommand reports whether the first argument selects a subcommand rather than a server flag
+func isCLICommand(arg string) bool {
+	_, ok := cliCommands[arg]
+	return ok
+}
+
+// CLIProfile is one hopper instance the CLI can talk to
+type CLIProfile struct {
+	URL          string `yaml:"url"`
+	APIKey       string `yaml:"api_key,omitempty"`
+	TrafficToken string `yaml:"traffic_token,omitempty"` // For traffic tail; the API key when empty
+}
+
+// CLIProfiles is the profiles file, by default ~/.config/http-hopper/profiles.yaml
+type CLIProfiles struct {
+	Current  string                `yaml:"current,omitempty"`
+	Profiles map[string]CLIProfile `yaml:"profiles"`
+}
+
+// cliProfilesPath returns the profiles file, which HOPPER_PROFILES can move
+func cliProfilesPath() (string, error) {
+	if path := os.Getenv("HOPPER_PROFILES"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "http-hopper", "profiles.yaml"), nil
+}
+
+// loadCLIProfiles reads the profiles file; a missing file has no profiles
+func loadCLIProfiles() (CLIProfiles, string, error) {
+	profiles := CLIProfiles{Profiles: map[string]CLIProfile{}}
+	path, err := cliProfilesPath()
+	if err != nil {
+		return profiles, "", err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return profiles, path, nil
+	}
+	if err != nil {
+		return profiles, path, err
+	}
+	if err := yaml.UnmarshalStrict(data, &profiles); err != nil {
+		return profiles, path, fmt.Errorf("invalid profiles file %s: %v", path, err)
+	}
+	if profiles.Profiles == nil {
+		profiles.Profiles = map[string]CLIProfile{}
+	}
+	return profiles, path, nil
+}
+
+// save writes the profiles file readable by the owner only, since it holds API keys
+func (p CLIProfiles) save(path string) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// cliOptions are the connection flags every subcommand accepts
+type cliOptions struct {
+	profile      string
+	url          string
+	apiKey       string
+	trafficToken string
+}
+
+// newCLIFlags creates the flag set of a subcommand with the connection flags already defined
+func newCLIFlags(name, usage string) (*flag.FlagSet, *cliOptions) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	opts := &cliOptions{}
+	flags.StringVar(&opts.profile, "profile", "", "profile to use instead of the current one ($HOPPER_PROFILE)")
+	flags.StringVar(&opts.url, "url", "", "hopper base URL, overriding the profile ($HOPPER_URL)")
+	flags.StringVar(&opts.apiKey, "api-key", "", "API key, overriding the profile ($HOPPER_API_KEY)")
+	flags.StringVar(&opts.trafficToken, "traffic-token", "", "traffic token, overriding the profile ($HOPPER_TRAFFIC_TOKEN)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s\n\nFlags:\n", filepath.Base(os.Args[0]), usage)
+		flags.PrintDefaults()
+	}
+	return flags, opts
+}
+
+// parseCLIFlags parses flags that may come before or after the positional arguments, so
+// "destinations rm ID --purge" works like "destinations rm --purge ID"
+func parseCLIFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// adminClient calls the admin API of one hopper
+type adminClient struct {
+	baseURL      string
+	apiKey       string
+	trafficToken string
+	http         *http.Client
+}
+
+// client resolves the connection settings: flags, then environment variables, then the
+// selected profile, then a hopper on localhost
+func (o *cliOptions) client() (*adminClient, error) {
+	profiles, path, err := loadCLIProfiles()
+	if err != nil {
+		return nil, err
+	}
+	name := firstNonEmpty(o.profile, os.Getenv("HOPPER_PROFILE"), profiles.Current)
+	profile, ok := profiles.Profiles[name]
+	if name != "" && !ok {
+		return nil, fmt.Errorf("no profile %q in %s", name, path)
+	}
+
+	c := &adminClient{
+		baseURL:      strings.TrimRight(firstNonEmpty(o.url, os.Getenv("HOPPER_URL"), profile.URL, "http://localhost:8080"), "/"),
+		apiKey:       firstNonEmpty(o.apiKey, os.Getenv("HOPPER_API_KEY"), profile.APIKey),
+		trafficToken: firstNonEmpty(o.trafficToken, os.Getenv("HOPPER_TRAFFIC_TOKEN"), profile.TrafficToken),
+		http:         &http.Client{Timeout: 60 * time.Second},
+	}
+	if c.trafficToken == "" {
+		c.trafficToken = c.apiKey
+	}
+	if _, err := url.ParseRequestURI(c.baseURL); err != nil {
+		return nil, fmt.Errorf("invalid hopper URL %q", c.baseURL)
+	}
+	return c, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// apiError is a non-2xx answer of the admin API
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.status, http.StatusText(e.status), e.message)
+}
+
+// do sends a request to the admin API (path is below /api/v1) and decodes the JSON answer into
+// out, if given
+func (c *adminClient) do(method, path string, body io.Reader, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequest(method, c.baseURL+apiPrefix+path, body)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set(apiKeyHeader, c.apiKey)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return &apiError{status: resp.StatusCode, message: apiErrorMessage(data)}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if raw, ok := out.(*json.RawMessage); ok {
+		*raw = data
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// apiErrorMessage extracts the message of an error answer: plain text from http.Error, or
+// a JSON error with optional field errors from validation
+func apiErrorMessage(data []byte) string {
+	var body struct {
+		Error  string `json:"error"`
+		Fields []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"fields"`
+	}
+	if json.Unmarshal(data, &body) != nil || body.Error == "" {
+		return strings.TrimSpace(string(data))
+	}
+	message := body.Error
+	for _, field := range body.Fields {
+		message += fmt.Sprintf("\n  %s: %s", field.Field, field.Message)
+	}
+	return message
+}
+
+// printJSON writes a value as indented JSON to stdout
+func printJSON(v interface{}) error {
+	if raw, ok := v.(json.RawMessage); ok {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return err
+		}
+		indented.WriteByte('\n')
+		_, err := indented.WriteTo(os.Stdout)
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// readInput reads a file, or stdin for "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// optionalBool is a flag that is only applied when given, e.g. --active=false
+type optionalBool struct {
+	value *bool
+}
+
+func (b *optionalBool) String() string {
+	if b == nil || b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.value = &v
+	return nil
+}
+
+func (b *optionalBool) IsBoolFlag() bool { return true }
+
+// cliDestinations handles "destinations list|add|update|rm"
+func cliDestinations(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing subcommand: list, add, update or rm")
+	}
+	switch args[0] {
+	case "list", "ls":
+		return cliDestinationsList(args[1:])
+	case "add":
+		return cliDestinationsAdd(args[1:])
+	case "update":
+		return cliDestinationsUpdate(args[1:])
+	case "rm", "delete":
+		return cliDestinationsRemove(args[1:])
+	}
+	return fmt.Errorf("unknown subcommand %q: use list, add, update or rm", args[0])
+}
+
+func cliDestinationsList(args []string) error {
+	flags, opts := newCLIFlags("destinations list", "destinations list [flags]")
+	asJSON := flags.Bool("json", false, "print the destinations as JSON")
+	archived := flags.Bool("archived", false, "list archived destinations")
+	group := flags.String("group", "", "only destinations in this group")
+	tag := flags.String("tag", "", "only destinations with this tag")
+	namespace := flags.String("namespace", "", "only destinations in this namespace, \"default\" for none")
+	search := flags.String("q", "", "only destinations whose URL contains this text")
+	if _, err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"sort": {"url"}}
+	if *archived {
+		query.Set("archived", "true")
+	}
+	for name, value := range map[string]string{"group": *group, "tag": *tag, "namespace": *namespace, "q": *search} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	var raw json.RawMessage
+	if err := c.do("GET", "/destinations?"+query.Encode(), nil, nil, &raw); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(raw)
+	}
+	var destinations []Destination
+	if err := json.Unmarshal(raw, &destinations); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tURL\tMETHODS\tNAMESPACE\tGROUP\tACTIVE\tDEFAULT\tVERSION")
+	for _, d := range destinations {
+		methods := strings.Join(d.Methods, ",")
+		if methods == "" {
+			methods = "all"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%t\t%d\n", d.ID.Hex(), d.URL, methods, d.Namespace, d.Group, d.IsActive, d.IsDefault, d.Version)
+	}
+	return w.Flush()
+}
+
+func cliDestinationsAdd(args []string) error {
+	flags, opts := newCLIFlags("destinations add", "destinations add (-f FILE | --to URL) [flags]")
+	file := flags.String("f", "", "destination as JSON, \"-\" for stdin")
+	destinationURL := flags.String("to", "", "URL of a destination with no other settings, instead of -f")
+	inactive := flags.Bool("inactive", false, "with --to, add the destination inactive")
+	namespace := flags.String("namespace", "", "with --to, add the destination to this namespace")
+	if _, err := parseCLIFlags(flags, args); err != nil {
+		return err
+	}
+	if (*file == "") == (*destinationURL == "") {
+		return errors.New("give either -f FILE or --to URL")
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if *file != "" {
+		if body, err = readInput(*file); err != nil {
+			return err
+		}
+	} else {
+		destination := map[string]interface{}{"url": *destinationURL, "isActive": !*inactive}
+		if *namespace != "" {
+			destination["namespace"] = *namespace
+		}
+		if body, err = json.Marshal(destination); err != nil {
+			return err
+		}
+	}
+	var created json.RawMessage
+	if err := c.do("POST", "/destinations", bytes.NewReader(body), nil, &created); err != nil {
+		return err
+	}
+	return printJSON(created)
+}
+
+func cliDestinationsUpdate(args []string) error {
+	flags, opts := newCLIFlags("destinations update", "destinations update ID [flags]")
+	file := flags.String("f", "", "fields to change as JSON, \"-\" for stdin")
+	var active, isDefault optionalBool
+	flags.Var(&active, "active", "activate (--active) or deactivate (--active=false) the destination")
+	flags.Var(&isDefault, "default", "make the destination a default (--default) or not (--default=false)")
+	version := flags.Int64("version", 0, "version the change is based on; the current version when 0")
+	force := flags.Bool("force", false, "skip the version check (If-Match: *)")
+	positional, err := parseCLIFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if *file == "" && active.value == nil && isDefault.value == nil {
+		return errors.New("nothing to change: give -f FILE, --active or --default")
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+	id := positional[0]
+
+	if *file != "" || active.value != nil {
+		fields := map[string]interface{}{}
+		if *file != "" {
+			data, err := readInput(*file)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &fields); err != nil {
+				return fmt.Errorf("invalid JSON in %s: %v", *file, err)
+			}
+		}
+		if active.value != nil {
+			fields["isActive"] = *active.value
+		}
+		// A PUT only changes the fields it names and needs the version it is based on
+		ifMatch := "*"
+		if !*force {
+			if *version == 0 {
+				current, err := c.findDestination(id)
+				if err != nil {
+					return err
+				}
+				*version = current.Version
+			}
+			ifMatch = `"` + strconv.FormatInt(*version, 10) + `"`
+		}
+		body, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		if err := c.do("PUT", "/destinations/"+url.PathEscape(id), bytes.NewReader(body), map[string]string{"If-Match": ifMatch}, nil); err != nil {
+			return err
+		}
+	}
+	if isDefault.value != nil {
+		method := "POST"
+		if !*isDefault.value {
+			method = "DELETE"
+		}
+		if err := c.do(method, "/destinations/"+url.PathEscape(id)+"/default", nil, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	updated, err := c.findDestination(id)
+	if err != nil {
+		return err
+	}
+	return printJSON(updated)
+}
+
+// findDestination reads a destination, archived or not
+func (c *adminClient) findDestination(id string) (Destination, error) {
+	var destination Destination
+	err := c.do("GET", "/destinations/"+url.PathEscape(id), nil, nil, &destination)
+	return destination, err
+}
+
+func cliDestinationsRemove(args []string) error {
+	flags, opts := newCLIFlags("destinations rm", "destinations rm ID... [flags]")
+	purge := flags.Bool("purge", false, "delete for good instead of archiving")
+	positional, err := parseCLIFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	query := ""
+	if *purge {
+		query = "?purge=true"
+	}
+	for _, id := range positional {
+		if err := c.do("DELETE", "/destinations/"+url.PathEscape(id)+query, nil, nil, nil); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
+
+// cliTraffic handles "traffic tail", which follows the SSE stream until interrupted
+func cliTraffic(args []string) error {
+	if len(args) == 0 || args[0] != "tail" {
+		return errors.New("missing subcommand: tail")
+	}
+	flags, opts := newCLIFlags("traffic tail", "traffic tail [flags]")
+	asJSON := flags.Bool("json", false, "print every event as a line of JSON")
+	filters := map[string]*string{
+		"path":        flags.String("path", "", "only requests below this path prefix"),
+		"status":      flags.String("status", "", "only responses with this status class, e.g. 5xx"),
+		"type":        flags.String("type", "", "only these event types, comma-separated"),
+		"method":      flags.String("method", "", "only these methods, comma-separated"),
+		"destination": flags.String("destination", "", "only events for this destination ID"),
+		"namespace":   flags.String("namespace", "", "only requests in this namespace, \"default\" for none"),
+	}
+	history := flags.Int("history", -1, "recent events to print first; the server default when negative")
+	if _, err := parseCLIFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for name, value := range filters {
+		if *value != "" {
+			query.Set(name, *value)
+		}
+	}
+	if *history >= 0 {
+		query.Set("history", strconv.Itoa(*history))
+	}
+	req, err := http.NewRequest("GET", c.baseURL+apiPrefix+"/traffic/sse?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.trafficToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.trafficToken)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// The stream stays open, so it has no overall timeout
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &apiError{status: resp.StatusCode, message: apiErrorMessage(data)}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue // Keep-alive comments and frame separators
+		}
+		if *asJSON {
+			fmt.Println(data)
+			continue
+		}
+		var event TrafficEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			fmt.Println(data)
+			continue
+		}
+		fmt.Println(formatTrafficEvent(event))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("the traffic stream was closed by the server")
+}
+
+// formatTrafficEvent renders an event as one line, like the dashboard does
+func formatTrafficEvent(e TrafficEvent) string {
+	timestamp := e.Timestamp.Local().Format("15:04:05.000")
+	switch e.Type {
+	case EventDropped:
+		return fmt.Sprintf("%s DROPPED %s", timestamp, e.Message)
+	case EventSchedule:
+		return fmt.Sprintf("%s SCHEDULE %s: %s", timestamp, e.Destination, e.Message)
+	}
+	path := e.Path
+	if e.Query != "" {
+		path += "?" + e.Query
+	}
+	parts := []string{timestamp, "[" + e.RequestID + "]", strings.ToUpper(e.Type), e.Method, path}
+	if e.Destination != "" {
+		destination := "-> " + e.Destination
+		if e.IsDefault {
+			destination += " (default)"
+		}
+		parts = append(parts, destination)
+	}
+	if e.Status != 0 {
+		parts = append(parts, strconv.Itoa(e.Status))
+	}
+	if e.LatencyMs != 0 {
+		parts = append(parts, strconv.FormatFloat(e.LatencyMs, 'f', 1, 64)+"ms")
+	}
+	if e.Error != "" {
+		parts = append(parts, "error: "+e.Error)
+	}
+	if e.Message != "" {
+		parts = append(parts, e.Message)
+	}
+	return strings.Join(parts, " ")
+}
+
+// cliReplay handles "replay CAPTURE_ID" and "replay import FILE"
+func cliReplay(args []string) error {
+	if len(args) > 0 && args[0] == "import" {
+		return cliReplayImport(args[1:])
+	}
+	flags, opts := newCLIFlags("replay", "replay CAPTURE_ID [flags] | replay import FILE [flags]")
+	destination := flags.String("destination", "", "replay to this destination ID only")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	positional, err := parseCLIFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	path := "/captures/" + url.PathEscape(positional[0]) + "/replay"
+	if *destination != "" {
+		path += "?destination=" + url.QueryEscape(*destination)
+	}
+	var raw json.RawMessage
+	if err := c.do("POST", path, nil, nil, &raw); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(raw)
+	}
+	var result ReplayResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return err
+	}
+	printReplay(result.RequestID, result.Error, result.Responses)
+	return nil
+}
+
+func cliReplayImport(args []string) error {
+	flags, opts := newCLIFlags("replay import", "replay import FILE [flags]")
+	format := flags.String("format", "", "har or postman; detected from the file when empty")
+	destination := flags.String("destination", "", "replay to this destination ID only")
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	positional, err := parseCLIFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+	data, err := readInput(positional[0])
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *format != "" {
+		query.Set("format", *format)
+	}
+	if *destination != "" {
+		query.Set("destination", *destination)
+	}
+	var raw json.RawMessage
+	if err := c.do("POST", "/replay/import?"+query.Encode(), bytes.NewReader(data), nil, &raw); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(raw)
+	}
+	var results []ImportedReplayResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return err
+	}
+	for _, result := range results {
+		fmt.Printf("#%d %s %s\n", result.Index, result.Method, result.URL)
+		printReplay(result.RequestID, result.Error, result.Responses)
+	}
+	return nil
+}
+
+// printReplay prints the responses of one replayed request, one line per destination
+func printReplay(requestID, replayError string, responses []CapturedResponse) {
+	fmt.Printf("  request %s\n", requestID)
+	if replayError != "" {
+		fmt.Printf("  error: %s\n", replayError)
+	}
+	for _, response := range responses {
+		outcome := strconv.Itoa(response.Status)
+		if response.Error != "" {
+			outcome = "error: " + response.Error
+		}
+		marker := ""
+		if response.IsDefault {
+			marker = " (default)"
+		}
+		fmt.Printf("  -> %s%s %s %dms\n", response.URL, marker, outcome, response.LatencyMs)
+	}
+}
+
+// cliProfile handles "profile list|add|use|rm", which only edit the profiles file
+func cliProfile(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing subcommand: list, add, use or rm")
+	}
+	profiles, path, err := loadCLIProfiles()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		names := make([]string, 0, len(profiles.Profiles))
+		for name := range profiles.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "\tNAME\tURL\tAPI KEY")
+		for _, name := range names {
+			current := ""
+			if name == profiles.Current {
+				current = "*"
+			}
+			hasKey := "no"
+			if profiles.Profiles[name].APIKey != "" {
+				hasKey = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", current, name, profiles.Profiles[name].URL, hasKey)
+		}
+		return w.Flush()
+
+	case "add", "set":
+		flags := flag.NewFlagSet("profile add", flag.ContinueOnError)
+		var profile CLIProfile
+		flags.StringVar(&profile.URL, "url", "", "hopper base URL, e.g. https://hopper.staging.internal")
+		flags.StringVar(&profile.APIKey, "api-key", "", "API key for the admin API")
+		flags.StringVar(&profile.TrafficToken, "traffic-token", "", "traffic token for traffic tail; the API key when empty")
+		use := flags.Bool("use", false, "make it the current profile")
+		positional, err := parseCLIFlags(flags, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 || profile.URL == "" {
+			return errors.New("usage: profile add NAME --url URL [--api-key KEY] [--traffic-token TOKEN] [--use]")
+		}
+		if _, err := url.ParseRequestURI(profile.URL); err != nil {
+			return fmt.Errorf("invalid URL %q", profile.URL)
+		}
+		profiles.Profiles[positional[0]] = profile
+		// The first profile becomes the current one
+		if *use || profiles.Current == "" {
+			profiles.Current = positional[0]
+		}
+		return profiles.save(path)
+
+	case "use":
+		if len(args) != 2 {
+			return errors.New("usage: profile use NAME")
+		}
+		if _, ok := profiles.Profiles[args[1]]; !ok {
+			return fmt.Errorf("no profile %q in %s", args[1], path)
+		}
+		profiles.Current = args[1]
+		return profiles.save(path)
+
+	case "rm", "delete":
+		if len(args) != 2 {
+			return errors.New("usage: profile rm NAME")
+		}
+		if _, ok := profiles.Profiles[args[1]]; !ok {
+			return fmt.Errorf("no profile %q in %s", args[1], path)
+		}
+		delete(profiles.Profiles, args[1])
+		if profiles.Current == args[1] {
+			profiles.Current = ""
+		}
+		return profiles.save(path)
+	}
+	return fmt.Errorf("unknown subcommand %q: use list, add, use or rm", args[0])
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings the hopper decodes, and produces toward clients
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+)
+
+// contentCodings returns the codings of a Content-Encoding header in the order they were applied
+func contentCodings(header http.Header) []string {
+	var codings []string
+	for _, value := range header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// decodeBody undoes the Content-Encoding of a complete body. Bodies that decode to more than
+// forwarding.max_buffered_body_bytes are refused, so a small compressed body can't exhaust
+// memory.
+func decodeBody(header http.Header, body []byte) ([]byte, error) {
+	codings := contentCodings(header)
+	limit := currentConfig().Forwarding.MaxBufferedBodyBytes
+	for i := len(codings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		switch codings[i] {
+		case EncodingGzip, "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case EncodingDeflate:
+			// Some servers send raw deflate instead of zlib-wrapped deflate
+			if reader, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		case EncodingBrotli:
+			reader = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content coding %q", codings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s body: %v", codings[i], err)
+		}
+		decoded, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s body: %v", codings[i], err)
+		}
+		if int64(len(decoded)) > limit {
+			return nil, fmt.Errorf("%s body decodes to more than %d bytes", codings[i], limit)
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// readableBody returns the body decoded for logs, traffic events and routing rules, or as is
+// when it can't be decoded
+func readableBody(header http.Header, body []byte) []byte {
+	if decoded, err := decodeBody(header, body); err == nil {
+		return decoded
+	}
+	return body
+}
+
+// acceptedCoding returns the q-value the client's Accept-Encoding gives a coding. Without the
+// header any coding is acceptable; identity is acceptable unless explicitly refused.
+func acceptedCoding(r *http.Request, coding string) float64 {
+	accept := r.Header.Values("Accept-Encoding")
+	if len(accept) == 0 {
+		return 1
+	}
+	wildcard := -1.0
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+			switch name {
+			case coding:
+				return q
+			case "*":
+				wildcard = q
+			}
+		}
+	}
+	if wildcard >= 0 {
+		return wildcard
+	}
+	if coding == "identity" {
+		return 1
+	}
+	return 0
+}
+
+// acceptableBody makes a stored response (response cache, dedup) fit the client: a body in a
+// coding the client doesn't accept is decoded and header updated to match
+func acceptableBody(r *http.Request, header http.Header, body []byte) ([]byte, error) {
+	codings := contentCodings(header)
+	accepted := true
+	for _, coding := range codings {
+		if acceptedCoding(r, coding) <= 0 {
+			accepted = false
+		}
+	}
+	if accepted {
+		return body, nil
+	}
+	decoded, err := decodeBody(header, body)
+	if err != nil {
+		return nil, err
+	}
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(decoded)))
+	return decoded, nil
+}
+
+// defaultCompressibleTypes are compressed when compression.content_types is empty
+var defaultCompressibleTypes = []string{
+	"text/", "application/json", "application/javascript", "application/xml",
+	"application/x-ndjson", "application/graphql-response+json", "image/svg+xml",
+}
+
+// compressible reports whether a Content-Type is in compression.content_types; entries ending
+// in / match every subtype, and +json and +xml types count as JSON and XML
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := currentConfig().Compression.ContentTypes
+	if len(types) == 0 {
+		types = defaultCompressibleTypes
+	}
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return strings.HasSuffix(mediaType, "+json") && contains(types, "application/json") ||
+		strings.HasSuffix(mediaType, "+xml") && contains(types, "application/xml")
+}
+
+// responseCoding picks the coding to compress a response with, or "" to send it as is. The
+// response headers must already be set in w.
+func responseCoding(w http.ResponseWriter, r *http.Request, status int, contentLength int64) string {
+	cfg := requestConfig(r.Context()).Compression
+	header := w.Header()
+	if !cfg.Enabled || r.Method == http.MethodHead || status < 200 || status == http.StatusNoContent ||
+		status == http.StatusPartialContent || status == http.StatusNotModified {
+		return ""
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" ||
+		strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") {
+		return ""
+	}
+	if contentLength >= 0 && contentLength < cfg.MinBytes || !compressible(header.Get("Content-Type")) {
+		return ""
+	}
+	if len(r.Header.Values("Accept-Encoding")) == 0 {
+		return "" // The client didn't ask for compression
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range cfg.Encodings {
+		if q := acceptedCoding(r, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressedResponseWriter compresses everything written to it
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (c *compressedResponseWriter) Write(p []byte) (int, error) {
+	return c.encoder.Write(p)
+}
+
+// Flush sends what was compressed so far, so streamed responses keep flowing
+func (c *compressedResponseWriter) Flush() {
+	c.encoder.Flush()
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// compressResponse compresses the response toward the client when compression is enabled and
+// the client accepts one of compression.encodings. It must be called after the response headers
+// are set in w and before WriteHeader; the body is then written to the returned writer and
+// finish called at the end. Otherwise w is returned as is.
+func compressResponse(w http.ResponseWriter, r *http.Request, status int, contentLength int64) (http.ResponseWriter, func()) {
+	coding := responseCoding(w, r, status, contentLength)
+	if coding == "" {
+		return w, func() {}
+	}
+	header := w.Header()
+	header.Set("Content-Encoding", coding)
+	header.Del("Content-Length")
+	if !strings.Contains(strings.ToLower(strings.Join(header.Values("Vary"), ",")), "accept-encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag) // The compressed bytes differ from the upstream's
+	}
+
+	cw := &compressedResponseWriter{ResponseWriter: w}
+	level := requestConfig(r.Context()).Compression.Level
+	switch coding {
+	case EncodingBrotli:
+		cw.encoder = brotli.NewWriterLevel(w, level)
+	default:
+		if level > gzip.BestCompression {
+			level = gzip.BestCompression
+		}
+		cw.encoder, _ = gzip.NewWriterLevel(w, level)
+	}
+	return cw, func() {
+		if err := cw.encoder.Close(); err != nil {
+			log.Printf("[%s] Error compressing the response: %v", requestIDFromContext(r.Context()), err)
+		}
+	}
+}
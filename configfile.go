@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/tls"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v2"
+)
+
+// configPath (--config) is the configuration file; its extension selects the format
+var configPath = flag.String("config", "./config.yaml", "configuration file (.yaml, .yml, .json or .toml)")
+
+// defaultConfig holds the built-in defaults, used when there is no config file so the binary
+// runs from any directory
+//
+//go:embed defaults.yaml
+var defaultConfig []byte
+
+// configPathSet reports whether --config was given
+func configPathSet() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			set = true
+		}
+	})
+	return set
+}
+
+// validateOnly (--validate-config) checks the configuration and exits
+var validateOnly = flag.Bool("validate-config", false, "check the configuration and exit with a non-zero code if it is invalid, without starting")
+
+// decodeConfig parses a configuration file by its extension. JSON and TOML use the same keys
+// as YAML, so they are decoded generically and then converted to YAML, which keeps the yaml
+// tags on Config the single source of field names. Strict decoding rejects unknown and
+// duplicate keys.
+func decodeConfig(path string, data []byte, cfg *Config, strict bool) error {
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", "":
+		return unmarshal(data, cfg)
+	case ".json":
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q: use .yaml, .yml, .json or .toml", filepath.Ext(path))
+	}
+	converted, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return unmarshal(converted, cfg)
+}
+
+// validateConfigFile implements --validate-config. The configuration is read as on a normal
+// start, including overrides and secret references, and then checked for unknown keys and for
+// the mistakes that would otherwise only show when connecting or listening. Nothing is started
+// and no database is contacted. It returns the exit code.
+func validateConfigFile() int {
+	var problems []string
+	cfg, err := readConfig()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		if data, err := ioutil.ReadFile(*configPath); err == nil {
+			if err := decodeConfig(*configPath, data, &Config{}, true); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		liveConfig.Store(&cfg)
+		problems = append(problems, checkConfig(&cfg)...)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Configuration %s is valid\n", *configPath)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Configuration %s is invalid:\n", *configPath)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+	}
+	return 1
+}
+
+// checkConfig returns the problems in cfg that readConfig leaves to startup: connection URLs,
+// certificates and the like
+func checkConfig(cfg *Config) []string {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	switch cfg.Storage.Driver {
+	case "mongodb":
+		_, err := mongoClientOptions(cfg.MongoDB)
+		check(err)
+	case "redis":
+		if _, err := redis.ParseURL(cfg.Storage.Redis.URL); err != nil {
+			check(fmt.Errorf("invalid redis url: %v", err))
+		}
+	case "postgres":
+		if _, err := pgxpool.ParseConfig(cfg.Storage.Postgres.URL); err != nil {
+			check(fmt.Errorf("invalid postgres url: %v", err))
+		}
+	}
+
+	if cfg.App.TLS.Enabled() {
+		_, err := buildServerTLSConfig()
+		if err != nil {
+			check(fmt.Errorf("invalid TLS configuration: %v", err))
+		}
+		if cfg.App.TLS.CertFile != "" && !cfg.App.TLS.ACME.Enabled {
+			if _, err := tls.LoadX509KeyPair(cfg.App.TLS.CertFile, cfg.App.TLS.KeyFile); err != nil {
+				check(fmt.Errorf("invalid TLS certificate: %v", err))
+			}
+		}
+		check(checkConfigURL("app.tls.acme.directory_url", cfg.App.TLS.ACME.DirectoryURL))
+	}
+	if cfg.App.HTTP3.Enabled && !cfg.App.TLS.ACME.Enabled {
+		if _, err := tls.LoadX509KeyPair(cfg.App.HTTP3.CertFile, cfg.App.HTTP3.KeyFile); err != nil {
+			check(fmt.Errorf("invalid HTTP/3 certificate: %v", err))
+		}
+	}
+	if cfg.Tracing.Enabled {
+		check(checkConfigURL("tracing.endpoint", cfg.Tracing.Endpoint))
+	}
+	if cfg.Auth.OIDC.Enabled {
+		check(checkConfigURL("auth.oidc.issuer", cfg.Auth.OIDC.Issuer))
+		check(checkConfigURL("auth.oidc.jwks_url", cfg.Auth.OIDC.JWKSURL))
+	}
+	if rotation := cfg.Logging.AccessLog.Rotation; cfg.Logging.AccessLog.Enabled && rotation != "" {
+		if interval, err := time.ParseDuration(rotation); err != nil || interval <= 0 {
+			check(fmt.Errorf("invalid access_log rotation %q", rotation))
+		}
+	}
+	return problems
+}
+
+// checkConfigURL checks an optional http(s) URL setting
+func checkConfigURL(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an http or https URL", name, value)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// configureUpstreamTransport applies the upstream settings to the shared transport; it runs at
+// startup, before any destination transport is cloned from it
+func configureUpstreamTransport(cfg UpstreamConfig) {
+	upstreamTransport.MaxIdleConns = cfg.MaxIdleConns
+	upstreamTransport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	upstreamTransport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	upstreamTransport.IdleConnTimeout = cfg.idleConnTimeout
+	if cfg.Proxy != "" {
+		upstreamTransport.Proxy, _ = proxyFunc(cfg.Proxy) // Checked by readConfig
+		if u, err := url.Parse(cfg.Proxy); err == nil {
+			log.Printf("Upstream proxy: %s", u.Redacted())
+		}
+	}
+	if cfg.DNS.Enabled {
+		resolver = newUpstreamResolver(cfg.DNS)
+		upstreamTransport.DialContext = resolver.DialContext
+		servers := "the system resolver"
+		if len(cfg.DNS.Servers) > 0 {
+			servers = strings.Join(cfg.DNS.Servers, ", ")
+		}
+		log.Printf("Upstream DNS: resolving with %s, answers cached", servers)
+	}
+	if cfg.DisableHTTP2 {
+		upstreamTransport.ForceAttemptHTTP2 = false
+		upstreamTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	log.Printf("Upstream connections: %d idle per host, %d idle in total, idle timeout %s, HTTP/2 %t",
+		cfg.MaxIdleConnsPerHost, cfg.MaxIdleConns, cfg.IdleConnTimeout, !cfg.DisableHTTP2)
+}
+
+// connectionStats counts how requests to one destination got their connection
+type connectionStats struct {
+	reused       atomic.Uint64
+	opened       atomic.Uint64
+	connectNanos atomic.Int64 // Time spent opening connections: DNS, TCP and TLS
+	id           string       // Empty for destinations without an ID
+	mu           sync.Mutex
+	url          string
+	protocols    map[string]uint64 // Responses by protocol, e.g. "HTTP/2.0"
+}
+
+// destinationConnections holds a *connectionStats per destination ID (URL for destinations
+// without one); counters start when the hopper does
+var destinationConnections sync.Map
+
+// traceConnection returns req with a trace that records in the destination's stats whether it
+// went out on a kept-alive connection; call the returned function with the response
+func traceConnection(req *http.Request, destination Destination) (*http.Request, func(*http.Response)) {
+	id := ""
+	if !destination.ID.IsZero() {
+		id = destination.ID.Hex()
+	}
+	key := id
+	if key == "" {
+		key = destination.URL
+	}
+	value, _ := destinationConnections.LoadOrStore(key, &connectionStats{id: id, url: destination.URL, protocols: make(map[string]uint64)})
+	stats := value.(*connectionStats)
+
+	var getConn time.Time
+	connected := false
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			getConn = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			connected = true
+			if info.Reused {
+				stats.reused.Add(1)
+			} else {
+				stats.opened.Add(1)
+				stats.connectNanos.Add(int64(time.Since(getConn)))
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), func(resp *http.Response) {
+		stats.mu.Lock()
+		stats.url = destination.URL // Follows URL changes
+		defer stats.mu.Unlock()
+		if !connected || resp == nil {
+			return // Failed before a connection was had, or a fault answered instead
+		}
+		stats.protocols[resp.Proto]++
+	}
+}
+
+// DestinationConnections is one destination's entry in GET /admin/connections
+type DestinationConnections struct {
+	DestinationID     string            `json:"destinationId,omitempty"`
+	Destination       string            `json:"destination"`
+	Requests          uint64            `json:"requests"`
+	ReusedConnections uint64            `json:"reusedConnections"` // Requests sent on a kept-alive connection (or HTTP/2 stream)
+	NewConnections    uint64            `json:"newConnections"`
+	ReuseRatio        float64           `json:"reuseRatio"`
+	AvgConnectMs      float64           `json:"avgConnectMs"` // Average time to open a new connection
+	Protocols         map[string]uint64 `json:"protocols"`
+}
+
+// GetConnections serves GET /admin/connections: how often connections to each destination were
+// reused and which protocols were negotiated, since the hopper started
+func GetConnections(w http.ResponseWriter, r *http.Request) {
+	entries := []DestinationConnections{}
+	destinationConnections.Range(func(_, value interface{}) bool {
+		stats := value.(*connectionStats)
+		entry := DestinationConnections{
+			DestinationID:     stats.id,
+			ReusedConnections: stats.reused.Load(),
+			NewConnections:    stats.opened.Load(),
+			Protocols:         make(map[string]uint64),
+		}
+		entry.Requests = entry.ReusedConnections + entry.NewConnections
+		if entry.Requests > 0 {
+			entry.ReuseRatio = float64(entry.ReusedConnections) / float64(entry.Requests)
+		}
+		if entry.NewConnections > 0 {
+			entry.AvgConnectMs = millis(time.Duration(stats.connectNanos.Load() / int64(entry.NewConnections)))
+		}
+		stats.mu.Lock()
+		entry.Destination = stats.url
+		for proto, count := range stats.protocols {
+			entry.Protocols[proto] = count
+		}
+		stats.mu.Unlock()
+		entries = append(entries, entry)
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Destination < entries[j].Destination })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"http2":        !requestConfig(r.Context()).Upstream.DisableHTTP2,
+		"destinations": entries,
+	})
+}
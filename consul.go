@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consulClient talks to the Consul HTTP API of the local agent
+type consulClient struct {
+	cfg    ConsulDiscoveryConfig
+	client *http.Client
+}
+
+// consulServiceEntry is a subset of an entry returned by /v1/health/service/<name>
+type consulServiceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Service string   `json:"Service"`
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+		Tags    []string `json:"Tags"`
+	} `json:"Service"`
+}
+
+func newConsulClient(cfg ConsulDiscoveryConfig) *consulClient {
+	return &consulClient{cfg: cfg, client: &http.Client{}}
+}
+
+// do sends a request to the agent; query may be nil
+func (c *consulClient) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.cfg.Datacenter != "" {
+		query.Set("dc", c.cfg.Datacenter)
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.cfg.Address, "/")+path+"?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// healthyInstances returns the passing instances of a service and the index for the next
+// blocking query; with index > 0 the call waits up to wait for a change
+func (c *consulClient) healthyInstances(ctx context.Context, service string, index uint64, wait time.Duration) ([]consulServiceEntry, uint64, error) {
+	query := url.Values{"passing": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", wait.String())
+	}
+	resp, err := c.do(ctx, "GET", "/v1/health/service/"+url.PathEscape(service), query, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Consul Health Error for %s: %v", service, err)
+	}
+	defer resp.Body.Close()
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("Consul Decode Error for %s: %v", service, err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return entries, next, nil
+}
+
+// startConsulDiscovery keeps one destination per passing instance of the configured services,
+// using blocking queries to follow changes as they happen
+func startConsulDiscovery(cfg ConsulDiscoveryConfig) {
+	c := newConsulClient(cfg)
+	trigger := make(chan struct{}, 1)
+	for _, service := range cfg.Services {
+		go c.watchService(service.Name, trigger)
+	}
+	go runDiscovery("consul", cfg.resyncInterval, trigger, c.targets)
+	names := make([]string, 0, len(cfg.Services))
+	for _, service := range cfg.Services {
+		names = append(names, service.Name)
+	}
+	log.Printf("Consul discovery enabled for %s via %s", strings.Join(names, ", "), cfg.Address)
+}
+
+// watchService triggers a reconciliation whenever the service's instances or their health change
+func (c *consulClient) watchService(service string, trigger chan struct{}) {
+	var index uint64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 6*time.Minute)
+		_, next, err := c.healthyInstances(ctx, service, index, 5*time.Minute)
+		cancel()
+		if err != nil {
+			log.Printf("Consul watch of %s failed: %v", service, err)
+			index = 0
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if index > 0 && next != index {
+			signalDiscovery(trigger)
+		}
+		if next < index {
+			next = 0 // The index went backwards (e.g. a Consul restart); start over
+		}
+		index = next
+	}
+}
+
+// targets lists the passing instances that carry all of the service's configured tags
+func (c *consulClient) targets(ctx context.Context) ([]Destination, error) {
+	targets := []Destination{}
+	for _, service := range c.cfg.Services {
+		entries, _, err := c.healthyInstances(ctx, service.Name, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !hasAllTags(entry.Service.Tags, service.Tags) {
+				continue
+			}
+			address := entry.Service.Address
+			if address == "" {
+				address = entry.Node.Address
+			}
+			hostPort := net.JoinHostPort(address, strconv.Itoa(entry.Service.Port))
+			targets = append(targets, Destination{
+				URL:       fmt.Sprintf("%s://%s%s", service.Scheme, hostPort, service.Path),
+				IsActive:  true,
+				IsDefault: service.Default,
+				Tags:      entry.Service.Tags,
+				Discovery: &DestinationDiscovery{Key: service.Name + "/" + entry.Node.Node + "/" + entry.Service.ID},
+			})
+		}
+	}
+	return targets, nil
+}
+
+func hasAllTags(tags, required []string) bool {
+	for _, tag := range required {
+		if !contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// consulServiceID is the ID the hopper registers itself under
+func consulServiceID(cfg ConsulRegistrationConfig) string {
+	if cfg.ID != "" {
+		return cfg.ID
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s-%d", cfg.Name, hostname, cfg.Port)
+}
+
+// registerWithConsul registers the hopper as a service with an HTTP check against its readiness
+// probe (health.readiness_path), so
+// Consul stops routing to it while it is draining
+func registerWithConsul(cfg ConsulDiscoveryConfig) error {
+	reg := cfg.Register
+	scheme := "http"
+	if currentConfig().App.TLS.Enabled() {
+		scheme = "https"
+	}
+	checkHost := reg.Address
+	if checkHost == "" {
+		checkHost = "127.0.0.1" // The check runs on the local agent
+	}
+	service := map[string]interface{}{
+		"ID":      consulServiceID(reg),
+		"Name":    reg.Name,
+		"Address": reg.Address,
+		"Port":    reg.Port,
+		"Tags":    reg.Tags,
+		"Check": map[string]interface{}{
+			"HTTP":                           fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(checkHost, strconv.Itoa(reg.Port)), currentConfig().Health.ReadinessPath),
+			"Interval":                       reg.CheckInterval,
+			"TLSSkipVerify":                  scheme == "https",
+			"DeregisterCriticalServiceAfter": "10m",
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := newConsulClient(cfg).do(ctx, "PUT", "/v1/agent/service/register", nil, service)
+	if err != nil {
+		return fmt.Errorf("Consul Register Error: %v", err)
+	}
+	resp.Body.Close()
+	log.Printf("Registered with Consul as %s (%s)", reg.Name, consulServiceID(reg))
+	return nil
+}
+
+// deregisterFromConsul removes the registration on shutdown
+func deregisterFromConsul(cfg ConsulDiscoveryConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := newConsulClient(cfg).do(ctx, "PUT", "/v1/agent/service/deregister/"+url.PathEscape(consulServiceID(cfg.Register)), nil, nil)
+	if err != nil {
+		log.Printf("Consul Deregister Error: %v", err)
+		return
+	}
+	resp.Body.Close()
+	log.Println("Deregistered from Consul")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Management route templates that answer CORS preflight requests
+var corsPaths = []string{
+	"/destinations", "/destinations/{id}", "/destinations/export", "/destinations/import", "/destinations/{id}/test", "/destinations/{id}/restore", "/destinations/{id}/default",
+	"/destinations/{id}/history", "/destinations/{id}/rollback/{version}", "/destinations/metrics", "/destinations/{id}/metrics",
+	"/audit", "/groups", "/groups/{name}", "/groups/{name}/activate", "/groups/{name}/deactivate",
+	"/captures", "/captures/replay", "/captures/export", "/replay/import", "/captures/{id}", "/captures/{id}/replay",
+	"/deadletters", "/deadletters/retry", "/deadletters/{id}/retry",
+	"/apikeys", "/apikeys/{id}", "/admin/drain", "/admin/reload", "/admin/faults", "/admin/faults/{id}", "/admin/connections", "/admin/dns", "/admin/workers",
+	"/traffic", "/traffic/sse", "/traffic/stats", "/openapi.json",
+}
+
+// corsEnabled reports whether any origin is allowed
+func corsEnabled(cfg *Config) bool {
+	return len(cfg.CORS.AllowedOrigins) > 0
+}
+
+// originAllowed reports whether the origin is in cors.allowed_origins ("*" allows any)
+func originAllowed(cfg *Config, origin string) bool {
+	for _, allowed := range cfg.CORS.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORSHeaders adds the headers that let the browser read the response. The origin is
+// echoed rather than sent as "*" so that credentials work when enabled.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	cfg := requestConfig(r.Context())
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !originAllowed(cfg, origin) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if cfg.CORS.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(cfg.CORS.ExposedHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(cfg.CORS.ExposedHeaders, ", "))
+	}
+	return true
+}
+
+// withCORS adds CORS headers to the responses of a management route
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if corsEnabled(requestConfig(r.Context())) {
+			setCORSHeaders(w, r)
+		}
+		next(w, r)
+	}
+}
+
+// corsPreflight answers OPTIONS preflight requests for the management routes
+func corsPreflight(w http.ResponseWriter, r *http.Request) {
+	if setCORSHeaders(w, r) {
+		cfg := requestConfig(r.Context())
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(cfg.CORS.AllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.CORS.AllowedHeaders, ", "))
+		if cfg.CORS.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.CORS.MaxAge))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkWebSocketOrigin limits /traffic WebSocket connections to cors.allowed_origins when
+// CORS is configured; browsers don't apply CORS to WebSockets, so the check happens here
+func checkWebSocketOrigin(r *http.Request) bool {
+	cfg := requestConfig(r.Context())
+	origin := r.Header.Get("Origin")
+	if !corsEnabled(cfg) || origin == "" {
+		return true
+	}
+	return originAllowed(cfg, origin)
+}
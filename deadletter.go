@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// BulkRetryRequest selects the dead letters to retry
+type BulkRetryRequest struct {
+	DestinationID string `json:"destinationId"`
+	Since         string `json:"since"`
+	Limit         int    `json:"limit"`
+}
+
+// RetryResult describes the outcome of retrying one dead letter
+type RetryResult struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// retryDeadLetter moves a dead letter back into the queue and records it in the audit log
+func retryDeadLetter(r *http.Request, id string) error {
+	d, err := deliveryQueue.Redeliver(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	log.Printf("[%s] Dead letter %s (request %s) queued again for %s", requestIDFromContext(r.Context()), id, d.RequestID, d.URL)
+	recordAudit(r, AuditEntry{Action: AuditRetry, Resource: AuditDeadLetter, ResourceID: id, Details: d.URL})
+	select {
+	case deliveryWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// GetDeadLetters lists deliveries that were given up, newest first, filtered by destination and age
+func GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := DeadLetterFilter{DestinationID: query.Get("destinationId"), Limit: 100}
+	if since := query.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "Invalid limit parameter (1-1000)", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	letters, err := deliveryQueue.DeadLetters(r.Context(), filter)
+	if err == errInvalidID {
+		http.Error(w, "Invalid destinationId parameter", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting dead letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(letters); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding dead letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RetryDeadLetter queues a dead letter again with a fresh set of attempts
+func RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := retryDeadLetter(r, id)
+	if err == errInvalidID {
+		http.Error(w, "Invalid dead letter ID", http.StatusBadRequest)
+		return
+	}
+	if err == errNotFound {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrying dead letter: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(RetryResult{ID: id})
+}
+
+// RetryDeadLetters queues every dead letter matching the filter again, e.g. after an upstream
+// outage: POST /deadletters/retry {"destinationId": "...", "since": "2h", "limit": 500}
+func RetryDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var request BulkRetryRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	filter := DeadLetterFilter{DestinationID: request.DestinationID, Limit: request.Limit}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	if filter.Limit > 1000 {
+		http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+		return
+	}
+	if request.Since != "" {
+		since, err := parseSince(request.Since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = since
+	}
+
+	letters, err := deliveryQueue.DeadLetters(r.Context(), filter)
+	if err == errInvalidID {
+		http.Error(w, "Invalid destinationId", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting dead letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Retry oldest first so upstreams see requests in their original order
+	results := make([]RetryResult, 0, len(letters))
+	for i := len(letters) - 1; i >= 0; i-- {
+		result := RetryResult{ID: letters[i].ID.Hex()}
+		if err := retryDeadLetter(r, result.ID); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(results),
+		"results": results,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding retry results: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
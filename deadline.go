@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestBudget returns how long a forwarded request may take in total, from arrival until the
+// client has its response: the client's forwarding.request_timeout_header capped at
+// forwarding.request_timeout, or the latter alone. 0 means no deadline.
+func requestBudget(r *http.Request) (time.Duration, error) {
+	cfg := requestConfig(r.Context())
+	budget := cfg.Forwarding.requestTimeout
+	header := cfg.Forwarding.RequestTimeoutHeader
+	if header == "" || r.Header.Get(header) == "" {
+		return budget, nil
+	}
+	requested, err := parseRequestTimeout(r.Header.Get(header))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", header, err)
+	}
+	if budget == 0 || requested < budget {
+		budget = requested
+	}
+	return budget, nil
+}
+
+// parseRequestTimeout parses a request timeout header: a duration such as "1500ms" or "2s", or
+// a number of seconds
+func parseRequestTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, numErr := strconv.ParseFloat(value, 64)
+		if numErr != nil {
+			return 0, fmt.Errorf("%q is neither a duration nor a number of seconds", value)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%q is not positive", value)
+	}
+	return timeout, nil
+}
+
+// withRequestDeadline returns r with its budget as the context deadline, so waiting for
+// workers and destination limits, failover and every destination's request share it
+func withRequestDeadline(r *http.Request, budget time.Duration) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	return r.WithContext(ctx), cancel
+}
+
+// propagateDeadline tells the destination how much of the budget is left in
+// forwarding.request_timeout_header, so a chain of services shares one timeout instead of
+// stacking their own
+func propagateDeadline(req *http.Request) {
+	header := requestConfig(req.Context()).Forwarding.RequestTimeoutHeader
+	deadline, ok := req.Context().Deadline()
+	if header == "" || !ok {
+		return
+	}
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+	req.Header.Set(header, strconv.FormatInt(remaining, 10)+"ms")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DedupStore remembers requests for dedup. Claim marks a key as seen for ttl and reports false
+// when it was seen already; the first request's response is then stored under the same key.
+// An entry without a status is a claim whose response isn't known yet.
+type DedupStore interface {
+	ResponseCache
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Answers to duplicate requests
+const (
+	DedupCached   = "cached"   // Replay the first request's response
+	DedupConflict = "conflict" // 409 Conflict
+)
+
+// dedupStore is nil when dedup is disabled
+var dedupStore DedupStore
+
+func openDedupStore(cfg DedupConfig) (DedupStore, error) {
+	return openCacheBackend(cfg.Backend, cfg.RedisURL, cfg.KeyPrefix+"dedup:", cfg.MaxEntries)
+}
+
+// dedupPlan tracks one request that dedup applies to
+type dedupPlan struct {
+	key       string
+	claimedAt time.Time
+	status    int    // Status the client received; 0 when forwarding failed
+	body      []byte // The complete response body, nil when it was streamed
+	header    http.Header
+}
+
+// planDedup returns nil when dedup doesn't apply to the request: it is off, the method or path
+// is not covered, or the request has neither the dedup header nor a buffered body to hash
+func planDedup(r *http.Request) *dedupPlan {
+	cfg := requestConfig(r.Context()).Dedup
+	if dedupStore == nil || !contains(cfg.Methods, r.Method) {
+		return nil
+	}
+	if len(cfg.PathPrefixes) > 0 {
+		covered := false
+		for _, prefix := range cfg.PathPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return nil
+		}
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.Path)
+	if namespace := requestNamespace(r.Context()); namespace != "" {
+		fmt.Fprintf(h, "namespace: %s\n", namespace)
+	}
+	if id := r.Header.Get(cfg.Header); cfg.Header != "" && id != "" {
+		fmt.Fprintf(h, "%s: %s\n", strings.ToLower(cfg.Header), id)
+	} else if body, ok := r.Context().Value(bufferedBodyKey{}).([]byte); ok {
+		h.Write(body)
+	} else {
+		return nil
+	}
+	return &dedupPlan{key: hex.EncodeToString(h.Sum(nil))}
+}
+
+// duplicate claims the request and reports whether it is a duplicate, in which case it has been
+// answered: with the first request's response, or 409 when on_duplicate is "conflict" or that
+// response isn't available (still in flight, or too large to keep). Store errors let the request
+// through.
+func (p *dedupPlan) duplicate(w http.ResponseWriter, r *http.Request) bool {
+	reqID := requestIDFromContext(r.Context())
+	cfg := requestConfig(r.Context()).Dedup
+	p.claimedAt = time.Now()
+	claimed, err := dedupStore.Claim(r.Context(), p.key, cfg.window)
+	if err != nil {
+		log.Printf("[%s] Error checking for a duplicate request: %v", reqID, err)
+		return false
+	}
+	if claimed {
+		return false
+	}
+
+	var first *CachedResponse
+	var body []byte
+	if cfg.OnDuplicate == DedupCached {
+		if first, err = dedupStore.Get(r.Context(), p.key); err != nil {
+			log.Printf("[%s] Error reading the first response of a duplicate request: %v", reqID, err)
+		}
+	}
+	if first != nil && first.Status != 0 {
+		first.Header = http.Header(first.Header).Clone()
+		if body, err = acceptableBody(r, first.Header, first.Body); err != nil {
+			log.Printf("[%s] First response of a duplicate request can't be sent to the client: %v", reqID, err)
+			first = nil
+		}
+	}
+	event := newTrafficEvent(EventDuplicate, r)
+	w.Header().Set("X-Hopper-Duplicate", "true")
+	if first == nil || first.Status == 0 {
+		log.Printf("[%s] Duplicate request within %s, answering 409", reqID, cfg.Window)
+		event.Status = http.StatusConflict
+		event.Message = "Duplicate request; not forwarded"
+		BroadcastTraffic(event)
+		http.Error(w, "Duplicate request", http.StatusConflict)
+		return true
+	}
+
+	for k, v := range first.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Hopper-Duplicate", "true")
+	out, finish := compressResponse(w, r, first.Status, int64(len(body)))
+	w.WriteHeader(first.Status)
+	out.Write(body)
+	finish()
+	log.Printf("[%s] Duplicate request within %s, answered with the first response: Status %d", reqID, cfg.Window, first.Status)
+	event.Status = first.Status
+	event.Message = fmt.Sprintf("Duplicate request; answered with the response of %s ago", time.Since(first.StoredAt).Round(time.Second))
+	BroadcastTraffic(event)
+	return true
+}
+
+// complete records the response the client received; body is nil when it was streamed
+func (p *dedupPlan) complete(resp *http.Response, body []byte) {
+	p.status = resp.StatusCode
+	p.header = http.Header(resp.Header).Clone()
+	p.body = body
+}
+
+// finish keeps the response for duplicates for the rest of the window. When forwarding failed
+// or the answer was a 5xx the claim is dropped, so the sender's retry is forwarded again.
+func (p *dedupPlan) finish(r *http.Request) {
+	reqID := requestIDFromContext(r.Context())
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	if p.status == 0 || p.status >= 500 {
+		if err := dedupStore.Delete(ctx, p.key); err != nil {
+			log.Printf("[%s] Error releasing the dedup claim: %v", reqID, err)
+		}
+		return
+	}
+	cfg := requestConfig(r.Context()).Dedup
+	remaining := cfg.window - time.Since(p.claimedAt)
+	if p.body == nil || cfg.OnDuplicate != DedupCached || remaining <= 0 {
+		return // The claim alone answers duplicates with 409
+	}
+	stored := CachedResponse{Status: p.status, Header: p.header, Body: p.body, StoredAt: time.Now().UTC()}
+	removeHopByHopHeaders(stored.Header)
+	if err := dedupStore.Set(ctx, p.key, stored, remaining); err != nil {
+		log.Printf("[%s] Error storing the response for duplicates: %v", reqID, err)
+	}
+}
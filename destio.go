@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+)
+
+// Import modes for POST /destinations/import
+const (
+	ImportUpsert  = "upsert"  // Create new destinations and update matching ones
+	ImportReplace = "replace" // Like upsert, and archive destinations missing from the import
+)
+
+// ImportResult describes what an import changed (or would change, for a dry run). Destinations
+// are identified by "METHOD URL" since IDs differ between environments.
+type ImportResult struct {
+	Mode      string   `json:"mode"`
+	DryRun    bool     `json:"dryRun"`
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// destinationKey identifies a destination across environments
+func destinationKey(d Destination) string {
+	return d.methodKey() + " " + d.URL
+}
+
+// auditImport records one destination changed by an import
+func auditImport(r *http.Request, action string, before, after *Destination) {
+	entry := AuditEntry{Action: action, Resource: AuditDestination, Before: before, After: after, Details: "import"}
+	if after != nil {
+		entry.ResourceID = after.ID.Hex()
+	} else {
+		entry.ResourceID = before.ID.Hex()
+	}
+	recordAudit(r, entry)
+	recordHistory(r, action, before, after)
+}
+
+// sameDestination compares destinations by their JSON form, so nil and empty lists are equal
+func sameDestination(a, b Destination) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// wantsYAML reports whether the request asks for YAML via ?format= or the given header
+func wantsYAML(r *http.Request, header string) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "yaml" || format == "yml"
+	}
+	return strings.Contains(r.Header.Get(header), "yaml")
+}
+
+// yamlToJSONValue converts the maps produced by yaml.v2 into JSON-compatible values so YAML
+// documents can be decoded with the same json tags as the API
+func yamlToJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = yamlToJSONValue(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = yamlToJSONValue(v[i])
+		}
+	}
+	return v
+}
+
+// Export all destinations as JSON (default) or YAML
+func ExportDestinations(w http.ResponseWriter, r *http.Request) {
+	destinations, err := store.All(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting destinations: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if destinations == nil {
+		destinations = []Destination{}
+	}
+
+	data, err := json.MarshalIndent(destinations, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding destinations: %v", err), http.StatusInternalServerError)
+		return
+	}
+	contentType, filename := "application/json", "destinations.json"
+	if wantsYAML(r, "Accept") {
+		// Round-trip through JSON so the YAML uses the API's field names
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err == nil {
+			data, err = yaml.Marshal(doc)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error encoding destinations: %v", err), http.StatusInternalServerError)
+			return
+		}
+		contentType, filename = "application/yaml", "destinations.yaml"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Write(data)
+}
+
+// decodeImport parses a JSON or YAML list of destinations
+func decodeImport(r *http.Request) ([]Destination, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %v", err)
+	}
+	if wantsYAML(r, "Content-Type") {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %v", err)
+		}
+		if data, err = json.Marshal(yamlToJSONValue(doc)); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %v", err)
+		}
+	}
+	var destinations []Destination
+	if err := json.Unmarshal(data, &destinations); err != nil {
+		return nil, fmt.Errorf("invalid destination list: %v", err)
+	}
+	return destinations, nil
+}
+
+// validateImport checks (and normalizes) every destination and rejects duplicates and multiple defaults
+func validateImport(destinations []Destination) error {
+	seen := make(map[string]bool)
+	defaults := 0
+	for i := range destinations {
+		d := &destinations[i]
+		if errs := d.validate(false); len(errs) > 0 {
+			return fmt.Errorf("destination %d (%s): %v", i, d.URL, errs)
+		}
+		key := destinationKey(*d)
+		if seen[key] {
+			return fmt.Errorf("destination %q is listed more than once", key)
+		}
+		seen[key] = true
+		if d.IsDefault {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("%d destinations are marked as default", defaults)
+	}
+	return nil
+}
+
+// Import destinations from JSON or YAML. ?mode=upsert|replace selects how existing
+// destinations are treated and ?dryRun=true reports the changes without applying them.
+func ImportDestinations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	result := ImportResult{Mode: query.Get("mode"), Created: []string{}, Updated: []string{}, Deleted: []string{}}
+	if result.Mode == "" {
+		result.Mode = ImportUpsert
+	}
+	if result.Mode != ImportUpsert && result.Mode != ImportReplace {
+		http.Error(w, fmt.Sprintf("Invalid mode %q (upsert or replace)", result.Mode), http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("dryRun"); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid dryRun parameter", http.StatusBadRequest)
+			return
+		}
+		result.DryRun = dryRun
+	}
+
+	imported, err := decodeImport(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateImport(imported); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid import: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := store.All(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting destinations: %v", err), http.StatusInternalServerError)
+		return
+	}
+	byKey := make(map[string]Destination, len(existing))
+	for _, d := range existing {
+		byKey[destinationKey(d)] = d
+	}
+
+	// Work out the changes first so a dry run reports exactly what would be applied
+	var creates, updates []Destination
+	inImport := make(map[string]bool, len(imported))
+	for _, d := range imported {
+		key := destinationKey(d)
+		inImport[key] = true
+		current, ok := byKey[key]
+		if !ok {
+			d.ID = primitive.NewObjectID() // Assigned up front so the audit log can reference it
+			d.Version = 1
+			creates = append(creates, d)
+			result.Created = append(result.Created, key)
+			continue
+		}
+		d.ID = current.ID
+		d.Version = current.Version // Versions in the file are ignored
+		if sameDestination(d, current) {
+			result.Unchanged++
+			continue
+		}
+		d.Version++
+		updates = append(updates, d)
+		result.Updated = append(result.Updated, key)
+	}
+	var deletes []Destination
+	if result.Mode == ImportReplace {
+		for _, d := range existing {
+			if !inImport[destinationKey(d)] {
+				deletes = append(deletes, d)
+				result.Deleted = append(result.Deleted, destinationKey(d))
+			}
+		}
+	}
+
+	if !result.DryRun {
+		if err := store.ApplyImport(r.Context(), creates, updates, deletes); err != nil {
+			log.Printf("[%s] Error importing destinations: %v", requestIDFromContext(r.Context()), err)
+			http.Error(w, fmt.Sprintf("Error importing destinations: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[%s] Imported destinations (%s): %d created, %d updated, %d deleted", requestIDFromContext(r.Context()),
+			result.Mode, len(creates), len(updates), len(deletes))
+		for i := range creates {
+			auditImport(r, AuditCreate, nil, &creates[i])
+		}
+		for i := range updates {
+			before := byKey[destinationKey(updates[i])]
+			auditImport(r, AuditUpdate, &before, &updates[i])
+		}
+		for i := range deletes {
+			auditImport(r, AuditDelete, &deletes[i], nil)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
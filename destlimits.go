@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// Overflow policies for requests that exceed a destination's limits
+const (
+	OverflowSkip  = "skip"  // Don't send the request to the destination (default)
+	OverflowQueue = "queue" // Wait for capacity, up to forwarding.queue_timeout
+)
+
+// DestinationLimits caps the load the hopper sends to a destination. Zero values mean unlimited.
+type DestinationLimits struct {
+	MaxConcurrent int          `bson:"maxConcurrent,omitempty" json:"maxConcurrent,omitempty"`
+	MaxRPS        float64      `bson:"maxRps,omitempty" json:"maxRps,omitempty"`
+	Overflow      string       `bson:"overflow,omitempty" json:"overflow,omitempty"`
+	MaxQueued     int          `bson:"maxQueued,omitempty" json:"maxQueued,omitempty"` // Requests that may wait for capacity at once; more are over capacity
+	Routes        []RouteLimit `bson:"routes,omitempty" json:"routes,omitempty"`
+}
+
+// RouteLimit throttles the requests below a path prefix on top of the destination-wide limits;
+// the longest matching prefix applies
+type RouteLimit struct {
+	PathPrefix string  `bson:"pathPrefix" json:"pathPrefix"`
+	MaxRPS     float64 `bson:"maxRps" json:"maxRps"`
+}
+
+var (
+	errDestinationAtCapacity = errors.New("destination is at capacity")
+	errThrottleQueueFull     = errors.New("destination is at capacity and its queue is full")
+)
+
+// validate checks the limits before they are stored
+func (l *DestinationLimits) validate() error {
+	if l.MaxConcurrent < 0 || l.MaxRPS < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if l.Overflow != "" && l.Overflow != OverflowSkip && l.Overflow != OverflowQueue {
+		return fmt.Errorf("overflow must be %q or %q", OverflowSkip, OverflowQueue)
+	}
+	if l.MaxQueued < 0 {
+		return fmt.Errorf("maxQueued must not be negative")
+	}
+	for i, route := range l.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("routes[%d]: pathPrefix must start with /", i)
+		}
+		if route.MaxRPS <= 0 {
+			return fmt.Errorf("routes[%d]: maxRps must be positive", i)
+		}
+	}
+	return nil
+}
+
+// unlimited reports whether the limits cap nothing
+func (l *DestinationLimits) unlimited() bool {
+	return l.MaxConcurrent == 0 && l.MaxRPS == 0 && len(l.Routes) == 0
+}
+
+// destinationLimiter enforces one destination's limits
+type destinationLimiter struct {
+	limits DestinationLimits
+	slots  chan struct{}   // nil when concurrency is unlimited
+	rps    *rate.Limiter   // nil when the rate is unlimited
+	routes []*rate.Limiter // By index of limits.Routes
+	queued atomic.Int64    // Requests waiting for capacity
+}
+
+func newDestinationLimiter(limits DestinationLimits) *destinationLimiter {
+	l := &destinationLimiter{limits: limits}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	if limits.MaxRPS > 0 {
+		l.rps = rate.NewLimiter(rate.Limit(limits.MaxRPS), burstFor(limits.MaxRPS, 0))
+	}
+	for _, route := range limits.Routes {
+		l.routes = append(l.routes, rate.NewLimiter(rate.Limit(route.MaxRPS), burstFor(route.MaxRPS, 0)))
+	}
+	return l
+}
+
+// routeLimiter returns the rate limiter of the longest route prefix matching path, or nil
+func (l *destinationLimiter) routeLimiter(path string) *rate.Limiter {
+	var limiter *rate.Limiter
+	matched := -1
+	for i, route := range l.limits.Routes {
+		if strings.HasPrefix(path, route.PathPrefix) && len(route.PathPrefix) > matched {
+			limiter, matched = l.routes[i], len(route.PathPrefix)
+		}
+	}
+	return limiter
+}
+
+// acquire takes a concurrency slot and a rate token from the destination and from the route
+// of path. When wait is false it fails immediately if any is unavailable; otherwise it waits
+// until ctx is done, unless maxQueued requests are waiting already. The returned function
+// releases the slot.
+func (l *destinationLimiter) acquire(ctx context.Context, path string, wait bool) (func(), error) {
+	if wait && l.limits.MaxQueued > 0 && !l.available(path) {
+		if l.queued.Add(1) > int64(l.limits.MaxQueued) {
+			l.queued.Add(-1)
+			return nil, errThrottleQueueFull
+		}
+		defer l.queued.Add(-1)
+	}
+	if l.slots != nil {
+		if wait {
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, fmt.Errorf("%v: %v", errDestinationAtCapacity, ctx.Err())
+			}
+		} else {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				return nil, errDestinationAtCapacity
+			}
+		}
+	}
+	release := func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+	for _, limiter := range []*rate.Limiter{l.rps, l.routeLimiter(path)} {
+		if limiter == nil {
+			continue
+		}
+		if wait {
+			if err := limiter.Wait(ctx); err != nil {
+				release()
+				return nil, fmt.Errorf("%v: %v", errDestinationAtCapacity, err)
+			}
+		} else if !limiter.Allow() {
+			release()
+			return nil, errDestinationAtCapacity
+		}
+	}
+	return release, nil
+}
+
+// available reports whether a request to path would get capacity right away; it is only a
+// hint, so a request may still end up waiting without being counted as queued
+func (l *destinationLimiter) available(path string) bool {
+	if l.slots != nil && len(l.slots) >= cap(l.slots) {
+		return false
+	}
+	for _, limiter := range []*rate.Limiter{l.rps, l.routeLimiter(path)} {
+		if limiter != nil && limiter.Tokens() < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Limiters by destination, recreated when a destination's limits change
+var (
+	destinationLimitersMu sync.Mutex
+	destinationLimiters   = make(map[string]*destinationLimiter)
+)
+
+// limiterForDestination returns the limiter for a destination, or nil when it has no limits
+func limiterForDestination(dest Destination) *destinationLimiter {
+	if dest.Limits == nil || dest.Limits.unlimited() {
+		return nil
+	}
+	key := dest.URL
+	if !dest.ID.IsZero() {
+		key = dest.ID.Hex()
+	}
+	destinationLimitersMu.Lock()
+	defer destinationLimitersMu.Unlock()
+	l, ok := destinationLimiters[key]
+	if !ok || !reflect.DeepEqual(l.limits, *dest.Limits) {
+		l = newDestinationLimiter(*dest.Limits)
+		destinationLimiters[key] = l
+	}
+	return l
+}
+
+// acquireDestination applies the destination's limits to one forwarded request to path. The
+// default destination always waits for capacity since the client needs its response; for the
+// others the destination's overflow policy decides. Queueing is also skipped for streamed
+// bodies, where a waiting destination would hold back the upload to every other destination.
+// Waiting ends early when ctx is done.
+func acquireDestination(ctx context.Context, dest Destination, path string, isDefault, streamed bool) (func(), error) {
+	l := limiterForDestination(dest)
+	if l == nil {
+		return func() {}, nil
+	}
+	wait := isDefault || (l.limits.Overflow == OverflowQueue && !streamed)
+	ctx, cancel := context.WithTimeout(ctx, requestConfig(ctx).Forwarding.queueTimeout)
+	defer cancel()
+	return l.acquire(ctx, path, wait)
+}
+
+// releasingBody releases a destination's concurrency slot once its response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// acceptsMethod reports whether the destination receives requests with the given method
+func (d Destination) acceptsMethod(method string) bool {
+	if contains(d.ExcludeMethods, method) {
+		return false
+	}
+	return len(d.Methods) == 0 || contains(d.Methods, method)
+}
+
+// methodKey describes the accepted methods for destinationKey, "*" when all are accepted
+func (d Destination) methodKey() string {
+	if len(d.Methods) == 0 {
+		return "*"
+	}
+	methods := append([]string(nil), d.Methods...)
+	sort.Strings(methods)
+	return strings.Join(methods, ",")
+}
+
+// migrateLegacyMethod moves the single method of documents and clients from before method
+// lists into Methods
+func (d *Destination) migrateLegacyMethod() {
+	if d.LegacyMethod != "" && len(d.Methods) == 0 {
+		d.Methods = []string{strings.ToUpper(d.LegacyMethod)}
+	}
+	d.LegacyMethod = ""
+}
+
+// UnmarshalJSON accepts the legacy "method" field of API clients and older exports
+func (d *Destination) UnmarshalJSON(data []byte) error {
+	type plain Destination
+	if err := json.Unmarshal(data, (*plain)(d)); err != nil {
+		return err
+	}
+	d.migrateLegacyMethod()
+	return nil
+}
+
+// UnmarshalBSON migrates stored documents that still have a single "method"
+func (d *Destination) UnmarshalBSON(data []byte) error {
+	type plain Destination
+	if err := bson.Unmarshal(data, (*plain)(d)); err != nil {
+		return err
+	}
+	d.migrateLegacyMethod()
+	return nil
+}
+
+// migrateDestinationDoc does the same for the raw documents drivers apply updates to, so the
+// legacy field is dropped when the document is written back
+func migrateDestinationDoc(doc bson.M) {
+	method, _ := doc["method"].(string)
+	if _, ok := doc["methods"]; !ok && method != "" {
+		doc["methods"] = bson.A{strings.ToUpper(method)}
+	}
+	delete(doc, "method")
+}
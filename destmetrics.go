@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Every request forwarded to a destination is counted here by status class, with the time to
+// the response headers, and also sent to StatsD and OTLP (see statsd.go and otlpmetrics.go).
+// Percentiles are computed over the last metrics.latency_samples requests, so they follow
+// recent behaviour; counts and min/mean/max cover everything since the counters started. With
+// metrics.persist_file the counters survive restarts.
+
+// MetricsConfig controls the per-destination metrics of GET /destinations/{id}/metrics
+type MetricsConfig struct {
+	LatencySamples  int               `yaml:"latency_samples"`  // Recent requests the latency percentiles are computed over; defaults to 1000
+	PersistFile     string            `yaml:"persist_file"`     // JSON file the metrics are loaded from at startup and saved to; in memory only when empty
+	PersistInterval string            `yaml:"persist_interval"` // How often persist_file is written, besides at shutdown; defaults to 1m
+	StatsD          StatsDConfig      `yaml:"statsd"`
+	OTLP            OTLPMetricsConfig `yaml:"otlp"`
+	persistInterval time.Duration
+}
+
+// DestinationMetrics is the body of GET /destinations/{id}/metrics
+type DestinationMetrics struct {
+	DestinationID string            `json:"destinationId,omitempty"`
+	Destination   string            `json:"destination"`
+	Since         *time.Time        `json:"since,omitempty"` // When counting started: the first request since startup or the last reset, or as loaded from metrics.persist_file
+	LastRequestAt *time.Time        `json:"lastRequestAt,omitempty"`
+	Requests      uint64            `json:"requests"`
+	Errors        uint64            `json:"errors"`        // Requests that got no response
+	StatusClasses map[string]uint64 `json:"statusClasses"` // Responses by class, e.g. "5xx"
+	ErrorRate     float64           `json:"errorRate"`     // Share of requests that failed: errors and 5xx
+	Latency       LatencySummary    `json:"latencyMs"`
+}
+
+// LatencySummary describes the time destinations took to answer, in milliseconds
+type LatencySummary struct {
+	Min     float64 `json:"min"`
+	Mean    float64 `json:"mean"`
+	Max     float64 `json:"max"`
+	Samples int     `json:"samples"` // Recent requests the percentiles are computed over
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+}
+
+// destinationMetrics counts the requests of one destination. The exported fields are what
+// metrics.persist_file holds.
+type destinationMetrics struct {
+	mu            sync.Mutex
+	DestinationID string            `json:"destinationId,omitempty"`
+	URL           string            `json:"url"`
+	Since         time.Time         `json:"since"`
+	LastRequestAt time.Time         `json:"lastRequestAt"`
+	Requests      uint64            `json:"requests"`
+	Errors        uint64            `json:"errors"`
+	StatusClasses map[string]uint64 `json:"statusClasses"`
+	TotalLatency  time.Duration     `json:"totalLatency"` // Of the requests with a response
+	MinLatency    time.Duration     `json:"minLatency"`
+	MaxLatency    time.Duration     `json:"maxLatency"`
+	Samples       []time.Duration   `json:"samples"` // Ring buffer of recent latencies
+	Next          int               `json:"next"`    // Where the next sample goes
+}
+
+// metricsByDestination holds a *destinationMetrics per destination ID (URL for destinations
+// without one)
+var metricsByDestination sync.Map
+
+// recordDestinationMetrics counts a request to a destination (status 0 with err when there was
+// no response) and the time it took
+func recordDestinationMetrics(destination Destination, status int, latency time.Duration, err error) {
+	cfg := currentConfig()
+	statsd.destinationRequest(destination, status, latency, err)
+	recordOTLPDestinationRequest(destination, status, latency, err)
+	now := time.Now()
+	id := ""
+	if !destination.ID.IsZero() {
+		id = destination.ID.Hex()
+	}
+	value, _ := metricsByDestination.LoadOrStore(outcomesKey(destination), &destinationMetrics{
+		DestinationID: id,
+		URL:           destination.URL,
+		Since:         now,
+		StatusClasses: make(map[string]uint64),
+	})
+	m := value.(*destinationMetrics)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.URL = destination.URL
+	m.LastRequestAt = now
+	m.Requests++
+	if err != nil {
+		m.Errors++
+		return
+	}
+	m.StatusClasses[strconv.Itoa(status/100)+"xx"]++
+	m.TotalLatency += latency
+	if latency < m.MinLatency || m.MinLatency == 0 {
+		m.MinLatency = latency
+	}
+	if latency > m.MaxLatency {
+		m.MaxLatency = latency
+	}
+	if len(m.Samples) < cfg.Metrics.LatencySamples {
+		m.Samples = append(m.Samples, latency)
+	} else {
+		m.Samples[m.Next] = latency
+	}
+	m.Next = (m.Next + 1) % cfg.Metrics.LatencySamples
+}
+
+// summary computes the metrics returned by the API; the caller holds the lock
+func (m *destinationMetrics) summary() DestinationMetrics {
+	summary := DestinationMetrics{
+		DestinationID: m.DestinationID,
+		Destination:   m.URL,
+		Requests:      m.Requests,
+		Errors:        m.Errors,
+		StatusClasses: make(map[string]uint64),
+	}
+	if !m.Since.IsZero() {
+		since, last := m.Since, m.LastRequestAt
+		summary.Since, summary.LastRequestAt = &since, &last
+	}
+	answered := uint64(0)
+	for class, count := range m.StatusClasses {
+		summary.StatusClasses[class] = count
+		answered += count
+	}
+	if m.Requests > 0 {
+		summary.ErrorRate = float64(m.Errors+m.StatusClasses["5xx"]) / float64(m.Requests)
+	}
+	if answered > 0 {
+		summary.Latency.Min = millis(m.MinLatency)
+		summary.Latency.Max = millis(m.MaxLatency)
+		summary.Latency.Mean = millis(m.TotalLatency / time.Duration(answered))
+	}
+
+	samples := append([]time.Duration(nil), m.Samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	summary.Latency.Samples = len(samples)
+	percentile := func(p float64) float64 {
+		if len(samples) == 0 {
+			return 0
+		}
+		return millis(samples[int(math.Ceil(p*float64(len(samples))))-1])
+	}
+	summary.Latency.P50 = percentile(0.50)
+	summary.Latency.P90 = percentile(0.90)
+	summary.Latency.P95 = percentile(0.95)
+	summary.Latency.P99 = percentile(0.99)
+	return summary
+}
+
+// metricsOf returns the metrics of a destination, empty when it received no request yet
+func metricsOf(destination Destination) DestinationMetrics {
+	value, ok := metricsByDestination.Load(outcomesKey(destination))
+	if !ok {
+		return DestinationMetrics{DestinationID: destination.ID.Hex(), Destination: destination.URL, StatusClasses: map[string]uint64{}}
+	}
+	m := value.(*destinationMetrics)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.summary()
+}
+
+// GetDestinationMetrics returns the request counts and latencies of a destination
+func GetDestinationMetrics(w http.ResponseWriter, r *http.Request) {
+	destination, err := store.Get(r.Context(), mux.Vars(r)["id"])
+	if !writeDestinationDBError(w, "getting", err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(metricsOf(destination))
+}
+
+// GetAllDestinationMetrics returns the metrics of every destination the caller may manage,
+// slowest (by p95) first
+func GetAllDestinationMetrics(w http.ResponseWriter, r *http.Request) {
+	destinations, err := store.All(r.Context())
+	if !writeDestinationDBError(w, "getting", err) {
+		return
+	}
+	metrics := []DestinationMetrics{}
+	for _, destination := range destinations {
+		if namespaceAllowed(r.Context(), destination.Namespace) {
+			metrics = append(metrics, metricsOf(destination))
+		}
+	}
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Latency.P95 > metrics[j].Latency.P95 })
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// ResetDestinationMetrics starts the counters of a destination over
+func ResetDestinationMetrics(w http.ResponseWriter, r *http.Request) {
+	destination, err := store.Get(r.Context(), mux.Vars(r)["id"])
+	if !writeDestinationDBError(w, "getting", err) {
+		return
+	}
+	metricsByDestination.Delete(outcomesKey(destination))
+	log.Printf("[%s] Metrics of destination %s reset by %s", requestIDFromContext(r.Context()), destination.ID.Hex(), principalOrAnonymous(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiMessage{Message: "Destination metrics reset"})
+}
+
+// loadDestinationMetrics reads metrics.persist_file, if any
+func loadDestinationMetrics() {
+	cfg := currentConfig()
+	file := cfg.Metrics.PersistFile
+	if file == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+	var saved []*destinationMetrics
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil {
+		log.Printf("Error loading destination metrics from %s, starting from zero: %v", file, err)
+		return
+	}
+	for _, m := range saved {
+		if m.StatusClasses == nil {
+			m.StatusClasses = make(map[string]uint64)
+		}
+		// metrics.latency_samples may have changed since the file was written
+		if len(m.Samples) > cfg.Metrics.LatencySamples {
+			m.Samples = m.Samples[:cfg.Metrics.LatencySamples]
+		}
+		if len(m.Samples) < cfg.Metrics.LatencySamples || m.Next >= len(m.Samples) {
+			m.Next = len(m.Samples) % cfg.Metrics.LatencySamples
+		}
+		key := m.DestinationID
+		if key == "" {
+			key = m.URL
+		}
+		metricsByDestination.Store(key, m)
+	}
+	log.Printf("Loaded the metrics of %d destinations from %s", len(saved), file)
+}
+
+// saveDestinationMetrics writes metrics.persist_file, if any. The file is replaced atomically
+// so a crash never leaves half of it.
+func saveDestinationMetrics() {
+	file := currentConfig().Metrics.PersistFile
+	if file == "" {
+		return
+	}
+	var saved []json.RawMessage
+	metricsByDestination.Range(func(_, value interface{}) bool {
+		m := value.(*destinationMetrics)
+		m.mu.Lock()
+		data, err := json.Marshal(m)
+		m.mu.Unlock()
+		if err == nil {
+			saved = append(saved, data)
+		}
+		return true
+	})
+	data, err := json.Marshal(saved)
+	if err == nil {
+		tmp := file + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, file)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving destination metrics to %s: %v", file, err)
+	}
+}
+
+// startMetricsPersistence loads the persisted metrics and saves them every
+// metrics.persist_interval
+func startMetricsPersistence() {
+	cfg := currentConfig()
+	if cfg.Metrics.PersistFile == "" {
+		return
+	}
+	loadDestinationMetrics()
+	go func() {
+		ticker := time.NewTicker(cfg.Metrics.persistInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			saveDestinationMetrics()
+		}
+	}()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	destinationTestTimeout   = 30 * time.Second
+	destinationTestBodyLimit = 64 << 10 // Response bytes returned by a test request
+)
+
+// DestinationTestRequest describes the request sent by POST /destinations/{id}/test
+type DestinationTestRequest struct {
+	Method  string            `json:"method"` // Defaults to the destination's first method, then GET
+	Path    string            `json:"path"`   // Appended to the destination URL like a forwarded path
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// DestinationTestResult is the upstream's answer to a test request
+type DestinationTestResult struct {
+	DestinationID string              `json:"destinationId"`
+	URL           string              `json:"url"`
+	Method        string              `json:"method"`
+	Status        int                 `json:"status,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	LatencyMs     float64             `json:"latencyMs"`
+	Body          string              `json:"body,omitempty"`
+	BodyEncoding  string              `json:"bodyEncoding,omitempty"` // "base64" for non-UTF-8 bodies
+	BodyTruncated bool                `json:"bodyTruncated,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// Send a test request to a single destination and report the upstream's response. The other
+// destinations are not contacted and the request does not appear on the traffic stream.
+func TestDestination(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	reqID := requestIDFromContext(r.Context())
+
+	var testReq DestinationTestRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&testReq); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	destination, err := store.Get(r.Context(), id)
+	if !writeDestinationDBError(w, "getting", err) {
+		return
+	}
+
+	method := strings.ToUpper(testReq.Method)
+	if method == "" && len(destination.Methods) > 0 {
+		method = destination.Methods[0]
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !isToken(method) {
+		http.Error(w, fmt.Sprintf("Invalid method %q", method), http.StatusBadRequest)
+		return
+	}
+
+	destURL, err := url.Parse(destination.URL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Destination URL is invalid: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	testURL := *destURL
+	if testReq.Path != "" {
+		testURL.Path = strings.TrimRight(testURL.Path, "/") + "/" + strings.TrimLeft(testReq.Path, "/")
+	}
+	testURL.RawQuery = strings.TrimPrefix(testReq.Query, "?")
+
+	req, err := http.NewRequestWithContext(r.Context(), method, testURL.String(), strings.NewReader(testReq.Body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating test request: %v", err), http.StatusBadRequest)
+		return
+	}
+	for k, v := range testReq.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(requestIDHeader, reqID)
+	req.Header.Set("X-Hopper-Test", "true")
+
+	result := DestinationTestResult{DestinationID: id, URL: testURL.String(), Method: method}
+	client, err := clientForDestination(destination, &testURL, req)
+	if err != nil {
+		result.Error = err.Error()
+		writeDestinationTestResult(w, result)
+		return
+	}
+	client.Timeout = destinationTestTimeout
+
+	log.Printf("[%s] Testing destination %s with %s %s", reqID, id, method, testURL.String())
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.LatencyMs = millis(time.Since(start))
+		result.Error = err.Error()
+		writeDestinationTestResult(w, result)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, destinationTestBodyLimit+1))
+	result.LatencyMs = millis(time.Since(start))
+	result.Status = resp.StatusCode
+	result.Headers = resp.Header
+	if err != nil {
+		result.Error = fmt.Sprintf("error reading response body: %v", err)
+	}
+	if len(body) > destinationTestBodyLimit {
+		body = body[:destinationTestBodyLimit]
+		result.BodyTruncated = true
+	}
+	if utf8.Valid(body) {
+		result.Body = string(body)
+	} else {
+		result.Body = base64.StdEncoding.EncodeToString(body)
+		result.BodyEncoding = "base64"
+	}
+	writeDestinationTestResult(w, result)
+}
+
+func writeDestinationTestResult(w http.ResponseWriter, result DestinationTestResult) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
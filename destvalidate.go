@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// probeTimeout bounds the connectivity check made with ?probe=true
+const probeTimeout = 5 * time.Second
+
+// ValidationError describes one invalid destination field
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects every problem with a destination so they can be fixed in one go
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = v.Field + ": " + v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// isToken reports whether s is a valid HTTP method token (RFC 7230 section 3.2.6)
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks a destination before it is stored and normalizes the methods to upper
+// case. For partial updates an empty URL means the URL is left unchanged.
+func (d *Destination) validate(partial bool) ValidationErrors {
+	var errs ValidationErrors
+
+	if d.URL == "" {
+		if !partial {
+			errs.add("url", "is required")
+		}
+	} else if u, err := url.Parse(d.URL); err != nil {
+		errs.add("url", "is not a valid URL: %v", err)
+	} else {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			errs.add("url", "scheme must be http or https, got %q", u.Scheme)
+		}
+		if u.Host == "" {
+			errs.add("url", "has no host")
+		}
+		if u.Fragment != "" || u.RawQuery != "" {
+			errs.add("url", "must not contain a query or fragment; the request's own query is forwarded")
+		}
+		if d.TLS != nil && u.Scheme != "https" {
+			errs.add("tls", "TLS settings require an https URL")
+		}
+	}
+
+	for i := range d.Methods {
+		d.Methods[i] = strings.ToUpper(d.Methods[i])
+		if !isToken(d.Methods[i]) {
+			errs.add(fmt.Sprintf("methods[%d]", i), "%q is not a valid HTTP method", d.Methods[i])
+		}
+	}
+	for i := range d.ExcludeMethods {
+		d.ExcludeMethods[i] = strings.ToUpper(d.ExcludeMethods[i])
+		if !isToken(d.ExcludeMethods[i]) {
+			errs.add(fmt.Sprintf("excludeMethods[%d]", i), "%q is not a valid HTTP method", d.ExcludeMethods[i])
+		} else if contains(d.Methods, d.ExcludeMethods[i]) {
+			errs.add(fmt.Sprintf("excludeMethods[%d]", i), "%s is also listed in methods", d.ExcludeMethods[i])
+		}
+	}
+	if d.IsDefault && !d.IsActive {
+		errs.add("isDefault", "the default destination must be active")
+	}
+	if d.Schedule != nil {
+		if err := d.Schedule.validate(); err != nil {
+			errs.add("schedule", "%v", err)
+		}
+		if d.IsDefault {
+			errs.add("schedule", "the default destination cannot be scheduled")
+		}
+	}
+	for i, rule := range d.Rules {
+		if err := rule.validate(); err != nil {
+			errs.add(fmt.Sprintf("rules[%d]", i), "%v", err)
+		}
+	}
+	if len(d.Rules) > 0 && d.IsDefault {
+		errs.add("rules", "the default destination cannot have routing rules")
+	}
+	if d.RuleMatch != "" && d.RuleMatch != RuleMatchAll && d.RuleMatch != RuleMatchAny {
+		errs.add("ruleMatch", "must be %q or %q", RuleMatchAll, RuleMatchAny)
+	}
+	d.Bucket = strings.ToUpper(d.Bucket)
+	if d.Bucket != "" && d.Bucket != BucketA && d.Bucket != BucketB {
+		errs.add("bucket", "must be %q or %q", BucketA, BucketB)
+	}
+	if d.Namespace != "" {
+		if _, ok := findNamespace(d.Namespace); !ok {
+			errs.add("namespace", "%q is not declared in namespaces.list", d.Namespace)
+		}
+	}
+	if d.Priority != nil && *d.Priority < 0 {
+		errs.add("priority", "must not be negative")
+	}
+	if d.TLS != nil {
+		if _, err := d.TLS.clientTLSConfig(); err != nil {
+			errs.add("tls", "%v", err)
+		}
+		if d.TLS.InsecureSkipVerify && !currentConfig().Upstream.AllowInsecureSkipVerify {
+			errs.add("tls.insecureSkipVerify", "not allowed unless upstream.allow_insecure_skip_verify is set")
+		}
+	}
+	if d.Proxy != "" {
+		if _, err := proxyFunc(d.Proxy); err != nil {
+			errs.add("proxy", "%v", err)
+		}
+	}
+	if d.Limits != nil {
+		if err := d.Limits.validate(); err != nil {
+			errs.add("limits", "%v", err)
+		}
+	}
+	for i, name := range d.AllowedClients {
+		if strings.TrimSpace(name) == "" {
+			errs.add(fmt.Sprintf("allowedClients[%d]", i), "must not be empty")
+		}
+	}
+	if len(d.AllowedClients) > 0 && !clientCertsVerified() {
+		errs.add("allowedClients", "needs app.tls.client_auth verify_if_given or require_and_verify")
+	}
+	return errs
+}
+
+// probeDestination checks that the destination accepts connections. Any HTTP response counts
+// as reachable, since the probe request itself may not be meaningful to the upstream.
+func probeDestination(d Destination) error {
+	transport, err := transportForDestination(d)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport, Timeout: probeTimeout}
+	resp, err := client.Head(d.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// wantsProbe reports whether the request asked for a connectivity probe with ?probe=true
+func wantsProbe(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("probe")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// writeValidationErrors answers 400 with the structured validation errors
+func writeValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Invalid destination",
+		"fields": errs,
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DestinationDiscovery marks a destination managed by a discovery provider. Such destinations
+// are created, updated and archived to follow the provider; changes made through the API to
+// the fields the provider sets are overwritten on the next reconciliation.
+type DestinationDiscovery struct {
+	Provider string `bson:"provider" json:"provider"` // "kubernetes", "dns" or "consul"
+	Key      string `bson:"key" json:"key"`           // Identifies the target within the provider
+}
+
+// reconcileDiscovered makes the destinations owned by provider match targets, keyed by their
+// Discovery.Key. Targets set the URL, method, default and active flags and tags; everything
+// else (limits, TLS, priority, ...) can be managed through the API as usual. Destinations whose
+// target is gone are archived.
+func reconcileDiscovered(ctx context.Context, provider string, targets []Destination) error {
+	existing, err := store.All(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting destinations: %v", err)
+	}
+	var creates, updates, deletes []Destination
+	owned := make(map[string]Destination)
+	for _, d := range existing {
+		if d.Discovery == nil || d.Discovery.Provider != provider {
+			continue
+		}
+		if _, ok := owned[d.Discovery.Key]; ok {
+			deletes = append(deletes, d) // Created twice, e.g. by two hoppers at the same time
+			continue
+		}
+		owned[d.Discovery.Key] = d
+	}
+
+	for _, target := range targets {
+		key := target.Discovery.Key
+		current, ok := owned[key]
+		if !ok {
+			target.ID = primitive.NewObjectID()
+			target.Version = 1
+			creates = append(creates, target)
+			continue
+		}
+		delete(owned, key)
+		updated := current
+		updated.URL, updated.Methods, updated.IsDefault, updated.Tags = target.URL, target.Methods, target.IsDefault, target.Tags
+		updated.IsActive = target.IsActive
+		if sameDestination(updated, current) {
+			continue
+		}
+		updated.Version++
+		updates = append(updates, updated)
+	}
+	for _, d := range owned {
+		deletes = append(deletes, d)
+	}
+
+	if len(creates) == 0 && len(updates) == 0 && len(deletes) == 0 {
+		return nil
+	}
+	if err := store.ApplyImport(ctx, creates, updates, deletes); err != nil {
+		return err
+	}
+	log.Printf("Discovery (%s): %d destinations created, %d updated, %d archived", provider, len(creates), len(updates), len(deletes))
+	return nil
+}
+
+// runDiscovery reconciles whenever trigger fires and at least every interval; list returns
+// the provider's current targets. Failed rounds are retried on the next trigger or tick.
+func runDiscovery(provider string, interval time.Duration, trigger <-chan struct{}, list func(ctx context.Context) ([]Destination, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		targets, err := list(ctx)
+		if err == nil {
+			for i := range targets {
+				targets[i].Discovery.Provider = provider
+			}
+			err = reconcileDiscovered(ctx, provider, targets)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("Discovery (%s) failed: %v", provider, err)
+		}
+
+		select {
+		case <-trigger:
+			time.Sleep(time.Second) // Let a burst of changes settle
+			select {
+			case <-trigger:
+			default:
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// signalDiscovery queues a reconciliation without blocking; one pending round covers any
+// number of changes
+func signalDiscovery(trigger chan struct{}) {
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dnsDiscovery resolves the SRV records in discovery.dns.records and keeps one destination per
+// target. With health checks every target is probed each round; a target failing
+// unhealthy_threshold probes in a row is deactivated until a probe succeeds again.
+type dnsDiscovery struct {
+	cfg      DNSDiscoveryConfig
+	resolver *net.Resolver
+
+	mu       sync.Mutex
+	failures map[string]int // Consecutive failed probes by destination key
+}
+
+func startDNSDiscovery(cfg DNSDiscoveryConfig) {
+	d := &dnsDiscovery{cfg: cfg, resolver: net.DefaultResolver, failures: make(map[string]int)}
+	go runDiscovery("dns", cfg.interval, nil, d.targets)
+	names := make([]string, 0, len(cfg.Records))
+	for _, record := range cfg.Records {
+		names = append(names, record.Name)
+	}
+	log.Printf("DNS discovery enabled for %s every %s", strings.Join(names, ", "), cfg.interval)
+}
+
+// targets resolves every record. A failed lookup fails the round, so a DNS outage doesn't
+// archive the destinations.
+func (d *dnsDiscovery) targets(ctx context.Context) ([]Destination, error) {
+	targets := []Destination{}
+	for _, record := range d.cfg.Records {
+		_, answers, err := d.resolver.LookupSRV(ctx, "", "", record.Name)
+		if err != nil {
+			return nil, fmt.Errorf("DNS Lookup Error for %s: %v", record.Name, err)
+		}
+		for _, srv := range answers {
+			hostPort := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+			targets = append(targets, Destination{
+				URL:       fmt.Sprintf("%s://%s%s", record.Scheme, hostPort, record.Path),
+				IsActive:  true,
+				IsDefault: record.Default,
+				Tags:      record.Tags,
+				Discovery: &DestinationDiscovery{Key: record.Name + "/" + hostPort},
+			})
+		}
+	}
+	if d.cfg.HealthCheck {
+		d.checkHealth(targets)
+	}
+	return targets, nil
+}
+
+// checkHealth probes the targets concurrently and deactivates the unhealthy ones
+func (d *dnsDiscovery) checkHealth(targets []Destination) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = probeDestination(targets[i])
+		}(i)
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := make(map[string]bool, len(targets))
+	for i := range targets {
+		key := targets[i].Discovery.Key
+		seen[key] = true
+		if errs[i] == nil {
+			if d.failures[key] >= d.cfg.UnhealthyThreshold {
+				log.Printf("DNS discovery: %s is healthy again", targets[i].URL)
+			}
+			d.failures[key] = 0
+			continue
+		}
+		d.failures[key]++
+		if d.failures[key] == d.cfg.UnhealthyThreshold {
+			log.Printf("DNS discovery: %s is unhealthy after %d failed probes: %v", targets[i].URL, d.failures[key], errs[i])
+		}
+		if d.failures[key] >= d.cfg.UnhealthyThreshold {
+			targets[i].IsActive = false
+		}
+	}
+	for key := range d.failures {
+		if !seen[key] {
+			delete(d.failures, key)
+		}
+	}
+}
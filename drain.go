@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainRetryAfter is the Retry-After (seconds) sent with the 503 for requests refused while
+// draining; by then a load balancer should have moved the client to another instance
+const drainRetryAfter = 5
+
+// DrainStatus is returned by the /admin/drain endpoints
+type DrainStatus struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+	InFlight int64      `json:"inFlight"` // Forwarded requests whose fan-out has not finished yet
+}
+
+// drain tracks forwarded requests in flight and whether new ones are refused
+var drain struct {
+	inFlight atomic.Int64
+	mu       sync.Mutex
+	since    *time.Time
+	idle     chan struct{} // Closed when a drain has no requests left in flight
+}
+
+func drainStatus() DrainStatus {
+	drain.mu.Lock()
+	defer drain.mu.Unlock()
+	return DrainStatus{Draining: drain.since != nil, Since: drain.since, InFlight: drain.inFlight.Load()}
+}
+
+func isDraining() bool {
+	drain.mu.Lock()
+	defer drain.mu.Unlock()
+	return drain.since != nil
+}
+
+// startDrain stops accepting forwarded requests; in-flight ones carry on
+func startDrain(reason string) {
+	drain.mu.Lock()
+	defer drain.mu.Unlock()
+	if drain.since != nil {
+		return
+	}
+	now := time.Now().UTC()
+	drain.since = &now
+	drain.idle = make(chan struct{})
+	if drain.inFlight.Load() == 0 {
+		close(drain.idle)
+	}
+	log.Printf("Draining (%s): refusing new forwarded requests, %d in flight", reason, drain.inFlight.Load())
+}
+
+// stopDrain accepts forwarded requests again
+func stopDrain() {
+	drain.mu.Lock()
+	defer drain.mu.Unlock()
+	if drain.since == nil {
+		return
+	}
+	drain.since = nil
+	log.Println("Drain cancelled, accepting forwarded requests again")
+}
+
+// waitForDrain blocks until the in-flight requests of the current drain have finished or ctx
+// is done, and reports whether they finished
+func waitForDrain(ctx context.Context) bool {
+	drain.mu.Lock()
+	idle := drain.idle
+	drain.mu.Unlock()
+	if idle == nil {
+		return true
+	}
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// drainable refuses forwarded requests with 503 while draining and counts the ones it lets
+// through until their whole fan-out has finished
+func drainable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		drain.mu.Lock()
+		if drain.since != nil {
+			drain.mu.Unlock()
+			w.Header().Set("Retry-After", strconv.Itoa(drainRetryAfter))
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Service is draining", http.StatusServiceUnavailable)
+			return
+		}
+		drain.inFlight.Add(1)
+		drain.mu.Unlock()
+
+		defer func() {
+			drain.mu.Lock()
+			if drain.inFlight.Add(-1) == 0 && drain.since != nil {
+				select {
+				case <-drain.idle:
+				default:
+					close(drain.idle)
+					log.Println("Drain complete: no forwarded requests in flight")
+				}
+			}
+			drain.mu.Unlock()
+		}()
+		next(w, r)
+	}
+}
+
+// StartDrain serves POST /admin/drain
+func StartDrain(w http.ResponseWriter, r *http.Request) {
+	startDrain("requested by " + principalOrAnonymous(r))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(drainStatus())
+}
+
+// GetDrainStatus serves GET /admin/drain, which can be polled until inFlight reaches 0
+func GetDrainStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drainStatus())
+}
+
+// StopDrain serves DELETE /admin/drain
+func StopDrain(w http.ResponseWriter, r *http.Request) {
+	stopDrain()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drainStatus())
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Alerts are mailed in digests: the first alert starts alerts.email.batch_window, and every alert
+// raised until it ends goes into the same message. Repeats of an alert (same type and
+// destination) are counted instead of listed again, so a destination flapping between failing
+// and recovered sends one message per window rather than one per change.
+
+// EmailAlertsConfig mails alerts through an SMTP server; enabled when there are recipients
+type EmailAlertsConfig struct {
+	SMTP          SMTPConfig `yaml:"smtp"`
+	From          string     `yaml:"from"`           // e.g. "Hopper <hopper@example.com>"
+	To            []string   `yaml:"to"`             // Recipients
+	Events        []string   `yaml:"events"`         // Alert types mailed; all when empty
+	SubjectPrefix string     `yaml:"subject_prefix"` // Defaults to "[http-hopper]"
+	BatchWindow   string     `yaml:"batch_window"`   // Alerts raised within it are mailed together; defaults to 1m
+	batchWindow   time.Duration
+}
+
+// SMTPConfig is the server alert emails are sent through
+type SMTPConfig struct {
+	Host               string `yaml:"host"`
+	Port               int    `yaml:"port"`     // Defaults to 465 with tls: "tls", else 587
+	Username           string `yaml:"username"` // PLAIN authentication when set
+	Password           string `yaml:"password"`
+	TLS                string `yaml:"tls"`     // "starttls" (default), "tls" for implicit TLS, or "none"
+	CAFile             string `yaml:"ca_file"` // Verifies the server with this CA instead of the system roots
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	Timeout            string `yaml:"timeout"` // For one attempt; defaults to 10s
+	timeout            time.Duration
+}
+
+func (e EmailAlertsConfig) enabled() bool {
+	return len(e.To) > 0
+}
+
+// validateEmailAlerts fills in the defaults of alerts.email and checks it
+func validateEmailAlerts(cfg *EmailAlertsConfig) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	if cfg.SMTP.Host == "" {
+		return errors.New("email needs an smtp host")
+	}
+	switch cfg.SMTP.TLS {
+	case "":
+		cfg.SMTP.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("invalid email smtp tls %q: must be starttls, tls or none", cfg.SMTP.TLS)
+	}
+	if cfg.SMTP.Port == 0 {
+		cfg.SMTP.Port = 587
+		if cfg.SMTP.TLS == "tls" {
+			cfg.SMTP.Port = 465
+		}
+	}
+	if cfg.SMTP.Port < 0 || cfg.SMTP.Port > 65535 {
+		return fmt.Errorf("invalid email smtp port %d", cfg.SMTP.Port)
+	}
+	if !isSecretRef(cfg.From) {
+		if _, err := mail.ParseAddress(cfg.From); err != nil {
+			return fmt.Errorf("invalid email from %q: %v", cfg.From, err)
+		}
+	}
+	for _, to := range cfg.To {
+		if _, err := mail.ParseAddress(to); err != nil && !isSecretRef(to) {
+			return fmt.Errorf("invalid email recipient %q: %v", to, err)
+		}
+	}
+	for _, event := range cfg.Events {
+		if !contains(alertTypes, event) {
+			return fmt.Errorf("email has unknown event %q", event)
+		}
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = "[http-hopper]"
+	}
+	var err error
+	if cfg.batchWindow, err = parseAlertDuration("email batch_window", &cfg.BatchWindow, "1m"); err != nil {
+		return err
+	}
+	cfg.SMTP.timeout, err = parseAlertDuration("email smtp timeout", &cfg.SMTP.Timeout, "10s")
+	return err
+}
+
+// alertEmails collects the alerts of the current digest
+var alertEmails emailDigest
+
+type emailDigest struct {
+	mu      sync.Mutex
+	entries []*digestEntry // In the order they were first raised
+	byKey   map[string]*digestEntry
+}
+
+// digestEntry is one alert of a digest and how often it was raised
+type digestEntry struct {
+	first AlertEvent
+	last  AlertEvent
+	count int
+}
+
+// add puts an alert into the digest, starting the batch window for the first one
+func (d *emailDigest) add(alert AlertEvent) {
+	cfg := currentConfig().Alerts.Email
+	if !cfg.enabled() || (len(cfg.Events) > 0 && !contains(cfg.Events, alert.Type)) {
+		return
+	}
+	key := alert.Type
+	if alert.Destination != nil {
+		key += " " + alert.Destination.ID + " " + alert.Destination.URL
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.byKey[key]; ok {
+		entry.last = alert
+		entry.count++
+		return
+	}
+	if d.byKey == nil {
+		d.byKey = map[string]*digestEntry{}
+	}
+	entry := &digestEntry{first: alert, last: alert, count: 1}
+	d.byKey[key] = entry
+	d.entries = append(d.entries, entry)
+	if len(d.entries) == 1 {
+		time.AfterFunc(cfg.batchWindow, d.flush)
+	}
+}
+
+// flush mails the digest, retrying like other notifiers
+func (d *emailDigest) flush() {
+	d.mu.Lock()
+	entries := d.entries
+	d.entries, d.byKey = nil, nil
+	d.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	cfg := currentConfig().Alerts.Email
+	if !cfg.enabled() {
+		log.Printf("Email alerts were disabled, not sending %d alerts", len(entries))
+		return
+	}
+	subject, body := digestMessage(cfg, entries)
+	message := emailMessage(cfg, subject, body)
+	retryAlert(fmt.Sprintf("email %q to %s", subject, strings.Join(cfg.To, ", ")), func() error {
+		return sendEmail(cfg, message)
+	})
+}
+
+// digestMessage writes the subject and plain text body of a digest
+func digestMessage(cfg EmailAlertsConfig, entries []*digestEntry) (string, string) {
+	raised, latest := 0, entries[0].last.Timestamp
+	for _, entry := range entries {
+		raised += entry.count
+		if entry.last.Timestamp.After(latest) {
+			latest = entry.last.Timestamp
+		}
+	}
+	subject := fmt.Sprintf("%s %d alerts on %s", cfg.SubjectPrefix, raised, hopperName)
+	if len(entries) == 1 {
+		alert := entries[0].last
+		subject = fmt.Sprintf("%s %s", cfg.SubjectPrefix, alertTitles[alert.Type])
+		if alert.Destination != nil {
+			subject += ": " + alert.Destination.Name
+		}
+		if raised > 1 {
+			subject += fmt.Sprintf(" (%d times)", raised)
+		}
+	}
+
+	var body strings.Builder
+	if raised == 1 {
+		fmt.Fprintf(&body, "An alert was raised on %s at %s.\n", hopperName, latest.Format(time.RFC1123))
+	} else {
+		fmt.Fprintf(&body, "%d alerts were raised on %s between %s and %s.\n", raised, hopperName,
+			entries[0].first.Timestamp.Format(time.RFC1123), latest.Format(time.RFC1123))
+	}
+	for _, entry := range entries {
+		alert := entry.last
+		body.WriteString("\n" + alertTitles[alert.Type])
+		if alert.Destination != nil {
+			body.WriteString(": " + alert.Destination.Name)
+		}
+		if entry.count > 1 {
+			fmt.Fprintf(&body, " (%d times, first at %s, last at %s)", entry.count, entry.first.Timestamp.Format("15:04:05"), alert.Timestamp.Format("15:04:05"))
+		}
+		fmt.Fprintf(&body, "\n  %s\n", alert.Message)
+		if alert.Destination != nil {
+			fmt.Fprintf(&body, "  Error rate %.0f%% of the last %d requests", alert.ErrorRate*100, outcomeWindow)
+			if alert.Destination.Namespace != "" {
+				fmt.Fprintf(&body, ", namespace %s", alert.Destination.Namespace)
+			}
+			body.WriteString("\n")
+		}
+		if link := alertStatsURL(alert); link != "" {
+			fmt.Fprintf(&body, "  Stats: %s\n", link)
+		}
+	}
+	return subject, body.String()
+}
+
+// emailMessage formats a plain text message with CRLF line endings
+func emailMessage(cfg EmailAlertsConfig, subject, body string) []byte {
+	headers := []string{
+		"From: " + cfg.From,
+		"To: " + strings.Join(cfg.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@%s>", primitive.NewObjectID().Hex(), hopperName),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	return []byte(message)
+}
+
+// sendEmail delivers a message over one SMTP connection. Rejections (5xx replies) are
+// permanent.
+func sendEmail(cfg EmailAlertsConfig, message []byte) error {
+	err := deliverEmail(cfg, message)
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return permanentAlertError{err}
+	}
+	return err
+}
+
+func deliverEmail(cfg EmailAlertsConfig, message []byte) error {
+	server := cfg.SMTP
+	tlsConfig := &tls.Config{ServerName: server.Host, InsecureSkipVerify: server.InsecureSkipVerify}
+	if server.CAFile != "" {
+		ca, err := ioutil.ReadFile(server.CAFile)
+		if err != nil {
+			return permanentAlertError{fmt.Errorf("error reading the smtp ca_file: %v", err)}
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return permanentAlertError{fmt.Errorf("no certificates in the smtp ca_file %s", server.CAFile)}
+		}
+	}
+
+	address := net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+	dialer := &net.Dialer{Timeout: server.timeout}
+	var conn net.Conn
+	var err error
+	if server.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(server.timeout))
+	client, err := smtp.NewClient(conn, server.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if hopperName != "" {
+		if err := client.Hello(hopperName); err != nil {
+			return err
+		}
+	}
+	if server.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return permanentAlertError{fmt.Errorf("smtp server %s doesn't offer STARTTLS", address)}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if server.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", server.Username, server.Password, server.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return permanentAlertError{err}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		recipient, err := mail.ParseAddress(to)
+		if err != nil {
+			return permanentAlertError{err}
+		}
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// etcdTxRetries bounds how often a change is retried when the key was written between
+// reading and writing it
+const etcdTxRetries = 10
+
+// etcdStore keeps each destination as a JSON document under <prefix>destinations/<id>
+// (storage.driver "etcd"), so external tooling can manage destinations by writing keys. The
+// ID comes from the key and the version from the key's etcd version, so writers only need to
+// provide the destination fields.
+type etcdStore struct {
+	client *clientv3.Client
+	prefix string
+	cancel context.CancelFunc
+	storeWatchers
+}
+
+// openEtcdStore connects to the cluster and starts watching the destination keys
+func openEtcdStore(cfg EtcdConfig) (*etcdStore, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to etcd: %v", err)
+	}
+	s := &etcdStore{client: client, prefix: cfg.Prefix + "destinations/"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Get(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error reading from etcd: %v", err)
+	}
+
+	watchCtx, stop := context.WithCancel(context.Background())
+	s.cancel = stop
+	go s.watch(watchCtx)
+	return s, nil
+}
+
+// watch reports every change below the prefix to the watchers. The client resumes the watch
+// after connection failures on its own.
+func (s *etcdStore) watch(ctx context.Context) {
+	for response := range s.client.Watch(clientv3.WithRequireLeader(ctx), s.prefix, clientv3.WithPrefix()) {
+		if err := response.Err(); err != nil {
+			log.Printf("etcd watch error: %v", err)
+			continue
+		}
+		for _, event := range response.Events {
+			action := "updated"
+			if event.Type == mvccpb.DELETE {
+				action = "deleted"
+			}
+			log.Printf("Destination %s %s in etcd (revision %d)", strings.TrimPrefix(string(event.Kv.Key), s.prefix), action, event.Kv.ModRevision)
+		}
+		if len(response.Events) > 0 {
+			s.notify()
+		}
+	}
+}
+
+func (s *etcdStore) Ping(ctx context.Context) error {
+	_, err := s.client.Get(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	return err
+}
+
+func (s *etcdStore) Close() error {
+	s.cancel()
+	return s.client.Close()
+}
+
+func (s *etcdStore) key(id primitive.ObjectID) string {
+	return s.prefix + id.Hex()
+}
+
+// decode reads a destination from its key-value pair
+func (s *etcdStore) decode(kv *mvccpb.KeyValue) (Destination, error) {
+	var destination Destination
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(string(kv.Key), s.prefix))
+	if err != nil {
+		return destination, fmt.Errorf("etcd key %s does not end in a destination ID", kv.Key)
+	}
+	if err := json.Unmarshal(kv.Value, &destination); err != nil {
+		return destination, fmt.Errorf("etcd Decode Error for %s: %v", kv.Key, err)
+	}
+	destination.ID = id
+	destination.Version = kv.Version - 1 // etcd counts the creation as version 1
+	return destination, nil
+}
+
+func encodeEtcdDestination(destination Destination) (string, error) {
+	data, err := json.Marshal(destination)
+	if err != nil {
+		return "", fmt.Errorf("etcd Encode Error: %v", err)
+	}
+	return string(data), nil
+}
+
+// load reads one destination along with the revision it was last written at
+func (s *etcdStore) load(ctx context.Context, id primitive.ObjectID) (Destination, int64, error) {
+	response, err := s.client.Get(ctx, s.key(id))
+	if err != nil {
+		return Destination{}, 0, fmt.Errorf("etcd Get Error: %v", err)
+	}
+	if len(response.Kvs) == 0 {
+		return Destination{}, 0, errNotFound
+	}
+	destination, err := s.decode(response.Kvs[0])
+	return destination, response.Kvs[0].ModRevision, err
+}
+
+// destinations returns the live or the archived destinations in ID order. Keys that cannot be
+// decoded are skipped, so one bad document written by hand does not stop forwarding.
+func (s *etcdStore) destinations(ctx context.Context, archived bool) ([]Destination, error) {
+	response, err := s.client.Get(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, fmt.Errorf("etcd Find Error: %v", err)
+	}
+	destinations := []Destination{}
+	for _, kv := range response.Kvs {
+		destination, err := s.decode(kv)
+		if err != nil {
+			log.Printf("Skipping destination: %v", err)
+			continue
+		}
+		if destination.Archived == archived {
+			destinations = append(destinations, destination)
+		}
+	}
+	return destinations, nil
+}
+
+func (s *etcdStore) All(ctx context.Context) ([]Destination, error) {
+	return s.destinations(ctx, false)
+}
+
+func (s *etcdStore) Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error) {
+	all, err := s.destinations(ctx, q.Archived)
+	if err != nil {
+		return nil, 0, err
+	}
+	destinations := []Destination{}
+	for _, d := range all {
+		if matchesDestinationQuery(d, q) {
+			destinations = append(destinations, d)
+		}
+	}
+	return pageDestinations(destinations, q)
+}
+
+func (s *etcdStore) Get(ctx context.Context, id string) (Destination, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Destination{}, errInvalidID
+	}
+	destination, _, err := s.load(ctx, objectID)
+	return destination, err
+}
+
+func (s *etcdStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	destination.ID = primitive.NewObjectID()
+	destination.Version = 0
+	value, err := encodeEtcdDestination(destination)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	key := s.key(destination.ID)
+	_, err = s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("etcd Insert Error: %v", err)
+	}
+	return destination.ID, nil
+}
+
+// modify runs change against the stored document and writes the result if the key was not
+// written in the meantime, retrying otherwise
+func (s *etcdStore) modify(ctx context.Context, id string, change func(current Destination, doc bson.M) error) (Destination, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Destination{}, errInvalidID
+	}
+	key := s.key(objectID)
+	for attempt := 0; attempt < etcdTxRetries; attempt++ {
+		current, revision, err := s.load(ctx, objectID)
+		if err != nil {
+			return current, err
+		}
+		updated, err := changeDestination(current, change)
+		if err != nil {
+			return current, err
+		}
+		value, err := encodeEtcdDestination(updated)
+		if err != nil {
+			return current, err
+		}
+		response, err := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+			Then(clientv3.OpPut(key, value)).
+			Commit()
+		if err != nil {
+			return current, fmt.Errorf("etcd Update Error: %v", err)
+		}
+		if response.Succeeded {
+			return updated, nil
+		}
+	}
+	return Destination{}, errConflict
+}
+
+func (s *etcdStore) Update(ctx context.Context, id string, updatedDestination Destination, version int64) error {
+	_, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		if version != anyVersion && current.Version != version {
+			return errConflict
+		}
+		for key, value := range destinationUpdate(updatedDestination) {
+			doc[key] = value
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Updated document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *etcdStore) Archive(ctx context.Context, id string) error {
+	_, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		doc["archived"] = true
+		doc["archivedAt"] = time.Now().UTC()
+		return nil
+	})
+	if err == nil {
+		log.Printf("Archived document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *etcdStore) Restore(ctx context.Context, id string) (Destination, error) {
+	destination, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if !current.Archived {
+			return errNotFound
+		}
+		delete(doc, "archived")
+		delete(doc, "archivedAt")
+		return nil
+	})
+	if err == nil {
+		log.Printf("Restored document with ID: %s", id)
+	}
+	return destination, err
+}
+
+func (s *etcdStore) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	response, err := s.client.Delete(ctx, s.key(objectID))
+	if err != nil {
+		return fmt.Errorf("etcd Delete Error: %v", err)
+	}
+	if response.Deleted == 0 {
+		return errNotFound
+	}
+	log.Printf("Deleted document with ID: %s", id)
+	return nil
+}
+
+func (s *etcdStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	current, revision, err := s.load(ctx, destination.ID)
+	if err == errNotFound || (err == nil && (current.Archived || current.Version != version)) {
+		return errConflict
+	}
+	if err != nil {
+		return err
+	}
+	destination.Version = version + 1
+	value, err := encodeEtcdDestination(destination)
+	if err != nil {
+		return err
+	}
+	key := s.key(destination.ID)
+	response, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("etcd Replace Error: %v", err)
+	}
+	if !response.Succeeded {
+		return errConflict
+	}
+	return nil
+}
+
+// ApplyImport applies an import in a single transaction, so it is all or nothing. etcd limits
+// the operations per transaction (--max-txn-ops, 128 by default), which caps the import size.
+func (s *etcdStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	var conditions []clientv3.Cmp
+	var ops []clientv3.Op
+	put := func(d Destination) error {
+		value, err := encodeEtcdDestination(d)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(s.key(d.ID), value))
+		return nil
+	}
+	for _, d := range creates {
+		conditions = append(conditions, clientv3.Compare(clientv3.CreateRevision(s.key(d.ID)), "=", 0))
+		if err := put(d); err != nil {
+			return err
+		}
+	}
+	for _, d := range updates {
+		// d.Version is already the next version, which etcd numbers one higher
+		conditions = append(conditions, clientv3.Compare(clientv3.Version(s.key(d.ID)), "=", d.Version))
+		if err := put(d); err != nil {
+			return err
+		}
+	}
+	archivedAt := time.Now().UTC()
+	for _, d := range deletes {
+		current, revision, err := s.load(ctx, d.ID)
+		if err == errNotFound {
+			continue // Already gone
+		}
+		if err != nil {
+			return err
+		}
+		current.Archived, current.ArchivedAt = true, &archivedAt
+		current.Version++
+		conditions = append(conditions, clientv3.Compare(clientv3.ModRevision(s.key(d.ID)), "=", revision))
+		if err := put(current); err != nil {
+			return err
+		}
+	}
+	response, err := s.client.Txn(ctx).If(conditions...).Then(ops...).Commit()
+	if err != nil {
+		return fmt.Errorf("etcd Import Error: %v", err)
+	}
+	if !response.Succeeded {
+		return fmt.Errorf("destinations were modified while the import was applied")
+	}
+	return nil
+}
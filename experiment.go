@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"net/http"
+)
+
+// Experiment buckets; destinations with a bucket only receive requests from clients in it
+const (
+	BucketA = "A"
+	BucketB = "B"
+)
+
+// experimentBucketKey carries the bucket assigned to the request's client
+type experimentBucketKey struct{}
+
+// assignExperimentBucket puts the client in bucket A or B when experiment is enabled. A client
+// that already has the bucket cookie keeps its bucket; anyone else is assigned by a hash of the
+// experiment name and client IP, so the same client lands in the same bucket on every hopper.
+// The assignment is returned in the response header and, for new clients, the cookie.
+func assignExperimentBucket(w http.ResponseWriter, r *http.Request) *http.Request {
+	cfg := requestConfig(r.Context()).Experiment
+	if !cfg.Enabled {
+		return r
+	}
+	bucket := ""
+	if cookie, err := r.Cookie(cfg.Cookie); err == nil && (cookie.Value == BucketA || cookie.Value == BucketB) {
+		bucket = cookie.Value
+	} else {
+		h := fnv.New32a()
+		h.Write([]byte(cfg.Name + "\x00" + clientIP(r)))
+		bucket = BucketA
+		if int(h.Sum32()%100) < cfg.BPercent {
+			bucket = BucketB
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     cfg.Cookie,
+			Value:    bucket,
+			Path:     "/",
+			MaxAge:   int(cfg.cookieMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	w.Header().Set(cfg.Header, bucket)
+	log.Printf("[%s] Experiment %q: client %s is in bucket %s", requestIDFromContext(r.Context()), cfg.Name, clientIP(r), bucket)
+	return r.WithContext(context.WithValue(r.Context(), experimentBucketKey{}, bucket))
+}
+
+// assignedBucket returns the request's bucket, or "" when none was assigned
+func assignedBucket(ctx context.Context) string {
+	bucket, _ := ctx.Value(experimentBucketKey{}).(string)
+	return bucket
+}
+
+// experimentBucket returns the bucket routing uses; requests without an assignment (the
+// experiment is off, or the request is a replay) are in bucket A
+func experimentBucket(ctx context.Context) string {
+	if bucket := assignedBucket(ctx); bucket != "" {
+		return bucket
+	}
+	return BucketA
+}
+
+// inBucket reports whether the destination receives requests from the bucket
+func (d Destination) inBucket(bucket string) bool {
+	return d.Bucket == "" || d.Bucket == bucket
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fault is a failure injected into a share of forwarded requests so clients and downstream
+// services can be tested against slow, failing or garbled upstreams. A fault may combine a
+// delay with an abort or a corruption.
+type Fault struct {
+	ID            string     `yaml:"-" json:"id"`
+	DestinationID string     `yaml:"destination_id" json:"destinationId,omitempty"` // Only requests to this destination; any when empty
+	PathPrefix    string     `yaml:"path_prefix" json:"pathPrefix,omitempty"`       // Only requests below this path; any when empty
+	Methods       []string   `yaml:"methods" json:"methods,omitempty"`              // Only requests with these methods; any when empty
+	Percent       float64    `yaml:"percent" json:"percent"`                        // Share of matching requests affected (0-100]
+	Delay         string     `yaml:"delay" json:"delay,omitempty"`                  // Latency added before the request is sent, e.g. "2s"
+	AbortStatus   int        `yaml:"abort_status" json:"abortStatus,omitempty"`     // Answer with this status instead of forwarding
+	Corrupt       bool       `yaml:"corrupt" json:"corrupt,omitempty"`              // Garble the response body
+	Duration      string     `yaml:"duration" json:"duration,omitempty"`            // Remove the fault after this long; kept until deleted when empty
+	ExpiresAt     *time.Time `yaml:"-" json:"expiresAt,omitempty"`
+	Injected      uint64     `yaml:"-" json:"injected"` // Requests the fault was applied to
+	delay         time.Duration
+	injected      *atomic.Uint64
+}
+
+func (f *Fault) validate() ValidationErrors {
+	var errs ValidationErrors
+	if f.Percent <= 0 || f.Percent > 100 {
+		errs.add("percent", "must be greater than 0 and at most 100")
+	}
+	if f.DestinationID != "" {
+		if _, err := primitive.ObjectIDFromHex(f.DestinationID); err != nil {
+			errs.add("destinationId", "%q is not a valid destination ID", f.DestinationID)
+		}
+	}
+	if f.PathPrefix != "" && !strings.HasPrefix(f.PathPrefix, "/") {
+		errs.add("pathPrefix", "must start with /")
+	}
+	for i := range f.Methods {
+		f.Methods[i] = strings.ToUpper(f.Methods[i])
+		if !isToken(f.Methods[i]) {
+			errs.add(fmt.Sprintf("methods[%d]", i), "%q is not a valid HTTP method", f.Methods[i])
+		}
+	}
+	if f.Delay != "" {
+		d, err := time.ParseDuration(f.Delay)
+		if err != nil || d < 0 {
+			errs.add("delay", "%q is not a valid duration", f.Delay)
+		}
+		f.delay = d
+	}
+	if f.AbortStatus != 0 && (f.AbortStatus < 100 || f.AbortStatus > 599) {
+		errs.add("abortStatus", "must be an HTTP status code")
+	}
+	if f.AbortStatus != 0 && f.Corrupt {
+		errs.add("corrupt", "an aborted request has no upstream response to corrupt")
+	}
+	if f.delay == 0 && f.AbortStatus == 0 && !f.Corrupt {
+		errs.add("delay", "a fault needs a delay, an abortStatus or corrupt")
+	}
+	if f.Duration != "" {
+		d, err := time.ParseDuration(f.Duration)
+		if err != nil || d <= 0 {
+			errs.add("duration", "%q is not a valid duration", f.Duration)
+		} else {
+			expiresAt := time.Now().UTC().Add(d)
+			f.ExpiresAt = &expiresAt
+		}
+	}
+	return errs
+}
+
+// matches reports whether the fault applies to the request to destination
+func (f *Fault) matches(r *http.Request, destination Destination, now time.Time) bool {
+	if f.ExpiresAt != nil && now.After(*f.ExpiresAt) {
+		return false
+	}
+	if f.DestinationID != "" && f.DestinationID != destination.ID.Hex() {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, f.PathPrefix) {
+		return false
+	}
+	return len(f.Methods) == 0 || contains(f.Methods, r.Method)
+}
+
+// faults holds the active faults; they live in memory, so each hopper has its own
+var faults struct {
+	mu   sync.RWMutex
+	list []*Fault
+}
+
+// addFault activates a validated fault
+func addFault(f Fault) Fault {
+	f.ID = primitive.NewObjectID().Hex()
+	f.injected = new(atomic.Uint64)
+	faults.mu.Lock()
+	faults.list = append(faults.list, &f)
+	faults.mu.Unlock()
+	return f
+}
+
+// loadConfiguredFaults activates fault_injection.faults at startup
+func loadConfiguredFaults() {
+	for _, f := range currentConfig().FaultInjection.Faults {
+		f.Methods = append([]string(nil), f.Methods...)
+		if errs := f.validate(); len(errs) > 0 {
+			continue // Rejected by readConfig already
+		}
+		f = addFault(f)
+		log.Printf("Fault %s active: %s", f.ID, f.describe())
+	}
+}
+
+// listFaults returns the active faults, removing expired ones
+func listFaults() []Fault {
+	now := time.Now()
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	list := make([]Fault, 0, len(faults.list))
+	kept := faults.list[:0]
+	for _, f := range faults.list {
+		if f.ExpiresAt != nil && now.After(*f.ExpiresAt) {
+			continue
+		}
+		kept = append(kept, f)
+		copied := *f
+		copied.Injected = f.injected.Load()
+		list = append(list, copied)
+	}
+	faults.list = kept
+	return list
+}
+
+// pickFault returns the first active fault that matches the request to destination and whose
+// percentage the request falls in, or nil
+func pickFault(r *http.Request, destination Destination) *Fault {
+	if !requestConfig(r.Context()).FaultInjection.Enabled {
+		return nil
+	}
+	now := time.Now()
+	faults.mu.RLock()
+	defer faults.mu.RUnlock()
+	for _, f := range faults.list {
+		if f.matches(r, destination, now) && rand.Float64()*100 < f.Percent {
+			f.injected.Add(1)
+			return f
+		}
+	}
+	return nil
+}
+
+// describe summarizes what the fault does, for logs and traffic events
+func (f *Fault) describe() string {
+	var effects []string
+	if f.delay > 0 {
+		effects = append(effects, "delay "+f.delay.String())
+	}
+	if f.AbortStatus != 0 {
+		effects = append(effects, fmt.Sprintf("abort with %d", f.AbortStatus))
+	}
+	if f.Corrupt {
+		effects = append(effects, "corrupt the response body")
+	}
+	return fmt.Sprintf("%s of %g%% of requests", strings.Join(effects, ", "), f.Percent)
+}
+
+// sendWithFaults sends req with client unless a fault intervenes. Delays wait before sending
+// (or until the request is cancelled), aborts answer with the fault's status without contacting
+// the destination and corruptions garble the body of the destination's response.
+func sendWithFaults(client *http.Client, req *http.Request, r *http.Request, destination Destination) (*http.Response, error) {
+	fault := pickFault(r, destination)
+	if fault == nil {
+		return client.Do(req)
+	}
+	reqID := requestIDFromContext(r.Context())
+	log.Printf("[%s] Injecting fault %s into the request to %s: %s", reqID, fault.ID, destination.URL, fault.describe())
+	event := newTrafficEvent(EventFault, r)
+	if !destination.ID.IsZero() {
+		event.DestinationID = destination.ID.Hex()
+	}
+	event.Destination = req.URL.String()
+	event.Message = fmt.Sprintf("Fault %s: %s", fault.ID, fault.describe())
+	BroadcastTraffic(event)
+
+	if fault.delay > 0 {
+		timer := time.NewTimer(fault.delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+		}
+	}
+	if fault.AbortStatus != 0 {
+		// The body is read so a streamed request body isn't held up for the other destinations
+		if req.Body != nil {
+			io.Copy(ioutil.Discard, req.Body)
+			req.Body.Close()
+		}
+		body := fmt.Sprintf("Injected fault %s\n", fault.ID)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", fault.AbortStatus, http.StatusText(fault.AbortStatus)),
+			StatusCode:    fault.AbortStatus,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "X-Hopper-Fault": {fault.ID}},
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	resp, err := client.Do(req)
+	if err != nil || !fault.Corrupt {
+		return resp, err
+	}
+	resp.Header.Set("X-Hopper-Fault", fault.ID)
+	resp.Body = &corruptingBody{ReadCloser: resp.Body}
+	return resp, nil
+}
+
+// corruptingBody flips the bits of every 64th byte, keeping the length intact so the response
+// still parses as HTTP but not as its content type
+type corruptingBody struct {
+	io.ReadCloser
+	offset int64
+}
+
+func (b *corruptingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	for i := 0; i < n; i++ {
+		if (b.offset+int64(i))%64 == 0 {
+			p[i] ^= 0xFF
+		}
+	}
+	b.offset += int64(n)
+	return n, err
+}
+
+// GetFaults serves GET /admin/faults
+func GetFaults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"enabled": requestConfig(r.Context()).FaultInjection.Enabled, "faults": listFaults()})
+}
+
+// AddFault serves POST /admin/faults, which starts injecting a fault right away
+func AddFault(w http.ResponseWriter, r *http.Request) {
+	var fault Fault
+	if err := json.NewDecoder(r.Body).Decode(&fault); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if errs := fault.validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	fault = addFault(fault)
+	log.Printf("Fault %s added by %s: %s", fault.ID, principalOrAnonymous(r), fault.describe())
+	recordAudit(r, AuditEntry{Action: AuditCreate, Resource: AuditFault, ResourceID: fault.ID, Details: fault.describe()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiPrefix+"/admin/faults/"+fault.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(fault)
+}
+
+// DeleteFault serves DELETE /admin/faults/{id}
+func DeleteFault(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	faults.mu.Lock()
+	found := false
+	for i, f := range faults.list {
+		if f.ID == id {
+			faults.list = append(faults.list[:i], faults.list[i+1:]...)
+			found = true
+			break
+		}
+	}
+	faults.mu.Unlock()
+	if !found {
+		http.Error(w, "Fault not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Fault %s removed by %s", id, principalOrAnonymous(r))
+	recordAudit(r, AuditEntry{Action: AuditDelete, Resource: AuditFault, ResourceID: id})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Fault deleted successfully"})
+}
+
+// ClearFaults serves DELETE /admin/faults, which stops every fault at once
+func ClearFaults(w http.ResponseWriter, r *http.Request) {
+	faults.mu.Lock()
+	count := len(faults.list)
+	faults.list = nil
+	faults.mu.Unlock()
+	log.Printf("%d faults removed by %s", count, principalOrAnonymous(r))
+	recordAudit(r, AuditEntry{Action: AuditDelete, Resource: AuditFault, Details: fmt.Sprintf("%d faults removed", count)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Faults deleted successfully", "count": count})
+}
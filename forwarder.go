@@ -2,114 +2,496 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
-func forwardRequestToDestinations(r *http.Request, destinations []Destination, defaultDest Destination) (*http.Response, []byte, error) {
-	var mu sync.Mutex
-	// Read the body once and allow it to be reused
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error reading request body: %v", err)
+// statusClientClosedRequest is recorded for requests whose client disconnected before the
+// response was ready (nginx's 499); the client never sees it
+const statusClientClosedRequest = 499
+
+// forwardResult carries the outcome of the request sent to the default destination
+type forwardResult struct {
+	resp *http.Response
+	err  error
+}
+
+// failoverCandidate holds the outcome of a destination with a failover priority until the
+// caller decides whether its response answers the client in place of the default destination
+type failoverCandidate struct {
+	destination Destination
+	result      chan forwardResult
+	use         chan bool
+	cancel      context.CancelFunc // Cancels the request of a racer that lost (fastest mode)
+}
+
+// needsFailover reports whether the default destination's outcome should be replaced
+func needsFailover(result forwardResult) bool {
+	return result.err != nil || result.resp.StatusCode >= http.StatusInternalServerError
+}
+
+// fanOutBody wraps the default destination's response body so that closing it
+// waits for the request body tee and the remaining destinations to finish
+type fanOutBody struct {
+	io.ReadCloser
+	wait func()
+}
+
+func (b *fanOutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.wait()
+	return err
+}
+
+// detachingWriter feeds one destination's pipe. Once the destination stops reading
+// (the transport closes the pipe on error) further writes are discarded so a single
+// failing destination cannot abort the upload to the others.
+type detachingWriter struct {
+	pw       *io.PipeWriter
+	detached bool
+}
+
+func (d *detachingWriter) Write(p []byte) (int, error) {
+	if !d.detached {
+		if _, err := d.pw.Write(p); err != nil {
+			d.detached = true
+		}
+	}
+	return len(p), nil
+}
+
+// shouldBufferBody reports whether the request body is small enough to be read into memory.
+// gRPC bodies are always streamed since calls may be long-lived bidirectional streams.
+func shouldBufferBody(r *http.Request) bool {
+	return !isGRPCRequest(r) && r.ContentLength >= 0 && r.ContentLength <= requestConfig(r.Context()).Forwarding.MaxBufferedBodyBytes
+}
+
+// teeRequestBody streams the request body into one pipe per destination (and the optional
+// capture sink) and returns the readers along with a channel that is closed once the whole
+// body has been copied
+func teeRequestBody(body io.Reader, count int, capture *cappedBuffer) ([]io.ReadCloser, <-chan struct{}) {
+	readers := make([]io.ReadCloser, count)
+	pipes := make([]*io.PipeWriter, count)
+	writers := make([]io.Writer, count)
+	for i := 0; i < count; i++ {
+		pr, pw := io.Pipe()
+		readers[i] = pr
+		pipes[i] = pw
+		writers[i] = &detachingWriter{pw: pw}
+	}
+	if capture != nil {
+		writers = append(writers, capture)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := io.Copy(io.MultiWriter(writers...), body)
+		if err != nil {
+			log.Printf("Error streaming request body: %v", err)
+		}
+		for _, pw := range pipes {
+			pw.CloseWithError(err)
+		}
+		log.Printf("Streamed %d bytes of request body to %d destinations", n, count)
+	}()
+	return readers, done
+}
+
+// buildForwardURL appends the request's path and query to a destination URL
+func buildForwardURL(destURL *url.URL, r *http.Request) url.URL {
+	forwardURL := *destURL
+	if !strings.HasSuffix(forwardURL.Path, "/") && !strings.HasPrefix(r.URL.Path, "/") {
+		forwardURL.Path += "/"
+	}
+	forwardURL.Path = strings.TrimRight(forwardURL.Path, "/") + r.URL.Path // Avoid double slashes
+	forwardURL.RawQuery = r.URL.RawQuery
+	return forwardURL
+}
+
+// forwardRequestToDestinations sends the request to every destination concurrently, on the
+// fan-out workers (see workerPool), and returns the response of the default destination as
+// soon as it arrives. When the default destination fails or answers 5xx, the first successful
+// response of the destinations with a failover priority is returned instead. In aggregate mode
+// every destination is waited for and the response combines their answers (see
+// AggregatedResponses), or in quorum mode decides whether the request succeeded (see
+// quorumResponse); in fastest mode the destinations race and the first successful response
+// wins (see raceCandidates). The caller must close the returned body; closing it waits for the
+// remaining destinations to complete. Outcomes are recorded in capture when capturing is
+// enabled. The requests to the destinations are cancelled when the client disconnects.
+func forwardRequestToDestinations(r *http.Request, destinations []Destination, defaultDest Destination, strategy responseStrategy, capture *captureRecorder) (*http.Response, error) {
+	reqID := requestIDFromContext(r.Context())
+	mode := strategy.mode
+	log.Printf("[%s] Original request: Method: %s, URL: %s, Headers: %+v", reqID, r.Method, r.URL.String(), r.Header)
+
+	// Small bodies are read once and replayed to every destination; anything else is streamed
+	var body []byte
+	var bodyReaders []io.ReadCloser
+	var teeDone <-chan struct{}
+	if shouldBufferBody(r) {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %v", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body)) // Reset the body for reuse
+		if sink := capture.requestBodyWriter(); sink != nil {
+			sink.Write(body)
+		}
+		done := make(chan struct{})
+		close(done)
+		teeDone = done
+	} else {
+		log.Printf("[%s] Streaming request body (Content-Length: %d) to %d destinations", reqID, r.ContentLength, len(destinations))
+		bodyReaders, teeDone = teeRequestBody(r.Body, len(destinations), capture.requestBodyWriter())
 	}
-	r.Body = ioutil.NopCloser(bytes.NewReader(body)) // Reset the body for reuse
 
-	log.Printf("Original request: Method: %s, URL: %s, Headers: %+v", r.Method, r.URL.String(), r.Header)
+	// Headers are prepared once: hop-by-hop headers stripped and X-Forwarded-* added
+	forwardedHeader := outboundHeaders(r)
 
 	// Use a WaitGroup to synchronize all goroutines
 	var wg sync.WaitGroup
-	var defaultResponseBody []byte
-	var defaultResponse *http.Response
+	defaultCh := make(chan forwardResult, 1)
+	defaultSeen := false
+	var candidates []*failoverCandidate
+	var aggregated []AggregatedResponse // By destination index in aggregate mode
+	if mode == ResponseModeAggregate || mode == ResponseModeQuorum {
+		aggregated = make([]AggregatedResponse, len(destinations))
+	}
+
+	for i, dest := range destinations {
+		var reqBody io.ReadCloser
+		contentLength := r.ContentLength
+		if bodyReaders != nil {
+			reqBody = bodyReaders[i]
+		} else {
+			reqBody = ioutil.NopCloser(bytes.NewReader(body))
+			contentLength = int64(len(body))
+		}
+		isDefault := !defaultSeen && dest.URL == defaultDest.URL
+		if isDefault {
+			defaultSeen = true
+		}
+		var candidate *failoverCandidate
+		ctx := r.Context() // Requests still in flight are cancelled when the client goes away
+		racer := mode == ResponseModeFastest && !dest.mirror
+		if racer {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			candidate = &failoverCandidate{destination: dest, result: make(chan forwardResult, 1), use: make(chan bool, 1), cancel: cancel}
+			candidates = append(candidates, candidate)
+		} else if !isDefault && dest.failoverPriority() > 0 && aggregated == nil {
+			candidate = &failoverCandidate{destination: dest, result: make(chan forwardResult, 1), use: make(chan bool, 1)}
+			candidates = append(candidates, candidate)
+		}
+
+		// With the delivery queue, the other destinations are delivered in the background;
+		// streamed bodies are not kept, so those requests are still sent directly
+		if !isDefault && !racer && dest.failoverPriority() == 0 && deliveryQueue != nil && bodyReaders == nil && aggregated == nil {
+			if queueRequest(r, dest, forwardedHeader, body) {
+				continue
+			}
+		}
+
+		// fail reports a destination the request could not be sent to
+		fail := func(err error) {
+			reqBody.Close() // Unblock the body tee for this destination
+			capture.addResponse(dest, isDefault, nil, 0, err)
+			if aggregated != nil {
+				aggregated[i] = newAggregatedResponse(dest, "", isDefault, 0, err)
+			}
+			if isDefault {
+				defaultCh <- forwardResult{err: err}
+			}
+			if candidate != nil {
+				candidate.result <- forwardResult{err: err}
+			}
+		}
 
-	for _, dest := range destinations {
 		wg.Add(1) // Increment the WaitGroup counter for each destination
-		go func(destination Destination) {
-			defer wg.Done() // Mark this goroutine as done when finished
+		forward := func(ctx context.Context, index int, destination Destination, reqBody io.ReadCloser, contentLength int64, isDefault bool, candidate *failoverCandidate) {
+			defer wg.Done() // Mark this job as done when finished
+
+			// A job that waited for a worker past the client's disconnect is not sent at all
+			if err := ctx.Err(); err != nil {
+				fail(fmt.Errorf("not forwarding to %s: client disconnected: %w", destination.URL, err))
+				return
+			}
 
 			// Parse the destination URL
 			destURL, err := url.Parse(destination.URL)
 			if err != nil {
-				log.Printf("Error parsing destination URL %s: %v", destination.URL, err)
+				log.Printf("[%s] Error parsing destination URL %s: %v", reqID, destination.URL, err)
+				fail(fmt.Errorf("error parsing destination URL %s: %v", destination.URL, err))
 				return
 			}
 
-			// Construct the forward URL correctly
-			forwardURL := *destURL
-			if !strings.HasSuffix(forwardURL.Path, "/") && !strings.HasPrefix(r.URL.Path, "/") {
-				forwardURL.Path += "/"
-			}
-			forwardURL.Path = strings.TrimRight(forwardURL.Path, "/") + r.URL.Path // Avoid double slashes
-			forwardURL.RawQuery = r.URL.RawQuery
+			forwardURL := buildForwardURL(destURL, r)
+			destination.stripQuery(&forwardURL)
 
-			log.Printf("Original request path: %s", r.URL.Path)
-			log.Printf("Destination URL: %s", destURL.String())
-			log.Printf("Forwarding to URL: %s", forwardURL.String())
+			log.Printf("[%s] Original request path: %s", reqID, r.URL.Path)
+			log.Printf("[%s] Destination URL: %s", reqID, destURL.String())
+			log.Printf("[%s] Forwarding to URL: %s", reqID, forwardURL.String())
 
-			req, err := http.NewRequest(r.Method, forwardURL.String(), bytes.NewReader(body))
+			req, err := http.NewRequestWithContext(ctx, r.Method, forwardURL.String(), reqBody)
 			if err != nil {
-				log.Printf("Error creating request for destination %s: %v", destination.URL, err)
+				log.Printf("[%s] Error creating request for destination %s: %v", reqID, destination.URL, err)
+				fail(fmt.Errorf("error creating request for destination %s: %v", destination.URL, err))
 				return
 			}
+			req.ContentLength = contentLength
 
-			// Copy the headers from the original request
-			req.Header = r.Header.Clone()
+			// Copy the proxied headers and trailers from the original request
+			req.Header = forwardedHeader.Clone()
+			req.Trailer = r.Trailer
+			setIdempotencyKey(req.Header, r, destination)
 
 			// Log the request being forwarded
-			log.Printf("Forwarding request to: %s\n", req.URL.String())
+			log.Printf("[%s] Forwarding request to: %s\n", reqID, req.URL.String())
+
+			// Respect the destination's concurrency and rate limits; skipped requests are reported as errors
+			release, err := acquireDestination(ctx, destination, r.URL.Path, isDefault, bodyReaders != nil)
+			if err != nil {
+				log.Printf("[%s] Not forwarding to %s: %v", reqID, destination.URL, err)
+				BroadcastTraffic(destinationEvent(EventError, r, destination, req.URL.String(), isDefault, 0, 0, err))
+				fail(fmt.Errorf("error forwarding to %s: %w", destination.URL, err))
+				return
+			}
+
+			// The destination gets what is left of the request's deadline
+			propagateDeadline(req)
+
+			// Each destination gets its own span, propagated upstream via traceparent
+			span := startDestinationSpan(r.Context(), destination, req)
 
 			// Forward the request to the destination
-			client := &http.Client{}
-			resp, err := client.Do(req)
+			client, err := clientForDestination(destination, &forwardURL, r)
+			if err != nil {
+				log.Printf("[%s] Error preparing transport for destination %s: %v", reqID, destination.URL, err)
+				BroadcastTraffic(destinationEvent(EventError, r, destination, req.URL.String(), isDefault, 0, 0, err))
+				endSpan(span, 0, err)
+				release()
+				fail(fmt.Errorf("error preparing transport for destination %s: %v", destination.URL, err))
+				return
+			}
+			req, traced := traceConnection(req, destination)
+			start := time.Now()
+			resp, err := sendWithFaults(client, req, r, destination)
+			latency := time.Since(start)
+			traced(resp)
+			endSpan(span, statusCodeOf(resp), err)
+			if !errors.Is(err, context.Canceled) { // The client went away, the destination did nothing wrong
+				recordDestinationOutcome(destination, statusCodeOf(resp), err)
+				recordDestinationMetrics(destination, statusCodeOf(resp), latency, err)
+			}
+			responseSink := capture.addResponse(destination, isDefault, resp, latency, err)
 			if err != nil {
 				// Log and broadcast if the destination is unavailable
-				log.Printf("Error forwarding to %s: %v", req.URL.String(), err)
-				BroadcastTraffic(fmt.Sprintf("Error forwarding to %s: %v", req.URL.String(), err)) // Broadcast error message
+				log.Printf("[%s] Error forwarding to %s: %v", reqID, req.URL.String(), err)
+				BroadcastTraffic(destinationEvent(EventError, r, destination, req.URL.String(), isDefault, 0, latency, err)) // Broadcast error message
+				release()
+				if aggregated != nil {
+					aggregated[index] = newAggregatedResponse(destination, req.URL.String(), isDefault, latency, err)
+				}
+				if isDefault {
+					defaultCh <- forwardResult{err: fmt.Errorf("error forwarding to default destination: %v", err)}
+				}
+				if candidate != nil {
+					candidate.result <- forwardResult{err: err}
+				}
 				return
 			}
-			defer resp.Body.Close()
+			// The slot is held until the response body has been consumed
+			resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+			// Log and broadcast the forwarded request and response status
+			message := fmt.Sprintf("[%s] Request forwarded to %s with status: %s", reqID, req.URL.String(), resp.Status)
+			BroadcastTraffic(destinationEvent(EventResponse, r, destination, req.URL.String(), isDefault, resp.StatusCode, latency, nil)) // Broadcast success message
+			log.Println(message)                                                                                                          // Log to console
 
-			// If this is the default destination, save the response
-			if destination.URL == defaultDest.URL {
-				mu.Lock()
-				defer mu.Unlock()
-				defaultResponseBody, err = ioutil.ReadAll(resp.Body)
-				if err != nil {
-					log.Printf("Error reading response body from default destination: %v", err)
-					return
+			// In aggregate mode every response is read into the combined document
+			if aggregated != nil {
+				var sink io.Writer
+				if responseSink != nil {
+					sink = responseSink
 				}
-				defaultResponse = &http.Response{
-					Status:        resp.Status,
-					StatusCode:    resp.StatusCode,
-					Proto:         resp.Proto,
-					ProtoMajor:    resp.ProtoMajor,
-					ProtoMinor:    resp.ProtoMinor,
-					Header:        resp.Header.Clone(),
-					Body:          ioutil.NopCloser(bytes.NewReader(defaultResponseBody)),
-					ContentLength: int64(len(defaultResponseBody)),
-					Request:       resp.Request,
+				entry := newAggregatedResponse(destination, req.URL.String(), isDefault, latency, nil)
+				entry.readBody(resp, sink)
+				aggregated[index] = entry
+				return
+			}
+
+			// The default destination's response is handed to the caller unread so it can be streamed
+			// (unless it races the others)
+			if isDefault && candidate == nil {
+				log.Printf("[%s] Response from default destination (%s): Status: %s, Headers: %+v", reqID, forwardURL.String(), resp.Status, resp.Header)
+				defaultCh <- forwardResult{resp: resp}
+				return
+			}
+
+			// Drain other responses so their connections can be reused
+			drain := func() {
+				var sink io.Writer = ioutil.Discard
+				if responseSink != nil {
+					sink = responseSink
 				}
-				log.Printf("Response from default destination (%s): Status: %s, Headers: %+v", forwardURL.String(), defaultResponse.Status, defaultResponse.Header)
-				log.Printf("Response body from default destination: %s", string(defaultResponseBody))
+				io.Copy(sink, resp.Body)
+				resp.Body.Close()
 			}
 
-			// Log and broadcast the forwarded request and response status
-			message := fmt.Sprintf("Request forwarded to %s with status: %s", req.URL.String(), resp.Status)
-			BroadcastTraffic(message) // Broadcast success message
-			log.Println(message)      // Log to console
-		}(dest)
+			// Failover candidates and racers wait to learn whether their response answers the
+			// client. The worker moves on meanwhile: the verdict may depend on requests still
+			// waiting for a worker.
+			if candidate != nil {
+				candidate.result <- forwardResult{resp: resp}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if !<-candidate.use {
+						drain()
+					}
+				}()
+				return
+			}
+			drain()
+		}
+		run := func() { forward(ctx, i, dest, reqBody, contentLength, isDefault, candidate) }
+
+		if bodyReaders != nil {
+			fanOutPool.runDirect(run)
+		} else if !fanOutPool.submit(run) {
+			log.Printf("[%s] Not forwarding to %s: %v", reqID, dest.URL, errFanOutQueueFull)
+			BroadcastTraffic(destinationEvent(EventError, r, dest, dest.URL, isDefault, 0, 0, errFanOutQueueFull))
+			fail(fmt.Errorf("error forwarding to %s: %w", dest.URL, errFanOutQueueFull))
+			wg.Done()
+		}
+	}
+
+	waitAll := func() {
+		<-teeDone
+		wg.Wait()
+	}
+	// Every candidate is told once whether its response is used so it can finish
+	settleCandidates := func(chosen *failoverCandidate) {
+		for _, c := range candidates {
+			c.use <- c == chosen
+		}
+	}
+
+	if aggregated != nil {
+		waitAll()
+		if mode == ResponseModeQuorum {
+			return quorumResponse(reqID, aggregated, strategy.quorum)
+		}
+		return aggregateResponse(aggregated)
+	}
+	if mode == ResponseModeFastest && len(candidates) > 0 {
+		return raceCandidates(reqID, candidates, waitAll)
+	}
+
+	if !defaultSeen {
+		settleCandidates(nil)
+		waitAll()
+		return nil, fmt.Errorf("no response received from default destination")
+	}
+
+	result := <-defaultCh
+	if needsFailover(result) && len(candidates) > 0 {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].destination.failoverPriority() < candidates[j].destination.failoverPriority()
+		})
+		for _, c := range candidates {
+			fallback := <-c.result
+			if needsFailover(fallback) {
+				continue
+			}
+			if result.err != nil {
+				log.Printf("[%s] Default destination %s failed (%v), answering from %s (priority %d)", reqID, defaultDest.URL, result.err, c.destination.URL, c.destination.failoverPriority())
+			} else {
+				log.Printf("[%s] Default destination %s answered %s, answering from %s (priority %d)", reqID, defaultDest.URL, result.resp.Status, c.destination.URL, c.destination.failoverPriority())
+				io.Copy(ioutil.Discard, result.resp.Body)
+				result.resp.Body.Close()
+			}
+			result = fallback
+			settleCandidates(c)
+			result.resp.Body = &fanOutBody{ReadCloser: result.resp.Body, wait: waitAll}
+			return result.resp, nil
+		}
+		log.Printf("[%s] No failover destination answered successfully", reqID)
+	}
+	settleCandidates(nil)
+	if result.err != nil {
+		waitAll()
+		return nil, result.err
 	}
+	result.resp.Body = &fanOutBody{ReadCloser: result.resp.Body, wait: waitAll}
+	return result.resp, nil
+}
 
-	// Wait for all goroutines to finish
-	wg.Wait()
+// queueRequest hands the request to destination over to the delivery queue and reports
+// whether it was queued; on failure the caller sends it directly instead
+func queueRequest(r *http.Request, destination Destination, header http.Header, body []byte) bool {
+	reqID := requestIDFromContext(r.Context())
+	destURL, err := url.Parse(destination.URL)
+	if err != nil {
+		return false // Reported by the direct attempt
+	}
+	forwardURL := buildForwardURL(destURL, r)
+	destination.stripQuery(&forwardURL)
+	header = header.Clone()
+	if timeoutHeader := requestConfig(r.Context()).Forwarding.RequestTimeoutHeader; timeoutHeader != "" {
+		header.Del(timeoutHeader) // Deliveries are sent on their own schedule, outside the request's deadline
+	}
+	key := setIdempotencyKey(header, r, destination)
+	if err := enqueueDelivery(r, destination, &forwardURL, header, body, key); err != nil {
+		log.Printf("[%s] Error queueing request to %s, sending it directly: %v", reqID, destination.URL, err)
+		return false
+	}
+	log.Printf("[%s] Queued request to %s", reqID, forwardURL.String())
+	return true
+}
+
+// flushWriter flushes after every write so streamed responses (e.g. gRPC server streams)
+// reach the client immediately instead of sitting in the server's buffer
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.flusher.Flush()
+	return n, err
+}
+
+// copyResponseBody streams the upstream body to the client, flushing eagerly when the
+// body has no known length, and then forwards any trailers the upstream sent
+func copyResponseBody(w http.ResponseWriter, resp *http.Response, body io.Reader) (int64, error) {
+	var dst io.Writer = w
+	if flusher, ok := w.(http.Flusher); ok && resp.ContentLength < 0 {
+		dst = flushWriter{w: w, flusher: flusher}
+	}
+	written, err := io.Copy(dst, body)
+	for k, v := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+k] = v
+	}
+	return written, err
+}
 
-	if defaultResponse == nil {
-		return nil, nil, fmt.Errorf("no response received from default destination")
+// statusCodeOf returns the status code of a response, or 0 when there is none
+func statusCodeOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
 	}
-	return defaultResponse, defaultResponseBody, nil
+	return resp.StatusCode
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// Group roles controlling how a group's members take part in forwarding
+const (
+	GroupRoleNone    = ""        // Members are forwarded to like ungrouped destinations
+	GroupRoleRespond = "respond" // The client's response comes from this group
+	GroupRoleMirror  = "mirror"  // Members only receive copies and never answer the client
+)
+
+// Group is a named set of destinations (destinations reference it by name)
+type Group struct {
+	Name        string `bson:"_id" json:"name"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	Role        string `bson:"role,omitempty" json:"role,omitempty"`
+}
+
+// GroupSummary is a group as listed by GET /groups
+type GroupSummary struct {
+	Group
+	Members int `json:"members"`
+	Active  int `json:"active"`
+}
+
+func (g *Group) validate() ValidationErrors {
+	var errs ValidationErrors
+	if g.Name == "" {
+		errs.add("name", "is required")
+	}
+	if g.Role != GroupRoleNone && g.Role != GroupRoleRespond && g.Role != GroupRoleMirror {
+		errs.add("role", "must be %q, %q or empty", GroupRoleRespond, GroupRoleMirror)
+	}
+	return errs
+}
+
+// groupsByName indexes groups for lookups during forwarding
+func groupsByName(groups []Group) map[string]Group {
+	m := make(map[string]Group, len(groups))
+	for _, g := range groups {
+		m[g.Name] = g
+	}
+	return m
+}
+
+// applyGroupRoles picks the destination that answers the client. Members of mirror groups
+// never answer. When a respond group has selected members, the answer comes from that group:
+// its default member if it has one, otherwise its first member.
+func applyGroupRoles(active []Destination, defaultDest *Destination, groups map[string]Group) *Destination {
+	if len(groups) == 0 {
+		return defaultDest
+	}
+	if defaultDest != nil && groups[defaultDest.Group].Role == GroupRoleMirror {
+		defaultDest = nil
+	}
+
+	var responders []Destination
+	for _, dest := range active {
+		if groups[dest.Group].Role == GroupRoleRespond {
+			responders = append(responders, dest)
+		}
+	}
+	if len(responders) == 0 {
+		return defaultDest
+	}
+	// Respond groups are considered in name order so the choice is deterministic
+	sort.SliceStable(responders, func(i, j int) bool { return responders[i].Group < responders[j].Group })
+	for i := range responders {
+		if responders[i].IsDefault && responders[i].Group == responders[0].Group {
+			return &responders[i]
+		}
+	}
+	return &responders[0]
+}
+
+// List all groups with their member counts
+func GetGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := getGroupsFromDB(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting groups: %v", err), http.StatusInternalServerError)
+		return
+	}
+	destinations, err := store.All(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting destinations: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]GroupSummary, len(groups))
+	index := make(map[string]int, len(groups))
+	for i, g := range groups {
+		summaries[i] = GroupSummary{Group: g}
+		index[g.Name] = i
+	}
+	for _, d := range destinations {
+		if i, ok := index[d.Group]; ok {
+			summaries[i].Members++
+			if d.IsActive {
+				summaries[i].Active++
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// Create a group
+func AddGroup(w http.ResponseWriter, r *http.Request) {
+	var group Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if errs := group.validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	err := addGroupToDB(r.Context(), group)
+	if err == errAlreadyExists {
+		http.Error(w, fmt.Sprintf("Group %q already exists", group.Name), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error adding group: %v", err), http.StatusInternalServerError)
+		return
+	}
+	invalidateGroupCache()
+	log.Printf("Added group %q (role: %q)", group.Name, group.Role)
+	recordAudit(r, AuditEntry{Action: AuditCreate, Resource: AuditGroup, ResourceID: group.Name, Details: fmt.Sprintf("role=%q", group.Role)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiPrefix+"/groups/"+group.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// Update a group's description and role
+func UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	var group Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	group.Name = mux.Vars(r)["name"]
+	if errs := group.validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	err := updateGroupInDB(r.Context(), group)
+	if err == errNotFound {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating group: %v", err), http.StatusInternalServerError)
+		return
+	}
+	invalidateGroupCache()
+	recordAudit(r, AuditEntry{Action: AuditUpdate, Resource: AuditGroup, ResourceID: group.Name, Details: fmt.Sprintf("role=%q", group.Role)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// Delete a group. Groups with members are only deleted with ?force=true, which also removes
+// the members from the group.
+func DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	force := r.URL.Query().Get("force") == "true"
+	err := deleteGroupFromDB(r.Context(), name, force)
+	if err == errNotFound {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err == errGroupNotEmpty {
+		http.Error(w, "Group still has members; move them first or pass ?force=true", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error deleting group: %v", err), http.StatusInternalServerError)
+		return
+	}
+	invalidateGroupCache()
+	invalidateDestinationCache() // A forced delete removed the members from the group
+	log.Printf("Deleted group %q", name)
+	details := ""
+	if force {
+		details = "force"
+	}
+	recordAudit(r, AuditEntry{Action: AuditDelete, Resource: AuditGroup, ResourceID: name, Details: details})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Group deleted successfully"})
+}
+
+// ActivateGroup and DeactivateGroup switch every member of a group on or off in one update
+func ActivateGroup(w http.ResponseWriter, r *http.Request) {
+	setGroupActive(w, r, true)
+}
+
+func DeactivateGroup(w http.ResponseWriter, r *http.Request) {
+	setGroupActive(w, r, false)
+}
+
+func setGroupActive(w http.ResponseWriter, r *http.Request, active bool) {
+	name := mux.Vars(r)["name"]
+	changed, err := setGroupActiveInDB(r.Context(), name, active)
+	if err == errNotFound {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating group: %v", err), http.StatusInternalServerError)
+		return
+	}
+	invalidateDestinationCache()
+	log.Printf("Set isActive=%t on %d destinations in group %q", active, len(changed), name)
+	action := AuditDeactivate
+	if active {
+		action = AuditActivate
+	}
+	recordAudit(r, AuditEntry{Action: action, Resource: AuditGroup, ResourceID: name, Details: fmt.Sprintf("%d destinations changed", len(changed))})
+	for i := range changed {
+		recordHistory(r, action, nil, &changed[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"group": name, "isActive": active, "modified": len(changed)})
+}
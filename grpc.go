@@ -0,0 +1,424 @@
+package main
+
+//go:generate sh -c "protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative adminpb/admin.proto"
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/your-username/http-hopper/adminpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// grpcForwardedMetadata are the metadata keys passed on to the REST routes as headers
+var grpcForwardedMetadata = []string{"x-api-key", "authorization", "x-request-id", "traceparent", "tracestate"}
+
+// adminGRPCServer implements adminpb.HopperAdminServer on top of the REST routes: every call is
+// turned into a request to the router, so authentication, ip_filter.admin, validation, the audit
+// log and the access log apply to gRPC clients exactly as they do to REST clients
+type adminGRPCServer struct {
+	adminpb.UnimplementedHopperAdminServer
+	routes http.Handler
+}
+
+// newGRPCServer creates the gRPC listener for the admin API. tlsConfig is the HTTPS listener's
+// configuration, so the same certificates (and client certificates) apply; nil serves plaintext.
+func newGRPCServer(routes http.Handler, tlsConfig *tls.Config) *grpc.Server {
+	var options []grpc.ServerOption
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := grpc.NewServer(options...)
+	adminpb.RegisterHopperAdminServer(srv, &adminGRPCServer{routes: routes})
+	if currentConfig().App.GRPC.Reflection {
+		reflection.Register(srv)
+	}
+	return srv
+}
+
+// startGRPCServer runs the gRPC listener in the background
+func startGRPCServer(srv *grpc.Server) {
+	cfg := currentConfig()
+	addr := fmt.Sprintf("%s:%s", cfg.App.Host, cfg.App.GRPC.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
+	go func() {
+		log.Printf("Starting gRPC admin API on %s", addr)
+		if err := srv.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+}
+
+// grpcResponse records what a REST route wrote
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *grpcResponse) Header() http.Header { return r.header }
+
+func (r *grpcResponse) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+func (r *grpcResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// call serves a request to a REST route (path is below /api/v1) for the gRPC call in ctx and
+// returns the answer; statuses of 300 and above become gRPC errors
+func (s *adminGRPCServer) call(ctx context.Context, method, path string, body interface{}, header http.Header) (*grpcResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "encoding request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiPrefix+path, reader)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range grpcForwardedMetadata {
+		for _, value := range md.Get(key) {
+			req.Header.Add(key, value)
+		}
+	}
+	// The caller's address and certificate are what ip_filter.admin and client_auth see
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+
+	resp := &grpcResponse{header: http.Header{}}
+	s.routes.ServeHTTP(resp, req)
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	if resp.status >= 300 {
+		return nil, grpcStatusError(resp)
+	}
+	return resp, nil
+}
+
+// grpcStatusError maps a REST error answer to the matching gRPC status. Validation errors carry
+// their fields as a BadRequest detail.
+func grpcStatusError(resp *grpcResponse) error {
+	code := codes.Unknown
+	switch resp.status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		code = codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	default:
+		if resp.status >= 500 {
+			code = codes.Internal
+		}
+	}
+
+	var validation struct {
+		Error  string           `json:"error"`
+		Fields ValidationErrors `json:"fields"`
+	}
+	if json.Unmarshal(resp.body.Bytes(), &validation) != nil || validation.Error == "" {
+		return status.Error(code, strings.TrimSpace(resp.body.String()))
+	}
+	st := status.New(code, validation.Error+": "+validation.Fields.Error())
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(validation.Fields))
+	for i, field := range validation.Fields {
+		violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message}
+	}
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// grpcJSON decodes a REST answer into a message; the JSON names of the messages match the REST
+// API, and fields the messages don't have are ignored
+func grpcJSON(data []byte, message proto.Message) error {
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, message); err != nil {
+		return status.Errorf(codes.Internal, "decoding response: %v", err)
+	}
+	return nil
+}
+
+// restDestination encodes a destination message as the JSON body of a REST request. Only the
+// fields in mask are set (unset ones as empty values, which clear them); without a mask every
+// populated field is.
+func restDestination(d *adminpb.Destination, mask []string) (map[string]interface{}, error) {
+	if d == nil {
+		d = &adminpb.Destination{}
+	}
+	options := protojson.MarshalOptions{EmitUnpopulated: len(mask) > 0}
+	data, err := options.Marshal(d)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "encoding destination: %v", err)
+	}
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, status.Errorf(codes.Internal, "encoding destination: %v", err)
+	}
+	// Read-only fields; protojson also writes int64s such as the version as strings
+	for _, name := range []string{"id", "version", "archived", "archivedAt"} {
+		delete(body, name)
+	}
+	if len(mask) == 0 {
+		return body, nil
+	}
+
+	fields := d.ProtoReflect().Descriptor().Fields()
+	masked := map[string]interface{}{}
+	for _, path := range mask {
+		field := fields.ByName(protoreflect.Name(path))
+		if field == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "update_mask: unknown field %q", path)
+		}
+		value := body[field.JSONName()]
+		// An empty object removes limits, a schedule or TLS settings
+		if field.Kind() == protoreflect.MessageKind && !field.IsList() && value == nil {
+			value = map[string]interface{}{}
+		}
+		masked[field.JSONName()] = value
+	}
+	return masked, nil
+}
+
+func (s *adminGRPCServer) ListDestinations(ctx context.Context, req *adminpb.ListDestinationsRequest) (*adminpb.ListDestinationsResponse, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"method": req.Method, "group": req.Group, "tag": req.Tag, "namespace": req.Namespace, "q": req.Query, "sort": req.Sort} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if req.IsActive != nil {
+		query.Set("isActive", strconv.FormatBool(*req.IsActive))
+	}
+	if req.Archived {
+		query.Set("archived", "true")
+	}
+	if req.Page > 0 {
+		query.Set("page", strconv.Itoa(int(req.Page)))
+	}
+	if req.Limit > 0 {
+		query.Set("limit", strconv.Itoa(int(req.Limit)))
+	}
+	resp, err := s.call(ctx, "GET", "/destinations?"+query.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without page or limit the answer is a plain list; otherwise a page with the items
+	items := resp.body.Bytes()
+	if req.Page > 0 || req.Limit > 0 {
+		var page struct {
+			Items json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return nil, status.Errorf(codes.Internal, "decoding response: %v", err)
+		}
+		items = page.Items
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(items, &raw); err != nil {
+		return nil, status.Errorf(codes.Internal, "decoding response: %v", err)
+	}
+	list := &adminpb.ListDestinationsResponse{Destinations: make([]*adminpb.Destination, len(raw))}
+	for i, data := range raw {
+		list.Destinations[i] = &adminpb.Destination{}
+		if err := grpcJSON(data, list.Destinations[i]); err != nil {
+			return nil, err
+		}
+	}
+	list.Total, _ = strconv.ParseInt(resp.header.Get("X-Total-Count"), 10, 64)
+	return list, nil
+}
+
+func (s *adminGRPCServer) GetDestination(ctx context.Context, req *adminpb.GetDestinationRequest) (*adminpb.Destination, error) {
+	resp, err := s.call(ctx, "GET", "/destinations/"+url.PathEscape(req.Id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	destination := &adminpb.Destination{}
+	return destination, grpcJSON(resp.body.Bytes(), destination)
+}
+
+func (s *adminGRPCServer) CreateDestination(ctx context.Context, req *adminpb.CreateDestinationRequest) (*adminpb.Destination, error) {
+	body, err := restDestination(req.Destination, nil)
+	if err != nil {
+		return nil, err
+	}
+	path := "/destinations"
+	if req.Probe {
+		path += "?probe=true"
+	}
+	resp, err := s.call(ctx, "POST", path, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	created := &adminpb.Destination{}
+	if err := grpcJSON(resp.body.Bytes(), created); err != nil {
+		return nil, err
+	}
+	// Read it back for the version the store assigned
+	return s.GetDestination(ctx, &adminpb.GetDestinationRequest{Id: created.Id})
+}
+
+func (s *adminGRPCServer) UpdateDestination(ctx context.Context, req *adminpb.UpdateDestinationRequest) (*adminpb.Destination, error) {
+	body, err := restDestination(req.Destination, req.UpdateMask.GetPaths())
+	if err != nil {
+		return nil, err
+	}
+	// PUT always sets isActive, so the current value is sent unless the caller named it
+	if _, named := body["isActive"]; !named {
+		current, err := s.GetDestination(ctx, &adminpb.GetDestinationRequest{Id: req.Id})
+		if err != nil {
+			return nil, err
+		}
+		body["isActive"] = current.GetIsActive()
+	}
+
+	header := http.Header{}
+	switch {
+	case req.Force:
+		header.Set("If-Match", "*")
+	case req.Version != nil:
+		header.Set("If-Match", `"`+strconv.FormatInt(*req.Version, 10)+`"`)
+	default:
+		return nil, status.Error(codes.FailedPrecondition, "version is required unless force is set")
+	}
+	path := "/destinations/" + url.PathEscape(req.Id)
+	if req.Probe {
+		path += "?probe=true"
+	}
+	if _, err := s.call(ctx, "PUT", path, body, header); err != nil {
+		return nil, err
+	}
+	return s.GetDestination(ctx, &adminpb.GetDestinationRequest{Id: req.Id})
+}
+
+func (s *adminGRPCServer) DeleteDestination(ctx context.Context, req *adminpb.DeleteDestinationRequest) (*adminpb.DeleteDestinationResponse, error) {
+	path := "/destinations/" + url.PathEscape(req.Id)
+	if req.Purge {
+		path += "?purge=true"
+	}
+	resp, err := s.call(ctx, "DELETE", path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	deleted := &adminpb.DeleteDestinationResponse{}
+	return deleted, grpcJSON(resp.body.Bytes(), deleted)
+}
+
+func (s *adminGRPCServer) RestoreDestination(ctx context.Context, req *adminpb.RestoreDestinationRequest) (*adminpb.Destination, error) {
+	resp, err := s.call(ctx, "POST", "/destinations/"+url.PathEscape(req.Id)+"/restore", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	destination := &adminpb.Destination{}
+	return destination, grpcJSON(resp.body.Bytes(), destination)
+}
+
+func (s *adminGRPCServer) SetDestinationDefault(ctx context.Context, req *adminpb.SetDestinationDefaultRequest) (*adminpb.Destination, error) {
+	method := "DELETE"
+	if req.IsDefault {
+		method = "POST"
+	}
+	resp, err := s.call(ctx, method, "/destinations/"+url.PathEscape(req.Id)+"/default", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	destination := &adminpb.Destination{}
+	return destination, grpcJSON(resp.body.Bytes(), destination)
+}
+
+func (s *adminGRPCServer) GetConnectionStats(ctx context.Context, _ *adminpb.GetConnectionStatsRequest) (*adminpb.ConnectionStats, error) {
+	resp, err := s.call(ctx, "GET", "/admin/connections", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	stats := &adminpb.ConnectionStats{}
+	return stats, grpcJSON(resp.body.Bytes(), stats)
+}
+
+func (s *adminGRPCServer) GetWorkerPoolStats(ctx context.Context, _ *adminpb.GetWorkerPoolStatsRequest) (*adminpb.WorkerPoolStats, error) {
+	resp, err := s.call(ctx, "GET", "/admin/workers", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	stats := &adminpb.WorkerPoolStats{}
+	return stats, grpcJSON(resp.body.Bytes(), stats)
+}
+
+func (s *adminGRPCServer) GetDrainStatus(ctx context.Context, _ *adminpb.GetDrainStatusRequest) (*adminpb.DrainStatus, error) {
+	resp, err := s.call(ctx, "GET", "/admin/drain", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	drain := &adminpb.DrainStatus{}
+	return drain, grpcJSON(resp.body.Bytes(), drain)
+}
+
+func (s *adminGRPCServer) GetTrafficStats(ctx context.Context, _ *adminpb.GetTrafficStatsRequest) (*adminpb.TrafficStats, error) {
+	resp, err := s.call(ctx, "GET", "/traffic/stats", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	stats := &adminpb.TrafficStats{}
+	return stats, grpcJSON(resp.body.Bytes(), stats)
+}
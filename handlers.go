@@ -1,49 +1,196 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type Destination struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	URL       string             `bson:"url" json:"url"`
-	Method    string             `bson:"method,omitempty" json:"method,omitempty"`
-	IsActive  bool               `bson:"isActive" json:"isActive"`
-	IsDefault bool               `bson:"isDefault" json:"isDefault"`
+	ID             primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	URL            string                `bson:"url" json:"url"`
+	Methods        []string              `bson:"methods,omitempty" json:"methods,omitempty"`               // Methods the destination receives; all when empty
+	ExcludeMethods []string              `bson:"excludeMethods,omitempty" json:"excludeMethods,omitempty"` // Methods it never receives, e.g. DELETE
+	LegacyMethod   string                `bson:"method,omitempty" json:"method,omitempty"`                 // Single method of older documents and clients; moved to Methods when decoded
+	IsActive       bool                  `bson:"isActive" json:"isActive"`
+	IsDefault      bool                  `bson:"isDefault" json:"isDefault"`
+	TLS            *DestinationTLS       `bson:"tls,omitempty" json:"tls,omitempty"`
+	Proxy          string                `bson:"proxy,omitempty" json:"proxy,omitempty"`                   // Egress proxy URL (http, https or socks5; or a secret reference to one), or "direct"; upstream.proxy when empty
+	AllowedClients []string              `bson:"allowedClients,omitempty" json:"allowedClients,omitempty"` // Client cert CNs/SANs allowed to reach this destination (empty allows all)
+	Limits         *DestinationLimits    `bson:"limits,omitempty" json:"limits,omitempty"`
+	Namespace      string                `bson:"namespace,omitempty" json:"namespace,omitempty"` // Namespace whose requests the destination receives; the default namespace when empty
+	Group          string                `bson:"group,omitempty" json:"group,omitempty"`         // Name of the group the destination belongs to
+	Tags           []string              `bson:"tags,omitempty" json:"tags,omitempty"`
+	Priority       *int                  `bson:"priority,omitempty" json:"priority,omitempty"` // Failover order when the default destination fails (1 answers first; 0 or unset never answers)
+	Schedule       *DestinationSchedule  `bson:"schedule,omitempty" json:"schedule,omitempty"`
+	Rules          []RoutingRule         `bson:"rules,omitempty" json:"rules,omitempty"`         // Conditions on headers or the JSON body; see RoutingRule
+	RuleMatch      string                `bson:"ruleMatch,omitempty" json:"ruleMatch,omitempty"` // "all" (default) or "any" of the rules must match
+	Bucket         string                `bson:"bucket,omitempty" json:"bucket,omitempty"`       // Experiment bucket ("A" or "B") whose clients are the only ones sent here
+	Discovery      *DestinationDiscovery `bson:"discovery,omitempty" json:"discovery,omitempty"` // Set on destinations created by service discovery
+	Archived       bool                  `bson:"archived,omitempty" json:"archived,omitempty"`   // Soft-deleted; see POST /destinations/{id}/restore
+	ArchivedAt     *time.Time            `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	Version        int64                 `bson:"version" json:"version"` // Incremented on every change; sent as the ETag
+	mirror         bool                  // Member of a mirror group for this request; set by selectDestinations
+	setDefault     bool                  // Makes DestinationStore.Update change IsDefault, which PUT leaves alone
 }
 
-// WebSocket clients and related variables
-var clients = make(map[*websocket.Conn]bool)
-var mu sync.Mutex
+// anyVersion skips the version check of DestinationStore.Update (If-Match: *)
+const anyVersion = -1
 
-// Upgrader for WebSocket connections
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for testing; adjust for production
-	},
+// etag returns the destination's version as an HTTP entity tag
+func (d Destination) etag() string {
+	return `"` + strconv.FormatInt(d.Version, 10) + `"`
 }
 
-// Get all destinations from the database
+// expectedVersion returns the version an update is based on, taken from If-Match or, failing
+// that, from the version in the body
+func expectedVersion(r *http.Request, body Destination) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "*" {
+		return anyVersion, nil
+	}
+	if ifMatch != "" {
+		version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+		if err != nil || version < 0 {
+			return 0, fmt.Errorf("invalid If-Match header %q", ifMatch)
+		}
+		return version, nil
+	}
+	if body.Version > 0 {
+		return body.Version, nil
+	}
+	return 0, errors.New("missing If-Match header or version")
+}
+
+// failoverPriority returns the destination's failover priority, 0 when it is not a failover candidate
+func (d Destination) failoverPriority() int {
+	if d.Priority == nil {
+		return 0
+	}
+	return *d.Priority
+}
+
+// DestinationTLS references the client certificate (mTLS) and CA bundle used when connecting
+// to a destination. Only file paths are stored for keys; key material never leaves the hopper's
+// disk. CA certificates are public and may also be given inline.
+type DestinationTLS struct {
+	ClientCertFile     string `bson:"clientCertFile,omitempty" json:"clientCertFile,omitempty"`
+	ClientKeyFile      string `bson:"clientKeyFile,omitempty" json:"clientKeyFile,omitempty"`
+	CAFile             string `bson:"caFile,omitempty" json:"caFile,omitempty"`
+	CA                 string `bson:"ca,omitempty" json:"ca,omitempty"`                                 // PEM CA certificates, trusted together with caFile's instead of the system roots
+	ServerName         string `bson:"serverName,omitempty" json:"serverName,omitempty"`                 // Name sent as SNI and verified in the certificate instead of the URL's host
+	InsecureSkipVerify bool   `bson:"insecureSkipVerify,omitempty" json:"insecureSkipVerify,omitempty"` // Accept any certificate; needs upstream.allow_insecure_skip_verify
+}
+
+// DestinationPage is returned by GET /destinations when a page or limit is requested
+type DestinationPage struct {
+	Items []Destination `json:"items"`
+	Total int64         `json:"total"`
+	Page  int           `json:"page"`
+	Limit int           `json:"limit"`
+}
+
+// destinationQueryFromRequest parses ?page=&limit=&sort=&isActive=&archived=&method=&group=&tag=&q=
+func destinationQueryFromRequest(r *http.Request) (DestinationQuery, error) {
+	query := r.URL.Query()
+	q := DestinationQuery{
+		Method: strings.ToUpper(query.Get("method")),
+		Group:  query.Get("group"),
+		Tag:    query.Get("tag"),
+		Search: query.Get("q"),
+		Sort:   query.Get("sort"),
+	}
+	if value := query.Get("namespace"); value != "" {
+		if value == defaultNamespace {
+			value = ""
+		} else if _, ok := findNamespace(value); !ok {
+			return q, fmt.Errorf("invalid namespace parameter %q", value)
+		}
+		q.Namespaces = []string{value}
+	}
+	if value := query.Get("isActive"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, fmt.Errorf("invalid isActive parameter %q", value)
+		}
+		q.IsActive = &active
+	}
+	if value := query.Get("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			return q, fmt.Errorf("invalid archived parameter %q", value)
+		}
+		q.Archived = archived
+	}
+	if q.Sort != "" {
+		if _, ok := destinationSortFields[strings.TrimPrefix(q.Sort, "-")]; !ok {
+			return q, fmt.Errorf("invalid sort parameter %q", q.Sort)
+		}
+	}
+	if query.Get("page") != "" || query.Get("limit") != "" {
+		q.Page, q.Limit = 1, 50
+		if value := query.Get("page"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return q, fmt.Errorf("invalid page parameter %q", value)
+			}
+			q.Page = n
+		}
+		if value := query.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 1000 {
+				return q, fmt.Errorf("invalid limit parameter %q (1-1000)", value)
+			}
+			q.Limit = n
+		}
+	}
+	return q, nil
+}
+
+// Get destinations from the database. Without page or limit the full list is returned as an
+// array, as before; otherwise a DestinationPage. X-Total-Count is set either way.
 func GetDestinations(w http.ResponseWriter, r *http.Request) {
-	destinations, err := getAllDestinationsFromDB()
+	q, err := destinationQueryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Callers limited to namespaces only see their destinations
+	if scope := namespaceScope(r.Context()); scope != nil {
+		if q.Namespaces == nil {
+			q.Namespaces = scope
+		} else if !checkNamespaceAllowed(w, r, q.Namespaces[0]) {
+			return
+		}
+	}
+	destinations, total, err := store.Find(r.Context(), q)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error getting destinations: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(destinations); err != nil {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	var response interface{} = destinations
+	if q.Page > 0 {
+		response = DestinationPage{Items: destinations, Total: total, Page: q.Page, Limit: q.Limit}
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, fmt.Sprintf("Error encoding destinations: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -56,11 +203,45 @@ func AddDestination(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	addDestinationToDB(destination)
+	if scope := namespaceScope(r.Context()); destination.Namespace == "" && len(scope) == 1 {
+		destination.Namespace = scope[0] // Keys limited to one namespace add to it
+	}
+	if errs := destination.validate(false); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if !checkNamespaceAllowed(w, r, destination.Namespace) {
+		return
+	}
+	if !checkGroupExists(w, r, destination.Group) {
+		return
+	}
+	if wantsProbe(r) {
+		if err := probeDestination(destination); err != nil {
+			writeValidationErrors(w, ValidationErrors{{Field: "url", Message: fmt.Sprintf("is not reachable: %v", err)}})
+			return
+		}
+	}
+	id, err := store.Add(r.Context(), destination)
+	if err != nil {
+		log.Printf("Error adding destination: %v", err)
+		http.Error(w, fmt.Sprintf("Error adding destination: %v", err), http.StatusInternalServerError)
+		return
+	}
+	destination.ID = id
+	destination.Version = 1
+	log.Printf("Added destination with ID: %s", id.Hex())
+	auditDestination(r, AuditCreate, id, nil, &destination)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiPrefix+"/destinations/"+id.Hex())
+	w.Header().Set("ETag", destination.etag())
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(destination)
 }
 
-// Update an existing destination in the database
+// Update an existing destination in the database. The update must name the version it is
+// based on (If-Match or "version"); if the destination has changed since, 409 is returned.
 func UpdateDestination(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	var updatedDestination Destination
@@ -70,141 +251,363 @@ func UpdateDestination(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Updating destination with ID: %s", params["id"])
-	updateDestinationInDB(params["id"], updatedDestination)
+	if errs := updatedDestination.validate(true); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if updatedDestination.Namespace != "" && !checkNamespaceAllowed(w, r, updatedDestination.Namespace) {
+		return
+	}
+	if !checkGroupExists(w, r, updatedDestination.Group) {
+		return
+	}
+	if wantsProbe(r) && updatedDestination.URL != "" {
+		if err := probeDestination(updatedDestination); err != nil {
+			writeValidationErrors(w, ValidationErrors{{Field: "url", Message: fmt.Sprintf("is not reachable: %v", err)}})
+			return
+		}
+	}
+
+	version, err := expectedVersion(r, updatedDestination)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+		return
+	}
+	before, err := store.Get(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "updating", err) {
+		return
+	}
+
+	log.Printf("Updating destination with ID: %s (version %d)", params["id"], version)
+	if !writeDestinationDBError(w, "updating", store.Update(r.Context(), params["id"], updatedDestination, version)) {
+		return
+	}
+	if after, err := store.Get(r.Context(), params["id"]); err == nil {
+		auditDestination(r, AuditUpdate, before.ID, &before, &after)
+		w.Header().Set("ETag", after.etag())
+	} else {
+		log.Printf("Error reading updated destination %s for the audit log: %v", params["id"], err)
+	}
 
 	if updatedDestination.IsDefault {
 		log.Printf("Setting destination %s as default", params["id"])
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Destination updated successfully"})
 }
 
-// Delete a destination from the database
+// Delete a destination. It is archived so it can be restored, unless ?purge=true asks for
+// permanent removal.
 func DeleteDestination(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	deleteDestinationFromDB(params["id"])
+	before, err := store.Get(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "deleting", err) {
+		return
+	}
+	if r.URL.Query().Get("purge") == "true" {
+		if !writeDestinationDBError(w, "deleting", store.Delete(r.Context(), params["id"])) {
+			return
+		}
+		auditDestination(r, AuditPurge, before.ID, &before, nil)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Destination deleted permanently"})
+		return
+	}
+	if !writeDestinationDBError(w, "archiving", store.Archive(r.Context(), params["id"])) {
+		return
+	}
+	auditDestination(r, AuditDelete, before.ID, &before, nil)
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Destination deleted successfully; restore it with POST /destinations/" + params["id"] + "/restore"})
 }
 
-// StreamTraffic handles WebSocket connections for viewing traffic
-func StreamTraffic(w http.ResponseWriter, r *http.Request) {
-	// Upgrade the connection from HTTP to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+// Get one destination, archived ones included, with its version as the ETag
+func GetDestination(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	destination, err := store.Get(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "getting", err) {
 		return
 	}
 
-	// Ensure the connection is closed when the function exits
-	defer func() {
-		conn.Close()
-		mu.Lock()
-		delete(clients, conn) // Remove the client from the map
-		mu.Unlock()
-		log.Println("WebSocket client disconnected")
-	}()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", destination.etag())
+	json.NewEncoder(w).Encode(destination)
+}
 
-	// Add the new client to the clients map
-	mu.Lock()
-	clients[conn] = true
-	mu.Unlock()
+// Restore an archived destination
+func RestoreDestination(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	destination, err := store.Restore(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "restoring", err) {
+		return
+	}
+	auditDestination(r, AuditRestore, destination.ID, nil, &destination)
 
-	log.Println("New WebSocket client connected")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", destination.etag())
+	json.NewEncoder(w).Encode(destination)
+}
 
-	// Keep reading from the WebSocket to prevent disconnection
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("WebSocket error: %v", err)
-			break // Exit the loop and close the connection on error
-		}
+// Make a destination a default destination (POST) or a regular one again (DELETE). PUT leaves
+// isDefault alone, so clients that omit it don't clear it by accident.
+func SetDestinationDefault(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	before, err := store.Get(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "updating", err) {
+		return
+	}
+	// The change must leave a destination that could have been created that way: a default
+	// destination is active, unscheduled and without routing rules
+	changed := before
+	changed.IsDefault = r.Method == http.MethodPost
+	if errs := changed.validate(false); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	update := Destination{IsActive: before.IsActive, IsDefault: changed.IsDefault, setDefault: true}
+	if !writeDestinationDBError(w, "updating", store.Update(r.Context(), params["id"], update, before.Version)) {
+		return
+	}
+	after, err := store.Get(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "updating", err) {
+		return
 	}
+	log.Printf("Set isDefault=%t on destination %s", update.IsDefault, params["id"])
+	auditDestination(r, AuditUpdate, before.ID, &before, &after)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", after.etag())
+	json.NewEncoder(w).Encode(after)
 }
 
-// BroadcastTraffic sends the traffic information to all connected WebSocket clients
-func BroadcastTraffic(message string) {
-	mu.Lock()
-	defer mu.Unlock()
-	for client := range clients {
-		err := client.WriteMessage(websocket.TextMessage, []byte(message))
-		if err != nil {
-			log.Printf("WebSocket error: %v", err)
-			client.Close()
-			delete(clients, client)
+// writeDestinationDBError maps a database error to 400, 404 or 500 and reports whether the
+// operation succeeded
+func writeDestinationDBError(w http.ResponseWriter, action string, err error) bool {
+	if err == nil {
+		return true
+	}
+	if err == errInvalidID {
+		http.Error(w, "Invalid destination ID", http.StatusBadRequest)
+		return false
+	}
+	if err == errNotFound {
+		http.Error(w, "Destination not found", http.StatusNotFound)
+		return false
+	}
+	if err == errConflict {
+		http.Error(w, "Destination was modified by someone else; reload it and retry", http.StatusConflict)
+		return false
+	}
+	log.Printf("Error %s destination: %v", action, err)
+	http.Error(w, fmt.Sprintf("Error %s destination: %v", action, err), http.StatusInternalServerError)
+	return false
+}
+
+// checkGroupExists answers 400 when a destination references an unknown group
+func checkGroupExists(w http.ResponseWriter, r *http.Request, group string) bool {
+	if group == "" {
+		return true
+	}
+	if mongoClient == nil {
+		writeValidationErrors(w, ValidationErrors{{Field: "group", Message: "groups require the mongodb storage driver"}})
+		return false
+	}
+	exists, err := groupExistsInDB(r.Context(), group)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking group: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		writeValidationErrors(w, ValidationErrors{{Field: "group", Message: fmt.Sprintf("group %q does not exist", group)}})
+		return false
+	}
+	return true
+}
+
+// selectDestinations picks the active destinations of the request's namespace that accept
+// its method, client identity and content (routing rules), along with the destination that answers the client (see applyGroupRoles)
+func selectDestinations(r *http.Request, destinations []Destination, groups map[string]Group, identity *ClientIdentity) ([]Destination, *Destination) {
+	reqID := requestIDFromContext(r.Context())
+	activeDestinations := []Destination{}
+	var defaultDestination *Destination
+	now := time.Now()
+	input := newRoutingInput(r)
+	bucket := experimentBucket(r.Context())
+	namespace := requestNamespace(r.Context())
+	for _, dest := range destinations {
+		if dest.Namespace != namespace {
+			continue
+		}
+		log.Printf("[%s] Checking destination: %+v", reqID, dest)
+		if dest.effectivelyActive(now) {
+			log.Printf("[%s] Destination is active", reqID)
+			if len(dest.AllowedClients) > 0 && !identity.Matches(dest.AllowedClients) {
+				log.Printf("[%s] Client %s is not allowed for this destination", reqID, identity)
+				continue
+			}
+			if !dest.matchesRules(input) {
+				log.Printf("[%s] Request does not match the destination's routing rules", reqID)
+				continue
+			}
+			if !dest.inBucket(bucket) {
+				log.Printf("[%s] Destination is in experiment bucket %s, the client in %s", reqID, dest.Bucket, bucket)
+				continue
+			}
+			// The default destination still answers the client while its circuit is open
+			if !dest.IsDefault && !circuitAllows(dest) {
+				log.Printf("[%s] Circuit of destination %s is open", reqID, dest.URL)
+				continue
+			}
+			// Only forward methods the destination accepts
+			if dest.acceptsMethod(r.Method) {
+				log.Printf("[%s] Adding destination to active destinations", reqID)
+				if groups[dest.Group].Role == GroupRoleMirror {
+					dest.Priority = nil // Mirrors never answer the client, not even on failover
+					dest.mirror = true
+				}
+				activeDestinations = append(activeDestinations, dest)
+				// A bucket's own default answers its clients instead of a shared default
+				if dest.IsDefault && (defaultDestination == nil || defaultDestination.Bucket == "" || dest.Bucket != "") {
+					defaultDestination = &dest
+					log.Printf("[%s] Default destination set: %+v", reqID, *defaultDestination)
+				}
+			} else {
+				log.Printf("[%s] Destination method does not match request method", reqID)
+			}
+		} else {
+			log.Printf("[%s] Destination is not active or outside its schedule", reqID)
 		}
 	}
+	return activeDestinations, applyGroupRoles(activeDestinations, defaultDestination, groups)
 }
 
 // Forward incoming requests to multiple destinations
 func ForwardRequest(w http.ResponseWriter, r *http.Request) {
-	log.Printf("ForwardRequest called with: Method: %s, URL: %s, Headers: %+v", r.Method, r.URL.String(), r.Header)
+	reqID := requestIDFromContext(r.Context())
+
+	// Trace the whole fan-out under a server span that continues the caller's trace
+	ctx, span := startServerSpan(r, "ForwardRequest")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	// One deadline covers the whole request, however many destinations and failovers it takes
+	budget, err := requestBudget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if budget > 0 {
+		var cancel context.CancelFunc
+		r, cancel = withRequestDeadline(r, budget)
+		defer cancel()
+	}
 
-	// Read and log the request body
-	body, err := ioutil.ReadAll(r.Body)
+	// Map the request to its namespace, whose destinations alone receive it
+	r, err = resolveNamespace(r)
 	if err != nil {
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		log.Printf("[%s] %v", reqID, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	r.Body.Close()                                             // Close the original body
-	r.Body = ioutil.NopCloser(strings.NewReader(string(body))) // Recreate the body
+
+	// Put the client in an experiment bucket so it is routed the same way every time
+	r = assignExperimentBucket(w, r)
+
+	log.Printf("[%s] ForwardRequest called with: Method: %s, URL: %s, Headers: %+v", reqID, r.Method, r.URL.String(), r.Header)
+
+	// Read and log the request body when it is small enough to buffer; large bodies are streamed
+	requestEvent := newTrafficEvent(EventRequest, r)
+	bodySummary := fmt.Sprintf("<streamed, Content-Length: %d>", r.ContentLength)
+	limitedBody := r.Body // Checked after forwarding in case a streamed body hits limits.max_body_bytes
+	if shouldBufferBody(r) {
+		body, err := ioutil.ReadAll(r.Body)
+		if bodyLimitExceeded(limitedBody) {
+			rejectTooLarge(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+			return
+		}
+		r.Body.Close()                                   // Close the original body
+		r.Body = ioutil.NopCloser(bytes.NewReader(body)) // Recreate the body
+		// Compressed bodies are forwarded as received, but logged and routed on decoded
+		readable := readableBody(r.Header, body)
+		r = r.WithContext(withBufferedBody(r.Context(), readable))
+		bodySummary = string(readable)
+		requestEvent.setBody(readable)
+	} else {
+		requestEvent.Message = "Request body is streamed and not included"
+	}
+
+	// Identify clients that authenticated with a certificate
+	identity := clientIdentityFromRequest(r)
 
 	// Log the incoming traffic
-	logMessage := fmt.Sprintf("Incoming Request: Method: %s, URL: %s, Body: %s, Headers: %+v",
-		r.Method, r.URL.String(), string(body), r.Header)
+	logMessage := fmt.Sprintf("[%s] Incoming Request: Method: %s, URL: %s, Client: %s, Body: %s, Headers: %+v",
+		reqID, r.Method, r.URL.String(), identity, bodySummary, r.Header)
 	log.Println(logMessage)
 
 	// Broadcast the traffic information to WebSocket clients
-	BroadcastTraffic(logMessage)
+	if identity != nil {
+		requestEvent.Client = identity.String()
+	}
+	requestEvent.Headers = redactCredentials(r.Header)
+	BroadcastTraffic(requestEvent)
+
+	// Duplicates of a recent request (e.g. webhook redeliveries) are not forwarded again
+	dedup := planDedup(r)
+	if dedup != nil {
+		if dedup.duplicate(w, r) {
+			return
+		}
+		defer dedup.finish(r)
+	}
 
-	// Fetch destinations from the database
-	destinations, err := getAllDestinationsFromDB()
+	// Repeated requests are answered from the response cache without reaching any destination
+	cache := planResponseCache(r)
+	if cache != nil && cache.serve(w, r) {
+		return
+	}
+
+	// Fetch destinations (cached for storage.cache_ttl)
+	destinations, err := store.All(r.Context())
 	if err != nil {
-		log.Printf("Error getting destinations: %v", err)
+		log.Printf("[%s] Error getting destinations: %v", reqID, err)
 		http.Error(w, fmt.Sprintf("Error getting destinations: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	activeDestinations := []Destination{}
-	var defaultDestination *Destination
-	for _, dest := range destinations {
-		log.Printf("Checking destination: %+v", dest)
-		if dest.IsActive {
-			log.Printf("Destination is active")
-			// If a method is specified, only forward if it matches the incoming request's method
-			if dest.Method == "" || dest.Method == r.Method {
-				log.Printf("Adding destination to active destinations")
-				activeDestinations = append(activeDestinations, dest)
-				if dest.IsDefault {
-					defaultDestination = &dest
-					log.Printf("Default destination set: %+v", *defaultDestination)
-				}
-			} else {
-				log.Printf("Destination method does not match request method")
-			}
-		} else {
-			log.Printf("Destination is not active")
-		}
+	groups, err := getCachedGroups(r.Context())
+	if err != nil {
+		log.Printf("[%s] Error getting groups: %v", reqID, err)
+		http.Error(w, fmt.Sprintf("Error getting groups: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	log.Printf("Active destinations: %+v", activeDestinations)
-	log.Printf("Default destination: %+v", defaultDestination)
+	activeDestinations, defaultDestination := selectDestinations(r, destinations, groupsByName(groups), identity)
+
+	log.Printf("[%s] Active destinations: %+v", reqID, activeDestinations)
+	log.Printf("[%s] Default destination: %+v", reqID, defaultDestination)
 
 	if len(activeDestinations) == 0 {
-		log.Println("No active destinations available for forwarding")
+		log.Printf("[%s] No active destinations available for forwarding", reqID)
 		http.Error(w, "No active destinations available", http.StatusBadGateway)
 		return
 	}
 
 	if defaultDestination == nil {
-		log.Println("No default destination specified")
+		log.Printf("[%s] No default destination specified", reqID)
 		http.Error(w, "No default destination specified", http.StatusInternalServerError)
 		return
 	}
 
 	if defaultDestination.URL == "" {
-		log.Println("Default destination URL is empty")
+		log.Printf("[%s] Default destination URL is empty", reqID)
 		http.Error(w, "Default destination URL is empty", http.StatusInternalServerError)
 		return
 	}
@@ -212,7 +615,7 @@ func ForwardRequest(w http.ResponseWriter, r *http.Request) {
 	// Construct the full URL for logging
 	destURL, err := url.Parse(defaultDestination.URL)
 	if err != nil {
-		log.Printf("Error parsing default destination URL: %v", err)
+		log.Printf("[%s] Error parsing default destination URL: %v", reqID, err)
 		http.Error(w, "Error parsing default destination URL", http.StatusInternalServerError)
 		return
 	}
@@ -223,39 +626,114 @@ func ForwardRequest(w http.ResponseWriter, r *http.Request) {
 	fullURL.Path += r.URL.Path
 	fullURL.RawQuery = r.URL.RawQuery
 
-	log.Printf("Original request path: %s", r.URL.Path)
-	log.Printf("Default destination URL: %s", defaultDestination.URL)
-	log.Printf("Constructed full URL: %s", fullURL.String())
+	log.Printf("[%s] Original request path: %s", reqID, r.URL.Path)
+	log.Printf("[%s] Default destination URL: %s", reqID, defaultDestination.URL)
+	log.Printf("[%s] Constructed full URL: %s", reqID, fullURL.String())
+
+	log.Printf("[%s] Forwarding request to destinations. Default destination: %+v", reqID, *defaultDestination)
 
-	log.Printf("Forwarding request to destinations. Default destination: %+v", *defaultDestination)
+	// Record the request and every destination's response when capturing is enabled
+	capture := newCaptureRecorder(r)
 
-	// Call the forwarding logic and get the response from the default destination
-	defaultResponse, responseBody, err := forwardRequestToDestinations(r, activeDestinations, *defaultDestination)
+	// Call the forwarding logic and get the response from the default destination, or every
+	// destination's in aggregate mode; the mode may come from the matching routing rule
+	strategy := responseStrategyFor(r, activeDestinations)
+	defaultResponse, err := forwardRequestToDestinations(r, activeDestinations, *defaultDestination, strategy, capture)
 	if err != nil {
-		log.Printf("Error forwarding request: %v", err)
+		log.Printf("[%s] Error forwarding request: %v", reqID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if bodyLimitExceeded(limitedBody) {
+			rejectTooLarge(w, r)
+			go capture.save(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if budget > 0 && errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			log.Printf("[%s] Request deadline of %s exceeded", reqID, budget)
+			http.Error(w, fmt.Sprintf("Request deadline of %s exceeded: %v", budget, err), http.StatusGatewayTimeout)
+			go capture.save(http.StatusGatewayTimeout)
+			return
+		}
+		if r.Context().Err() != nil {
+			// Nobody is left to answer; the requests to the destinations were cancelled with the client's
+			log.Printf("[%s] Client disconnected before the response was ready", reqID)
+			go capture.save(statusClientClosedRequest)
+			return
+		}
+		if errors.Is(err, errThrottleQueueFull) {
+			// The default destination is throttled and enough requests are already waiting
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("Error forwarding request: %v", err), http.StatusServiceUnavailable)
+			go capture.save(http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Error forwarding request: %v", err), http.StatusInternalServerError)
+		go capture.save(http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		// Closing waits for the other destinations, so the capture is complete afterwards
+		defaultResponse.Body.Close()
+		go capture.save(defaultResponse.StatusCode)
+	}()
+
+	// A streamed body may have hit the size limit while the default destination was answering
+	if bodyLimitExceeded(limitedBody) {
+		rejectTooLarge(w, r)
 		return
 	}
 
-	log.Printf("Response received from forwardRequestToDestinations")
+	log.Printf("[%s] Response received from forwardRequestToDestinations", reqID)
 
-	if defaultResponse.StatusCode == 404 {
-		log.Printf("Default destination returned 404. URL: %s, Response: %s", fullURL.String(), string(responseBody))
+	// Buffer small responses so they can be logged; stream everything else straight through
+	var responseBody io.Reader = defaultResponse.Body
+	var buffered []byte
+	if defaultResponse.ContentLength >= 0 && defaultResponse.ContentLength <= requestConfig(r.Context()).Forwarding.MaxBufferedBodyBytes {
+		buffered, err = ioutil.ReadAll(defaultResponse.Body)
+		if err != nil {
+			log.Printf("[%s] Error reading response body from default destination: %v", reqID, err)
+			http.Error(w, "Error reading response from default destination", http.StatusBadGateway)
+			return
+		}
+		readable := readableBody(defaultResponse.Header, buffered)
+		if defaultResponse.StatusCode == 404 {
+			log.Printf("[%s] Default destination returned 404. URL: %s, Response: %s", reqID, fullURL.String(), string(readable))
+		}
+		log.Printf("[%s] Response body: %s", reqID, string(readable))
+		responseBody = bytes.NewReader(buffered)
+	} else if defaultResponse.StatusCode == 404 {
+		log.Printf("[%s] Default destination returned 404. URL: %s", reqID, fullURL.String())
+	}
+	if sink := capture.defaultResponseBodyWriter(); sink != nil {
+		responseBody = io.TeeReader(responseBody, sink)
 	}
 
-	log.Printf("Received response from default destination: Status %d, Headers: %+v, Body length %d", defaultResponse.StatusCode, defaultResponse.Header, len(responseBody))
-	log.Printf("Response body: %s", string(responseBody))
+	log.Printf("[%s] Received response from default destination: Status %d, Headers: %+v, Content-Length %d", reqID, defaultResponse.StatusCode, defaultResponse.Header, defaultResponse.ContentLength)
 
 	// Copy the response from the default destination to the client
+	removeHopByHopHeaders(defaultResponse.Header)
 	for k, v := range defaultResponse.Header {
+		if k == "Set-Cookie" {
+			v = append(w.Header()[k], v...) // Keep the experiment cookie
+		}
 		w.Header()[k] = v
-		log.Printf("Setting header: %s: %v", k, v)
+		log.Printf("[%s] Setting header: %s: %v", reqID, k, v)
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", defaultResponse.StatusCode))
+	out, finish := compressResponse(w, r, defaultResponse.StatusCode, defaultResponse.ContentLength)
 	w.WriteHeader(defaultResponse.StatusCode)
-	_, err = w.Write(responseBody)
+	written, err := copyResponseBody(out, defaultResponse, responseBody)
+	finish()
 	if err != nil {
-		log.Printf("Error writing response: %v", err)
+		log.Printf("[%s] Error writing response: %v", reqID, err)
+	} else {
+		if cache != nil && buffered != nil {
+			cache.store(r, defaultResponse, buffered)
+		}
+		if dedup != nil {
+			dedup.complete(defaultResponse, buffered)
+		}
 	}
 
-	log.Printf("Response sent to client: Status %d, Body length %d", defaultResponse.StatusCode, len(responseBody))
+	log.Printf("[%s] Response sent to client: Status %d, Body length %d", reqID, defaultResponse.StatusCode, written)
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR is an HTTP Archive (http://www.softwareishard.com/blog/har-12-spec/), the format browser
+// devtools import and export. Fields prefixed with an underscore are hopper extensions.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	RequestID       string      `json:"_requestId,omitempty"`
+	Destination     string      `json:"_destination,omitempty"` // URL of the destination that answered
+	Error           string      `json:"_error,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text"`
+	Encoding  string `json:"_encoding,omitempty"` // "base64" for binary bodies
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type HARContent struct {
+	Size      int    `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harHeaders flattens headers into HAR name/value pairs, sorted by name
+func harHeaders(h map[string][]string) []HARNameValue {
+	pairs := []HARNameValue{}
+	for name, values := range h {
+		for _, value := range values {
+			pairs = append(pairs, HARNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+// harBody returns the body as text, base64-encoding it unless it is valid UTF-8
+func harBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// harMimeType returns the media type of a Content-Type header
+func harMimeType(h map[string][]string) string {
+	contentType := http.Header(h).Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// harEntry converts a capture into a HAR entry; the response is the one the client received
+// (the default destination's), or the first one recorded when there was no default
+func harEntry(capture Capture) HAREntry {
+	target := url.URL{Scheme: "http", Host: capture.Host, Path: capture.Path, RawQuery: capture.RawQuery}
+	entry := HAREntry{
+		StartedDateTime: capture.CreatedAt,
+		RequestID:       capture.RequestID,
+		Request: HARRequest{
+			Method:      capture.Method,
+			URL:         target.String(),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(capture.Headers),
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(capture.Body),
+		},
+		Response: HARResponse{
+			Status:      capture.Status,
+			StatusText:  http.StatusText(capture.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	if query, err := url.ParseQuery(capture.RawQuery); err == nil {
+		entry.Request.QueryString = harHeaders(query)
+	}
+	if len(capture.Body) > 0 {
+		text, encoding := harBody(capture.Body)
+		entry.Request.PostData = &HARPostData{
+			MimeType:  harMimeType(capture.Headers),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: capture.BodyTruncated,
+		}
+	}
+
+	var resp *CapturedResponse
+	for i := range capture.Responses {
+		if capture.Responses[i].IsDefault {
+			resp = &capture.Responses[i]
+			break
+		}
+	}
+	if resp == nil && len(capture.Responses) > 0 {
+		resp = &capture.Responses[0]
+	}
+	if resp != nil {
+		entry.Destination = resp.URL
+		entry.Error = resp.Error
+		entry.Time = float64(resp.LatencyMs)
+		entry.Timings.Wait = float64(resp.LatencyMs)
+		entry.Response.Headers = harHeaders(resp.Headers)
+		entry.Response.BodySize = len(resp.Body)
+		// HAR content is the decoded body; bodySize stays what was transferred
+		content := resp.Body
+		if !resp.BodyTruncated {
+			content = readableBody(resp.Headers, resp.Body)
+		}
+		entry.Response.Content = HARContent{Size: len(content), MimeType: harMimeType(resp.Headers), Truncated: resp.BodyTruncated}
+		entry.Response.Content.Text, entry.Response.Content.Encoding = harBody(content)
+		if location := http.Header(resp.Headers).Get("Location"); location != "" {
+			entry.Response.RedirectURL = location
+		}
+	}
+	return entry
+}
+
+// harCreatorVersion is the module version the binary was built from
+func harCreatorVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// ExportCaptures returns captured traffic as a HAR file, oldest first, filtered by path prefix
+// and time range: GET /captures/export?format=har&path=/orders&since=2h&until=...
+func ExportCaptures(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "har" {
+		http.Error(w, "Invalid format parameter (har)", http.StatusBadRequest)
+		return
+	}
+	filter := CaptureFilter{PathPrefix: query.Get("path"), Limit: 1000}
+	if since := query.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := parseSince(until)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid until parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Until = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 10000 {
+			http.Error(w, "Invalid limit parameter (1-10000)", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	if !strings.HasPrefix(filter.PathPrefix, "/") && filter.PathPrefix != "" {
+		filter.PathPrefix = "/" + filter.PathPrefix
+	}
+
+	captures, err := findCapturesInDB(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting captures: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Captures come newest first; HAR viewers expect the entries in the order they happened
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "http-hopper", Version: harCreatorVersion()},
+		Entries: make([]HAREntry, 0, len(captures)),
+	}}
+	for i := len(captures) - 1; i >= 0; i-- {
+		har.Log.Entries = append(har.Log.Entries, harEntry(captures[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="captures.har"`)
+	if err := json.NewEncoder(w).Encode(har); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding HAR: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
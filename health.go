@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errNoActiveDestination = errors.New("no active destination")
+	errDraining            = errors.New("draining")
+)
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Status    string `json:"status"` // "ok" or "fail"
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+	Active    *int   `json:"active,omitempty"` // Active destinations, for the destinations check
+}
+
+// HealthStatus is returned by /healthz and /readyz
+type HealthStatus struct {
+	Status string                 `json:"status"` // "ok" or "unavailable"
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// Healthz is the liveness probe: the process is up and serving requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthStatus{Status: "ok"})
+}
+
+// Readyz is the readiness probe: the hopper is not draining, the storage backend answers and, with
+// health.require_active_destination, there is an active destination to forward to
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestConfig(r.Context()).Health.timeout)
+	defer cancel()
+	status := checkReadiness(ctx)
+	for name, result := range status.Checks {
+		if result.Status != "ok" {
+			log.Printf("[%s] Readiness check %s failed: %s", requestIDFromContext(r.Context()), name, result.Error)
+		}
+	}
+	writeHealth(w, status)
+}
+
+// checkReadiness runs the readiness checks of /readyz
+func checkReadiness(ctx context.Context) HealthStatus {
+	status := HealthStatus{Status: "ok", Checks: map[string]HealthCheck{}}
+
+	check := func(name string, run func() (*int, error)) {
+		start := time.Now()
+		active, err := run()
+		result := HealthCheck{Status: "ok", LatencyMs: time.Since(start).Milliseconds(), Active: active}
+		if err != nil {
+			result.Status, result.Error = "fail", err.Error()
+			status.Status = "unavailable"
+		}
+		status.Checks[name] = result
+	}
+
+	check("drain", func() (*int, error) {
+		if isDraining() {
+			return nil, errDraining
+		}
+		return nil, nil
+	})
+	check("storage", func() (*int, error) {
+		return nil, store.Ping(ctx)
+	})
+	if requestConfig(ctx).Health.RequireActiveDestination {
+		check("destinations", func() (*int, error) {
+			destinations, err := store.All(ctx)
+			if err != nil {
+				return nil, err
+			}
+			active := 0
+			for _, d := range destinations {
+				if d.effectivelyActive(time.Now()) {
+					active++
+				}
+			}
+			if active == 0 {
+				return &active, errNoActiveDestination
+			}
+			return &active, nil
+		})
+	}
+	return status
+}
+
+// failedChecks describes the failed checks of a status, e.g. "storage: connection refused"
+func failedChecks(status HealthStatus) string {
+	var failed []string
+	for name, result := range status.Checks {
+		if result.Status != "ok" {
+			failed = append(failed, name+": "+result.Error)
+		}
+	}
+	sort.Strings(failed)
+	return strings.Join(failed, ", ")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditRollback is the audit and history action of POST /destinations/{id}/rollback/{version}
+const AuditRollback = "rollback"
+
+// DestinationVersion is a snapshot of a destination as it was after one change
+type DestinationVersion struct {
+	DestinationID primitive.ObjectID `bson:"destinationId" json:"destinationId"`
+	Version       int64              `bson:"version" json:"version"`
+	Timestamp     time.Time          `bson:"timestamp" json:"timestamp"`
+	Principal     string             `bson:"principal" json:"principal"`
+	Action        string             `bson:"action" json:"action"`
+	Destination   *Destination       `bson:"destination,omitempty" json:"destination,omitempty"` // nil when the change deleted the destination
+}
+
+// recordHistory snapshots a destination after a change. after is nil for deletions, which
+// are recorded under the version that follows before.
+func recordHistory(r *http.Request, action string, before, after *Destination) {
+	if mongoClient == nil {
+		return // History requires the mongodb storage driver
+	}
+	entry := DestinationVersion{
+		Timestamp:   time.Now().UTC(),
+		Principal:   principalFromContext(r.Context()),
+		Action:      action,
+		Destination: after,
+	}
+	if entry.Principal == "" {
+		entry.Principal = "anonymous"
+	}
+	if after != nil {
+		entry.DestinationID, entry.Version = after.ID, after.Version
+	} else {
+		entry.DestinationID, entry.Version = before.ID, before.Version+1
+	}
+	if err := insertDestinationVersionToDB(context.WithoutCancel(r.Context()), entry); err != nil {
+		log.Printf("[%s] Error recording version %d of destination %s: %v", requestIDFromContext(r.Context()), entry.Version, entry.DestinationID.Hex(), err)
+	}
+}
+
+// GetDestinationHistory lists a destination's versions, newest first
+func GetDestinationHistory(w http.ResponseWriter, r *http.Request) {
+	objectID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid destination ID", http.StatusBadRequest)
+		return
+	}
+	versions, err := getDestinationHistoryFromDB(r.Context(), objectID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting destination history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "No history for this destination", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// RollbackDestination restores a destination to the state recorded for an earlier version.
+// The rollback is itself a new version, so it can be rolled back too.
+func RollbackDestination(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	target, err := strconv.ParseInt(params["version"], 10, 64)
+	if err != nil || target < 1 {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	current, err := store.Get(r.Context(), params["id"])
+	if !writeDestinationDBError(w, "rolling back", err) {
+		return
+	}
+	if current.Archived {
+		http.Error(w, "Destination is deleted; restore it before rolling back", http.StatusConflict)
+		return
+	}
+	// If-Match is optional here: a rollback usually has to happen in a single call
+	if r.Header.Get("If-Match") != "" {
+		version, err := expectedVersion(r, Destination{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if version != anyVersion && version != current.Version {
+			writeDestinationDBError(w, "rolling back", errConflict)
+			return
+		}
+	}
+
+	snapshot, err := getDestinationVersionFromDB(r.Context(), current.ID, target)
+	if err == errNotFound {
+		http.Error(w, fmt.Sprintf("Version %d not found", target), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting version %d: %v", target, err), http.StatusInternalServerError)
+		return
+	}
+	if snapshot.Destination == nil {
+		http.Error(w, fmt.Sprintf("Version %d records a deletion and cannot be rolled back to", target), http.StatusConflict)
+		return
+	}
+
+	restored := *snapshot.Destination
+	restored.ID = current.ID
+	restored.Version = current.Version + 1
+	restored.Archived, restored.ArchivedAt = false, nil
+	if !checkGroupExists(w, r, restored.Group) {
+		return
+	}
+	if !writeDestinationDBError(w, "rolling back", store.Replace(r.Context(), restored, current.Version)) {
+		return
+	}
+	log.Printf("Rolled back destination %s from version %d to the state of version %d", current.ID.Hex(), current.Version, target)
+	recordAudit(r, AuditEntry{Action: AuditRollback, Resource: AuditDestination, ResourceID: current.ID.Hex(),
+		Before: &current, After: &restored, Details: fmt.Sprintf("to version %d", target)})
+	recordHistory(r, AuditRollback, &current, &restored)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", restored.etag())
+	json.NewEncoder(w).Encode(restored)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newHTTP3Server creates the optional QUIC listener that serves the same handler as the TCP
+// listener. With ACME enabled it shares the autocert manager's certificates.
+func newHTTP3Server(handler http.Handler, acmeManager *autocert.Manager) *http3.Server {
+	cfg := currentConfig()
+	srv := &http3.Server{
+		Addr:    fmt.Sprintf("%s:%s", cfg.App.Host, cfg.App.HTTP3.Port),
+		Handler: handler,
+	}
+	if acmeManager != nil {
+		srv.TLSConfig = http3.ConfigureTLSConfig(&tls.Config{GetCertificate: acmeManager.GetCertificate})
+	}
+	return srv
+}
+
+// startHTTP3Server runs the QUIC listener in the background
+func startHTTP3Server(srv *http3.Server) {
+	cfg := currentConfig()
+	go func() {
+		log.Printf("Starting HTTP/3 (QUIC) listener on udp %s", srv.Addr)
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServe()
+		} else {
+			err = srv.ListenAndServeTLS(cfg.App.HTTP3.CertFile, cfg.App.HTTP3.KeyFile)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start HTTP/3 server: %v", err)
+		}
+	}()
+}
+
+// AltSvcMiddleware advertises the HTTP/3 endpoint on TCP responses so clients can upgrade to QUIC
+func AltSvcMiddleware(srv *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.SetQUICHeaders(w.Header()); err != nil {
+			log.Printf("Error setting Alt-Svc header: %v", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+)
+
+// idempotencyBaseKey carries the request ID generated idempotency keys are derived from when it
+// differs from the request's own ID, i.e. the original request's ID on replays
+type idempotencyBaseKey struct{}
+
+// withIdempotencyBase makes generated idempotency keys derive from requestID
+func withIdempotencyBase(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, idempotencyBaseKey{}, requestID)
+}
+
+// idempotencyKey returns the key sent to destination for the request: the client's own
+// Idempotency-Key when it sent one, otherwise (with forwarding.generate_idempotency_keys) a key
+// derived from the request ID and the destination. The derived key is the same for every
+// attempt of a queued delivery, for dead letters retried through the API and for replays of a
+// captured request, so upstreams can recognize them as repeats.
+func idempotencyKey(r *http.Request, destination Destination) string {
+	cfg := requestConfig(r.Context()).Forwarding
+	if key := r.Header.Get(cfg.IdempotencyHeader); key != "" {
+		return key
+	}
+	if !cfg.GenerateIdempotencyKeys {
+		return ""
+	}
+	base, ok := r.Context().Value(idempotencyBaseKey{}).(string)
+	if !ok || base == "" {
+		base = requestIDFromContext(r.Context())
+	}
+	sum := sha256.Sum256([]byte(base + "/" + destination.ID.Hex()))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// setIdempotencyKey adds the destination's idempotency key to the outbound headers and returns it
+func setIdempotencyKey(h http.Header, r *http.Request, destination Destination) string {
+	key := idempotencyKey(r, destination)
+	if key != "" {
+		h.Set(requestConfig(r.Context()).Forwarding.IdempotencyHeader, key)
+	}
+	return key
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPFilterList allows or denies clients by address. Deny entries win over allow entries and
+// an empty allow list allows everyone not denied.
+type IPFilterList struct {
+	Allow []string `yaml:"allow"` // CIDRs or single addresses
+	Deny  []string `yaml:"deny"`
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// parsePrefixes parses CIDRs, treating a bare address as a single-host prefix
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parse prepares the list after the configuration is loaded
+func (l *IPFilterList) parse() error {
+	var err error
+	if l.allow, err = parsePrefixes(l.Allow); err != nil {
+		return err
+	}
+	l.deny, err = parsePrefixes(l.Deny)
+	return err
+}
+
+// containsAddr reports whether any prefix contains the address
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether a client address may use the routes guarded by the list
+func (l *IPFilterList) Allows(ip string) bool {
+	if len(l.allow) == 0 && len(l.deny) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false // Unparseable addresses can't be matched against an allow list
+	}
+	addr = addr.Unmap()
+	if containsAddr(l.deny, addr) {
+		return false
+	}
+	return len(l.allow) == 0 || containsAddr(l.allow, addr)
+}
+
+// isTrustedProxy reports whether an address belongs to forwarding.trusted_proxies
+func isTrustedProxy(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	return err == nil && containsAddr(proxies, addr.Unmap())
+}
+
+// The lists of ip_filter, taken from the configuration of each request since a reload can
+// change them
+func adminIPs(cfg *Config) *IPFilterList      { return &cfg.IPFilter.Admin }
+func forwardingIPs(cfg *Config) *IPFilterList { return &cfg.IPFilter.Forwarding }
+
+// allowIPs rejects clients the list does not allow with 403
+func allowIPs(list func(*Config) *IPFilterList, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if !list(requestConfig(r.Context())).Allows(ip) {
+			log.Printf("[%s] Client %s is not allowed to access %s %s", requestIDFromContext(r.Context()), ip, r.Method, r.URL.Path)
+			event := newTrafficEvent(EventRejected, r)
+			event.Status = http.StatusForbidden
+			event.Message = fmt.Sprintf("Client %s is not allowed", ip)
+			BroadcastTraffic(event)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaSink publishes traffic events to traffic.kafka.topic, keyed by request ID so the
+// events of one request stay in order on one partition. Events are queued and written in
+// batches by a single goroutine; when the queue is full (Kafka is slow or down) new events are
+// dropped rather than slowing down forwarding.
+type kafkaSink struct {
+	cfg       KafkaSinkConfig
+	writer    *kafka.Writer
+	events    chan kafka.Message
+	done      chan struct{}
+	ctx       context.Context // Cancelled when Close gives up flushing
+	cancel    context.CancelFunc
+	published atomic.Uint64
+	dropped   atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// trafficSink is the Kafka sink, nil when traffic.kafka is disabled
+var trafficSink *kafkaSink
+
+// kafkaWriteBatch bounds the number of events written at once
+const kafkaWriteBatch = 500
+
+// kafkaFlushTimeout bounds how long shutdown waits for queued events to be published
+const kafkaFlushTimeout = 5 * time.Second
+
+// startKafkaSink connects the writer and starts publishing
+func startKafkaSink(cfg KafkaSinkConfig) (*kafkaSink, error) {
+	transport := &kafka.Transport{ClientID: "http-hopper"}
+	if cfg.TLS.Enabled {
+		tlsConfig, err := (&DestinationTLS{ClientCertFile: cfg.TLS.CertFile, ClientKeyFile: cfg.TLS.KeyFile, CAFile: cfg.TLS.CAFile}).clientTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kafka TLS configuration: %v", err)
+		}
+		tlsConfig.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+		transport.TLS = tlsConfig
+	}
+	mechanism, err := kafkaSASLMechanism(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = mechanism
+
+	s := &kafkaSink{
+		cfg: cfg,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    kafkaWriteBatch,
+			BatchTimeout: cfg.batchTimeout,
+			RequiredAcks: kafka.RequireOne,
+			Transport:    transport,
+		},
+		events: make(chan kafka.Message, cfg.Buffer),
+		done:   make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	log.Printf("Publishing traffic events to Kafka topic %s on %s", cfg.Topic, strings.Join(cfg.Brokers, ","))
+	return s, nil
+}
+
+// kafkaSASLMechanism returns the configured SASL mechanism, nil when none is configured
+func kafkaSASLMechanism(cfg KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToLower(cfg.Mechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	}
+	return nil, fmt.Errorf("invalid Kafka SASL mechanism %q: must be plain, scram-sha-256 or scram-sha-512", cfg.Mechanism)
+}
+
+// publish queues an event without blocking; message is the event as sent to stream clients
+func (s *kafkaSink) publish(event TrafficEvent, message []byte) {
+	if len(s.cfg.Types) > 0 && !contains(s.cfg.Types, event.Type) {
+		return
+	}
+	if s.cfg.Redact {
+		redacted, err := json.Marshal(redactEvent(event))
+		if err != nil {
+			return
+		}
+		message = redacted
+	}
+	select {
+	case s.events <- kafka.Message{Key: []byte(event.RequestID), Value: message, Time: event.Timestamp}:
+	default:
+		if s.dropped.Add(1)%1000 == 1 {
+			log.Printf("Kafka sink is falling behind, %d traffic events dropped so far", s.dropped.Load())
+		}
+	}
+}
+
+// run writes the queued events in batches until the sink is closed
+func (s *kafkaSink) run() {
+	defer close(s.done)
+	batch := make([]kafka.Message, 0, kafkaWriteBatch)
+	for message := range s.events {
+		batch = append(batch[:0], message)
+	collect:
+		for len(batch) < kafkaWriteBatch {
+			select {
+			case message, ok := <-s.events:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, message)
+			default:
+				break collect
+			}
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+		err := s.writer.WriteMessages(ctx, batch...)
+		cancel()
+		if err != nil {
+			s.failed.Add(uint64(len(batch)))
+			log.Printf("Error publishing %d traffic events to Kafka: %v", len(batch), err)
+			continue
+		}
+		s.published.Add(uint64(len(batch)))
+	}
+}
+
+// Close publishes the events still queued, giving up after kafkaFlushTimeout, and closes the writer
+func (s *kafkaSink) Close() error {
+	close(s.events)
+	select {
+	case <-s.done:
+	case <-time.After(kafkaFlushTimeout):
+		log.Printf("Kafka sink flush timed out with %d traffic events queued", len(s.events))
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+	return s.writer.Close()
+}
+
+// kafkaSinkStats is reported by GET /traffic/stats
+type kafkaSinkStats struct {
+	Topic     string `json:"topic"`
+	Queued    int    `json:"queued"`
+	Published uint64 `json:"published"`
+	Dropped   uint64 `json:"dropped"` // The queue was full
+	Failed    uint64 `json:"failed"`  // Kafka rejected the write
+}
+
+func (s *kafkaSink) stats() kafkaSinkStats {
+	return kafkaSinkStats{
+		Topic:     s.cfg.Topic,
+		Queued:    len(s.events),
+		Published: s.published.Load(),
+		Dropped:   s.dropped.Load(),
+		Failed:    s.failed.Load(),
+	}
+}
+
+// stopKafkaSink detaches the sink from the traffic stream and flushes it
+func stopKafkaSink() {
+	mu.Lock()
+	sink := trafficSink
+	trafficSink = nil
+	mu.Unlock()
+	if sink == nil {
+		return
+	}
+	if err := sink.Close(); err != nil {
+		log.Printf("Error closing the Kafka sink: %v", err)
+	}
+}
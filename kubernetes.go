@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service annotations read by Kubernetes discovery
+const (
+	k8sAnnotationDefault = "http-hopper/default" // "true" makes the destination the default one
+	k8sAnnotationTags    = "http-hopper/tags"    // Comma-separated destination tags
+	k8sAnnotationPath    = "http-hopper/path"    // Appended to the destination URL
+	k8sAnnotationPort    = "http-hopper/port"    // Port name or number; overrides kubernetes.port
+)
+
+// k8sServiceAccountDir holds the credentials Kubernetes mounts into every pod
+const k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesDiscovery turns the Services matching discovery.kubernetes.label_selector into
+// destinations: one per Service (its cluster DNS name) in "service" mode, or one per ready
+// endpoint in "endpoints" mode. It talks to the API server directly with the pod's service
+// account and watches for changes, with a periodic resync as a fallback.
+type kubernetesDiscovery struct {
+	cfg       KubernetesDiscoveryConfig
+	apiServer string
+	namespace string // Empty for all namespaces
+	client    *http.Client
+	trigger   chan struct{}
+}
+
+// Subsets of the Kubernetes API objects
+type k8sMetadata struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	Annotations     map[string]string `json:"annotations"`
+	ResourceVersion string            `json:"resourceVersion"`
+}
+
+type k8sService struct {
+	Metadata k8sMetadata `json:"metadata"`
+	Spec     struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type k8sEndpointSlice struct {
+	Ports []struct {
+		Name string `json:"name"`
+		Port *int   `json:"port"`
+	} `json:"ports"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+}
+
+// startKubernetesDiscovery connects to the API server and starts reconciling in the background
+func startKubernetesDiscovery(cfg KubernetesDiscoveryConfig) error {
+	k := &kubernetesDiscovery{cfg: cfg, apiServer: cfg.APIServer, trigger: make(chan struct{}, 1)}
+	if k.apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" {
+			return fmt.Errorf("api_server is not set and the hopper is not running in a cluster")
+		}
+		k.apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	k.apiServer = strings.TrimRight(k.apiServer, "/")
+
+	switch cfg.Namespace {
+	case "*":
+	case "":
+		namespace, err := ioutil.ReadFile(k8sServiceAccountDir + "/namespace")
+		if err != nil {
+			return fmt.Errorf("namespace is not set and the pod's namespace is unknown: %v", err)
+		}
+		k.namespace = strings.TrimSpace(string(namespace))
+	default:
+		k.namespace = cfg.Namespace
+	}
+
+	tlsConfig, err := (&DestinationTLS{CAFile: cfg.CAFile}).clientTLSConfig()
+	if err != nil {
+		return fmt.Errorf("invalid Kubernetes CA: %v", err)
+	}
+	k.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}}
+
+	go k.watch("/api/v1", "services", url.Values{"labelSelector": {cfg.LabelSelector}})
+	if cfg.Mode == "endpoints" {
+		go k.watch("/apis/discovery.k8s.io/v1", "endpointslices", url.Values{})
+	}
+	go runDiscovery("kubernetes", cfg.resyncInterval, k.trigger, k.targets)
+	where := "all namespaces"
+	if k.namespace != "" {
+		where = "namespace " + k.namespace
+	}
+	log.Printf("Kubernetes discovery enabled: %s matching %q in %s", cfg.Mode, cfg.LabelSelector, where)
+	return nil
+}
+
+// resourcePath returns the path of a resource list in the watched namespace(s)
+func (k *kubernetesDiscovery) resourcePath(group, resource, namespace string) string {
+	if namespace == "" {
+		return group + "/" + resource
+	}
+	return group + "/namespaces/" + namespace + "/" + resource
+}
+
+// request sends an authenticated GET; the service account token is read every time since
+// Kubernetes rotates it
+func (k *kubernetesDiscovery) request(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", k.apiServer+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token, err := ioutil.ReadFile(k.cfg.TokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// list decodes the items of a resource list
+func (k *kubernetesDiscovery) list(ctx context.Context, path string, query url.Values, items interface{}) error {
+	resp, err := k.request(ctx, path, query)
+	if err != nil {
+		return fmt.Errorf("Kubernetes List Error: %v", err)
+	}
+	defer resp.Body.Close()
+	list := struct {
+		Items interface{} `json:"items"`
+	}{Items: items}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("Kubernetes Decode Error for %s: %v", path, err)
+	}
+	return nil
+}
+
+// watch triggers a reconciliation on every change to the resources, reconnecting whenever
+// the API server ends the watch
+func (k *kubernetesDiscovery) watch(group, resource string, query url.Values) {
+	query.Set("watch", "true")
+	query.Set("timeoutSeconds", "300")
+	path := k.resourcePath(group, resource, k.namespace)
+	for {
+		resp, err := k.request(context.Background(), path, query)
+		if err != nil {
+			log.Printf("Kubernetes watch of %s failed: %v", resource, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var event struct {
+				Type string `json:"type"`
+			}
+			if err := decoder.Decode(&event); err != nil {
+				break
+			}
+			if event.Type != "BOOKMARK" {
+				signalDiscovery(k.trigger)
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+// targets lists the matching Services and builds their destinations
+func (k *kubernetesDiscovery) targets(ctx context.Context) ([]Destination, error) {
+	var services []k8sService
+	if err := k.list(ctx, k.resourcePath("/api/v1", "services", k.namespace), url.Values{"labelSelector": {k.cfg.LabelSelector}}, &services); err != nil {
+		return nil, err
+	}
+	targets := []Destination{}
+	for _, service := range services {
+		meta := service.Metadata
+		port := k.cfg.Port
+		if annotated := meta.Annotations[k8sAnnotationPort]; annotated != "" {
+			port = annotated
+		}
+		base := Destination{
+			IsActive:  true,
+			IsDefault: meta.Annotations[k8sAnnotationDefault] == "true",
+			Tags:      splitTags(meta.Annotations[k8sAnnotationTags]),
+		}
+		path := meta.Annotations[k8sAnnotationPath]
+
+		if k.cfg.Mode == "service" {
+			servicePort, ok := selectK8sPort(service, port)
+			if !ok {
+				log.Printf("Kubernetes discovery: service %s/%s has no port %q", meta.Namespace, meta.Name, port)
+				continue
+			}
+			d := base
+			host := fmt.Sprintf("%s.%s.svc", meta.Name, meta.Namespace)
+			d.URL = fmt.Sprintf("%s://%s%s", k.cfg.Scheme, net.JoinHostPort(host, strconv.Itoa(servicePort)), path)
+			d.Discovery = &DestinationDiscovery{Key: meta.Namespace + "/" + meta.Name}
+			targets = append(targets, d)
+			continue
+		}
+
+		var slices []k8sEndpointSlice
+		query := url.Values{"labelSelector": {"kubernetes.io/service-name=" + meta.Name}}
+		if err := k.list(ctx, k.resourcePath("/apis/discovery.k8s.io/v1", "endpointslices", meta.Namespace), query, &slices); err != nil {
+			return nil, err
+		}
+		for _, slice := range slices {
+			endpointPort := 0
+			for i, p := range slice.Ports {
+				if p.Port != nil && (p.Name == port || strconv.Itoa(*p.Port) == port || (port == "" && i == 0)) {
+					endpointPort = *p.Port
+					break
+				}
+			}
+			if endpointPort == 0 {
+				continue
+			}
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+					continue
+				}
+				for _, address := range endpoint.Addresses {
+					hostPort := net.JoinHostPort(address, strconv.Itoa(endpointPort))
+					d := base
+					d.URL = fmt.Sprintf("%s://%s%s", k.cfg.Scheme, hostPort, path)
+					d.Discovery = &DestinationDiscovery{Key: meta.Namespace + "/" + meta.Name + "/" + hostPort}
+					targets = append(targets, d)
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// selectK8sPort picks the Service port by name or number, or the first one when port is empty
+func selectK8sPort(service k8sService, port string) (int, bool) {
+	for i, p := range service.Spec.Ports {
+		if p.Name == port || strconv.Itoa(p.Port) == port || (port == "" && i == 0) {
+			return p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// splitTags parses a comma-separated tag list
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// bodyLimitReader enforces limits.max_body_bytes on a request body and remembers whether the
+// limit was hit, so the handler can answer 413 even when the error surfaced upstream
+type bodyLimitReader struct {
+	io.ReadCloser
+	exceeded atomic.Bool
+}
+
+func (b *bodyLimitReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// bodyLimitExceeded reports whether the request body was cut off by limits.max_body_bytes
+func bodyLimitExceeded(body io.Reader) bool {
+	limited, ok := body.(*bodyLimitReader)
+	return ok && limited.exceeded.Load()
+}
+
+// rejectTooLarge answers 413 and reports the rejected request on the traffic stream
+func rejectTooLarge(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("Request body exceeds the limit of %d bytes", requestConfig(r.Context()).Limits.MaxBodyBytes)
+	log.Printf("[%s] %s: %s %s (Content-Length: %d)", requestIDFromContext(r.Context()), message, r.Method, r.URL.Path, r.ContentLength)
+	event := newTrafficEvent(EventRejected, r)
+	event.Status = http.StatusRequestEntityTooLarge
+	event.Message = message
+	BroadcastTraffic(event)
+	http.Error(w, message, http.StatusRequestEntityTooLarge)
+}
+
+// BodyLimitMiddleware rejects requests whose declared size exceeds limits.max_body_bytes and
+// caps the bytes read from every other body
+func BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := requestConfig(r.Context()).Limits.MaxBodyBytes
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			rejectTooLarge(w, r)
+			return
+		}
+		r.Body = &bodyLimitReader{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shedLoad answers 429 with Retry-After when limits.max_in_flight forwarded requests are in
+// flight already (counted by drainable, which must wrap it), so an overloaded hopper turns
+// away the excess right away instead of letting every request slow down until it times out
+func shedLoad(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limits := requestConfig(r.Context()).Limits
+		limit := limits.MaxInFlight
+		if inFlight := drain.inFlight.Load(); limit > 0 && inFlight > int64(limit) {
+			retryAfter := int(math.Ceil(limits.overloadRetryAfter.Seconds()))
+			message := fmt.Sprintf("Overloaded: %d forwarded requests in flight, the limit is %d", inFlight-1, limit)
+			log.Printf("[%s] %s; shedding %s %s", requestIDFromContext(r.Context()), message, r.Method, r.URL.Path)
+			event := newTrafficEvent(EventRejected, r)
+			event.Status = http.StatusTooManyRequests
+			event.Message = message
+			BroadcastTraffic(event)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
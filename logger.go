@@ -10,7 +10,10 @@ func init() {
 	// Set up logging to a file
 	logFile, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+		// Don't refuse to start where the working directory isn't writable
+		log.SetOutput(os.Stdout)
+		log.Printf("Failed to open log file, logging to stdout only: %v", err)
+		return
 	}
 	defer logFile.Close()
 
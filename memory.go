@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps destinations in memory (storage.driver "memory" or --dev). With a snapshot
+// file the destinations are loaded from it at startup and written back after every change.
+type memoryStore struct {
+	mu           sync.RWMutex
+	destinations map[primitive.ObjectID]Destination
+	snapshot     string
+	storeWatchers
+}
+
+// openMemoryStore creates the store, loading the snapshot file when it exists
+func openMemoryStore(snapshot string) (*memoryStore, error) {
+	s := &memoryStore{destinations: make(map[primitive.ObjectID]Destination), snapshot: snapshot}
+	if snapshot == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(snapshot)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot %s: %v", snapshot, err)
+	}
+	var destinations []Destination
+	if err := json.Unmarshal(data, &destinations); err != nil {
+		return nil, fmt.Errorf("error decoding snapshot %s: %v", snapshot, err)
+	}
+	for _, d := range destinations {
+		if d.ID.IsZero() {
+			d.ID = primitive.NewObjectID()
+		}
+		s.destinations[d.ID] = d
+	}
+	log.Printf("Loaded %d destinations from %s", len(destinations), snapshot)
+	return s, nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// save writes the snapshot file, if any; the caller must hold s.mu. The file is replaced
+// atomically so a crash never leaves half a snapshot. Errors are logged only: the change
+// itself has been made.
+func (s *memoryStore) save() {
+	if s.snapshot == "" {
+		return
+	}
+	destinations := []Destination{}
+	for _, d := range s.destinations {
+		destinations = append(destinations, d)
+	}
+	sortDestinations(destinations, "")
+	data, err := json.MarshalIndent(destinations, "", "  ")
+	if err == nil {
+		tmp := s.snapshot + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, s.snapshot)
+		}
+	}
+	if err != nil {
+		log.Printf("Error writing snapshot %s: %v", s.snapshot, err)
+	}
+}
+
+// destinationsWhere returns the live or the archived destinations in ID order
+func (s *memoryStore) destinationsWhere(archived bool) []Destination {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	destinations := []Destination{}
+	for _, d := range s.destinations {
+		if d.Archived == archived {
+			destinations = append(destinations, d)
+		}
+	}
+	sortDestinations(destinations, "")
+	return destinations
+}
+
+func (s *memoryStore) All(ctx context.Context) ([]Destination, error) {
+	return s.destinationsWhere(false), nil
+}
+
+func (s *memoryStore) Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error) {
+	destinations := []Destination{}
+	for _, d := range s.destinationsWhere(q.Archived) {
+		if matchesDestinationQuery(d, q) {
+			destinations = append(destinations, d)
+		}
+	}
+	return pageDestinations(destinations, q)
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (Destination, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Destination{}, errInvalidID
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	destination, ok := s.destinations[objectID]
+	if !ok {
+		return Destination{}, errNotFound
+	}
+	return destination, nil
+}
+
+func (s *memoryStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	destination.ID = primitive.NewObjectID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destinations[destination.ID] = destination
+	s.save()
+	return destination.ID, nil
+}
+
+// modify applies change to a destination under the write lock
+func (s *memoryStore) modify(id string, change func(current Destination, doc bson.M) error) (Destination, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Destination{}, errInvalidID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.destinations[objectID]
+	if !ok {
+		return Destination{}, errNotFound
+	}
+	updated, err := changeDestination(current, change)
+	if err != nil {
+		return Destination{}, err
+	}
+	s.destinations[objectID] = updated
+	s.save()
+	return updated, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, id string, updatedDestination Destination, version int64) error {
+	_, err := s.modify(id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		if version != anyVersion && current.Version != version {
+			return errConflict
+		}
+		for key, value := range destinationUpdate(updatedDestination) {
+			doc[key] = value
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Updated document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *memoryStore) Archive(ctx context.Context, id string) error {
+	_, err := s.modify(id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		doc["archived"] = true
+		doc["archivedAt"] = time.Now().UTC()
+		return nil
+	})
+	if err == nil {
+		log.Printf("Archived document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *memoryStore) Restore(ctx context.Context, id string) (Destination, error) {
+	destination, err := s.modify(id, func(current Destination, doc bson.M) error {
+		if !current.Archived {
+			return errNotFound
+		}
+		delete(doc, "archived")
+		delete(doc, "archivedAt")
+		return nil
+	})
+	if err == nil {
+		log.Printf("Restored document with ID: %s", id)
+	}
+	return destination, err
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.destinations[objectID]; !ok {
+		return errNotFound
+	}
+	delete(s.destinations, objectID)
+	s.save()
+	log.Printf("Deleted document with ID: %s", id)
+	return nil
+}
+
+func (s *memoryStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.destinations[destination.ID]
+	if !ok || current.Archived || current.Version != version {
+		return errConflict
+	}
+	s.destinations[destination.ID] = destination
+	s.save()
+	return nil
+}
+
+// ApplyImport checks every update before changing anything, so it is all or nothing
+func (s *memoryStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range updates {
+		if current, ok := s.destinations[d.ID]; !ok || current.Version != d.Version-1 {
+			return fmt.Errorf("%s was modified while the import was applied", d.URL)
+		}
+	}
+	for _, d := range creates {
+		s.destinations[d.ID] = d
+	}
+	for _, d := range updates {
+		s.destinations[d.ID] = d
+	}
+	archivedAt := time.Now().UTC()
+	for _, d := range deletes {
+		current, ok := s.destinations[d.ID]
+		if !ok {
+			continue // Already gone
+		}
+		current.Archived, current.ArchivedAt = true, &archivedAt
+		current.Version++
+		s.destinations[d.ID] = current
+	}
+	s.save()
+	return nil
+}
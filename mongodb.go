@@ -2,88 +2,1077 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
-func getAllDestinationsFromDB() ([]Destination, error) {
-	collection := mongoClient.Database("http_hopper").Collection("destinations")
-	cursor, err := collection.Find(context.TODO(), bson.M{})
+var (
+	errNotFound      = errors.New("document not found")
+	errInvalidID     = errors.New("invalid ID format")
+	errAlreadyExists = errors.New("document already exists")
+	errGroupNotEmpty = errors.New("group has members")
+	errConflict      = errors.New("version conflict")
+)
+
+// mongoClientOptions builds the client options from the URL and the explicit settings, which
+// take precedence over the URL's
+func mongoClientOptions(cfg MongoDBConfig) (*options.ClientOptions, error) {
+	clientOptions := options.Client().ApplyURI(cfg.URL)
+	if err := clientOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid MongoDB url: %v", err)
+	}
+
+	if cfg.Username != "" || cfg.AuthMechanism != "" {
+		credential := options.Credential{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			PasswordSet:   cfg.Password != "" || cfg.PasswordFile != "",
+			AuthSource:    cfg.AuthSource,
+			AuthMechanism: cfg.AuthMechanism,
+		}
+		if cfg.PasswordFile != "" {
+			password, err := ioutil.ReadFile(cfg.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading MongoDB password file: %v", err)
+			}
+			credential.Password = strings.TrimRight(string(password), "\r\n")
+		}
+		clientOptions.SetAuth(credential)
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := (&DestinationTLS{ClientCertFile: cfg.TLS.CertFile, ClientKeyFile: cfg.TLS.KeyFile, CAFile: cfg.TLS.CAFile}).clientTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid MongoDB TLS configuration: %v", err)
+		}
+		tlsConfig.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if cfg.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.serverSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(cfg.serverSelectionTimeout)
+	}
+	if cfg.socketTimeout > 0 {
+		clientOptions.SetSocketTimeout(cfg.socketTimeout)
+	}
+
+	if cfg.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(cfg.ReplicaSet)
+	}
+	if cfg.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.ReadPreference)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MongoDB read preference: %v", err)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MongoDB read preference: %v", err)
+		}
+		clientOptions.SetReadPreference(rp)
+	}
+	switch cfg.WriteConcern {
+	case "":
+	case "majority":
+		clientOptions.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	default:
+		w, err := strconv.Atoi(cfg.WriteConcern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MongoDB write concern %q", cfg.WriteConcern)
+		}
+		clientOptions.SetWriteConcern(writeconcern.New(writeconcern.W(w)))
+	}
+	return clientOptions, nil
+}
+
+// changeStreamRetryInterval is the pause before a failed change stream is reopened
+const changeStreamRetryInterval = 5 * time.Second
+
+// mongoStore keeps destinations in the configured MongoDB collection
+type mongoStore struct {
+	cancel context.CancelFunc
+	storeWatchers
+}
+
+// newMongoStore returns the store for the connected client and starts following changes to
+// destinations and groups, so edits made directly in MongoDB or by other hoppers reach the
+// caches right away
+func newMongoStore() *mongoStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &mongoStore{cancel: cancel}
+	go watchCollection(ctx, destinationsCollection(), s.notify)
+	go watchCollection(ctx, groupsCollection(), invalidateGroupCache)
+	return s
+}
+
+func (s *mongoStore) Ping(ctx context.Context) error {
+	return mongoClient.Ping(ctx, nil)
+}
+
+func (s *mongoStore) Close() error {
+	s.cancel()
+	return mongoClient.Disconnect(context.Background())
+}
+
+// watchCollection calls onChange for every change to the collection, reopening the stream
+// where it left off after errors. Change streams need a replica set or sharded cluster; on a
+// standalone server it gives up and the caches only expire after storage.cache_ttl.
+func watchCollection(ctx context.Context, collection *mongo.Collection, onChange func()) {
+	var resumeToken bson.Raw
+	for ctx.Err() == nil {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := collection.Watch(ctx, mongo.Pipeline{}, opts)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == 40573 {
+			log.Printf("Change streams are not available for %s (requires a replica set); changes by others apply after storage.cache_ttl", collection.Name())
+			return
+		}
+		if err != nil {
+			log.Printf("Error opening change stream for %s: %v", collection.Name(), err)
+		} else {
+			log.Printf("Watching %s for changes", collection.Name())
+			onChange() // Changes may have been missed while the stream was down
+			for stream.Next(ctx) {
+				resumeToken = stream.ResumeToken()
+				onChange()
+			}
+			if err := stream.Err(); err != nil && ctx.Err() == nil {
+				log.Printf("Change stream for %s failed: %v", collection.Name(), err)
+			}
+			stream.Close(context.Background())
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(changeStreamRetryInterval):
+		}
+	}
+}
+
+func destinationsCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.MongoDB.Collection)
+}
+
+// migrateDestinationMethods rewrites documents from before method lists, which have a single
+// "method", to "methods". Decoding converts such documents as well (e.g. in the history or
+// restored from a backup), see Destination.UnmarshalBSON.
+func migrateDestinationMethods(ctx context.Context) error {
+	result, err := destinationsCollection().UpdateMany(ctx, bson.M{"method": bson.M{"$exists": true}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"methods": bson.M{"$cond": bson.A{
+			bson.M{"$in": bson.A{"$method", bson.A{"", nil}}}, "$methods", bson.A{bson.M{"$toUpper": "$method"}},
+		}}}}},
+		{{Key: "$unset", Value: "method"}},
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Migrated %d destinations from method to methods", result.ModifiedCount)
+	}
+	return nil
+}
+
+// notArchived matches destinations that have not been soft-deleted
+var notArchived = bson.M{"$ne": true}
+
+// All returns every destination except archived ones, which are kept only so they can be
+// restored
+func (s *mongoStore) All(ctx context.Context) ([]Destination, error) {
+	cursor, err := destinationsCollection().Find(ctx, bson.M{"archived": notArchived})
 	if err != nil {
 		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
 	}
 	var destinations []Destination
-	if err = cursor.All(context.TODO(), &destinations); err != nil {
+	if err = cursor.All(ctx, &destinations); err != nil {
 		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
 	}
 	return destinations, nil
 }
 
-func addDestinationToDB(destination Destination) {
-	collection := mongoClient.Database("http_hopper").Collection("destinations")
-	_, err := collection.InsertOne(context.TODO(), destination)
+// DestinationQuery filters, sorts and paginates the destination list
+type DestinationQuery struct {
+	IsActive   *bool
+	Archived   bool // List archived destinations instead of live ones
+	Method     string
+	Group      string
+	Tag        string
+	Namespaces []string // Destinations in any of these namespaces ("" is the default namespace); all when nil
+	Search     string   // Case-insensitive substring of the URL
+	Sort       string   // Field name, prefixed with "-" for descending order
+	Page       int      // 1-based; 0 returns every match
+	Limit      int
+}
+
+// Fields GET /destinations can sort by, mapped to their document keys
+var destinationSortFields = map[string]string{
+	"id":        "_id",
+	"url":       "url",
+	"group":     "group",
+	"method":    "methods",
+	"isActive":  "isActive",
+	"isDefault": "isDefault",
+}
+
+// Find returns one page of matching destinations and the total number of matches
+func (s *mongoStore) Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error) {
+	collection := destinationsCollection()
+
+	filter := bson.M{"archived": notArchived}
+	if q.Archived {
+		filter["archived"] = true
+	}
+	if q.IsActive != nil {
+		filter["isActive"] = *q.IsActive
+	}
+	if q.Method != "" {
+		filter["methods"] = q.Method
+	}
+	if q.Group != "" {
+		filter["group"] = q.Group
+	}
+	if q.Tag != "" {
+		filter["tags"] = q.Tag
+	}
+	if q.Namespaces != nil {
+		namespaces := bson.A{}
+		for _, name := range q.Namespaces {
+			if name == "" {
+				namespaces = append(namespaces, nil) // Also matches documents without the field
+			} else {
+				namespaces = append(namespaces, name)
+			}
+		}
+		filter["namespace"] = bson.M{"$in": namespaces}
+	}
+	if q.Search != "" {
+		filter["url"] = bson.M{"$regex": regexp.QuoteMeta(q.Search), "$options": "i"}
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
-		log.Fatal("MongoDB Insert Error:", err)
+		return nil, 0, fmt.Errorf("MongoDB Count Error: %v", err)
+	}
+
+	order := 1
+	field := strings.TrimPrefix(q.Sort, "-")
+	if strings.HasPrefix(q.Sort, "-") {
+		order = -1
+	}
+	sort := bson.D{{Key: "_id", Value: 1}}
+	if key, ok := destinationSortFields[field]; ok {
+		sort = bson.D{{Key: key, Value: order}}
+		if key != "_id" {
+			sort = append(sort, bson.E{Key: "_id", Value: 1}) // Keeps pages stable for equal values
+		}
+	}
+	opts := options.Find().SetSort(sort)
+	if q.Page > 0 {
+		opts.SetSkip(int64((q.Page - 1) * q.Limit)).SetLimit(int64(q.Limit))
 	}
-}
 
-func updateDestinationInDB(id string, updatedDestination Destination) {
-	collection := mongoClient.Database("http_hopper").Collection("destinations")
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	destinations := []Destination{}
+	if err = cursor.All(ctx, &destinations); err != nil {
+		return nil, 0, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return destinations, total, nil
+}
 
-	// Convert the ID string to ObjectID
+func (s *mongoStore) Get(ctx context.Context, id string) (Destination, error) {
+	var destination Destination
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		log.Printf("Invalid ID format: %v", err)
-		return
+		return destination, errInvalidID
+	}
+	err = destinationsCollection().FindOne(ctx, bson.M{"_id": objectID}).Decode(&destination)
+	if err == mongo.ErrNoDocuments {
+		return destination, errNotFound
 	}
+	if err != nil {
+		return destination, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	return destination, nil
+}
 
-	// Prepare the update document
+// Add inserts a destination and returns its generated ID
+func (s *mongoStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	destination.ID = primitive.NilObjectID // Let MongoDB generate the ID
+	destination.Archived, destination.ArchivedAt = false, nil
+	destination.Version = 1
+	result, err := destinationsCollection().InsertOne(ctx, destination)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	id, _ := result.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+// versionFilter matches a document at the given version; documents written before versioning
+// have no version field and count as version 0
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// destinationUpdate returns the fields a partial update (PUT /destinations/{id}) sets
+func destinationUpdate(updatedDestination Destination) bson.M {
 	update := bson.M{}
 	if updatedDestination.URL != "" {
 		update["url"] = updatedDestination.URL
 	}
 	update["isActive"] = updatedDestination.IsActive // Always update isActive
-	if updatedDestination.Method != "" {
-		update["method"] = updatedDestination.Method
+	if updatedDestination.setDefault {
+		update["isDefault"] = updatedDestination.IsDefault
+	}
+	if updatedDestination.Methods != nil {
+		update["methods"] = updatedDestination.Methods // An empty list accepts every method
+	}
+	if updatedDestination.ExcludeMethods != nil {
+		update["excludeMethods"] = updatedDestination.ExcludeMethods
+	}
+	if updatedDestination.TLS != nil {
+		update["tls"] = updatedDestination.TLS
+	}
+	if updatedDestination.Proxy != "" {
+		update["proxy"] = updatedDestination.Proxy
+	}
+	if updatedDestination.AllowedClients != nil {
+		update["allowedClients"] = updatedDestination.AllowedClients
+	}
+	if updatedDestination.Limits != nil {
+		update["limits"] = updatedDestination.Limits // An empty object removes the limits
+	}
+	if updatedDestination.Namespace != "" {
+		update["namespace"] = updatedDestination.Namespace
+	}
+	if updatedDestination.Group != "" {
+		update["group"] = updatedDestination.Group
+	}
+	if updatedDestination.Tags != nil {
+		update["tags"] = updatedDestination.Tags
+	}
+	if updatedDestination.Priority != nil {
+		update["priority"] = updatedDestination.Priority // 0 removes the destination from failover
+	}
+	if updatedDestination.Schedule != nil {
+		update["schedule"] = updatedDestination.Schedule // An empty object removes the schedule
+	}
+	if updatedDestination.Rules != nil {
+		update["rules"] = updatedDestination.Rules // An empty list removes the rules
+	}
+	if updatedDestination.RuleMatch != "" {
+		update["ruleMatch"] = updatedDestination.RuleMatch
+	}
+	if updatedDestination.Bucket != "" {
+		update["bucket"] = updatedDestination.Bucket
+	}
+	return update
+}
+
+// Update applies a partial update if the destination is still at the expected version
+// (anyVersion skips the check) and bumps its version. errConflict is returned when someone
+// else changed it first.
+func (s *mongoStore) Update(ctx context.Context, id string, updatedDestination Destination, version int64) error {
+	// Convert the ID string to ObjectID
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
 	}
 
+	update := destinationUpdate(updatedDestination)
+
 	// Perform the update operation
-	result, err := collection.UpdateOne(context.TODO(), bson.M{"_id": objectID}, bson.M{"$set": update})
+	filter := bson.M{"_id": objectID, "archived": notArchived}
+	if version != anyVersion {
+		filter["version"] = versionFilter(version)
+	}
+	result, err := destinationsCollection().UpdateOne(ctx, filter, bson.M{"$set": update, "$inc": bson.M{"version": 1}})
 	if err != nil {
-		log.Printf("MongoDB Update Error: %v", err)
-		return
+		return fmt.Errorf("MongoDB Update Error: %v", err)
 	}
+	if result.MatchedCount == 0 {
+		if version == anyVersion {
+			return errNotFound
+		}
+		exists, err := destinationsCollection().CountDocuments(ctx, bson.M{"_id": objectID, "archived": notArchived})
+		if err != nil {
+			return fmt.Errorf("MongoDB Count Error: %v", err)
+		}
+		if exists > 0 {
+			return errConflict
+		}
+		return errNotFound
+	}
+	log.Printf("Updated document with ID: %s", id)
+	return nil
+}
 
+// Archive soft-deletes a destination: it stops receiving traffic but can be restored
+func (s *mongoStore) Archive(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	result, err := destinationsCollection().UpdateOne(ctx, bson.M{"_id": objectID, "archived": notArchived},
+		bson.M{"$set": bson.M{"archived": true, "archivedAt": time.Now().UTC()}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return fmt.Errorf("MongoDB Update Error: %v", err)
+	}
 	if result.MatchedCount == 0 {
-		log.Printf("No document found with ID: %s", id)
-	} else {
-		log.Printf("Updated document with ID: %s", id)
+		return errNotFound
 	}
+	log.Printf("Archived document with ID: %s", id)
+	return nil
 }
 
-func deleteDestinationFromDB(id string) {
-	collection := mongoClient.Database("http_hopper").Collection("destinations")
+// Restore brings back an archived destination and returns it
+func (s *mongoStore) Restore(ctx context.Context, id string) (Destination, error) {
+	var destination Destination
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return destination, errInvalidID
+	}
+	err = destinationsCollection().FindOneAndUpdate(ctx, bson.M{"_id": objectID, "archived": true},
+		bson.M{"$unset": bson.M{"archived": "", "archivedAt": ""}, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&destination)
+	if err == mongo.ErrNoDocuments {
+		return destination, errNotFound
+	}
+	if err != nil {
+		return destination, fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+	log.Printf("Restored document with ID: %s", id)
+	return destination, nil
+}
 
-	// Convert the ID string to ObjectID if you're using ObjectID in MongoDB
+// Delete removes a destination permanently, archived or not
+func (s *mongoStore) Delete(ctx context.Context, id string) error {
+	// Convert the ID string to ObjectID
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		log.Fatalf("Invalid ID format: %v", err)
-		return
+		return errInvalidID
 	}
 
 	// Delete the document with the matching ObjectID
-	result, err := collection.DeleteOne(context.TODO(), bson.M{"_id": objectID})
+	result, err := destinationsCollection().DeleteOne(ctx, bson.M{"_id": objectID})
 	if err != nil {
-		log.Fatal("MongoDB Delete Error:", err)
-		return
+		return fmt.Errorf("MongoDB Delete Error: %v", err)
 	}
-
 	if result.DeletedCount == 0 {
-		log.Printf("No document found with ID: %s", id)
+		return errNotFound
+	}
+	log.Printf("Deleted document with ID: %s", id)
+	return nil
+}
+
+// Replace overwrites a destination if it is still at the given version
+func (s *mongoStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	result, err := destinationsCollection().ReplaceOne(ctx,
+		bson.M{"_id": destination.ID, "archived": notArchived, "version": versionFilter(version)}, destination)
+	if err != nil {
+		return fmt.Errorf("MongoDB Replace Error: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errConflict
+	}
+	return nil
+}
+
+// ApplyImport writes the changes computed by an import. Writes are not transactional; an
+// error part-way leaves the earlier changes in place.
+func (s *mongoStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	collection := destinationsCollection()
+	for _, d := range creates {
+		if _, err := collection.InsertOne(ctx, d); err != nil {
+			return fmt.Errorf("MongoDB Insert Error for %s: %v", d.URL, err)
+		}
+	}
+	for _, d := range updates {
+		// d.Version is already the next version; the replace only applies on top of the previous one
+		result, err := collection.ReplaceOne(ctx, bson.M{"_id": d.ID, "version": versionFilter(d.Version - 1)}, d)
+		if err != nil {
+			return fmt.Errorf("MongoDB Replace Error for %s: %v", d.URL, err)
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%s was modified while the import was applied", d.URL)
+		}
+	}
+	// Destinations missing from a replace import are archived like any other deletion
+	archivedAt := time.Now().UTC()
+	for _, d := range deletes {
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": d.ID}, bson.M{"$set": bson.M{"archived": true, "archivedAt": archivedAt}, "$inc": bson.M{"version": 1}}); err != nil {
+			return fmt.Errorf("MongoDB Update Error for %s: %v", d.URL, err)
+		}
+	}
+	return nil
+}
+
+// CaptureFilter narrows the captures returned by findCapturesInDB
+type CaptureFilter struct {
+	PathPrefix string
+	Status     int
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+func capturesCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.Capture.Collection)
+}
+
+// ensureCaptureIndexes creates the TTL index that enforces the capture retention period
+func ensureCaptureIndexes() error {
+	cfg := currentConfig()
+	retention, err := time.ParseDuration(cfg.Capture.Retention)
+	if err != nil {
+		return fmt.Errorf("invalid capture retention %q: %v", cfg.Capture.Retention, err)
+	}
+	_, err = capturesCollection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())).SetName("createdAt_ttl"),
+		},
+		{
+			Keys: bson.D{{Key: "path", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Index Error: %v", err)
+	}
+	return nil
+}
+
+func insertCaptureToDB(ctx context.Context, capture Capture) error {
+	_, err := capturesCollection().InsertOne(ctx, capture)
+	if err != nil {
+		return fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	return nil
+}
+
+func findCapturesInDB(ctx context.Context, filter CaptureFilter) ([]Capture, error) {
+	query := bson.M{}
+	if filter.PathPrefix != "" {
+		query["path"] = bson.M{"$regex": "^" + regexp.QuoteMeta(filter.PathPrefix)}
+	}
+	if filter.Status != 0 {
+		query["status"] = filter.Status
+	}
+	createdAt := bson.M{}
+	if !filter.Since.IsZero() {
+		createdAt["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		createdAt["$lt"] = filter.Until
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(filter.Limit))
+	cursor, err := capturesCollection().Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	captures := []Capture{}
+	if err = cursor.All(ctx, &captures); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return captures, nil
+}
+
+func getCaptureFromDB(ctx context.Context, id string) (Capture, error) {
+	var capture Capture
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return capture, errInvalidID
+	}
+	err = capturesCollection().FindOne(ctx, bson.M{"_id": objectID}).Decode(&capture)
+	if err == mongo.ErrNoDocuments {
+		return capture, errNotFound
+	}
+	if err != nil {
+		return capture, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	return capture, nil
+}
+
+func apiKeysCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.Auth.Collection)
+}
+
+func getAPIKeysFromDB(ctx context.Context) ([]APIKey, error) {
+	cursor, err := apiKeysCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	keys := []APIKey{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return keys, nil
+}
+
+func insertAPIKeyToDB(ctx context.Context, key APIKey) (primitive.ObjectID, error) {
+	result, err := apiKeysCollection().InsertOne(ctx, key)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	id, _ := result.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+// ensureAPIKeyIndexes makes key lookups by hash use an index
+func ensureAPIKeyIndexes() error {
+	_, err := apiKeysCollection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Index Error: %v", err)
+	}
+	return nil
+}
+
+// findAPIKeyByHash returns the unrevoked API key with the given hash
+func findAPIKeyByHash(ctx context.Context, hash string) (APIKey, error) {
+	var key APIKey
+	err := apiKeysCollection().FindOne(ctx, bson.M{"keyHash": hash, "revokedAt": bson.M{"$exists": false}}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return key, errNotFound
+	}
+	if err != nil {
+		return key, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	return key, nil
+}
+
+func revokeAPIKeyInDB(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	result, err := apiKeysCollection().UpdateOne(ctx,
+		bson.M{"_id": objectID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	if err != nil {
+		return fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func groupsCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.MongoDB.GroupsCollection)
+}
+
+func getGroupsFromDB(ctx context.Context) ([]Group, error) {
+	if mongoClient == nil {
+		return []Group{}, nil // Groups require the mongodb storage driver
+	}
+	cursor, err := groupsCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	groups := []Group{}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return groups, nil
+}
+
+func groupExistsInDB(ctx context.Context, name string) (bool, error) {
+	n, err := groupsCollection().CountDocuments(ctx, bson.M{"_id": name})
+	if err != nil {
+		return false, fmt.Errorf("MongoDB Count Error: %v", err)
+	}
+	return n > 0, nil
+}
+
+func addGroupToDB(ctx context.Context, group Group) error {
+	_, err := groupsCollection().InsertOne(ctx, group)
+	if mongo.IsDuplicateKeyError(err) {
+		return errAlreadyExists
+	}
+	if err != nil {
+		return fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	return nil
+}
+
+func updateGroupInDB(ctx context.Context, group Group) error {
+	result, err := groupsCollection().UpdateOne(ctx, bson.M{"_id": group.Name},
+		bson.M{"$set": bson.M{"description": group.Description, "role": group.Role}})
+	if err != nil {
+		return fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// deleteGroupFromDB deletes a group; with force its members are removed from the group first
+func deleteGroupFromDB(ctx context.Context, name string, force bool) error {
+	if force {
+		_, err := destinationsCollection().UpdateMany(ctx, bson.M{"group": name}, bson.M{"$unset": bson.M{"group": ""}})
+		if err != nil {
+			return fmt.Errorf("MongoDB Update Error: %v", err)
+		}
 	} else {
-		log.Printf("Deleted document with ID: %s", id)
+		members, err := destinationsCollection().CountDocuments(ctx, bson.M{"group": name, "archived": notArchived})
+		if err != nil {
+			return fmt.Errorf("MongoDB Count Error: %v", err)
+		}
+		if members > 0 {
+			return errGroupNotEmpty
+		}
+	}
+	result, err := groupsCollection().DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return fmt.Errorf("MongoDB Delete Error: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// setGroupActiveInDB switches every member of a group with a single update and returns how
+// many destinations changed
+func setGroupActiveInDB(ctx context.Context, name string, active bool) ([]Destination, error) {
+	exists, err := groupExistsInDB(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNotFound
+	}
+	collection := destinationsCollection()
+	filter := bson.M{"group": name, "archived": notArchived, "isActive": !active}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	var members []Destination
+	if err = cursor.All(ctx, &members); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	if len(members) == 0 {
+		return []Destination{}, nil
+	}
+	ids := make([]primitive.ObjectID, len(members))
+	for i, d := range members {
+		ids[i] = d.ID
+	}
+	filter["_id"] = bson.M{"$in": ids}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"isActive": active}, "$inc": bson.M{"version": 1}}); err != nil {
+		return nil, fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+
+	// Read the changed destinations back so each new version can be recorded
+	cursor, err = collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "isActive": active})
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	changed := []Destination{}
+	if err = cursor.All(ctx, &changed); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return changed, nil
+}
+
+func historyCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.MongoDB.HistoryCollection)
+}
+
+func ensureHistoryIndexes() error {
+	_, err := historyCollection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "destinationId", Value: 1}, {Key: "version", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Index Error: %v", err)
+	}
+	return nil
+}
+
+func insertDestinationVersionToDB(ctx context.Context, version DestinationVersion) error {
+	_, err := historyCollection().InsertOne(ctx, version)
+	if err != nil {
+		return fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	return nil
+}
+
+func getDestinationHistoryFromDB(ctx context.Context, id primitive.ObjectID) ([]DestinationVersion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}, {Key: "timestamp", Value: -1}})
+	cursor, err := historyCollection().Find(ctx, bson.M{"destinationId": id}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	versions := []DestinationVersion{}
+	if err = cursor.All(ctx, &versions); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
 	}
+	return versions, nil
+}
+
+func getDestinationVersionFromDB(ctx context.Context, id primitive.ObjectID, version int64) (DestinationVersion, error) {
+	var snapshot DestinationVersion
+	opts := options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	err := historyCollection().FindOne(ctx, bson.M{"destinationId": id, "version": version}, opts).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return snapshot, errNotFound
+	}
+	if err != nil {
+		return snapshot, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	return snapshot, nil
+}
+
+func auditCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.Audit.Collection)
+}
+
+// ensureAuditIndexes indexes entries for the GET /audit filters and, when a retention is
+// configured, expires old entries through a TTL index
+func ensureAuditIndexes() error {
+	cfg := currentConfig()
+	timestampIndex := options.Index().SetName("timestamp")
+	if cfg.Audit.Retention != "" {
+		retention, err := time.ParseDuration(cfg.Audit.Retention)
+		if err != nil {
+			return fmt.Errorf("invalid audit retention %q: %v", cfg.Audit.Retention, err)
+		}
+		timestampIndex = options.Index().SetName("timestamp_ttl").SetExpireAfterSeconds(int32(retention.Seconds()))
+	}
+	_, err := auditCollection().Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: 1}}, Options: timestampIndex},
+		{Keys: bson.D{{Key: "resource", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Index Error: %v", err)
+	}
+	return nil
+}
+
+func insertAuditEntryToDB(ctx context.Context, entry AuditEntry) error {
+	_, err := auditCollection().InsertOne(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	return nil
+}
+
+func findAuditEntriesInDB(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query := bson.M{}
+	if filter.Resource != "" {
+		query["resource"] = filter.Resource
+	}
+	if filter.ResourceID != "" {
+		query["resourceId"] = filter.ResourceID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.Principal != "" {
+		query["principal"] = filter.Principal
+	}
+	timestamp := bson.M{}
+	if !filter.Since.IsZero() {
+		timestamp["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		timestamp["$lt"] = filter.Until
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(filter.Limit))
+	cursor, err := auditCollection().Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	entries := []AuditEntry{}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return entries, nil
+}
+
+func deliveriesCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.Queue.Collection)
+}
+
+// ensureDeliveryIndexes indexes deliveries by their next attempt for Claim and dead letters
+// by when they were given up
+func ensureDeliveryIndexes() error {
+	_, err := deliveriesCollection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "nextAttemptAt", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Index Error: %v", err)
+	}
+	_, err = deadLettersCollection().Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "deadLetteredAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("MongoDB Index Error: %v", err)
+	}
+	return nil
+}
+
+func deadLettersCollection() *mongo.Collection {
+	cfg := currentConfig()
+	return mongoClient.Database(cfg.MongoDB.Database).Collection(cfg.Queue.DeadLetterCollection)
+}
+
+// mongoQueue keeps deliveries in queue.collection and dead letters in
+// queue.dead_letter_collection (queue.backend "mongodb")
+type mongoQueue struct{}
+
+func (mongoQueue) Enqueue(ctx context.Context, d Delivery) error {
+	_, err := deliveriesCollection().InsertOne(ctx, d)
+	if err != nil {
+		return fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	return nil
+}
+
+func (mongoQueue) Claim(ctx context.Context, lease time.Duration) (*Delivery, error) {
+	now := time.Now().UTC()
+	var d Delivery
+	err := deliveriesCollection().FindOneAndUpdate(ctx,
+		bson.M{"nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}})).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+	return &d, nil
+}
+
+func (mongoQueue) Reschedule(ctx context.Context, d Delivery) error {
+	_, err := deliveriesCollection().UpdateOne(ctx, bson.M{"_id": d.ID}, bson.M{"$set": bson.M{
+		"attempts":      d.Attempts,
+		"nextAttemptAt": d.NextAttemptAt,
+		"lastStatus":    d.LastStatus,
+		"lastError":     d.LastError,
+	}})
+	if err != nil {
+		return fmt.Errorf("MongoDB Update Error: %v", err)
+	}
+	return nil
+}
+
+func (mongoQueue) Remove(ctx context.Context, id primitive.ObjectID) error {
+	_, err := deliveriesCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("MongoDB Delete Error: %v", err)
+	}
+	return nil
+}
+
+func (mongoQueue) DeadLetter(ctx context.Context, d Delivery) error {
+	_, err := deadLettersCollection().InsertOne(ctx, d)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	_, err = deliveriesCollection().DeleteOne(ctx, bson.M{"_id": d.ID})
+	if err != nil {
+		return fmt.Errorf("MongoDB Delete Error: %v", err)
+	}
+	return nil
+}
+
+func (mongoQueue) DeadLetters(ctx context.Context, filter DeadLetterFilter) ([]Delivery, error) {
+	query := bson.M{}
+	if filter.DestinationID != "" {
+		objectID, err := primitive.ObjectIDFromHex(filter.DestinationID)
+		if err != nil {
+			return nil, errInvalidID
+		}
+		query["destinationId"] = objectID
+	}
+	if !filter.Since.IsZero() {
+		query["deadLetteredAt"] = bson.M{"$gte": filter.Since}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "deadLetteredAt", Value: -1}}).SetLimit(int64(filter.Limit))
+	cursor, err := deadLettersCollection().Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	letters := []Delivery{}
+	if err = cursor.All(ctx, &letters); err != nil {
+		return nil, fmt.Errorf("MongoDB Cursor Error: %v", err)
+	}
+	return letters, nil
+}
+
+func (mongoQueue) Redeliver(ctx context.Context, id string) (Delivery, error) {
+	var d Delivery
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return d, errInvalidID
+	}
+	err = deadLettersCollection().FindOne(ctx, bson.M{"_id": objectID}).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return d, errNotFound
+	}
+	if err != nil {
+		return d, fmt.Errorf("MongoDB Find Error: %v", err)
+	}
+	// The delivery keeps its ID, so a concurrent retry of the same dead letter fails here
+	d = requeued(d)
+	if _, err := deliveriesCollection().InsertOne(ctx, d); mongo.IsDuplicateKeyError(err) {
+		return d, errNotFound
+	} else if err != nil {
+		return d, fmt.Errorf("MongoDB Insert Error: %v", err)
+	}
+	if _, err := deadLettersCollection().DeleteOne(ctx, bson.M{"_id": objectID}); err != nil {
+		return d, fmt.Errorf("MongoDB Delete Error: %v", err)
+	}
+	return d, nil
+}
+
+func (mongoQueue) Close() error {
+	return nil
 }
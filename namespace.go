@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Namespaces let several teams share one hopper. Every destination lives in one namespace (or
+// in none, the shared default), inbound requests are mapped to a namespace by header or path
+// prefix and only reach its destinations, and API keys and traffic tokens can be limited to
+// some namespaces. See docs/namespaces.md.
+
+// NamespaceConfig declares a namespace and the path prefix that maps requests to it
+type NamespaceConfig struct {
+	Name        string `yaml:"name"`
+	PathPrefix  string `yaml:"path_prefix"`  // Requests below this path belong to the namespace; optional with namespaces.header
+	StripPrefix bool   `yaml:"strip_prefix"` // Forward requests without the path prefix
+}
+
+// defaultNamespace names the namespace of destinations without one in ?namespace= parameters
+const defaultNamespace = "default"
+
+// namespaceNamePattern keeps names usable in paths, headers and query parameters
+var namespaceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// validateNamespaces checks the declared namespaces and every reference to them
+func validateNamespaces(cfg *Config) error {
+	declared := map[string]bool{}
+	prefixes := map[string]string{}
+	for i := range cfg.Namespaces.List {
+		ns := &cfg.Namespaces.List[i]
+		if !namespaceNamePattern.MatchString(ns.Name) {
+			return fmt.Errorf("namespace name %q must be lowercase letters, digits and dashes", ns.Name)
+		}
+		if ns.Name == defaultNamespace {
+			return fmt.Errorf("namespace name %q is reserved for destinations without a namespace", ns.Name)
+		}
+		if declared[ns.Name] {
+			return fmt.Errorf("namespace %q is declared twice", ns.Name)
+		}
+		declared[ns.Name] = true
+		if ns.PathPrefix == "" {
+			if cfg.Namespaces.Header == "" || ns.StripPrefix {
+				return fmt.Errorf("namespace %q needs a path_prefix", ns.Name)
+			}
+			continue
+		}
+		ns.PathPrefix = "/" + strings.Trim(ns.PathPrefix, "/")
+		if other, ok := prefixes[ns.PathPrefix]; ok {
+			return fmt.Errorf("namespaces %q and %q have the same path_prefix %s", other, ns.Name, ns.PathPrefix)
+		}
+		prefixes[ns.PathPrefix] = ns.Name
+	}
+
+	for _, k := range cfg.Auth.APIKeys {
+		for _, name := range k.Namespaces {
+			if !declared[name] {
+				return fmt.Errorf("API key %q is limited to undeclared namespace %q", k.Name, name)
+			}
+		}
+	}
+	for _, t := range cfg.Traffic.Tokens {
+		for _, name := range t.Namespaces {
+			if !declared[name] {
+				return fmt.Errorf("traffic token %q is limited to undeclared namespace %q", t.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// findNamespace returns a declared namespace
+func findNamespace(name string) (NamespaceConfig, bool) {
+	for _, ns := range currentConfig().Namespaces.List {
+		if ns.Name == name {
+			return ns, true
+		}
+	}
+	return NamespaceConfig{}, false
+}
+
+// namespaceKey carries the namespace an inbound request was mapped to
+type namespaceKey struct{}
+
+// requestNamespace returns the request's namespace, "" for the default namespace
+func requestNamespace(ctx context.Context) string {
+	name, _ := ctx.Value(namespaceKey{}).(string)
+	return name
+}
+
+func withNamespace(r *http.Request, name string) *http.Request {
+	if name == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), namespaceKey{}, name))
+}
+
+// resolveNamespace maps an inbound request to a namespace: the one named in namespaces.header,
+// else the one with the longest matching path prefix, else the default namespace. The prefix
+// is removed from the path when the namespace strips it.
+func resolveNamespace(r *http.Request) (*http.Request, error) {
+	namespaces := requestConfig(r.Context()).Namespaces
+	if len(namespaces.List) == 0 {
+		return r, nil
+	}
+	if header := namespaces.Header; header != "" {
+		if name := r.Header.Get(header); name != "" {
+			if _, ok := findNamespace(name); !ok {
+				return r, fmt.Errorf("unknown namespace %q", name)
+			}
+			return withNamespace(r, name), nil
+		}
+	}
+
+	var matched *NamespaceConfig
+	for i, ns := range namespaces.List {
+		if ns.PathPrefix == "" || !pathHasPrefix(r.URL.Path, ns.PathPrefix) {
+			continue
+		}
+		if matched == nil || len(ns.PathPrefix) > len(matched.PathPrefix) {
+			matched = &namespaces.List[i]
+		}
+	}
+	if matched == nil {
+		return r, nil
+	}
+	if matched.StripPrefix {
+		stripped := *r.URL
+		stripped.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, matched.PathPrefix), "/")
+		stripped.RawPath = ""
+		r = r.Clone(r.Context())
+		r.URL = &stripped
+	}
+	return withNamespace(r, matched.Name), nil
+}
+
+// pathHasPrefix matches whole segments, so /billing covers /billing/x but not /billingx
+func pathHasPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == "/"
+}
+
+// namespaceScopeKey carries the namespaces the caller's credential is limited to
+type namespaceScopeKey struct{}
+
+// namespaceScope returns the namespaces the admin caller may manage; nil means every namespace
+func namespaceScope(ctx context.Context) []string {
+	scope, _ := ctx.Value(namespaceScopeKey{}).([]string)
+	return scope
+}
+
+// namespaceAllowed reports whether the caller may manage destinations in the namespace.
+// Limited callers never manage the default namespace.
+func namespaceAllowed(ctx context.Context, name string) bool {
+	scope := namespaceScope(ctx)
+	return scope == nil || (name != "" && contains(scope, name))
+}
+
+// unscopedOnly refuses callers limited to namespaces, for admin routes that are not about
+// their destinations (reload, drain, API keys, ...)
+func unscopedOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if namespaceScope(r.Context()) != nil {
+			log.Printf("[%s] %s may only manage namespaces %v, refusing %s %s", requestIDFromContext(r.Context()), principalOrAnonymous(r), namespaceScope(r.Context()), r.Method, r.URL.Path)
+			http.Error(w, "Forbidden: this API key is limited to namespaces", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// destinationInScope answers 404 for destinations outside the caller's namespaces, so limited
+// callers can't tell them from missing ones
+func destinationInScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if namespaceScope(r.Context()) == nil {
+			next(w, r)
+			return
+		}
+		destination, err := store.Get(r.Context(), mux.Vars(r)["id"])
+		if !writeDestinationDBError(w, "getting", err) {
+			return
+		}
+		if !namespaceAllowed(r.Context(), destination.Namespace) {
+			http.Error(w, "Destination not found", http.StatusNotFound)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkNamespaceAllowed answers 403 when the caller may not manage destinations in the
+// namespace
+func checkNamespaceAllowed(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !namespaceAllowed(r.Context(), name) {
+		http.Error(w, fmt.Sprintf("Forbidden: this API key can't manage namespace %q", name), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // Register the hashes used by JWT signatures
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksMinRefreshInterval limits how often an unknown key ID triggers a JWKS refetch
+const jwksMinRefreshInterval = time.Minute
+
+// jwksMaxAge is how long fetched signing keys are used before they are refreshed
+const jwksMaxAge = time.Hour
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// jwtClaims holds the registered claims checked by the hopper
+type jwtClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          jwtAudience `json:"aud"`
+	ExpiresAt         float64     `json:"exp"`
+	NotBefore         float64     `json:"nbf"`
+	PreferredUsername string      `json:"preferred_username"`
+	Email             string      `json:"email"`
+}
+
+// principal returns a human-readable identity for logs
+func (c *jwtClaims) principal() string {
+	switch {
+	case c.PreferredUsername != "":
+		return c.PreferredUsername
+	case c.Email != "":
+		return c.Email
+	}
+	return c.Subject
+}
+
+// jwtAudience accepts the "aud" claim as either a string or an array of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// jwk is a single key of a JSON Web Key Set (RSA and EC keys only)
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey converts the JWK into an *rsa.PublicKey or *ecdsa.PublicKey
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %v", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %v", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %v", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// oidcVerifier validates JWTs issued by the configured OIDC provider. Signing keys are
+// discovered through the issuer's metadata and cached.
+type oidcVerifier struct {
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+var oidc = &oidcVerifier{}
+
+// discoverJWKSURL reads the issuer's OpenID configuration to find its JWKS endpoint
+func discoverJWKSURL() (string, error) {
+	cfg := currentConfig()
+	if cfg.Auth.OIDC.JWKSURL != "" {
+		return cfg.Auth.OIDC.JWKSURL, nil
+	}
+	discoveryURL := strings.TrimRight(cfg.Auth.OIDC.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := oidcHTTPClient.Get(discoveryURL)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %v", discoveryURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching %s: status %s", discoveryURL, resp.Status)
+	}
+	var metadata struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("error decoding %s: %v", discoveryURL, err)
+	}
+	if metadata.Issuer != cfg.Auth.OIDC.Issuer {
+		return "", fmt.Errorf("discovery document issuer %q does not match configured issuer %q", metadata.Issuer, cfg.Auth.OIDC.Issuer)
+	}
+	if metadata.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document has no jwks_uri")
+	}
+	return metadata.JWKSURI, nil
+}
+
+// refresh fetches the signing keys; the caller must hold v.mu
+func (v *oidcVerifier) refresh() error {
+	if v.jwksURL == "" {
+		jwksURL, err := discoverJWKSURL()
+		if err != nil {
+			return err
+		}
+		v.jwksURL = jwksURL
+	}
+	v.fetchedAt = time.Now()
+
+	resp, err := oidcHTTPClient.Get(v.jwksURL)
+	if err != nil {
+		return fmt.Errorf("error fetching JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching JWKS: status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("error decoding JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	v.keys = keys
+	log.Printf("Loaded %d OIDC signing keys from %s", len(keys), v.jwksURL)
+	return nil
+}
+
+// key returns the signing key with the given ID, refetching the JWKS when the key is unknown
+// (e.g. after the provider rotated its keys) or the cached set is stale
+func (v *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > jwksMaxAge
+	if (!ok || stale) && time.Since(v.fetchedAt) > jwksMinRefreshInterval {
+		if err := v.refresh(); err != nil {
+			log.Printf("Error refreshing OIDC signing keys: %v", err)
+			if !ok {
+				return nil, err
+			}
+		}
+		key, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// verify checks the token's signature, issuer, audience and validity period
+func (v *oidcVerifier) verify(token string) (*jwtClaims, error) {
+	cfg := currentConfig()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+	if claims.Issuer != cfg.Auth.OIDC.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if !contains(claims.Audience, cfg.Auth.OIDC.Audience) {
+		return nil, fmt.Errorf("token is not intended for audience %q", cfg.Auth.OIDC.Audience)
+	}
+	now := float64(time.Now().Unix())
+	skew := cfg.Auth.OIDC.clockSkew.Seconds()
+	if claims.ExpiresAt == 0 || now > claims.ExpiresAt+skew {
+		return nil, errors.New("token has expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore-skew {
+		return nil, errors.New("token is not valid yet")
+	}
+	return &claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature checks an RS*, PS* or ES* signature. Other algorithms (notably "none"
+// and the HMAC family) are rejected.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the signing key", alg)
+		}
+		var err error
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the signing key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// authenticateJWT validates a bearer token against the OIDC provider and returns the
+// caller's identity
+func authenticateJWT(token string) (string, bool) {
+	if !currentConfig().Auth.OIDC.Enabled || strings.Count(token, ".") != 2 {
+		return "", false
+	}
+	claims, err := oidc.verify(token)
+	if err != nil {
+		log.Printf("Rejected JWT: %v", err)
+		return "", false
+	}
+	return claims.principal(), true
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiOperation describes one admin API route for the OpenAPI document
+type apiOperation struct {
+	method   string
+	path     string
+	tag      string
+	summary  string
+	auth     string // "admin" (API key or OIDC token), "traffic" (traffic token) or "" (open)
+	params   []apiParam
+	request  interface{} // Zero value of the request body type; nil when there is none
+	status   int         // Success status; 200 when 0
+	response interface{} // Zero value of the response body type, or a schema built with objectSchema
+}
+
+type apiParam struct {
+	name        string
+	in          string // "path" or "query"
+	kind        string // OpenAPI type of the value
+	description string
+}
+
+var destinationIDParam = apiParam{name: "id", in: "path", kind: "string", description: "Destination ID"}
+
+// apiMessage is the {"message": ...} document most write operations answer with
+type apiMessage struct {
+	Message string `json:"message"`
+}
+
+// apiOperations lists the routes the document describes: destination management, the traffic
+// stream and the stats endpoints. Request and response schemas are generated from the Go types,
+// so they follow the code.
+var apiOperations = []apiOperation{
+	{method: "get", path: "/destinations", tag: "destinations", auth: "admin",
+		summary: "List destinations; an array, or a DestinationPage with page or limit",
+		params: []apiParam{
+			{name: "page", in: "query", kind: "integer", description: "Page number, from 1"},
+			{name: "limit", in: "query", kind: "integer", description: "Page size, 1-1000; defaults to 50"},
+			{name: "sort", in: "query", kind: "string", description: "Field to sort by, - for descending"},
+			{name: "isActive", in: "query", kind: "boolean"},
+			{name: "archived", in: "query", kind: "boolean", description: "List archived destinations instead"},
+			{name: "method", in: "query", kind: "string"},
+			{name: "group", in: "query", kind: "string"},
+			{name: "tag", in: "query", kind: "string"},
+			{name: "namespace", in: "query", kind: "string", description: "\"default\" for destinations without a namespace"},
+			{name: "q", in: "query", kind: "string", description: "Search in URLs"},
+		},
+		response: []Destination{}},
+	{method: "post", path: "/destinations", tag: "destinations", auth: "admin",
+		summary: "Add a destination",
+		params:  []apiParam{{name: "probe", in: "query", kind: "boolean", description: "Refuse a destination that can't be reached"}},
+		request: Destination{}, status: http.StatusCreated, response: Destination{}},
+	{method: "get", path: "/destinations/{id}", tag: "destinations", auth: "admin",
+		summary: "Get a destination, archived or not; the ETag is its version",
+		params:  []apiParam{destinationIDParam}, response: Destination{}},
+	{method: "put", path: "/destinations/{id}", tag: "destinations", auth: "admin",
+		summary: "Update a destination; the version it is based on goes in If-Match or \"version\"",
+		params:  []apiParam{destinationIDParam, {name: "probe", in: "query", kind: "boolean"}},
+		request: Destination{}, response: apiMessage{}},
+	{method: "delete", path: "/destinations/{id}", tag: "destinations", auth: "admin",
+		summary:  "Archive a destination, or delete it with purge=true",
+		params:   []apiParam{destinationIDParam, {name: "purge", in: "query", kind: "boolean"}},
+		response: apiMessage{}},
+	{method: "post", path: "/destinations/{id}/restore", tag: "destinations", auth: "admin",
+		summary: "Restore an archived destination",
+		params:  []apiParam{destinationIDParam}, response: Destination{}},
+	{method: "post", path: "/destinations/{id}/default", tag: "destinations", auth: "admin",
+		summary: "Make a destination a default destination",
+		params:  []apiParam{destinationIDParam}, response: Destination{}},
+	{method: "delete", path: "/destinations/{id}/default", tag: "destinations", auth: "admin",
+		summary: "Make a default destination a regular one again",
+		params:  []apiParam{destinationIDParam}, response: Destination{}},
+	{method: "post", path: "/destinations/{id}/test", tag: "destinations", auth: "admin",
+		summary: "Send a test request to a destination",
+		params:  []apiParam{destinationIDParam}, request: DestinationTestRequest{}, response: DestinationTestResult{}},
+	{method: "get", path: "/destinations/metrics", tag: "stats", auth: "admin",
+		summary:  "Request counts, status classes and latency percentiles of every destination, slowest first",
+		response: []DestinationMetrics{}},
+	{method: "get", path: "/destinations/{id}/metrics", tag: "stats", auth: "admin",
+		summary: "Request counts, status classes and latency percentiles of a destination",
+		params:  []apiParam{destinationIDParam}, response: DestinationMetrics{}},
+	{method: "delete", path: "/destinations/{id}/metrics", tag: "stats", auth: "admin",
+		summary: "Start the metrics of a destination over",
+		params:  []apiParam{destinationIDParam}, response: apiMessage{}},
+	{method: "get", path: "/traffic", tag: "traffic", auth: "traffic",
+		summary: "Stream traffic events over a WebSocket (one TrafficEvent per message)",
+		params:  trafficFilterParams, status: http.StatusSwitchingProtocols},
+	{method: "get", path: "/traffic/sse", tag: "traffic", auth: "traffic",
+		summary: "Stream traffic events as server-sent events (one TrafficEvent per event)",
+		params:  trafficFilterParams, response: TrafficEvent{}},
+	{method: "get", path: "/traffic/stats", tag: "stats", auth: "traffic",
+		summary: "Connected traffic stream clients and dropped events",
+		response: objectSchema(map[string]interface{}{
+			"clients": []trafficClientStats{},
+			"dropped": uint64(0),
+			"kafka":   &kafkaSinkStats{},
+		})},
+	{method: "get", path: "/admin/connections", tag: "stats", auth: "admin",
+		summary: "Connection reuse and negotiated protocols per destination",
+		response: objectSchema(map[string]interface{}{
+			"http2":        false,
+			"destinations": []DestinationConnections{},
+		})},
+	{method: "get", path: "/admin/workers", tag: "stats", auth: "admin",
+		summary: "Saturation of the fan-out workers", response: WorkerPoolStats{}},
+	{method: "get", path: "/admin/dns", tag: "stats", auth: "admin",
+		summary: "Cached answers of the upstream DNS resolver",
+		response: objectSchema(map[string]interface{}{
+			"servers": []string{},
+			"entries": []resolvedHost{},
+		})},
+	{method: "get", path: "/admin/drain", tag: "stats", auth: "admin",
+		summary: "Whether the hopper is draining, and the forwarded requests in flight", response: DrainStatus{}},
+}
+
+var trafficFilterParams = []apiParam{
+	{name: "destination", in: "query", kind: "string", description: "Destination ID"},
+	{name: "method", in: "query", kind: "string", description: "Comma-separated methods"},
+	{name: "path", in: "query", kind: "string", description: "Path prefix"},
+	{name: "status", in: "query", kind: "string", description: "Status class, e.g. 5xx"},
+	{name: "type", in: "query", kind: "string", description: "Comma-separated event types"},
+	{name: "namespace", in: "query", kind: "string", description: "Namespace of the request, \"default\" for none"},
+	{name: "token", in: "query", kind: "string", description: "Traffic token, for clients that can't send headers"},
+}
+
+// inlineSchema is a response schema given as is rather than generated from a type
+type inlineSchema map[string]interface{}
+
+// objectSchema describes a JSON object whose properties have the types of the given values
+func objectSchema(properties map[string]interface{}) inlineSchema {
+	return inlineSchema{"properties": properties}
+}
+
+// schemaGenerator turns Go types into JSON schemas, collecting named structs as components
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// componentName is the exported form of a type's name
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+func (g *schemaGenerator) schemaOf(value interface{}) interface{} {
+	if inline, ok := value.(inlineSchema); ok {
+		properties := make(map[string]interface{})
+		for name, v := range inline["properties"].(map[string]interface{}) {
+			properties[name] = g.schema(reflect.TypeOf(v))
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return g.schema(reflect.TypeOf(value))
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case rawJSONType:
+		return map[string]interface{}{}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "description": "Nanoseconds"}
+	}
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"} // e.g. IP addresses
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // Placeholder for recursive types
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{} // interface{}: any value
+}
+
+// structSchema describes the JSON encoding of a struct, following its json tags
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+// buildOpenAPI generates the OpenAPI 3 document for apiOperations
+func buildOpenAPI() []byte {
+	g := &schemaGenerator{components: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+	for _, op := range apiOperations {
+		operation := map[string]interface{}{
+			"summary":     op.summary,
+			"tags":        []string{op.tag},
+			"operationId": op.method + strings.NewReplacer("/", "_", "{", "", "}", "").Replace(op.path),
+		}
+		if len(op.params) > 0 {
+			params := make([]interface{}, 0, len(op.params))
+			for _, p := range op.params {
+				param := map[string]interface{}{"name": p.name, "in": p.in, "schema": map[string]interface{}{"type": p.kind}}
+				if p.in == "path" {
+					param["required"] = true
+				}
+				if p.description != "" {
+					param["description"] = p.description
+				}
+				params = append(params, param)
+			}
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schemaOf(op.request)}},
+			}
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.response != nil {
+			contentType := "application/json"
+			if op.path == "/traffic/sse" {
+				contentType = "text/event-stream"
+			}
+			success["content"] = map[string]interface{}{contentType: map[string]interface{}{"schema": g.schemaOf(op.response)}}
+		}
+		responses := map[string]interface{}{strconv.Itoa(status): success}
+		if _, ok := op.request.(Destination); ok {
+			responses["400"] = map[string]interface{}{
+				"description": "Invalid destination",
+				"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schemaOf(objectSchema(map[string]interface{}{
+					"error":  "",
+					"fields": ValidationErrors{},
+				}))}},
+			}
+		}
+		switch op.auth {
+		case "admin":
+			operation["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}, map[string]interface{}{"bearer": []string{}}}
+			responses["401"] = map[string]interface{}{"description": "Authentication required"}
+		case "traffic":
+			operation["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}}
+			responses["401"] = map[string]interface{}{"description": "Missing or invalid traffic token"}
+		}
+		operation["responses"] = responses
+		if paths[apiPrefix+op.path] == nil {
+			paths[apiPrefix+op.path] = make(map[string]interface{})
+		}
+		paths[apiPrefix+op.path][op.method] = operation
+	}
+
+	health := currentConfig().Health
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "HTTP Hopper admin API",
+			"version": "1",
+			"description": fmt.Sprintf("Destination management, the traffic stream and stats. Every other path is forwarded to the destinations, "+
+				"except the dashboard at %s/ui and the health probes at %s and %s.", apiPrefix, health.LivenessPath, health.ReadinessPath),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API key, OIDC token or traffic token"},
+			},
+		},
+	}
+	data, _ := json.MarshalIndent(doc, "", "  ")
+	return data
+}
+
+// GetOpenAPI serves GET /openapi.json
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDocument = buildOpenAPI()
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLPMetricsConfig pushes the destination metrics to an OpenTelemetry collector, for hoppers
+// that can't be scraped (e.g. behind NAT)
+type OTLPMetricsConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Endpoint string            `yaml:"endpoint"` // OTLP/HTTP URL, e.g. http://otel-collector:4318; /v1/metrics is added when there is no path
+	Insecure bool              `yaml:"insecure"` // Plain HTTP, for http:// endpoints
+	Headers  map[string]string `yaml:"headers"`  // e.g. an API key the collector expects
+	Interval string            `yaml:"interval"` // How often metrics are pushed; defaults to 30s
+	interval time.Duration
+}
+
+// validateOTLPMetrics fills in the defaults of metrics.otlp and checks it
+func validateOTLPMetrics(cfg *OTLPMetricsConfig) error {
+	u, err := url.Parse(cfg.Endpoint)
+	if !isSecretRef(cfg.Endpoint) && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		return fmt.Errorf("endpoint %q must be an http or https URL", cfg.Endpoint)
+	}
+	if cfg.Interval == "" {
+		cfg.Interval = "30s"
+	}
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid interval %q", cfg.Interval)
+	}
+	cfg.interval = interval
+	return nil
+}
+
+// The instruments requests to destinations are recorded with; nil until initOTLPMetrics
+var (
+	otlpRequests metric.Int64Counter
+	otlpLatency  metric.Float64Histogram
+)
+
+// initOTLPMetrics starts pushing metrics when metrics.otlp is enabled. The returned function
+// pushes what is left and stops the exporter on shutdown.
+func initOTLPMetrics(ctx context.Context) (func(context.Context) error, error) {
+	cfg := currentConfig().Metrics.OTLP
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint := cfg.Endpoint
+	if u, err := url.Parse(endpoint); err == nil && strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/metrics"
+		endpoint = u.String()
+	}
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlpmetrichttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", currentConfig().Tracing.ServiceName))
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(cfg.interval))),
+		sdkmetric.WithResource(res),
+	)
+	meter := provider.Meter("github.com/your-username/http-hopper")
+	if err := registerOTLPInstruments(meter); err != nil {
+		provider.Shutdown(ctx)
+		return nil, err
+	}
+
+	log.Printf("OpenTelemetry metrics enabled, pushing to %s every %s", endpoint, cfg.interval)
+	return provider.Shutdown, nil
+}
+
+// registerOTLPInstruments creates the instruments of docs/metrics.md. Gauges are sampled when
+// the metrics are pushed.
+func registerOTLPInstruments(meter metric.Meter) error {
+	var err error
+	if otlpRequests, err = meter.Int64Counter("http_hopper.destination.requests",
+		metric.WithDescription("Requests forwarded to a destination, by status class")); err != nil {
+		return err
+	}
+	if otlpLatency, err = meter.Float64Histogram("http_hopper.destination.latency", metric.WithUnit("ms"),
+		metric.WithDescription("Time until a destination's response headers arrived")); err != nil {
+		return err
+	}
+
+	inFlight, err := meter.Int64ObservableGauge("http_hopper.requests.in_flight",
+		metric.WithDescription("Forwarded requests being handled"))
+	if err != nil {
+		return err
+	}
+	fanOutBusy, err := meter.Int64ObservableGauge("http_hopper.fanout.busy",
+		metric.WithDescription("Busy fan-out workers"))
+	if err != nil {
+		return err
+	}
+	fanOutQueued, err := meter.Int64ObservableGauge("http_hopper.fanout.queued",
+		metric.WithDescription("Requests waiting for a fan-out worker"))
+	if err != nil {
+		return err
+	}
+	circuitsOpen, err := meter.Int64ObservableGauge("http_hopper.circuits.open",
+		metric.WithDescription("Destinations whose circuit is open"))
+	if err != nil {
+		return err
+	}
+	errorRate, err := meter.Float64ObservableGauge("http_hopper.destination.error_rate",
+		metric.WithDescription("Share of failures among a destination's last 100 requests"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(inFlight, drainStatus().InFlight)
+		if fanOutPool != nil {
+			stats := fanOutPool.stats()
+			o.ObserveInt64(fanOutBusy, stats.Busy)
+			o.ObserveInt64(fanOutQueued, int64(stats.Queued))
+		}
+		open := int64(0)
+		outcomesByDestination.Range(func(_, value interface{}) bool {
+			outcomes := value.(*destinationOutcomes)
+			outcomes.mu.Lock()
+			if outcomes.circuit != CircuitClosed {
+				open++
+			}
+			rate, attributes := outcomes.errorRate(), destinationAttributes(outcomes.destination)
+			outcomes.mu.Unlock()
+			o.ObserveFloat64(errorRate, rate, metric.WithAttributes(attributes...))
+			return true
+		})
+		o.ObserveInt64(circuitsOpen, open)
+		return nil
+	}, inFlight, fanOutBusy, fanOutQueued, circuitsOpen, errorRate)
+	return err
+}
+
+// recordOTLPDestinationRequest counts and times a request to a destination (status 0 with err
+// when there was no response); a no-op unless metrics.otlp is enabled
+func recordOTLPDestinationRequest(destination Destination, status int, latency time.Duration, err error) {
+	if otlpRequests == nil {
+		return
+	}
+	ctx := context.Background()
+	attributes := destinationAttributes(destination)
+	class := "error"
+	if err == nil {
+		class = strconv.Itoa(status/100) + "xx"
+		otlpLatency.Record(ctx, millis(latency), metric.WithAttributes(attributes...))
+	}
+	otlpRequests.Add(ctx, 1, metric.WithAttributes(append(attributes, attribute.String("status_class", class))...))
+}
+
+// destinationAttributes identifies a destination in OpenTelemetry metrics, like the StatsD tags
+func destinationAttributes(destination Destination) []attribute.KeyValue {
+	name := destination.URL
+	if u, err := url.Parse(destination.URL); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	attributes := []attribute.KeyValue{attribute.String("destination", name)}
+	if !destination.ID.IsZero() {
+		attributes = append(attributes, attribute.String("destination_id", destination.ID.Hex()))
+	}
+	if destination.Namespace != "" {
+		attributes = append(attributes, attribute.String("namespace", destination.Namespace))
+	}
+	if destination.Group != "" {
+		attributes = append(attributes, attribute.String("group", destination.Group))
+	}
+	return attributes
+}
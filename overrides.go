@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// configOverride is a config field that can also be set with a HOPPER_* environment variable
+// and a command-line flag, both named after its YAML path: mongodb.url is HOPPER_MONGODB_URL
+// and --mongodb.url. Precedence, lowest first: defaults, config file, environment, flags.
+type configOverride struct {
+	name  string // YAML path, e.g. "mongodb.url"
+	env   string
+	index []int // Field index within Config
+	flag  overrideFlag
+}
+
+// overrideFlag records the value of a flag that was given on the command line
+type overrideFlag struct {
+	set    bool
+	value  string
+	isBool bool // Lets boolean flags be given without a value
+}
+
+func (f *overrideFlag) String() string { return f.value }
+
+func (f *overrideFlag) IsBoolFlag() bool { return f.isBool }
+
+func (f *overrideFlag) Set(value string) error {
+	f.set, f.value = true, value
+	return nil
+}
+
+var configOverrides []*configOverride
+
+func init() {
+	registerConfigOverrides(reflect.TypeOf(Config{}), nil, nil)
+}
+
+// registerConfigOverrides adds an override and a flag for every settable field below t. Lists
+// of structs (auth.api_keys, traffic.tokens) can only be set in the config file.
+func registerConfigOverrides(t reflect.Type, path []string, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string(nil), path...), name)
+		fieldIndex := append(append([]int(nil), index...), i)
+		if field.Type.Kind() == reflect.Struct {
+			registerConfigOverrides(field.Type, fieldPath, fieldIndex)
+			continue
+		}
+		if !overridable(field.Type) {
+			continue
+		}
+		o := &configOverride{
+			name:  strings.Join(fieldPath, "."),
+			env:   "HOPPER_" + strings.ToUpper(strings.Join(fieldPath, "_")),
+			index: fieldIndex,
+			flag:  overrideFlag{isBool: field.Type.Kind() == reflect.Bool},
+		}
+		flag.Var(&o.flag, o.name, fmt.Sprintf("overrides %s in the config file (env %s)", o.name, o.env))
+		configOverrides = append(configOverrides, o)
+	}
+}
+
+func overridable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setConfigField parses a value into a field. Lists are comma separated and maps are
+// comma-separated key=value pairs.
+func setConfigField(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		pairs := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			pairs[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		v.Set(reflect.ValueOf(pairs))
+	}
+	return nil
+}
+
+// applyConfigOverrides sets the fields given in the environment or on the command line. Only
+// the names are logged since values may be secrets.
+func applyConfigOverrides(cfg *Config) error {
+	root := reflect.ValueOf(cfg).Elem()
+	for _, o := range configOverrides {
+		source, value := "", ""
+		if env, ok := os.LookupEnv(o.env); ok {
+			source, value = o.env, env
+		}
+		if o.flag.set {
+			source, value = "--"+o.name, o.flag.value
+		}
+		if source == "" {
+			continue
+		}
+		if err := setConfigField(root.FieldByIndex(o.index), value); err != nil {
+			return fmt.Errorf("invalid %s: %v", source, err)
+		}
+		log.Printf("Config %s set from %s", o.name, source)
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresMigrations are applied in order; each one runs once and is recorded in
+// schema_migrations. Append new migrations, never edit released ones.
+var postgresMigrations = []string{
+	// 1: destinations are BSON documents like in the other drivers, with the columns needed
+	// for filtering and optimistic locking next to them
+	`CREATE TABLE destinations (
+		id         TEXT PRIMARY KEY,
+		version    BIGINT NOT NULL DEFAULT 0,
+		archived   BOOLEAN NOT NULL DEFAULT FALSE,
+		doc        BYTEA NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX destinations_archived ON destinations (archived)`,
+}
+
+// postgresMigrationLock is the advisory lock key that serialises migrations between hoppers
+// starting at the same time
+const postgresMigrationLock = 0x686f70706572
+
+// postgresStore keeps destinations in a PostgreSQL table (storage.driver "postgres")
+type postgresStore struct {
+	pool *pgxpool.Pool
+	storeWatchers
+}
+
+// openPostgresStore connects to the database and brings its schema up to date
+func openPostgresStore(cfg PostgresConfig) (*postgresStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pool, err := pgxpool.New(ctx, cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres url: %v", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error connecting to PostgreSQL: %v", err)
+	}
+	if err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &postgresStore{pool: pool}, nil
+}
+
+// migratePostgres applies the migrations the database has not seen yet
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+			return fmt.Errorf("error locking schema migrations: %v", err)
+		}
+		if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+			return fmt.Errorf("error creating schema_migrations: %v", err)
+		}
+		var current int
+		if err := tx.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+			return fmt.Errorf("error reading schema version: %v", err)
+		}
+		if current > len(postgresMigrations) {
+			return fmt.Errorf("database schema version %d is newer than this hopper supports (%d)", current, len(postgresMigrations))
+		}
+		for version := current + 1; version <= len(postgresMigrations); version++ {
+			if _, err := tx.Exec(ctx, postgresMigrations[version-1]); err != nil {
+				return fmt.Errorf("error applying migration %d: %v", version, err)
+			}
+			if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+				return fmt.Errorf("error recording migration %d: %v", version, err)
+			}
+			log.Printf("Applied PostgreSQL migration %d", version)
+		}
+		return nil
+	})
+}
+
+func (s *postgresStore) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+func (s *postgresStore) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+func decodePostgresDestination(data []byte) (Destination, error) {
+	var destination Destination
+	if err := bson.Unmarshal(data, &destination); err != nil {
+		return destination, fmt.Errorf("PostgreSQL Decode Error: %v", err)
+	}
+	return destination, nil
+}
+
+// save inserts or overwrites a destination row
+func (s *postgresStore) save(ctx context.Context, q pgx.Tx, destination Destination) error {
+	data, err := bson.Marshal(destination)
+	if err != nil {
+		return fmt.Errorf("PostgreSQL Encode Error: %v", err)
+	}
+	_, err = q.Exec(ctx, `INSERT INTO destinations (id, version, archived, doc) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET version = $2, archived = $3, doc = $4, updated_at = now()`,
+		destination.ID.Hex(), destination.Version, destination.Archived, data)
+	return err
+}
+
+// lock reads a destination and holds its row lock until the transaction ends
+func (s *postgresStore) lock(ctx context.Context, tx pgx.Tx, id primitive.ObjectID) (Destination, []byte, error) {
+	var data []byte
+	err := tx.QueryRow(ctx, "SELECT doc FROM destinations WHERE id = $1 FOR UPDATE", id.Hex()).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Destination{}, nil, errNotFound
+	}
+	if err != nil {
+		return Destination{}, nil, fmt.Errorf("PostgreSQL Find Error: %v", err)
+	}
+	destination, err := decodePostgresDestination(data)
+	return destination, data, err
+}
+
+// destinations returns the live or the archived destinations
+func (s *postgresStore) destinations(ctx context.Context, archived bool) ([]Destination, error) {
+	rows, err := s.pool.Query(ctx, "SELECT doc FROM destinations WHERE archived = $1 ORDER BY id", archived)
+	if err != nil {
+		return nil, fmt.Errorf("PostgreSQL Find Error: %v", err)
+	}
+	defer rows.Close()
+	destinations := []Destination{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("PostgreSQL Find Error: %v", err)
+		}
+		destination, err := decodePostgresDestination(data)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("PostgreSQL Find Error: %v", err)
+	}
+	return destinations, nil
+}
+
+func (s *postgresStore) All(ctx context.Context) ([]Destination, error) {
+	return s.destinations(ctx, false)
+}
+
+func (s *postgresStore) Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error) {
+	all, err := s.destinations(ctx, q.Archived)
+	if err != nil {
+		return nil, 0, err
+	}
+	destinations := []Destination{}
+	for _, d := range all {
+		if matchesDestinationQuery(d, q) {
+			destinations = append(destinations, d)
+		}
+	}
+	return pageDestinations(destinations, q)
+}
+
+func (s *postgresStore) Get(ctx context.Context, id string) (Destination, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Destination{}, errInvalidID
+	}
+	var data []byte
+	err = s.pool.QueryRow(ctx, "SELECT doc FROM destinations WHERE id = $1", objectID.Hex()).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Destination{}, errNotFound
+	}
+	if err != nil {
+		return Destination{}, fmt.Errorf("PostgreSQL Find Error: %v", err)
+	}
+	return decodePostgresDestination(data)
+}
+
+func (s *postgresStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	destination.ID = primitive.NewObjectID()
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return s.save(ctx, tx, destination)
+	})
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("PostgreSQL Insert Error: %v", err)
+	}
+	return destination.ID, nil
+}
+
+// modify runs change against the stored document while holding its row lock and saves the
+// result with its version bumped
+func (s *postgresStore) modify(ctx context.Context, id string, change func(current Destination, doc bson.M) error) (Destination, error) {
+	var updated Destination
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return updated, errInvalidID
+	}
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		current, data, err := s.lock(ctx, tx, objectID)
+		if err != nil {
+			return err
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("PostgreSQL Decode Error: %v", err)
+		}
+		migrateDestinationDoc(doc)
+		if err := change(current, doc); err != nil {
+			return err
+		}
+		doc["version"] = current.Version + 1
+		encoded, err := bson.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("PostgreSQL Encode Error: %v", err)
+		}
+		if updated, err = decodePostgresDestination(encoded); err != nil {
+			return err
+		}
+		if err := s.save(ctx, tx, updated); err != nil {
+			return fmt.Errorf("PostgreSQL Update Error: %v", err)
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (s *postgresStore) Update(ctx context.Context, id string, updatedDestination Destination, version int64) error {
+	_, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		if version != anyVersion && current.Version != version {
+			return errConflict
+		}
+		for key, value := range destinationUpdate(updatedDestination) {
+			doc[key] = value
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Updated document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *postgresStore) Archive(ctx context.Context, id string) error {
+	_, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		doc["archived"] = true
+		doc["archivedAt"] = time.Now().UTC()
+		return nil
+	})
+	if err == nil {
+		log.Printf("Archived document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *postgresStore) Restore(ctx context.Context, id string) (Destination, error) {
+	destination, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if !current.Archived {
+			return errNotFound
+		}
+		delete(doc, "archived")
+		delete(doc, "archivedAt")
+		return nil
+	})
+	if err == nil {
+		log.Printf("Restored document with ID: %s", id)
+	}
+	return destination, err
+}
+
+func (s *postgresStore) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	result, err := s.pool.Exec(ctx, "DELETE FROM destinations WHERE id = $1", objectID.Hex())
+	if err != nil {
+		return fmt.Errorf("PostgreSQL Delete Error: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return errNotFound
+	}
+	log.Printf("Deleted document with ID: %s", id)
+	return nil
+}
+
+func (s *postgresStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		current, _, err := s.lock(ctx, tx, destination.ID)
+		if err == errNotFound || (err == nil && (current.Archived || current.Version != version)) {
+			return errConflict
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.save(ctx, tx, destination); err != nil {
+			return fmt.Errorf("PostgreSQL Replace Error: %v", err)
+		}
+		return nil
+	})
+}
+
+// ApplyImport applies an import in a single transaction, so it is all or nothing
+func (s *postgresStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for _, d := range creates {
+			if err := s.save(ctx, tx, d); err != nil {
+				return fmt.Errorf("PostgreSQL Insert Error for %s: %v", d.URL, err)
+			}
+		}
+		for _, d := range updates {
+			current, _, err := s.lock(ctx, tx, d.ID)
+			if err == errNotFound || (err == nil && current.Version != d.Version-1) {
+				return fmt.Errorf("%s was modified while the import was applied", d.URL)
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.save(ctx, tx, d); err != nil {
+				return fmt.Errorf("PostgreSQL Replace Error for %s: %v", d.URL, err)
+			}
+		}
+		archivedAt := time.Now().UTC()
+		for _, d := range deletes {
+			current, _, err := s.lock(ctx, tx, d.ID)
+			if err == errNotFound {
+				continue // Already gone
+			}
+			if err != nil {
+				return err
+			}
+			current.Archived, current.ArchivedAt = true, &archivedAt
+			current.Version++
+			if err := s.save(ctx, tx, current); err != nil {
+				return fmt.Errorf("PostgreSQL Update Error for %s: %v", d.URL, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Hop-by-hop headers as defined by RFC 7230 section 6.1; these apply to a single
+// connection and must not be forwarded by proxies
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // non-standard but still sent by some clients
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders strips hop-by-hop headers, including any listed in Connection
+func removeHopByHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// outboundHeaders builds the header set sent upstream: hop-by-hop headers are removed and
+// X-Forwarded-For/Proto/Host and Forwarded are added. Incoming values are extended when
+// forwarding.trust_forwarded_headers is set and overwritten otherwise.
+func outboundHeaders(r *http.Request) http.Header {
+	h := r.Header.Clone()
+
+	// gRPC relies on "TE: trailers" reaching the upstream, so it survives the stripping
+	keepTrailers := false
+	for _, value := range r.Header.Values("Te") {
+		if strings.Contains(strings.ToLower(value), "trailers") {
+			keepTrailers = true
+		}
+	}
+	removeHopByHopHeaders(h)
+	if keepTrailers {
+		h.Set("Te", "trailers")
+	}
+
+	clientIP := remoteIP(r)
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	trust := requestConfig(r.Context()).Forwarding.TrustForwardedHeaders
+	if prior := strings.Join(h.Values("X-Forwarded-For"), ", "); trust && prior != "" {
+		h.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else {
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if !trust || h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if !trust || h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+
+	// RFC 7239 requires IPv6 addresses to be bracketed and quoted
+	forNode := clientIP
+	if strings.Contains(clientIP, ":") {
+		forNode = `"[` + clientIP + `]"`
+	}
+	element := "for=" + forNode + ";host=" + quoteForwardedValue(r.Host) + ";proto=" + proto
+	if prior := strings.Join(h.Values("Forwarded"), ", "); trust && prior != "" {
+		h.Set("Forwarded", prior+", "+element)
+	} else {
+		h.Set("Forwarded", element)
+	}
+	return h
+}
+
+// quoteForwardedValue quotes a Forwarded parameter value when it is not a plain token
+func quoteForwardedValue(v string) string {
+	if strings.ContainsAny(v, ":[]\" ,;=") {
+		return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	}
+	return v
+}
+
+// remoteIP returns the IP address of the connection's peer
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the IP address of the original client. When the peer is one of
+// forwarding.trusted_proxies, X-Forwarded-For is walked from the right, skipping trusted
+// proxies, and the first other address is used. Otherwise the peer is the client: entries
+// added by the client itself must never pick its identity for IP filters and rate limits, so
+// forwarding.trust_forwarded_headers alone is not enough.
+func clientIP(r *http.Request) string {
+	peer := remoteIP(r)
+	proxies := requestConfig(r.Context()).Forwarding.trustedProxies
+	if !isTrustedProxy(proxies, peer) {
+		return peer
+	}
+	var chain []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		return peer
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !isTrustedProxy(proxies, chain[i]) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
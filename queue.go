@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Delivery is a request to one destination waiting in the queue. With queue.enabled, requests
+// to destinations other than the default one (and the failover candidates, whose responses may
+// answer the client) are stored as deliveries and sent by background workers, which retry
+// failures with exponential backoff.
+type Delivery struct {
+	ID             primitive.ObjectID  `bson:"_id" json:"id"`
+	RequestID      string              `bson:"requestId" json:"requestId"`
+	DestinationID  primitive.ObjectID  `bson:"destinationId" json:"destinationId"`
+	Method         string              `bson:"method" json:"method"`
+	Path           string              `bson:"path" json:"path"` // Path and query of the inbound request
+	RawQuery       string              `bson:"rawQuery,omitempty" json:"rawQuery,omitempty"`
+	URL            string              `bson:"url" json:"url"` // Full URL the request is sent to
+	Headers        map[string][]string `bson:"headers" json:"headers"`
+	Body           []byte              `bson:"body,omitempty" json:"body,omitempty"`
+	IdempotencyKey string              `bson:"idempotencyKey,omitempty" json:"idempotencyKey,omitempty"` // Sent with every attempt
+	Attempts       int                 `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time           `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	LastStatus     int                 `bson:"lastStatus,omitempty" json:"lastStatus,omitempty"`
+	LastError      string              `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
+	DeadLettered   *time.Time          `bson:"deadLetteredAt,omitempty" json:"deadLetteredAt,omitempty"` // Set once the delivery was given up
+}
+
+// DeliveryQueue persists deliveries until they succeed or run out of attempts. Claiming a
+// delivery leases it by moving its next attempt past the lease, so a delivery held by a hopper
+// that dies is picked up again once the lease runs out: delivery is at least once, and
+// upstreams that must not process a request twice should deduplicate on the idempotency key.
+// Deliveries that are given up are kept as dead letters until they are retried through the API.
+type DeliveryQueue interface {
+	Enqueue(ctx context.Context, d Delivery) error
+	Claim(ctx context.Context, lease time.Duration) (*Delivery, error) // nil when nothing is due
+	Reschedule(ctx context.Context, d Delivery) error                  // Saves the attempts, last outcome and next attempt
+	Remove(ctx context.Context, id primitive.ObjectID) error
+	DeadLetter(ctx context.Context, d Delivery) error                             // Moves the delivery to the dead letters
+	DeadLetters(ctx context.Context, filter DeadLetterFilter) ([]Delivery, error) // Newest first
+	Redeliver(ctx context.Context, id string) (Delivery, error)                   // Moves a dead letter back into the queue, due now
+	Close() error
+}
+
+// DeadLetterFilter narrows the dead letters returned by DeliveryQueue.DeadLetters
+type DeadLetterFilter struct {
+	DestinationID string
+	Since         time.Time
+	Limit         int
+}
+
+// matches reports whether a dead letter passes the filter
+func (f DeadLetterFilter) matches(d Delivery) bool {
+	if f.DestinationID != "" && d.DestinationID.Hex() != f.DestinationID {
+		return false
+	}
+	return f.Since.IsZero() || (d.DeadLettered != nil && !d.DeadLettered.Before(f.Since))
+}
+
+// requeued resets a dead letter for a fresh round of attempts
+func requeued(d Delivery) Delivery {
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now().UTC()
+	d.DeadLettered = nil
+	return d
+}
+
+// deliveryQueue is the queue selected by queue.backend, nil when queueing is disabled
+var deliveryQueue DeliveryQueue
+
+// deliveryWake lets idle workers pick up a delivery enqueued by this hopper right away
+var deliveryWake = make(chan struct{}, 1)
+
+// deliveryPollInterval is how often idle workers look for due deliveries
+const deliveryPollInterval = time.Second
+
+// openDeliveryQueue opens the configured backend
+func openDeliveryQueue(cfg QueueConfig) (DeliveryQueue, error) {
+	if cfg.Backend == "redis" {
+		return openRedisQueue(cfg)
+	}
+	if err := ensureDeliveryIndexes(); err != nil {
+		return nil, err
+	}
+	return mongoQueue{}, nil
+}
+
+// startDeliveryWorkers starts queue.workers workers
+func startDeliveryWorkers() {
+	cfg := currentConfig()
+	for i := 0; i < cfg.Queue.Workers; i++ {
+		go deliveryWorker()
+	}
+	log.Printf("Delivery queue enabled (%s backend, %d workers, up to %d attempts)", cfg.Queue.Backend, cfg.Queue.Workers, cfg.Queue.MaxAttempts)
+}
+
+// deliveryLease is how long a claimed delivery stays hidden from other workers; it covers the
+// wait for a destination slot and the attempt itself
+func deliveryLease() time.Duration {
+	cfg := currentConfig()
+	return cfg.Forwarding.queueTimeout + cfg.Queue.timeout + 30*time.Second
+}
+
+func deliveryWorker() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		d, err := deliveryQueue.Claim(ctx, deliveryLease())
+		cancel()
+		if err != nil {
+			log.Printf("Error claiming delivery: %v", err)
+		}
+		if d == nil {
+			select {
+			case <-deliveryWake:
+			case <-time.After(deliveryPollInterval):
+			}
+			continue
+		}
+		deliver(*d)
+	}
+}
+
+// enqueueDelivery stores the request to destination in the queue
+func enqueueDelivery(r *http.Request, destination Destination, forwardURL *url.URL, header http.Header, body []byte, idempotencyKey string) error {
+	now := time.Now().UTC()
+	d := Delivery{
+		ID:             primitive.NewObjectID(),
+		RequestID:      requestIDFromContext(r.Context()),
+		DestinationID:  destination.ID,
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		RawQuery:       r.URL.RawQuery,
+		URL:            forwardURL.String(),
+		Headers:        header,
+		Body:           body,
+		IdempotencyKey: idempotencyKey,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if err := deliveryQueue.Enqueue(ctx, d); err != nil {
+		return err
+	}
+	event := destinationEvent(EventQueued, r, destination, d.URL, false, 0, 0, nil)
+	event.Message = "Delivery " + d.ID.Hex() + " queued"
+	BroadcastTraffic(event)
+	select {
+	case deliveryWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// retryableStatus reports whether a response status is worth another attempt
+func retryableStatus(status int) bool {
+	return status >= http.StatusInternalServerError || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// deliveryBackoff returns the delay after the given number of failed attempts: the initial
+// backoff doubled for every further attempt, capped at the maximum, with up to 20% jitter
+func deliveryBackoff(attempts int) time.Duration {
+	cfg := currentConfig()
+	backoff := cfg.Queue.initialBackoff
+	for i := 1; i < attempts && backoff < cfg.Queue.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > cfg.Queue.maxBackoff {
+		backoff = cfg.Queue.maxBackoff
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// deliver makes one attempt and removes, reschedules or gives up on the delivery
+func deliver(d Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	destination, err := store.Get(ctx, d.DestinationID.Hex())
+	cancel()
+	if err == errNotFound || (err == nil && destination.Archived) {
+		log.Printf("[%s] Dropping delivery %s: destination %s no longer exists", d.RequestID, d.ID.Hex(), d.DestinationID.Hex())
+		removeDelivery(d)
+		return
+	}
+
+	d.Attempts++
+	status := 0
+	if err != nil {
+		err = fmt.Errorf("error getting destination: %v", err)
+	} else {
+		status, err = attemptDelivery(destination, d)
+	}
+	if err == nil && status < http.StatusBadRequest {
+		log.Printf("[%s] Delivered %s to %s with status %d after %d attempts", d.RequestID, d.ID.Hex(), d.URL, status, d.Attempts)
+		removeDelivery(d)
+		return
+	}
+
+	d.LastStatus, d.LastError = status, ""
+	if err != nil {
+		d.LastError = err.Error()
+	}
+	if err == nil && !retryableStatus(status) {
+		log.Printf("[%s] Delivery %s to %s rejected with status %d, not retrying", d.RequestID, d.ID.Hex(), d.URL, status)
+		deadLetter(d)
+		return
+	}
+	if d.Attempts >= currentConfig().Queue.MaxAttempts {
+		log.Printf("[%s] Giving up on delivery %s to %s after %d attempts (status %d, error %q)", d.RequestID, d.ID.Hex(), d.URL, d.Attempts, status, d.LastError)
+		deadLetter(d)
+		return
+	}
+	backoff := deliveryBackoff(d.Attempts)
+	d.NextAttemptAt = time.Now().UTC().Add(backoff)
+	log.Printf("[%s] Delivery %s to %s failed (attempt %d, status %d, error %q), retrying in %s", d.RequestID, d.ID.Hex(), d.URL, d.Attempts, status, d.LastError, backoff.Round(time.Millisecond))
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := deliveryQueue.Reschedule(ctx, d); err != nil {
+		log.Printf("[%s] Error rescheduling delivery %s: %v", d.RequestID, d.ID.Hex(), err)
+	}
+}
+
+func removeDelivery(d Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := deliveryQueue.Remove(ctx, d.ID); err != nil {
+		log.Printf("[%s] Error removing delivery %s: %v", d.RequestID, d.ID.Hex(), err)
+	}
+}
+
+// deadLetter keeps a delivery that was given up for GET /deadletters
+func deadLetter(d Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now().UTC()
+	d.DeadLettered = &now
+	if err := deliveryQueue.DeadLetter(ctx, d); err != nil {
+		log.Printf("[%s] Error moving delivery %s to the dead letters: %v", d.RequestID, d.ID.Hex(), err)
+	}
+}
+
+// attemptDelivery sends the delivery once and returns the response status
+func attemptDelivery(destination Destination, d Delivery) (int, error) {
+	// Traffic events carry the original request's ID, method and path
+	inbound := &http.Request{Method: d.Method, URL: &url.URL{Path: d.Path, RawQuery: d.RawQuery}, Header: http.Header{}}
+	inbound = inbound.WithContext(context.WithValue(context.Background(), requestIDKey{}, d.RequestID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), currentConfig().Queue.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, d.Method, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header = http.Header(d.Headers).Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("X-Hopper-Delivery-Attempt", strconv.Itoa(d.Attempts))
+
+	release, err := acquireDestination(context.Background(), destination, d.Path, false, false)
+	if err != nil {
+		BroadcastTraffic(destinationEvent(EventError, inbound, destination, d.URL, false, 0, 0, err))
+		return 0, err
+	}
+	defer release()
+	client, err := clientForDestination(destination, req.URL, req)
+	if err != nil {
+		BroadcastTraffic(destinationEvent(EventError, inbound, destination, d.URL, false, 0, 0, err))
+		return 0, fmt.Errorf("error preparing transport: %v", err)
+	}
+	span := startDestinationSpan(ctx, destination, req)
+	req, traced := traceConnection(req, destination)
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	traced(resp)
+	endSpan(span, statusCodeOf(resp), err)
+	if err != nil {
+		BroadcastTraffic(destinationEvent(EventError, inbound, destination, d.URL, false, 0, latency, err))
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	BroadcastTraffic(destinationEvent(EventResponse, inbound, destination, d.URL, false, resp.StatusCode, latency, nil))
+	return resp.StatusCode, nil
+}
+
+// redisQueue keeps deliveries in Redis (queue.backend "redis"):
+//
+//	<prefix>delivery:<id>    the delivery as JSON
+//	<prefix>deliveries       sorted set of delivery IDs scored by their next attempt (Unix ms)
+//	<prefix>deadletter:<id>  a dead letter as JSON
+//	<prefix>deadletters      sorted set of dead letter IDs scored by when they were given up (Unix ms)
+type redisQueue struct {
+	client *redis.Client
+	prefix string
+}
+
+// redisClaimScript leases the first due delivery atomically
+var redisClaimScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #due == 0 then
+	return false
+end
+redis.call('ZADD', KEYS[1], ARGV[2], due[1])
+return due[1]
+`)
+
+func openRedisQueue(cfg QueueConfig) (*redisQueue, error) {
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %v", err)
+	}
+	q := &redisQueue{client: redis.NewClient(opts), prefix: cfg.KeyPrefix}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.client.Ping(ctx).Err(); err != nil {
+		q.client.Close()
+		return nil, fmt.Errorf("error connecting to %s: %v", opts.Addr, err)
+	}
+	return q, nil
+}
+
+func (q *redisQueue) indexKey() string {
+	return q.prefix + "deliveries"
+}
+
+func (q *redisQueue) deliveryKey(id string) string {
+	return q.prefix + "delivery:" + id
+}
+
+func (q *redisQueue) deadLetterIndexKey() string {
+	return q.prefix + "deadletters"
+}
+
+func (q *redisQueue) deadLetterKey(id string) string {
+	return q.prefix + "deadletter:" + id
+}
+
+func (q *redisQueue) save(ctx context.Context, d Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("Redis Encode Error: %v", err)
+	}
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, q.deliveryKey(d.ID.Hex()), data, 0)
+		pipe.ZAdd(ctx, q.indexKey(), redis.Z{Score: float64(d.NextAttemptAt.UnixMilli()), Member: d.ID.Hex()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Redis Write Error: %v", err)
+	}
+	return nil
+}
+
+func (q *redisQueue) Enqueue(ctx context.Context, d Delivery) error {
+	return q.save(ctx, d)
+}
+
+func (q *redisQueue) Reschedule(ctx context.Context, d Delivery) error {
+	return q.save(ctx, d)
+}
+
+func (q *redisQueue) Claim(ctx context.Context, lease time.Duration) (*Delivery, error) {
+	now := time.Now()
+	id, err := redisClaimScript.Run(ctx, q.client, []string{q.indexKey()}, now.UnixMilli(), now.Add(lease).UnixMilli()).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Redis Claim Error: %v", err)
+	}
+	data, err := q.client.Get(ctx, q.deliveryKey(id)).Bytes()
+	if err == redis.Nil {
+		q.client.ZRem(ctx, q.indexKey(), id) // Removed between the index and the document
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Redis Read Error: %v", err)
+	}
+	var d Delivery
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("Redis Decode Error for delivery %s: %v", id, err)
+	}
+	return &d, nil
+}
+
+func (q *redisQueue) Remove(ctx context.Context, id primitive.ObjectID) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, q.indexKey(), id.Hex())
+		pipe.Del(ctx, q.deliveryKey(id.Hex()))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Redis Delete Error: %v", err)
+	}
+	return nil
+}
+
+func (q *redisQueue) DeadLetter(ctx context.Context, d Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("Redis Encode Error: %v", err)
+	}
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, q.indexKey(), d.ID.Hex())
+		pipe.Del(ctx, q.deliveryKey(d.ID.Hex()))
+		pipe.Set(ctx, q.deadLetterKey(d.ID.Hex()), data, 0)
+		pipe.ZAdd(ctx, q.deadLetterIndexKey(), redis.Z{Score: float64(d.DeadLettered.UnixMilli()), Member: d.ID.Hex()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Redis Write Error: %v", err)
+	}
+	return nil
+}
+
+func (q *redisQueue) DeadLetters(ctx context.Context, filter DeadLetterFilter) ([]Delivery, error) {
+	if filter.DestinationID != "" {
+		if _, err := primitive.ObjectIDFromHex(filter.DestinationID); err != nil {
+			return nil, errInvalidID
+		}
+	}
+	min := "-inf"
+	if !filter.Since.IsZero() {
+		min = strconv.FormatInt(filter.Since.UnixMilli(), 10)
+	}
+	ids, err := q.client.ZRevRangeByScore(ctx, q.deadLetterIndexKey(), &redis.ZRangeBy{Min: min, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Redis Read Error: %v", err)
+	}
+	letters := []Delivery{}
+	if len(ids) == 0 {
+		return letters, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = q.deadLetterKey(id)
+	}
+	values, err := q.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Redis Read Error: %v", err)
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue // Retried since the index was read
+		}
+		var d Delivery
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return nil, fmt.Errorf("Redis Decode Error for dead letter %s: %v", ids[i], err)
+		}
+		if !filter.matches(d) {
+			continue
+		}
+		letters = append(letters, d)
+		if filter.Limit > 0 && len(letters) == filter.Limit {
+			break
+		}
+	}
+	return letters, nil
+}
+
+func (q *redisQueue) Redeliver(ctx context.Context, id string) (Delivery, error) {
+	var d Delivery
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return d, errInvalidID
+	}
+	// GETDEL makes sure only one caller moves the dead letter back
+	data, err := q.client.GetDel(ctx, q.deadLetterKey(id)).Bytes()
+	if err == redis.Nil {
+		return d, errNotFound
+	}
+	if err != nil {
+		return d, fmt.Errorf("Redis Read Error: %v", err)
+	}
+	q.client.ZRem(ctx, q.deadLetterIndexKey(), id)
+	if err := json.Unmarshal(data, &d); err != nil {
+		return d, fmt.Errorf("Redis Decode Error for dead letter %s: %v", id, err)
+	}
+	d = requeued(d)
+	return d, q.save(ctx, d)
+}
+
+func (q *redisQueue) Close() error {
+	return q.client.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// quorumSize returns how many 2xx answers out of n destinations make a quorum; k is the
+// routing rule's quorum, if any
+func quorumSize(n, k int) int {
+	if k > 0 {
+		return k
+	}
+	if k := currentConfig().Forwarding.Quorum; k > 0 {
+		return k
+	}
+	return n/2 + 1
+}
+
+// quorumResponse succeeds when at least quorumSize destinations answered 2xx. The client
+// then gets one of those responses, the default destination's when it is among them; otherwise
+// it gets forwarding.quorum_failure_status with every destination's result (see
+// AggregatedResponses).
+func quorumResponse(reqID string, entries []AggregatedResponse, quorum int) (*http.Response, error) {
+	required := quorumSize(len(entries), quorum)
+	var chosen *AggregatedResponse
+	succeeded := 0
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Error != "" || entry.Status < 200 || entry.Status > 299 {
+			continue
+		}
+		succeeded++
+		if !entry.BodyTruncated && (chosen == nil || entry.IsDefault && !chosen.IsDefault) {
+			chosen = entry
+		}
+	}
+	counts := fmt.Sprintf("%d/%d", succeeded, required)
+
+	if succeeded < required || chosen == nil {
+		doc := AggregatedResponses{Count: len(entries), Succeeded: succeeded, Responses: entries}
+		status := http.StatusOK
+		if succeeded < required {
+			log.Printf("[%s] Quorum missed: %d of %d destinations answered 2xx, %d required", reqID, succeeded, len(entries), required)
+			status = currentConfig().Forwarding.QuorumFailureStatus
+		}
+		// A quorum whose bodies were all cut off is reported like a missed one, but succeeds
+		resp, err := aggregatedDocument(doc, status, ResponseModeQuorum)
+		if resp != nil {
+			resp.Header.Set("X-Hopper-Quorum", counts)
+		}
+		return resp, err
+	}
+
+	log.Printf("[%s] Quorum reached: %d of %d destinations answered 2xx, %d required; answering with %s", reqID, succeeded, len(entries), required, chosen.Destination)
+	header := http.Header(chosen.Headers).Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(chosen.raw)))
+	header.Set("X-Hopper-Response-Mode", ResponseModeQuorum)
+	header.Set("X-Hopper-Quorum", counts)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", chosen.Status, http.StatusText(chosen.Status)),
+		StatusCode:    chosen.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(chosen.raw)),
+		ContentLength: int64(len(chosen.raw)),
+	}, nil
+}
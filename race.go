@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// racedResult is a racer's outcome in the order they arrive
+type racedResult struct {
+	candidate *failoverCandidate
+	result    forwardResult
+}
+
+// raceCandidates returns the first response below 500 and cancels the requests of the other
+// racers, hedging the request against slow destinations. Without any successful response the
+// first 5xx answers the client, or the first error is returned when nobody answered. The
+// losers finish in the background; closing the returned body waits for them.
+func raceCandidates(reqID string, candidates []*failoverCandidate, waitAll func()) (*http.Response, error) {
+	results := make(chan racedResult, len(candidates))
+	for _, c := range candidates {
+		go func(c *failoverCandidate) {
+			results <- racedResult{candidate: c, result: <-c.result}
+		}(c)
+	}
+	cancelAll := func() {
+		for _, c := range candidates {
+			c.cancel()
+		}
+	}
+
+	var fallback *racedResult // First 5xx, used when nobody succeeds
+	var firstErr error
+	for received := 0; received < len(candidates); received++ {
+		raced := <-results
+		if !needsFailover(raced.result) {
+			log.Printf("[%s] %s won the race with status %d", reqID, raced.candidate.destination.URL, raced.result.resp.StatusCode)
+			for _, c := range candidates {
+				if c != raced.candidate {
+					c.cancel()
+				}
+			}
+			raced.candidate.use <- true
+			if fallback != nil {
+				fallback.candidate.use <- false
+			}
+			// Losers still in flight are settled as they give up
+			go func(remaining int) {
+				for ; remaining > 0; remaining-- {
+					(<-results).candidate.use <- false
+				}
+			}(len(candidates) - received - 1)
+			resp := raced.result.resp
+			resp.Body = &fanOutBody{ReadCloser: resp.Body, wait: func() {
+				waitAll()
+				cancelAll()
+			}}
+			return resp, nil
+		}
+		if raced.result.err != nil {
+			if firstErr == nil {
+				firstErr = raced.result.err
+			}
+			raced.candidate.use <- false
+			continue
+		}
+		if fallback == nil {
+			fallback = &raced
+			continue
+		}
+		raced.candidate.use <- false
+	}
+
+	if fallback == nil {
+		waitAll()
+		cancelAll()
+		return nil, fmt.Errorf("no destination answered: %v", firstErr)
+	}
+	log.Printf("[%s] No destination answered successfully, answering with %s's status %d", reqID, fallback.candidate.destination.URL, fallback.result.resp.StatusCode)
+	fallback.candidate.use <- true
+	resp := fallback.result.resp
+	resp.Body = &fanOutBody{ReadCloser: resp.Body, wait: func() {
+		waitAll()
+		cancelAll()
+	}}
+	return resp, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterEntry is a client's token bucket and when it was last used
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// clientLimiters holds one token bucket per client key, created on first use
+type clientLimiters struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[string]*limiterEntry
+}
+
+func (c *clientLimiters) get(key string) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.clients[key]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(c.limit, c.burst)}
+		c.clients[key] = entry
+	}
+	entry.lastSeen = time.Now()
+	return entry.limiter
+}
+
+// evictIdle forgets clients that have not sent a request for maxIdle; their buckets
+// would be full again by now anyway
+func (c *clientLimiters) evictIdle(maxIdle time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.clients {
+		if time.Since(entry.lastSeen) > maxIdle {
+			delete(c.clients, key)
+		}
+	}
+}
+
+// Limiters for the forwarding path; nil when the corresponding limit is disabled. They are
+// replaced when the configuration is reloaded.
+var (
+	rateLimitMu   sync.RWMutex
+	globalLimiter *rate.Limiter
+	perClient     *clientLimiters
+	evictOnce     sync.Once
+)
+
+// burstFor defaults the burst to one second's worth of requests
+func burstFor(rps float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(rps)))
+}
+
+// initRateLimiting creates the limiters from the configuration and starts evicting idle
+// clients. On a reload the buckets start over full.
+func initRateLimiting() {
+	cfg := currentConfig().RateLimit
+	var global *rate.Limiter
+	var clients *clientLimiters
+	if cfg.Enabled && cfg.GlobalRPS > 0 {
+		global = rate.NewLimiter(rate.Limit(cfg.GlobalRPS), burstFor(cfg.GlobalRPS, cfg.GlobalBurst))
+	}
+	if cfg.Enabled && cfg.ClientRPS > 0 {
+		clients = &clientLimiters{
+			limit:   rate.Limit(cfg.ClientRPS),
+			burst:   burstFor(cfg.ClientRPS, cfg.ClientBurst),
+			clients: make(map[string]*limiterEntry),
+		}
+		evictOnce.Do(func() {
+			go func() {
+				ticker := time.NewTicker(time.Minute)
+				defer ticker.Stop()
+				for range ticker.C {
+					if _, clients := currentLimiters(); clients != nil {
+						clients.evictIdle(currentConfig().RateLimit.idleTimeout)
+					}
+				}
+			}()
+		})
+	}
+	rateLimitMu.Lock()
+	globalLimiter, perClient = global, clients
+	rateLimitMu.Unlock()
+	if cfg.Enabled {
+		log.Printf("Rate limiting enabled: global %.2f rps, per %s %.2f rps", cfg.GlobalRPS, cfg.ClientKey, cfg.ClientRPS)
+	}
+}
+
+func currentLimiters() (*rate.Limiter, *clientLimiters) {
+	rateLimitMu.RLock()
+	defer rateLimitMu.RUnlock()
+	return globalLimiter, perClient
+}
+
+// rateLimitKey identifies the caller for per-client limits: the API key when configured and
+// valid, otherwise the client IP. Unknown keys count against the IP, or a client could send a
+// new key with every request to get a fresh bucket each time.
+func rateLimitKey(r *http.Request) string {
+	if requestConfig(r.Context()).RateLimit.ClientKey == "api_key" {
+		if key := apiKeyFromRequest(r); key != "" && rateLimitKeyValid(r.Context(), key) {
+			return "key:" + hashAPIKey(key)
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// How long rate limiting trusts a lookup of a stored API key, how many lookups are remembered
+// and how many may run at once
+const (
+	storedKeyTTL         = time.Minute
+	storedKeyCacheSize   = 10000
+	storedKeyLookupSlots = 4
+)
+
+// storedKeys remembers which key hashes are stored API keys. Forwarded requests carry all
+// sorts of bearer tokens meant for the upstreams, so the limiter must not query MongoDB for
+// each of them: keys not in the cache count against the client IP while a lookup runs in the
+// background, and at most storedKeyLookupSlots lookups run at a time.
+var storedKeys = struct {
+	sync.Mutex
+	entries map[string]storedKeyEntry
+	lookups chan struct{}
+}{entries: make(map[string]storedKeyEntry), lookups: make(chan struct{}, storedKeyLookupSlots)}
+
+type storedKeyEntry struct {
+	valid     bool
+	pending   bool
+	checkedAt time.Time
+}
+
+// rateLimitKeyValid reports whether a key is in auth.api_keys or, as far as known, stored
+func rateLimitKeyValid(ctx context.Context, key string) bool {
+	if _, ok := configuredAPIKey(ctx, key); ok {
+		return true
+	}
+	if mongoClient == nil {
+		return false
+	}
+	hash := hashAPIKey(key)
+	storedKeys.Lock()
+	defer storedKeys.Unlock()
+	entry, ok := storedKeys.entries[hash]
+	if ok && (entry.pending || time.Since(entry.checkedAt) < storedKeyTTL) {
+		return entry.valid
+	}
+	select {
+	case storedKeys.lookups <- struct{}{}:
+	default:
+		return entry.valid // Lookups are busy; try again with a later request
+	}
+	if len(storedKeys.entries) >= storedKeyCacheSize {
+		storedKeys.entries = make(map[string]storedKeyEntry)
+	}
+	storedKeys.entries[hash] = storedKeyEntry{valid: entry.valid, pending: true}
+	go lookupStoredKey(hash)
+	return entry.valid
+}
+
+// lookupStoredKey checks a key hash in MongoDB and remembers the answer
+func lookupStoredKey(hash string) {
+	defer func() { <-storedKeys.lookups }()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := findAPIKeyByHash(ctx, hash)
+	if err != nil && err != errNotFound {
+		log.Printf("Error looking up API key for rate limiting: %v", err)
+	}
+	storedKeys.Lock()
+	storedKeys.entries[hash] = storedKeyEntry{valid: err == nil, checkedAt: time.Now()}
+	storedKeys.Unlock()
+}
+
+// reserve takes a token from every applicable bucket. When any bucket is empty nothing is
+// consumed and the time until a token is available is returned.
+func reserve(limiters ...*rate.Limiter) (bool, time.Duration) {
+	now := time.Now()
+	var taken []*rate.Reservation
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+		res := limiter.ReserveN(now, 1)
+		delay := res.DelayFrom(now)
+		if !res.OK() || delay > 0 {
+			res.CancelAt(now)
+			for _, t := range taken {
+				t.CancelAt(now)
+			}
+			if !res.OK() {
+				delay = time.Second
+			}
+			return false, delay
+		}
+		taken = append(taken, res)
+	}
+	return true, 0
+}
+
+// rateLimit rejects requests over the global or per-client limit with 429 and Retry-After
+func rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		global, clients := currentLimiters()
+		if global == nil && clients == nil {
+			next(w, r)
+			return
+		}
+		var clientLimiter *rate.Limiter
+		if clients != nil {
+			clientLimiter = clients.get(rateLimitKey(r))
+		}
+		if ok, wait := reserve(clientLimiter, global); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			log.Printf("[%s] Rate limit exceeded for client %s, retry after %ds", requestIDFromContext(r.Context()), clientIP(r), retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// redisTxRetries bounds how often a change is retried when another writer touched the
+// destination between reading and writing it
+const redisTxRetries = 10
+
+// redisStore keeps each destination in a hash (storage.driver "redis"):
+//
+//	<prefix>destination:<id>  doc (BSON document), version
+//	<prefix>destinations      set of all destination IDs, archived ones included
+//
+// Writes are optimistic transactions (WATCH/MULTI), so several hoppers can share a server.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+	pubsub *redis.PubSub
+	storeWatchers
+}
+
+// openRedisStore connects to the configured server and, if enabled, subscribes to keyspace
+// notifications for the destination keys
+func openRedisStore(cfg RedisConfig) (*redisStore, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %v", err)
+	}
+	s := &redisStore{client: redis.NewClient(opts), prefix: cfg.KeyPrefix}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		s.client.Close()
+		return nil, fmt.Errorf("error connecting to %s: %v", opts.Addr, err)
+	}
+	if cfg.Notifications {
+		s.subscribe(opts.DB)
+	}
+	return s, nil
+}
+
+// subscribe reports changes to destination hashes to the watchers. The server must publish
+// keyspace events for hashes and generic commands (notify-keyspace-events "Khg" or more).
+func (s *redisStore) subscribe(db int) {
+	channel := fmt.Sprintf("__keyspace@%d__:%sdestination:*", db, s.prefix)
+	s.pubsub = s.client.PSubscribe(context.Background(), channel)
+	go func() {
+		for range s.pubsub.Channel() {
+			s.notify()
+		}
+	}()
+	log.Printf("Subscribed to Redis keyspace notifications on %s", channel)
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *redisStore) Close() error {
+	if s.pubsub != nil {
+		s.pubsub.Close()
+	}
+	return s.client.Close()
+}
+
+func (s *redisStore) indexKey() string {
+	return s.prefix + "destinations"
+}
+
+func (s *redisStore) destinationKey(id primitive.ObjectID) string {
+	return s.prefix + "destination:" + id.Hex()
+}
+
+// hashFields is the hash stored for a destination
+func (s *redisStore) hashFields(destination Destination) ([]interface{}, error) {
+	data, err := bson.Marshal(destination)
+	if err != nil {
+		return nil, fmt.Errorf("Redis Encode Error: %v", err)
+	}
+	return []interface{}{"doc", data, "version", destination.Version}, nil
+}
+
+func decodeRedisDestination(data string) (Destination, error) {
+	var destination Destination
+	if err := bson.Unmarshal([]byte(data), &destination); err != nil {
+		return destination, fmt.Errorf("Redis Decode Error: %v", err)
+	}
+	return destination, nil
+}
+
+// load reads one destination through cmd, which is the client or a transaction
+func (s *redisStore) load(ctx context.Context, cmd redis.Cmdable, id primitive.ObjectID) (Destination, error) {
+	data, err := cmd.HGet(ctx, s.destinationKey(id), "doc").Result()
+	if err == redis.Nil {
+		return Destination{}, errNotFound
+	}
+	if err != nil {
+		return Destination{}, fmt.Errorf("Redis Get Error: %v", err)
+	}
+	return decodeRedisDestination(data)
+}
+
+// destinations returns the live or the archived destinations
+func (s *redisStore) destinations(ctx context.Context, archived bool) ([]Destination, error) {
+	ids, err := s.client.SMembers(ctx, s.indexKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Redis Find Error: %v", err)
+	}
+	cmds := make([]*redis.StringCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGet(ctx, s.prefix+"destination:"+id, "doc")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("Redis Find Error: %v", err)
+	}
+	destinations := []Destination{}
+	for _, cmd := range cmds {
+		data, err := cmd.Result()
+		if err == redis.Nil {
+			continue // Deleted after the index was read
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Redis Find Error: %v", err)
+		}
+		destination, err := decodeRedisDestination(data)
+		if err != nil {
+			return nil, err
+		}
+		if destination.Archived == archived {
+			destinations = append(destinations, destination)
+		}
+	}
+	sortDestinations(destinations, "")
+	return destinations, nil
+}
+
+func (s *redisStore) All(ctx context.Context) ([]Destination, error) {
+	return s.destinations(ctx, false)
+}
+
+func (s *redisStore) Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error) {
+	all, err := s.destinations(ctx, q.Archived)
+	if err != nil {
+		return nil, 0, err
+	}
+	destinations := []Destination{}
+	for _, d := range all {
+		if matchesDestinationQuery(d, q) {
+			destinations = append(destinations, d)
+		}
+	}
+	return pageDestinations(destinations, q)
+}
+
+func (s *redisStore) Get(ctx context.Context, id string) (Destination, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Destination{}, errInvalidID
+	}
+	return s.load(ctx, s.client, objectID)
+}
+
+func (s *redisStore) Add(ctx context.Context, destination Destination) (primitive.ObjectID, error) {
+	destination.ID = primitive.NewObjectID()
+	fields, err := s.hashFields(destination)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.destinationKey(destination.ID), fields...)
+		pipe.SAdd(ctx, s.indexKey(), destination.ID.Hex())
+		return nil
+	})
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("Redis Insert Error: %v", err)
+	}
+	return destination.ID, nil
+}
+
+// modify runs change against the stored document and saves the result with its version
+// bumped, retrying when the destination is changed concurrently
+func (s *redisStore) modify(ctx context.Context, id string, change func(current Destination, doc bson.M) error) (Destination, error) {
+	var updated Destination
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return updated, errInvalidID
+	}
+	key := s.destinationKey(objectID)
+	for attempt := 0; attempt < redisTxRetries; attempt++ {
+		err = s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.HGet(ctx, key, "doc").Result()
+			if err == redis.Nil {
+				return errNotFound
+			}
+			if err != nil {
+				return fmt.Errorf("Redis Get Error: %v", err)
+			}
+			current, err := decodeRedisDestination(data)
+			if err != nil {
+				return err
+			}
+			doc := bson.M{}
+			if err := bson.Unmarshal([]byte(data), &doc); err != nil {
+				return fmt.Errorf("Redis Decode Error: %v", err)
+			}
+			migrateDestinationDoc(doc)
+			if err := change(current, doc); err != nil {
+				return err
+			}
+			doc["version"] = current.Version + 1
+			encoded, err := bson.Marshal(doc)
+			if err != nil {
+				return fmt.Errorf("Redis Encode Error: %v", err)
+			}
+			if updated, err = decodeRedisDestination(string(encoded)); err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, key, "doc", encoded, "version", updated.Version)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err == redis.TxFailedErr {
+		return updated, errConflict
+	}
+	return updated, err
+}
+
+func (s *redisStore) Update(ctx context.Context, id string, updatedDestination Destination, version int64) error {
+	_, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		if version != anyVersion && current.Version != version {
+			return errConflict
+		}
+		for key, value := range destinationUpdate(updatedDestination) {
+			doc[key] = value
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("Updated document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *redisStore) Archive(ctx context.Context, id string) error {
+	_, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if current.Archived {
+			return errNotFound
+		}
+		doc["archived"] = true
+		doc["archivedAt"] = time.Now().UTC()
+		return nil
+	})
+	if err == nil {
+		log.Printf("Archived document with ID: %s", id)
+	}
+	return err
+}
+
+func (s *redisStore) Restore(ctx context.Context, id string) (Destination, error) {
+	destination, err := s.modify(ctx, id, func(current Destination, doc bson.M) error {
+		if !current.Archived {
+			return errNotFound
+		}
+		delete(doc, "archived")
+		delete(doc, "archivedAt")
+		return nil
+	})
+	if err == nil {
+		log.Printf("Restored document with ID: %s", id)
+	}
+	return destination, err
+}
+
+func (s *redisStore) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errInvalidID
+	}
+	var deleted *redis.IntCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, s.destinationKey(objectID))
+		pipe.SRem(ctx, s.indexKey(), objectID.Hex())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Redis Delete Error: %v", err)
+	}
+	if deleted.Val() == 0 {
+		return errNotFound
+	}
+	log.Printf("Deleted document with ID: %s", id)
+	return nil
+}
+
+func (s *redisStore) Replace(ctx context.Context, destination Destination, version int64) error {
+	key := s.destinationKey(destination.ID)
+	fields, err := s.hashFields(destination)
+	if err != nil {
+		return err
+	}
+	err = s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := s.load(ctx, tx, destination.ID)
+		if err == errNotFound || (err == nil && (current.Archived || current.Version != version)) {
+			return errConflict
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, fields...)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return errConflict
+	}
+	return err
+}
+
+// ApplyImport applies an import in a single MULTI/EXEC, so it is all or nothing; it fails
+// if any updated or archived destination changes while the import is checked
+func (s *redisStore) ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error {
+	watched := []string{s.indexKey()} // WATCH needs at least one key
+	for _, d := range append(append([]Destination{}, updates...), deletes...) {
+		watched = append(watched, s.destinationKey(d.ID))
+	}
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		writes := []Destination{}
+		for _, d := range updates {
+			current, err := s.load(ctx, tx, d.ID)
+			if err == errNotFound || (err == nil && current.Version != d.Version-1) {
+				return fmt.Errorf("%s was modified while the import was applied", d.URL)
+			}
+			if err != nil {
+				return err
+			}
+			writes = append(writes, d)
+		}
+		archivedAt := time.Now().UTC()
+		for _, d := range deletes {
+			current, err := s.load(ctx, tx, d.ID)
+			if err == errNotFound {
+				continue // Already gone
+			}
+			if err != nil {
+				return err
+			}
+			current.Archived, current.ArchivedAt = true, &archivedAt
+			current.Version++
+			writes = append(writes, current)
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, d := range append(writes, creates...) {
+				fields, err := s.hashFields(d)
+				if err != nil {
+					return err
+				}
+				pipe.HSet(ctx, s.destinationKey(d.ID), fields...)
+				pipe.SAdd(ctx, s.indexKey(), d.ID.Hex())
+			}
+			return nil
+		})
+		return err
+	}, watched...)
+	if errors.Is(err, redis.TxFailedErr) {
+		return errors.New("destinations were modified while the import was applied")
+	}
+	return err
+}
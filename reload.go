@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ReloadResult is returned by POST /admin/reload
+type ReloadResult struct {
+	Applied         []string `json:"applied"`         // Changed sections now in effect
+	RestartRequired []string `json:"restartRequired"` // Changed sections that keep their old values until a restart
+}
+
+// reloadMu serializes reloads
+var reloadMu sync.Mutex
+
+type configKey struct{}
+
+// ConfigSnapshotMiddleware hands every request the configuration running when it arrived, so a
+// reload never changes the settings of a request halfway through
+func ConfigSnapshotMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), configKey{}, currentConfig())))
+	})
+}
+
+// requestConfig returns the configuration of a request, or the running one outside a request
+func requestConfig(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(configKey{}).(*Config); ok {
+		return cfg
+	}
+	return currentConfig()
+}
+
+// reloadConfig re-reads the configuration file and applies the sections that are read per
+// request: the access log, limits, rate limits, forwarding, IP filters, CORS, API keys, traffic
+// tokens and health checks. Listeners, storage and everything set up once at startup keep their current
+// values, so in-flight requests and WebSocket clients are not affected. An invalid file leaves
+// the running configuration untouched.
+func reloadConfig() (ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := readConfig()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	current := *currentConfig()
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+
+	// Sections (or parts of them) only used at startup
+	keep := func(section string, changed bool) {
+		if changed {
+			result.RestartRequired = append(result.RestartRequired, section)
+		}
+	}
+	keep("app", !reflect.DeepEqual(current.App, next.App))
+	next.App = current.App
+	keep("storage", !reflect.DeepEqual(current.Storage, next.Storage))
+	next.Storage = current.Storage
+	keep("mongodb", !reflect.DeepEqual(current.MongoDB, next.MongoDB))
+	next.MongoDB = current.MongoDB
+	keep("tracing", !reflect.DeepEqual(current.Tracing, next.Tracing))
+	next.Tracing = current.Tracing
+	keep("capture", !reflect.DeepEqual(current.Capture, next.Capture))
+	next.Capture = current.Capture
+	keep("audit", !reflect.DeepEqual(current.Audit, next.Audit))
+	next.Audit = current.Audit
+	keep("discovery", !reflect.DeepEqual(current.Discovery, next.Discovery))
+	next.Discovery = current.Discovery
+	keep("queue", !reflect.DeepEqual(current.Queue, next.Queue))
+	next.Queue = current.Queue
+	keep("response_cache", !reflect.DeepEqual(current.ResponseCache, next.ResponseCache))
+	next.ResponseCache = current.ResponseCache
+	keep("auth.collection", current.Auth.Collection != next.Auth.Collection)
+	next.Auth.Collection = current.Auth.Collection
+	keep("auth.oidc", !reflect.DeepEqual(current.Auth.OIDC, next.Auth.OIDC))
+	next.Auth.OIDC = current.Auth.OIDC
+	keep("traffic.history_size", current.Traffic.HistorySize != next.Traffic.HistorySize)
+	next.Traffic.HistorySize = current.Traffic.HistorySize
+	keep("dedup", !reflect.DeepEqual(current.Dedup, next.Dedup))
+	next.Dedup = current.Dedup
+	keep("fault_injection.faults", !reflect.DeepEqual(current.FaultInjection.Faults, next.FaultInjection.Faults))
+	next.FaultInjection.Faults = current.FaultInjection.Faults
+	keep("traffic.kafka", !reflect.DeepEqual(current.Traffic.Kafka, next.Traffic.Kafka))
+	next.Traffic.Kafka = current.Traffic.Kafka
+	// Only the access log is reopened; the application log is set up once at startup
+	keep("logging", current.Logging.FilePath != next.Logging.FilePath ||
+		current.Logging.Rotation != next.Logging.Rotation || current.Logging.Retention != next.Logging.Retention)
+	next.Logging.FilePath = current.Logging.FilePath
+	next.Logging.Rotation = current.Logging.Rotation
+	next.Logging.Retention = current.Logging.Retention
+	keep("upstream", !reflect.DeepEqual(current.Upstream, next.Upstream))
+	next.Upstream = current.Upstream
+	keep("metrics", !reflect.DeepEqual(current.Metrics, next.Metrics))
+	next.Metrics = current.Metrics
+	keep("health.paths", current.Health.LivenessPath != next.Health.LivenessPath ||
+		current.Health.ReadinessPath != next.Health.ReadinessPath)
+	next.Health.LivenessPath = current.Health.LivenessPath
+	next.Health.ReadinessPath = current.Health.ReadinessPath
+	keep("forwarding.fan_out_workers", current.Forwarding.FanOutWorkers != next.Forwarding.FanOutWorkers ||
+		current.Forwarding.FanOutQueue != next.Forwarding.FanOutQueue)
+	next.Forwarding.FanOutWorkers = current.Forwarding.FanOutWorkers
+	next.Forwarding.FanOutQueue = current.Forwarding.FanOutQueue
+
+	applied := func(section string, changed bool) {
+		if changed {
+			result.Applied = append(result.Applied, section)
+		}
+	}
+	applied("logging.access_log", !reflect.DeepEqual(current.Logging.AccessLog, next.Logging.AccessLog))
+	applied("limits", !reflect.DeepEqual(current.Limits, next.Limits))
+	applied("rate_limit", !reflect.DeepEqual(current.RateLimit, next.RateLimit))
+	applied("forwarding", !reflect.DeepEqual(current.Forwarding, next.Forwarding))
+	applied("ip_filter", !reflect.DeepEqual(current.IPFilter, next.IPFilter))
+	applied("cors", !reflect.DeepEqual(current.CORS, next.CORS))
+	applied("auth", !reflect.DeepEqual(current.Auth, next.Auth))
+	applied("traffic", !reflect.DeepEqual(current.Traffic, next.Traffic))
+	applied("health", !reflect.DeepEqual(current.Health, next.Health))
+	applied("experiment", !reflect.DeepEqual(current.Experiment, next.Experiment))
+	applied("fault_injection", current.FaultInjection.Enabled != next.FaultInjection.Enabled)
+	applied("compression", !reflect.DeepEqual(current.Compression, next.Compression))
+	applied("ui", current.UI != next.UI)
+	applied("namespaces", !reflect.DeepEqual(current.Namespaces, next.Namespaces))
+	applied("alerts", !reflect.DeepEqual(current.Alerts, next.Alerts))
+
+	liveConfig.Store(&next)
+	if !reflect.DeepEqual(current.Logging.AccessLog, next.Logging.AccessLog) {
+		initAccessLog()
+	}
+	if !reflect.DeepEqual(current.RateLimit, next.RateLimit) {
+		initRateLimiting()
+	}
+
+	log.Printf("Configuration reloaded: applied [%s]", strings.Join(result.Applied, ", "))
+	if len(result.RestartRequired) > 0 {
+		log.Printf("Configuration changes that need a restart were not applied: %s", strings.Join(result.RestartRequired, ", "))
+	}
+	return result, nil
+}
+
+// ReloadConfig serves POST /admin/reload
+func ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := reloadConfig()
+	if err != nil {
+		log.Printf("[%s] Configuration reload failed, keeping the current configuration: %v", requestIDFromContext(r.Context()), err)
+		http.Error(w, fmt.Sprintf("Configuration reload failed: %v", err), http.StatusBadRequest)
+		return
+	}
+	recordAudit(r, AuditEntry{Action: AuditReload, Resource: AuditConfiguration, ResourceID: *configPath,
+		Details: fmt.Sprintf("applied=%q restartRequired=%q", result.Applied, result.RestartRequired)})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
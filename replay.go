@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReplayResult describes the outcome of re-sending one captured request
+type ReplayResult struct {
+	CaptureID primitive.ObjectID `json:"captureId"`
+	RequestID string             `json:"requestId"`
+	Error     string             `json:"error,omitempty"`
+	Responses []CapturedResponse `json:"responses"`
+}
+
+// BulkReplayRequest selects the captures to replay and where to send them
+type BulkReplayRequest struct {
+	Path          string `json:"path"`
+	Status        int    `json:"status"`
+	Since         string `json:"since"`
+	Limit         int    `json:"limit"`
+	DestinationID string `json:"destinationId"`
+}
+
+// requestFromCapture rebuilds an inbound request from a stored capture under a new request ID,
+// in the namespace it was captured in. Imported requests are mapped to a namespace like
+// inbound ones.
+func requestFromCapture(ctx context.Context, capture Capture) *http.Request {
+	r := &http.Request{
+		Method:        capture.Method,
+		URL:           &url.URL{Path: capture.Path, RawQuery: capture.RawQuery},
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(capture.Headers).Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(capture.Body)),
+		ContentLength: int64(len(capture.Body)),
+		Host:          capture.Host,
+		RemoteAddr:    capture.RemoteAddr,
+	}
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	// Credentials were redacted when the request was captured
+	for _, name := range credentialHeaders {
+		if r.Header.Get(name) == redactedValue {
+			r.Header.Del(name)
+		}
+	}
+	id := newRequestID()
+	r.Header.Set(requestIDHeader, id)
+	if !capture.ID.IsZero() {
+		r.Header.Set("X-Hopper-Replay-Of", capture.ID.Hex())
+	}
+	// Generated idempotency keys stay those of the original request
+	ctx = withIdempotencyBase(ctx, capture.RequestID)
+	ctx = withBufferedBody(ctx, capture.Body)
+	r = r.WithContext(context.WithValue(ctx, requestIDKey{}, id))
+	if capture.Namespace != "" {
+		return withNamespace(r, capture.Namespace)
+	}
+	if resolved, err := resolveNamespace(r); err == nil {
+		r = resolved
+	}
+	return r
+}
+
+// replayCapture re-sends a captured (or imported) request either to one destination or to the destinations
+// that currently match it, and returns every destination's response
+func replayCapture(ctx context.Context, capture Capture, destinationID string) ReplayResult {
+	r := requestFromCapture(ctx, capture)
+	result := ReplayResult{CaptureID: capture.ID, RequestID: requestIDFromContext(r.Context()), Responses: []CapturedResponse{}}
+
+	if capture.BodyTruncated {
+		result.Error = "captured body was truncated and cannot be replayed"
+		return result
+	}
+
+	destinations, err := store.All(ctx)
+	if err != nil {
+		result.Error = fmt.Sprintf("error getting destinations: %v", err)
+		return result
+	}
+
+	var targets []Destination
+	var defaultDest *Destination
+	if destinationID != "" {
+		for _, dest := range destinations {
+			if dest.ID.Hex() == destinationID {
+				targets = []Destination{dest}
+				defaultDest = &dest
+			}
+		}
+		if defaultDest == nil {
+			result.Error = fmt.Sprintf("destination %s not found", destinationID)
+			return result
+		}
+	} else {
+		groups, err := getCachedGroups(ctx)
+		if err != nil {
+			result.Error = fmt.Sprintf("error getting groups: %v", err)
+			return result
+		}
+		targets, defaultDest = selectDestinations(r, destinations, groupsByName(groups), nil)
+		if len(targets) == 0 {
+			result.Error = "no active destinations available"
+			return result
+		}
+		// Replays report every response, so any target can stand in for a missing default
+		if defaultDest == nil {
+			defaultDest = &targets[0]
+		}
+	}
+
+	// Imported requests (POST /replay/import) have no capture ID
+	source := "captured request " + capture.ID.Hex()
+	if capture.ID.IsZero() {
+		source = "imported request"
+	}
+	log.Printf("[%s] Replaying %s to %d destinations", result.RequestID, source, len(targets))
+	event := newTrafficEvent(EventReplay, r)
+	event.Message = "Replaying " + source
+	event.setBody(readableBody(capture.Headers, capture.Body))
+	BroadcastTraffic(event)
+
+	recorder := startCaptureRecorder(r)
+	resp, err := forwardRequestToDestinations(r, targets, *defaultDest, responseStrategy{mode: ResponseModeDefault}, recorder)
+	status := http.StatusBadGateway
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		var sink io.Writer = ioutil.Discard
+		if body := recorder.defaultResponseBodyWriter(); body != nil {
+			sink = body
+		}
+		io.Copy(sink, resp.Body)
+		resp.Body.Close() // Waits for every destination
+		status = resp.StatusCode
+	}
+
+	result.Responses = recorder.snapshot(status).Responses
+	if requestConfig(ctx).Capture.Enabled {
+		go recorder.save(status)
+	}
+	return result
+}
+
+// ReplayCapture handles POST /captures/{id}/replay[?destination=<id>]
+func ReplayCapture(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	capture, err := getCaptureFromDB(r.Context(), params["id"])
+	if err == errInvalidID {
+		http.Error(w, "Invalid capture ID", http.StatusBadRequest)
+		return
+	}
+	if err == errNotFound {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting capture: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	result := replayCapture(r.Context(), capture, r.URL.Query().Get("destination"))
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Error != "" && len(result.Responses) == 0 {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding replay result: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ReplayCaptures handles POST /captures/replay, replaying every capture matching the filters
+func ReplayCaptures(w http.ResponseWriter, r *http.Request) {
+	var request BulkReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	filter := CaptureFilter{PathPrefix: request.Path, Status: request.Status, Limit: request.Limit}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	if filter.Limit > 1000 {
+		http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+		return
+	}
+	if request.Since != "" {
+		since, err := parseSince(request.Since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = since
+	}
+
+	captures, err := findCapturesInDB(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting captures: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Replay oldest first so upstreams see requests in their original order
+	results := make([]ReplayResult, 0, len(captures))
+	for i := len(captures) - 1; i >= 0; i-- {
+		results = append(results, replayCapture(r.Context(), captures[i], request.DestinationID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(results),
+		"results": results,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding replay results: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
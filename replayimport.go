@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// importMaxRequests bounds the number of requests replayed from one upload
+const importMaxRequests = 1000
+
+// ImportedRequest is a request read from a HAR file or Postman collection
+type ImportedRequest struct {
+	Name    string
+	Method  string
+	URL     *url.URL
+	Headers http.Header
+	Body    []byte
+}
+
+// ImportedReplayResult describes the outcome of replaying one imported request
+type ImportedReplayResult struct {
+	Index     int                `json:"index"`
+	Name      string             `json:"name,omitempty"`
+	Method    string             `json:"method"`
+	URL       string             `json:"url"`
+	RequestID string             `json:"requestId,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Responses []CapturedResponse `json:"responses"`
+}
+
+// importedHeader builds the header set of an imported request, leaving out HTTP/2 pseudo-headers
+// and headers that describe the original connection rather than the request
+func importedHeader(h http.Header, name, value string) {
+	switch {
+	case name == "" || strings.HasPrefix(name, ":"):
+		return
+	case strings.EqualFold(name, "Host"), strings.EqualFold(name, "Content-Length"):
+		return
+	}
+	h.Add(name, value)
+}
+
+// harRequests reads the requests of a HAR file, in the order they were recorded
+func harRequests(har HAR) ([]ImportedRequest, error) {
+	requests := make([]ImportedRequest, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		target, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: invalid url %q", i, entry.Request.URL)
+		}
+		request := ImportedRequest{Method: entry.Request.Method, URL: target, Headers: http.Header{}}
+		for _, header := range entry.Request.Headers {
+			importedHeader(request.Headers, header.Name, header.Value)
+		}
+		if postData := entry.Request.PostData; postData != nil {
+			request.Body = []byte(postData.Text)
+			if postData.Encoding == "base64" {
+				request.Body, err = base64.StdEncoding.DecodeString(postData.Text)
+				if err != nil {
+					return nil, fmt.Errorf("entry %d: invalid base64 body", i)
+				}
+			}
+			if postData.Truncated {
+				return nil, fmt.Errorf("entry %d: body was truncated and cannot be replayed", i)
+			}
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// PostmanCollection is the part of a Postman collection (v2.0 or v2.1) needed to replay it
+type PostmanCollection struct {
+	Info     PostmanInfo       `json:"info"`
+	Item     []PostmanItem     `json:"item"`
+	Variable []PostmanKeyValue `json:"variable"`
+}
+
+type PostmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// PostmanItem is a request or, when it has items of its own, a folder
+type PostmanItem struct {
+	Name    string          `json:"name"`
+	Item    []PostmanItem   `json:"item"`
+	Request json.RawMessage `json:"request"`
+}
+
+type PostmanRequest struct {
+	Method string            `json:"method"`
+	Header []PostmanKeyValue `json:"header"`
+	URL    json.RawMessage   `json:"url"`
+	Body   *PostmanBody      `json:"body"`
+}
+
+type PostmanURL struct {
+	Raw   string            `json:"raw"`
+	Host  []string          `json:"host"`
+	Path  []string          `json:"path"`
+	Query []PostmanKeyValue `json:"query"`
+}
+
+type PostmanBody struct {
+	Mode       string            `json:"mode"`
+	Raw        string            `json:"raw"`
+	URLEncoded []PostmanKeyValue `json:"urlencoded"`
+	GraphQL    json.RawMessage   `json:"graphql"`
+}
+
+type PostmanKeyValue struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled"`
+}
+
+// postmanRequests reads the requests of a Postman collection, walking folders depth first.
+// Collection variables ({{name}}) are substituted; unknown variables are left as they are.
+func postmanRequests(collection PostmanCollection) ([]ImportedRequest, error) {
+	pairs := []string{}
+	for _, v := range collection.Variable {
+		pairs = append(pairs, "{{"+v.Key+"}}", v.Value)
+	}
+	vars := strings.NewReplacer(pairs...)
+
+	requests := []ImportedRequest{}
+	var walk func(items []PostmanItem, prefix string) error
+	walk = func(items []PostmanItem, prefix string) error {
+		for _, item := range items {
+			name := prefix + item.Name
+			if len(item.Item) > 0 || len(item.Request) == 0 {
+				if err := walk(item.Item, name+"/"); err != nil {
+					return err
+				}
+				continue
+			}
+			request, err := postmanRequest(item.Request, vars)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			request.Name = name
+			requests = append(requests, request)
+		}
+		return nil
+	}
+	if err := walk(collection.Item, ""); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// postmanRequest converts one Postman request, which may also be given as a bare URL string
+func postmanRequest(raw json.RawMessage, vars *strings.Replacer) (ImportedRequest, error) {
+	var pr PostmanRequest
+	var rawURL string
+	if err := json.Unmarshal(raw, &rawURL); err != nil {
+		if err := json.Unmarshal(raw, &pr); err != nil {
+			return ImportedRequest{}, fmt.Errorf("invalid request")
+		}
+		if err := json.Unmarshal(pr.URL, &rawURL); err != nil {
+			var u PostmanURL
+			if err := json.Unmarshal(pr.URL, &u); err != nil {
+				return ImportedRequest{}, fmt.Errorf("invalid url")
+			}
+			rawURL = u.Raw
+			if rawURL == "" {
+				rawURL = strings.Join(u.Host, ".") + "/" + strings.Join(u.Path, "/")
+				query := []string{}
+				for _, q := range u.Query {
+					if !q.Disabled {
+						query = append(query, q.Key+"="+q.Value)
+					}
+				}
+				if len(query) > 0 {
+					rawURL += "?" + strings.Join(query, "&")
+				}
+			}
+		}
+	}
+
+	rawURL = vars.Replace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return ImportedRequest{}, fmt.Errorf("invalid url %q", rawURL)
+	}
+
+	request := ImportedRequest{Method: strings.ToUpper(pr.Method), URL: target, Headers: http.Header{}}
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+	for _, header := range pr.Header {
+		if !header.Disabled {
+			importedHeader(request.Headers, header.Key, vars.Replace(header.Value))
+		}
+	}
+	if body := pr.Body; body != nil {
+		switch body.Mode {
+		case "raw":
+			request.Body = []byte(vars.Replace(body.Raw))
+		case "urlencoded":
+			form := url.Values{}
+			for _, field := range body.URLEncoded {
+				if !field.Disabled {
+					form.Add(field.Key, vars.Replace(field.Value))
+				}
+			}
+			request.Body = []byte(form.Encode())
+			if request.Headers.Get("Content-Type") == "" {
+				request.Headers.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		case "graphql":
+			request.Body = []byte(vars.Replace(string(body.GraphQL)))
+			if request.Headers.Get("Content-Type") == "" {
+				request.Headers.Set("Content-Type", "application/json")
+			}
+		case "", "none":
+		default:
+			return ImportedRequest{}, fmt.Errorf("unsupported body mode %q", body.Mode)
+		}
+	}
+	return request, nil
+}
+
+// parseImport reads a HAR file or a Postman collection; format is "har", "postman" or empty to
+// tell them apart by their top-level fields
+func parseImport(data []byte, format string) ([]ImportedRequest, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if format == "" {
+		if _, ok := probe["log"]; ok {
+			format = "har"
+		} else if _, ok := probe["item"]; ok {
+			format = "postman"
+		} else {
+			return nil, fmt.Errorf("not a HAR file or Postman collection")
+		}
+	}
+
+	switch format {
+	case "har":
+		var har HAR
+		if err := json.Unmarshal(data, &har); err != nil {
+			return nil, fmt.Errorf("invalid HAR file: %v", err)
+		}
+		return harRequests(har)
+	case "postman":
+		var collection PostmanCollection
+		if err := json.Unmarshal(data, &collection); err != nil {
+			return nil, fmt.Errorf("invalid Postman collection: %v", err)
+		}
+		return postmanRequests(collection)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// ImportReplay handles POST /replay/import[?format=har|postman][&destination=<id>]: the uploaded
+// requests are replayed in order through the current destinations (or one destination), and every
+// replay shows up on the traffic stream like a replayed capture. Uploads are bounded by
+// limits.max_body_bytes like any other request body.
+func ImportReplay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "har" && format != "postman" {
+		http.Error(w, "Invalid format parameter (har or postman)", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if bodyLimitExceeded(r.Body) {
+		rejectTooLarge(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	requests, err := parseImport(data, format)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(requests) > importMaxRequests {
+		http.Error(w, fmt.Sprintf("Upload contains %d requests, at most %d can be replayed at once", len(requests), importMaxRequests), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[%s] Replaying %d imported requests", requestIDFromContext(r.Context()), len(requests))
+	destinationID := query.Get("destination")
+	results := make([]ImportedReplayResult, 0, len(requests))
+	for i, request := range requests {
+		// Imported requests are replayed like captures, against the hopper's own routes
+		capture := Capture{
+			CreatedAt:  time.Now().UTC(),
+			Method:     request.Method,
+			Path:       request.URL.Path,
+			RawQuery:   request.URL.RawQuery,
+			Host:       request.URL.Host,
+			RemoteAddr: r.RemoteAddr,
+			Headers:    request.Headers,
+			Body:       request.Body,
+		}
+		if capture.Path == "" {
+			capture.Path = "/"
+		}
+		replayed := replayCapture(r.Context(), capture, destinationID)
+		results = append(results, ImportedReplayResult{
+			Index:     i,
+			Name:      request.Name,
+			Method:    request.Method,
+			URL:       request.URL.String(),
+			RequestID: replayed.RequestID,
+			Error:     replayed.Error,
+			Responses: replayed.Responses,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(results),
+		"results": results,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding replay results: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
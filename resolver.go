@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+// upstreamResolver resolves destination hostnames for the upstream transports and caches the
+// answers. With upstream.dns.servers it queries those servers directly and keeps each answer for
+// its TTL (clamped to min_ttl..max_ttl); otherwise it asks the system resolver, which doesn't
+// report TTLs, and keeps answers for default_ttl. When a refresh fails, the expired answer is
+// used for up to serve_stale longer, so a DNS outage doesn't take the destinations with it.
+type upstreamResolver struct {
+	cfg    UpstreamDNSConfig
+	dialer *net.Dialer
+	group  singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*resolvedHost
+}
+
+// resolvedHost is a cached answer
+type resolvedHost struct {
+	Host       string       `json:"host"`
+	Addresses  []netip.Addr `json:"addresses"`
+	ResolvedAt time.Time    `json:"resolvedAt"`
+	ExpiresAt  time.Time    `json:"expiresAt"`
+	Server     string       `json:"server,omitempty"` // DNS server that answered; empty for the system resolver
+	Stale      bool         `json:"stale,omitempty"`  // Expired, kept because the last refresh failed
+	LastError  string       `json:"lastError,omitempty"`
+	retryAt    time.Time    // A stale answer is used without retrying until then
+}
+
+// resolver is nil when upstream.dns is disabled and the transports resolve on their own
+var resolver *upstreamResolver
+
+func newUpstreamResolver(cfg UpstreamDNSConfig) *upstreamResolver {
+	return &upstreamResolver{
+		cfg:     cfg,
+		dialer:  &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		entries: make(map[string]*resolvedHost),
+	}
+}
+
+// DialContext resolves the host through the cache and connects to its addresses in turn
+func (res *upstreamResolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return res.dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := res.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := res.dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// lookup returns the host's addresses from the cache, resolving it when the entry expired.
+// Concurrent lookups of the same host share one query.
+func (res *upstreamResolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	now := time.Now()
+	res.mu.Lock()
+	entry := res.entries[host]
+	res.mu.Unlock()
+	if entry != nil && (now.Before(entry.ExpiresAt) || now.Before(entry.retryAt)) {
+		return entry.Addresses, nil
+	}
+
+	result, err, _ := res.group.Do(host, func() (interface{}, error) {
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), res.cfg.timeout)
+		defer cancel()
+		addrs, ttl, server, err := res.resolve(lookupCtx, host)
+
+		res.mu.Lock()
+		defer res.mu.Unlock()
+		if err != nil {
+			stale := res.entries[host]
+			if stale != nil && time.Now().Before(stale.ExpiresAt.Add(res.cfg.serveStale)) {
+				log.Printf("DNS Lookup Error for %s, using the expired answer: %v", host, err)
+				stale.Stale = true
+				stale.LastError = err.Error()
+				stale.retryAt = time.Now().Add(res.cfg.minTTL)
+				return stale.Addresses, nil
+			}
+			return nil, fmt.Errorf("DNS Lookup Error for %s: %v", host, err)
+		}
+		resolvedAt := time.Now().UTC()
+		res.entries[host] = &resolvedHost{
+			Host:       host,
+			Addresses:  addrs,
+			ResolvedAt: resolvedAt,
+			ExpiresAt:  resolvedAt.Add(ttl),
+			Server:     server,
+		}
+		return addrs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]netip.Addr), nil
+}
+
+// resolve looks the host up without the cache, returning its addresses (IPv4 first), how long
+// to keep them and the server that answered
+func (res *upstreamResolver) resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, string, error) {
+	if len(res.cfg.Servers) == 0 {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		sortAddrs(addrs)
+		return addrs, res.cfg.defaultTTL, "", nil
+	}
+	var lastErr error
+	for _, server := range res.cfg.Servers {
+		addrs, ttl, err := res.query(ctx, server, host)
+		if err == nil {
+			return addrs, ttl, server, nil
+		}
+		lastErr = fmt.Errorf("%s: %v", server, err)
+		var notFound *net.DNSError
+		if errors.As(err, &notFound) && notFound.IsNotFound {
+			break // An authoritative "no such host" isn't worth asking the next server about
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, "", lastErr
+}
+
+// query asks one server for the host's A and AAAA records. The TTL is the lowest of the answers,
+// clamped to min_ttl..max_ttl.
+func (res *upstreamResolver) query(ctx context.Context, server, host string) ([]netip.Addr, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	var addrs []netip.Addr
+	var ttl uint32
+	found := false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := res.exchange(ctx, server, dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET})
+		if err != nil {
+			return nil, 0, err
+		}
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: server, IsNotFound: true}
+		default:
+			return nil, 0, fmt.Errorf("server answered %s", msg.RCode)
+		}
+		for _, answer := range msg.Answers {
+			var addr netip.Addr
+			switch body := answer.Body.(type) {
+			case *dnsmessage.AResource:
+				addr = netip.AddrFrom4(body.A)
+			case *dnsmessage.AAAAResource:
+				addr = netip.AddrFrom16(body.AAAA)
+			default:
+				continue // CNAMEs leading to the addresses
+			}
+			addrs = append(addrs, addr)
+			if !found || answer.Header.TTL < ttl {
+				ttl = answer.Header.TTL
+			}
+			found = true
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, &net.DNSError{Err: "no addresses", Name: host, Server: server, IsNotFound: true}
+	}
+	d := time.Duration(ttl) * time.Second
+	if d < res.cfg.minTTL {
+		d = res.cfg.minTTL
+	}
+	if d > res.cfg.maxTTL {
+		d = res.cfg.maxTTL
+	}
+	return addrs, d, nil
+}
+
+// exchange sends a question over UDP, and again over TCP when the answer was truncated
+func (res *upstreamResolver) exchange(ctx context.Context, server string, question dnsmessage.Question) (*dnsmessage.Message, error) {
+	id := uint16(rand.Intn(1 << 16))
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := res.roundTrip(ctx, "udp", server, id, query)
+	if err == nil && msg.Truncated {
+		msg, err = res.roundTrip(ctx, "tcp", server, id, query)
+	}
+	return msg, err
+}
+
+func (res *upstreamResolver) roundTrip(ctx context.Context, network, server string, id uint16, query []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var answer []byte
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		answer = buf[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(answer); err != nil {
+		return nil, fmt.Errorf("invalid answer: %v", err)
+	}
+	if msg.ID != id || !msg.Response {
+		return nil, fmt.Errorf("answer doesn't match the query")
+	}
+	return &msg, nil
+}
+
+// sortAddrs puts IPv4 addresses first, keeping the resolver's order otherwise
+func sortAddrs(addrs []netip.Addr) {
+	sort.SliceStable(addrs, func(i, j int) bool { return addrs[i].Unmap().Is4() && !addrs[j].Unmap().Is4() })
+}
+
+// cached returns the cache entries, sorted by host
+func (res *upstreamResolver) cached() []resolvedHost {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	entries := make([]resolvedHost, 0, len(res.entries))
+	for _, entry := range res.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Host < entries[j].Host })
+	return entries
+}
+
+// flush drops the cached answer for host, or every answer when host is empty; it returns how
+// many were dropped
+func (res *upstreamResolver) flush(host string) int {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if host == "" {
+		count := len(res.entries)
+		res.entries = make(map[string]*resolvedHost)
+		return count
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if _, ok := res.entries[host]; !ok {
+		return 0
+	}
+	delete(res.entries, host)
+	return 1
+}
+
+// GetDNSCache serves GET /admin/dns
+func GetDNSCache(w http.ResponseWriter, r *http.Request) {
+	if resolver == nil {
+		http.Error(w, "The upstream DNS cache is not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"servers": resolver.cfg.Servers, "entries": resolver.cached()})
+}
+
+// FlushDNSCache serves DELETE /admin/dns, which drops every cached answer, or only the one for
+// the host given with ?host=
+func FlushDNSCache(w http.ResponseWriter, r *http.Request) {
+	if resolver == nil {
+		http.Error(w, "The upstream DNS cache is not enabled", http.StatusNotFound)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	count := resolver.flush(host)
+	details := fmt.Sprintf("%d cached answers dropped", count)
+	if host != "" {
+		details = fmt.Sprintf("%s (%s)", details, host)
+	}
+	log.Printf("DNS cache flushed by %s: %s", principalOrAnonymous(r), details)
+	recordAudit(r, AuditEntry{Action: AuditDelete, Resource: AuditDNSCache, Details: details})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "DNS cache flushed successfully", "count": count})
+}
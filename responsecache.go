@@ -0,0 +1,368 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CachedResponse is a default destination's response kept by the response cache
+type CachedResponse struct {
+	Status   int                 `json:"status"`
+	Header   map[string][]string `json:"header"`
+	Body     []byte              `json:"body"`
+	StoredAt time.Time           `json:"storedAt"`
+}
+
+// ResponseCache stores responses by key until their TTL runs out
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, resp CachedResponse, ttl time.Duration) error
+	Close() error
+}
+
+// responseCache is nil when response_cache is disabled
+var responseCache ResponseCache
+
+func openResponseCache(cfg ResponseCacheConfig) (ResponseCache, error) {
+	return openCacheBackend(cfg.Backend, cfg.RedisURL, cfg.KeyPrefix+"cache:", cfg.MaxEntries)
+}
+
+// openCacheBackend opens the memory or redis backend shared by the response cache and dedup;
+// prefix separates their Redis keys
+func openCacheBackend(backend, redisURL, prefix string, maxEntries int) (DedupStore, error) {
+	if backend == "redis" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %v", err)
+		}
+		c := &redisResponseCache{client: redis.NewClient(opts), prefix: prefix}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := c.client.Ping(ctx).Err(); err != nil {
+			c.client.Close()
+			return nil, fmt.Errorf("error connecting to %s: %v", opts.Addr, err)
+		}
+		return c, nil
+	}
+	return newMemoryResponseCache(maxEntries), nil
+}
+
+// cacheCredentialHeaders identify the client; requests carrying one are only cached when it is
+// part of the key, or one client's responses would be served to others
+var cacheCredentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", apiKeyHeader}
+
+// cachePlan is how the response cache treats one request
+type cachePlan struct {
+	key    string
+	ttl    time.Duration
+	lookup bool // False when the client asked for a fresh response, which is still stored
+}
+
+// planResponseCache returns nil when the request's response must not be cached: the cache is
+// off, the method isn't cacheable, the route's TTL is zero, the request carries a body or
+// credentials that are not part of the key, or the client sent the bypass header or
+// Cache-Control: no-store
+func planResponseCache(r *http.Request) *cachePlan {
+	cfg := requestConfig(r.Context()).ResponseCache
+	if responseCache == nil || !contains(cfg.Methods, r.Method) || r.ContentLength > 0 {
+		return nil
+	}
+	if cfg.BypassHeader != "" && r.Header.Get(cfg.BypassHeader) != "" {
+		return nil
+	}
+	for _, name := range cacheCredentialHeaders {
+		if r.Header.Get(name) != "" && !containsFold(cfg.VaryHeaders, name) {
+			return nil
+		}
+	}
+	cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") {
+		return nil
+	}
+
+	ttl := cfg.defaultTTL
+	matched := -1
+	for _, route := range cfg.Routes {
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) && len(route.PathPrefix) > matched {
+			ttl, matched = route.ttl, len(route.PathPrefix)
+		}
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	// Query parameters are sorted so their order doesn't split the cache
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", r.Method, r.URL.Path, r.URL.Query().Encode())
+	for _, name := range cfg.VaryHeaders {
+		fmt.Fprintf(h, "%s: %s\n", strings.ToLower(name), strings.Join(r.Header.Values(name), ","))
+	}
+	if mode := requestedResponseMode(r); mode != "" {
+		fmt.Fprintf(h, "mode: %s\n", mode) // Clients may pick another mode per request
+	}
+	if bucket := assignedBucket(r.Context()); bucket != "" {
+		fmt.Fprintf(h, "bucket: %s\n", bucket) // Buckets may be answered by different destinations
+	}
+	if namespace := requestNamespace(r.Context()); namespace != "" {
+		fmt.Fprintf(h, "namespace: %s\n", namespace) // Stripped prefixes leave the same path in several namespaces
+	}
+	return &cachePlan{
+		key:    hex.EncodeToString(h.Sum(nil)),
+		ttl:    ttl,
+		lookup: !strings.Contains(cacheControl, "no-cache") && !strings.Contains(cacheControl, "max-age=0"),
+	}
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// serve answers the request from the cache and reports whether it did; cache errors count as a miss
+func (p *cachePlan) serve(w http.ResponseWriter, r *http.Request) bool {
+	if !p.lookup {
+		w.Header().Set("X-Hopper-Cache", "BYPASS")
+		return false
+	}
+	reqID := requestIDFromContext(r.Context())
+	cached, err := responseCache.Get(r.Context(), p.key)
+	if err != nil {
+		log.Printf("[%s] Error reading the response cache: %v", reqID, err)
+	}
+	if cached == nil {
+		w.Header().Set("X-Hopper-Cache", "MISS")
+		return false
+	}
+
+	// The response is stored as the destination sent it, possibly compressed
+	header := http.Header(cached.Header).Clone()
+	body, err := acceptableBody(r, header, cached.Body)
+	if err != nil {
+		log.Printf("[%s] Cached response can't be sent to the client: %v", reqID, err)
+		w.Header().Set("X-Hopper-Cache", "MISS")
+		return false
+	}
+	age := time.Since(cached.StoredAt)
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	w.Header().Set("X-Hopper-Cache", "HIT")
+	out, finish := compressResponse(w, r, cached.Status, int64(len(body)))
+	w.WriteHeader(cached.Status)
+	if r.Method != http.MethodHead {
+		out.Write(body)
+	}
+	finish()
+	log.Printf("[%s] Served from the response cache: Status %d, Age %s", reqID, cached.Status, age.Round(time.Second))
+
+	event := newTrafficEvent(EventCached, r)
+	event.Status = cached.Status
+	event.Message = fmt.Sprintf("Served from the response cache, stored %s ago", age.Round(time.Second))
+	BroadcastTraffic(event)
+	return true
+}
+
+// store keeps the default destination's response when its status is cacheable and the upstream
+// allows it; body is the complete response body. A response that varies on request headers
+// outside the key is not stored, since it may not fit other clients. Accept-Encoding is fine:
+// cached bodies are decoded for clients that don't accept their coding.
+func (p *cachePlan) store(r *http.Request, resp *http.Response, body []byte) {
+	cfg := requestConfig(r.Context()).ResponseCache
+	if !containsInt(cfg.Statuses, resp.StatusCode) || int64(len(body)) > cfg.MaxBodyBytes {
+		return
+	}
+	cacheControl := strings.ToLower(resp.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") ||
+		resp.Header.Get("Set-Cookie") != "" {
+		return
+	}
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" || (name != "" && !strings.EqualFold(name, "Accept-Encoding") && !containsFold(cfg.VaryHeaders, name)) {
+				return
+			}
+		}
+	}
+
+	cached := CachedResponse{
+		Status:   resp.StatusCode,
+		Header:   http.Header(resp.Header).Clone(),
+		Body:     body,
+		StoredAt: time.Now().UTC(),
+	}
+	delete(cached.Header, "X-Hopper-Cache")
+	reqID := requestIDFromContext(r.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := responseCache.Set(ctx, p.key, cached, p.ttl); err != nil {
+			log.Printf("[%s] Error writing the response cache: %v", reqID, err)
+		}
+	}()
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryResponseCache keeps up to maxEntries responses, evicting the least recently used
+type memoryResponseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // Most recently used first
+}
+
+type memoryCacheEntry struct {
+	key       string
+	resp      CachedResponse
+	expiresAt time.Time
+}
+
+func newMemoryResponseCache(maxEntries int) *memoryResponseCache {
+	return &memoryResponseCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *memoryResponseCache) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, nil
+	}
+	c.order.MoveToFront(element)
+	resp := entry.resp
+	return &resp, nil
+}
+
+func (c *memoryResponseCache) Set(ctx context.Context, key string, resp CachedResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, resp, ttl)
+	return nil
+}
+
+// set stores an entry; c.mu must be held
+func (c *memoryResponseCache) set(key string, resp CachedResponse, ttl time.Duration) {
+	entry := &memoryCacheEntry{key: key, resp: resp, expiresAt: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+func (c *memoryResponseCache) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok && time.Now().Before(element.Value.(*memoryCacheEntry).expiresAt) {
+		return false, nil
+	}
+	c.set(key, CachedResponse{StoredAt: time.Now().UTC()}, ttl)
+	return true, nil
+}
+
+func (c *memoryResponseCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *memoryResponseCache) Close() error {
+	return nil
+}
+
+// redisResponseCache shares cached responses between hoppers; Redis expires the keys
+type redisResponseCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func (c *redisResponseCache) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Redis Get Error: %v", err)
+	}
+	var resp CachedResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("Redis Decode Error: %v", err)
+	}
+	return &resp, nil
+}
+
+func (c *redisResponseCache) Set(ctx context.Context, key string, resp CachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("Redis Encode Error: %v", err)
+	}
+	if err := c.client.Set(ctx, c.prefix+key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("Redis Insert Error: %v", err)
+	}
+	return nil
+}
+
+func (c *redisResponseCache) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(CachedResponse{StoredAt: time.Now().UTC()})
+	if err != nil {
+		return false, fmt.Errorf("Redis Encode Error: %v", err)
+	}
+	claimed, err := c.client.SetNX(ctx, c.prefix+key, data, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("Redis Insert Error: %v", err)
+	}
+	return claimed, nil
+}
+
+func (c *redisResponseCache) Delete(ctx context.Context, key string) error {
+	if err := c.client.Del(ctx, c.prefix+key).Err(); err != nil {
+		return fmt.Errorf("Redis Delete Error: %v", err)
+	}
+	return nil
+}
+
+func (c *redisResponseCache) Close() error {
+	return c.client.Close()
+}
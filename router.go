@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"path"
@@ -9,6 +10,21 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// apiPrefix is where the management routes live, so that every other path can be forwarded
+const apiPrefix = "/api/v1"
+
+// deprecatedPath serves a management route at its unprefixed path of earlier releases, and
+// tells the client where it moved (RFC 8594 style Deprecation and Link headers)
+func deprecatedPath(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := apiPrefix + r.URL.Path
+		log.Printf("[%s] %s %s uses a deprecated path; use %s", requestIDFromContext(r.Context()), r.Method, r.URL.Path, successor)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
 // URLNormalizationMiddleware normalizes the URL path
 func URLNormalizationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -23,22 +39,169 @@ func URLNormalizationMiddleware(next http.Handler) http.Handler {
 
 // initializeRoutes sets up the HTTP routes for the application
 func initializeRoutes(r *mux.Router) *mux.Router {
+	cfg := currentConfig()
 	r = r.SkipClean(true)
 
-	// Apply the URL normalization middleware to all routes
+	// Apply the configuration snapshot, access log, request ID, URL normalization and body limit
+	// middleware to all routes
+	r.Use(ConfigSnapshotMiddleware)
+	r.Use(AccessLogMiddleware)
+	r.Use(RequestIDMiddleware)
 	r.Use(URLNormalizationMiddleware)
+	r.Use(BodyLimitMiddleware)
+
+	// Management routes send CORS headers, are limited to ip_filter.admin and require
+	// authentication when enabled. API keys limited to namespaces only reach the routes that
+	// manage their destinations (see namespace.go).
+	scoped := func(h http.HandlerFunc) http.HandlerFunc {
+		return withCORS(allowIPs(adminIPs, requireAuth(h)))
+	}
+	protected := func(h http.HandlerFunc) http.HandlerFunc {
+		return scoped(unscopedOnly(h))
+	}
+	scopedDestination := func(h http.HandlerFunc) http.HandlerFunc {
+		return scoped(destinationInScope(h))
+	}
+	// Groups, audit, history, captures and stored API keys live in MongoDB only
+	mongoOnly := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if mongoClient == nil {
+				http.Error(w, fmt.Sprintf("Not available with storage driver %q", cfg.Storage.Driver), http.StatusNotImplemented)
+				return
+			}
+			h(w, r)
+		}
+	}
+	// Dead letters need the delivery queue
+	queueOnly := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if deliveryQueue == nil {
+				http.Error(w, "The delivery queue is not enabled", http.StatusNotImplemented)
+				return
+			}
+			h(w, r)
+		}
+	}
+	monitoring := func(h http.HandlerFunc) http.HandlerFunc {
+		return withCORS(allowIPs(adminIPs, h))
+	}
+	// Management routes are served under /api/v1, and at their old paths as deprecated
+	// aliases until app.disable_legacy_admin_paths hands those paths to forwarding
+	handle := func(path string, h http.HandlerFunc, methods ...string) {
+		r.HandleFunc(apiPrefix+path, h).Methods(methods...)
+		if !cfg.App.DisableLegacyAdminPaths {
+			r.HandleFunc(path, deprecatedPath(h)).Methods(methods...)
+		}
+	}
+
+	// Answer CORS preflight requests instead of forwarding them when CORS is configured; the
+	// check is made per request because cors can be enabled by a reload
+	preflight := func(r *http.Request, _ *mux.RouteMatch) bool {
+		return corsEnabled(requestConfig(r.Context()))
+	}
+	for _, path := range corsPaths {
+		r.HandleFunc(apiPrefix+path, corsPreflight).Methods("OPTIONS").MatcherFunc(preflight)
+		if !cfg.App.DisableLegacyAdminPaths {
+			r.HandleFunc(path, corsPreflight).Methods("OPTIONS").MatcherFunc(preflight)
+		}
+	}
 
 	// Destination management routes
-	r.HandleFunc("/destinations", GetDestinations).Methods("GET")
-	r.HandleFunc("/destinations", AddDestination).Methods("POST")
-	r.HandleFunc("/destinations/{id}", UpdateDestination).Methods("PUT")
-	r.HandleFunc("/destinations/{id}", DeleteDestination).Methods("DELETE")
+	handle("/destinations", scoped(GetDestinations), "GET")
+	handle("/destinations", scoped(AddDestination), "POST")
+	handle("/destinations/export", protected(ExportDestinations), "GET")
+	handle("/destinations/import", protected(ImportDestinations), "POST")
+	handle("/destinations/metrics", scoped(GetAllDestinationMetrics), "GET")
+	handle("/destinations/{id}", scopedDestination(GetDestination), "GET")
+	handle("/destinations/{id}", scopedDestination(UpdateDestination), "PUT")
+	handle("/destinations/{id}", scopedDestination(DeleteDestination), "DELETE")
+	handle("/destinations/{id}/test", scopedDestination(TestDestination), "POST")
+	handle("/destinations/{id}/restore", scopedDestination(RestoreDestination), "POST")
+	handle("/destinations/{id}/default", scopedDestination(SetDestinationDefault), "POST", "DELETE")
+	handle("/destinations/{id}/metrics", scopedDestination(GetDestinationMetrics), "GET")
+	handle("/destinations/{id}/metrics", scopedDestination(ResetDestinationMetrics), "DELETE")
+	handle("/destinations/{id}/history", scopedDestination(mongoOnly(GetDestinationHistory)), "GET")
+	handle("/destinations/{id}/rollback/{version}", scopedDestination(mongoOnly(RollbackDestination)), "POST")
+	handle("/audit", protected(mongoOnly(GetAudit)), "GET")
+	handle("/groups", protected(mongoOnly(GetGroups)), "GET")
+	handle("/groups", protected(mongoOnly(AddGroup)), "POST")
+	handle("/groups/{name}", protected(mongoOnly(UpdateGroup)), "PUT")
+	handle("/groups/{name}", protected(mongoOnly(DeleteGroup)), "DELETE")
+	handle("/groups/{name}/activate", protected(mongoOnly(ActivateGroup)), "POST")
+	handle("/groups/{name}/deactivate", protected(mongoOnly(DeactivateGroup)), "POST")
+
+	// Captured traffic query routes (captures contain request bodies, so they are protected too)
+	handle("/captures", protected(mongoOnly(GetCaptures)), "GET")
+	handle("/captures/replay", protected(mongoOnly(ReplayCaptures)), "POST")
+	handle("/captures/export", protected(mongoOnly(ExportCaptures)), "GET")
+	handle("/captures/{id}", protected(mongoOnly(GetCapture)), "GET")
+	handle("/captures/{id}/replay", protected(mongoOnly(ReplayCapture)), "POST")
+
+	// Replaying an uploaded HAR file or Postman collection, e.g. to smoke-test a new environment
+	handle("/replay/import", protected(ImportReplay), "POST")
+
+	// Deliveries the queue gave up on, and re-driving them once the upstream is back
+	handle("/deadletters", protected(queueOnly(GetDeadLetters)), "GET")
+	handle("/deadletters/retry", protected(queueOnly(RetryDeadLetters)), "POST")
+	handle("/deadletters/{id}/retry", protected(queueOnly(RetryDeadLetter)), "POST")
+
+	// API key management routes
+	handle("/apikeys", protected(mongoOnly(GetAPIKeys)), "GET")
+	handle("/apikeys", protected(mongoOnly(CreateAPIKey)), "POST")
+	handle("/apikeys/{id}", protected(mongoOnly(RevokeAPIKey)), "DELETE")
+
+	// Drain control: stop accepting forwarded requests ahead of a shutdown or deploy
+	handle("/admin/drain", protected(GetDrainStatus), "GET")
+	handle("/admin/drain", protected(StartDrain), "POST")
+	handle("/admin/drain", protected(StopDrain), "DELETE")
+
+	// Fault injection for chaos testing; faults only take effect with fault_injection.enabled
+	handle("/admin/faults", protected(GetFaults), "GET")
+	handle("/admin/faults", protected(AddFault), "POST")
+	handle("/admin/faults", protected(ClearFaults), "DELETE")
+	handle("/admin/faults/{id}", protected(DeleteFault), "DELETE")
+
+	// Connection reuse and negotiated protocols per destination
+	handle("/admin/connections", protected(GetConnections), "GET")
+
+	// Cached answers of the upstream DNS resolver
+	handle("/admin/dns", protected(GetDNSCache), "GET")
+	handle("/admin/dns", protected(FlushDNSCache), "DELETE")
+
+	// Saturation of the workers that send requests to destinations
+	handle("/admin/workers", protected(GetWorkerPool), "GET")
+
+	// Re-read the config file without restarting (also on SIGHUP)
+	handle("/admin/reload", protected(ReloadConfig), "POST")
+
+	// Traffic monitoring endpoints (authenticated with traffic tokens)
+	handle("/traffic", monitoring(StreamTraffic), "GET")
+	handle("/traffic/sse", monitoring(StreamTrafficSSE), "GET")
+	handle("/traffic/stats", monitoring(GetTrafficStats), "GET")
+
+	// Web dashboard: destinations and the live traffic stream, on top of the API above. Like the
+	// admin API it moved under the prefix, and /ui stays until legacy paths are disabled.
+	dashboard := func(prefix string) {
+		r.Handle(prefix, uiHandler(prefix)).MatcherFunc(uiEnabled)
+		r.PathPrefix(prefix + "/").Handler(uiHandler(prefix)).MatcherFunc(uiEnabled)
+	}
+	dashboard(apiPrefix + "/ui")
+	if !cfg.App.DisableLegacyAdminPaths {
+		dashboard("/ui")
+	}
+
+	// OpenAPI document of the admin API, open so clients and dashboards can be generated from it
+	handle("/openapi.json", withCORS(allowIPs(adminIPs, GetOpenAPI)), "GET")
 
-	// WebSocket traffic monitoring endpoint
-	r.HandleFunc("/traffic", StreamTraffic).Methods("GET")
+	// Liveness and readiness probes; open to everyone so orchestrators and load balancers can
+	// reach them, and therefore never forwarded. health.liveness_path and readiness_path move
+	// them off paths the upstreams use.
+	r.HandleFunc(cfg.Health.LivenessPath, Healthz).Methods("GET", "HEAD")
+	r.HandleFunc(cfg.Health.ReadinessPath, Readyz).Methods("GET", "HEAD")
 
-	// Catch-all route for forwarding any request (handles any path, method, etc.)
-	r.PathPrefix("/").HandlerFunc(ForwardRequest)
+	// Catch-all route for forwarding any request (handles any path, method, etc.), limited to
+	// ip_filter.forwarding, rate limited and shed under overload when configured
+	r.PathPrefix("/").HandlerFunc(allowIPs(forwardingIPs, drainable(shedLoad(rateLimit(ForwardRequest)))))
 
 	return r
 }
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// RoutingRule is a condition on the inbound request. A destination with rules only receives
+// requests that satisfy all of them (or any of them with ruleMatch "any").
+type RoutingRule struct {
+	Header    string   `bson:"header,omitempty" json:"header,omitempty"`       // Request header to test
+	BodyField string   `bson:"bodyField,omitempty" json:"bodyField,omitempty"` // Dotted path into a JSON body, e.g. "type" or "data.items.0.sku"
+	BodyPath  string   `bson:"bodyPath,omitempty" json:"bodyPath,omitempty"`   // JSONPath ("$.data.items[*].sku") or GJSON path ("data.items.#.sku") into a JSON body
+	Query     string   `bson:"query,omitempty" json:"query,omitempty"`         // Query parameter to test
+	Path      bool     `bson:"path,omitempty" json:"path,omitempty"`           // Test the request path
+	Strip     bool     `bson:"strip,omitempty" json:"strip,omitempty"`         // Remove the query parameter before forwarding to the destination
+	Op        string   `bson:"op,omitempty" json:"op,omitempty"`               // equals (default), not_equals, prefix, contains, regex, in, exists or missing
+	Value     string   `bson:"value,omitempty" json:"value,omitempty"`
+	Values    []string `bson:"values,omitempty" json:"values,omitempty"` // Candidates for "in"
+
+	// How the client is answered when the rule matches a request the destination receives;
+	// forwarding.response_mode applies when empty (see responseStrategyFor)
+	ResponseMode string `bson:"responseMode,omitempty" json:"responseMode,omitempty"`
+	Quorum       int    `bson:"quorum,omitempty" json:"quorum,omitempty"` // 2xx answers a "quorum" response needs; forwarding.quorum when 0
+}
+
+const (
+	RuleMatchAll = "all"
+	RuleMatchAny = "any"
+)
+
+// ruleRegexps caches compiled "regex" rule values
+var ruleRegexps sync.Map
+
+func ruleRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := ruleRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	ruleRegexps.Store(pattern, re)
+	return re, nil
+}
+
+func (rule RoutingRule) validate() error {
+	set := 0
+	for _, field := range []string{rule.Header, rule.BodyField, rule.BodyPath, rule.Query} {
+		if field != "" {
+			set++
+		}
+	}
+	if rule.Path {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of header, query, path, bodyField and bodyPath is required")
+	}
+	if rule.Strip && rule.Query == "" {
+		return fmt.Errorf("strip only applies to query rules")
+	}
+	if rule.BodyPath != "" {
+		if _, err := gjsonPath(rule.BodyPath); err != nil {
+			return fmt.Errorf("invalid bodyPath %q: %v", rule.BodyPath, err)
+		}
+	}
+	if rule.ResponseMode != "" && !validResponseMode(rule.ResponseMode) {
+		return fmt.Errorf("unknown responseMode %q", rule.ResponseMode)
+	}
+	if rule.Quorum < 0 {
+		return fmt.Errorf("quorum must not be negative")
+	}
+	if rule.Quorum > 0 && rule.ResponseMode != ResponseModeQuorum {
+		return fmt.Errorf(`quorum only applies to responseMode "quorum"`)
+	}
+	switch rule.Op {
+	case "", "equals", "not_equals", "prefix", "contains", "exists", "missing":
+	case "regex":
+		if _, err := regexp.Compile(rule.Value); err != nil {
+			return fmt.Errorf("invalid regex %q: %v", rule.Value, err)
+		}
+	case "in":
+		if len(rule.Values) == 0 {
+			return fmt.Errorf(`"in" requires values`)
+		}
+	default:
+		return fmt.Errorf("unknown op %q", rule.Op)
+	}
+	return nil
+}
+
+// bufferedBodyKey carries the request body when it was buffered, so body rules can read it
+type bufferedBodyKey struct{}
+
+func withBufferedBody(ctx context.Context, body []byte) context.Context {
+	return context.WithValue(ctx, bufferedBodyKey{}, body)
+}
+
+// routingInput gives rules access to a request; the JSON body is checked and decoded once, on
+// first use
+type routingInput struct {
+	r       *http.Request
+	query   url.Values
+	checked bool
+	body    []byte // nil when the body is streamed, larger than forwarding.max_rule_body_bytes or not JSON
+	decoded bool
+	doc     interface{}
+}
+
+func newRoutingInput(r *http.Request) *routingInput {
+	return &routingInput{r: r}
+}
+
+// queryValues returns the values of a query parameter; the query is parsed once
+func (in *routingInput) queryValues(name string) []string {
+	if in.query == nil {
+		in.query = in.r.URL.Query()
+	}
+	return in.query[name]
+}
+
+// jsonBody returns the request body when body rules can look at it
+func (in *routingInput) jsonBody() []byte {
+	if !in.checked {
+		in.checked = true
+		body, ok := in.r.Context().Value(bufferedBodyKey{}).([]byte)
+		if ok && int64(len(body)) <= requestConfig(in.r.Context()).Forwarding.MaxRuleBodyBytes && gjson.ValidBytes(body) {
+			in.body = body
+		}
+	}
+	return in.body
+}
+
+// bodyField returns the value at a dotted path in the JSON body; objects and arrays are
+// returned as JSON and array elements are addressed by index
+func (in *routingInput) bodyField(path string) (string, bool) {
+	if !in.decoded {
+		in.decoded = true
+		if body := in.jsonBody(); body != nil {
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if decoder.Decode(&in.doc) != nil {
+				in.doc = nil
+			}
+		}
+	}
+	if in.doc == nil {
+		return "", false
+	}
+	value := in.doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return "", false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			value = v[i]
+		default:
+			return "", false
+		}
+	}
+	return jsonValueString(value)
+}
+
+// jsonValueString renders a decoded JSON value for comparison; strings are unquoted
+func jsonValueString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case nil:
+		return "null", true
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// bodyPath returns the values a JSONPath or GJSON path selects in the JSON body. Paths with a
+// wildcard ([*] or #) select every matching element; otherwise there is at most one value.
+func (in *routingInput) bodyPath(path string) []string {
+	body := in.jsonBody()
+	if body == nil {
+		return nil
+	}
+	gpath, err := gjsonPath(path)
+	if err != nil {
+		return nil
+	}
+	result := gjson.GetBytes(body, gpath)
+	if !result.Exists() {
+		return nil
+	}
+	results := []gjson.Result{result}
+	if strings.Contains(gpath, "#") && result.IsArray() {
+		results = result.Array()
+	}
+	values := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Type == gjson.String {
+			values = append(values, r.Str)
+		} else {
+			values = append(values, r.Raw)
+		}
+	}
+	return values
+}
+
+// gjsonPath converts a JSONPath expression ($.a.b[0], $['a'], $.items[*].sku) to GJSON syntax.
+// Paths without the leading $ are taken as GJSON paths, which also cover filters such as
+// items.#(type=="refund").sku.
+func gjsonPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "$") {
+		if path == "" {
+			return "", fmt.Errorf("empty path")
+		}
+		return path, nil
+	}
+	var parts []string
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			return "", fmt.Errorf("recursive descent (..) is not supported")
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" || name == "*" {
+				return "", fmt.Errorf("expected a member name after '.'")
+			}
+			parts = append(parts, gjson.Escape(name))
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated '['")
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+			if n := len(selector); n >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[n-1] == selector[0] {
+				parts = append(parts, gjson.Escape(selector[1:n-1]))
+			} else if selector == "*" {
+				parts = append(parts, "#")
+			} else if _, err := strconv.Atoi(selector); err == nil && selector[0] != '-' {
+				parts = append(parts, selector)
+			} else {
+				return "", fmt.Errorf("unsupported selector [%s]; use GJSON syntax for filters, e.g. items.#(type==\"refund\")", selector)
+			}
+		default:
+			return "", fmt.Errorf("unexpected %q", rest[:1])
+		}
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("the path selects the whole body")
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// matches evaluates the rule; a header or query parameter with several values (or a body path selecting several)
+// matches when any value does
+func (rule RoutingRule) matches(in *routingInput) bool {
+	var values []string
+	switch {
+	case rule.Header != "":
+		values = in.r.Header.Values(rule.Header)
+	case rule.Query != "":
+		values = in.queryValues(rule.Query)
+	case rule.Path:
+		values = []string{in.r.URL.Path}
+	case rule.BodyPath != "":
+		values = in.bodyPath(rule.BodyPath)
+	default:
+		if value, ok := in.bodyField(rule.BodyField); ok {
+			values = []string{value}
+		}
+	}
+
+	switch rule.Op {
+	case "exists":
+		return len(values) > 0
+	case "missing":
+		return len(values) == 0
+	case "not_equals":
+		for _, value := range values {
+			if value == rule.Value {
+				return false
+			}
+		}
+		return true
+	}
+	for _, value := range values {
+		switch rule.Op {
+		case "", "equals":
+			if value == rule.Value {
+				return true
+			}
+		case "prefix":
+			if strings.HasPrefix(value, rule.Value) {
+				return true
+			}
+		case "contains":
+			if strings.Contains(value, rule.Value) {
+				return true
+			}
+		case "regex":
+			if re, err := ruleRegexp(rule.Value); err == nil && re.MatchString(value) {
+				return true
+			}
+		case "in":
+			if contains(rule.Values, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesRules reports whether the destination's routing rules let the request through
+func (d Destination) matchesRules(in *routingInput) bool {
+	if len(d.Rules) == 0 {
+		return true
+	}
+	for _, rule := range d.Rules {
+		matched := rule.matches(in)
+		if d.RuleMatch == RuleMatchAny && matched {
+			return true
+		}
+		if d.RuleMatch != RuleMatchAny && !matched {
+			return false
+		}
+	}
+	return d.RuleMatch != RuleMatchAny
+}
+
+// stripQuery removes the query parameters of the destination's strip rules from a forward URL,
+// keeping the remaining parameters as the client sent them
+func (d Destination) stripQuery(u *url.URL) {
+	var strip []string
+	for _, rule := range d.Rules {
+		if rule.Strip {
+			strip = append(strip, rule.Query)
+		}
+	}
+	if len(strip) == 0 || u.RawQuery == "" {
+		return
+	}
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name = pair[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !contains(strip, name) {
+			kept = append(kept, pair)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+}
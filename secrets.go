@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Any string in the configuration can be a reference to a secret instead of the value itself:
+//
+//	env://NAME                the environment variable NAME
+//	file:///run/secrets/name  the contents of a file, without the trailing newline
+//	vault://secret/data/hopper#mongodb_url
+//	                          a field of a Vault secret, read from VAULT_ADDR with VAULT_TOKEN
+//	                          (and VAULT_NAMESPACE when set); KV v1 and v2 are both understood
+//
+// References are resolved every time the configuration is read, so a reload picks up rotated
+// secrets.
+const (
+	secretEnvPrefix   = "env://"
+	secretFilePrefix  = "file://"
+	secretVaultPrefix = "vault://"
+)
+
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// resolveSecretRefs replaces every secret reference below v; path names the field in errors
+func resolveSecretRefs(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			if err := resolveSecretRefs(v.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecretRefs(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			value, err := resolveSecret(v.MapIndex(key).String())
+			if err != nil {
+				return fmt.Errorf("%s.%s: %v", path, key.String(), err)
+			}
+			v.SetMapIndex(key, reflect.ValueOf(value).Convert(v.Type().Elem()))
+		}
+	case reflect.String:
+		value, err := resolveSecret(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		v.SetString(value)
+	}
+	return nil
+}
+
+// isSecretRef reports whether a value is a reference, which can't be validated before it is
+// resolved
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretEnvPrefix) || strings.HasPrefix(value, secretFilePrefix) || strings.HasPrefix(value, secretVaultPrefix)
+}
+
+// resolveSecret returns the value a reference points to; other values are returned unchanged
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretFilePrefix):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(value, secretFilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, secretVaultPrefix):
+		return readVaultSecret(strings.TrimPrefix(value, secretVaultPrefix))
+	}
+	return value, nil
+}
+
+// readVaultSecret reads one field of a secret, given as <path>#<field> with the path as it
+// appears in the Vault API after /v1/
+func readVaultSecret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference %q must be vault://<path>#<field>", secretVaultPrefix+ref)
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error reading vault secret %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error reading vault secret %s: %s", path, resp.Status)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("error decoding vault secret %s: %v", path, err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested // KV v2 wraps the fields with their metadata
+	}
+	secret, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field %q", path, field)
+	}
+	if s, ok := secret.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(secret), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// statsdSink sends the destination metrics to a StatsD server, with DogStatsD tags: every
+// request forwarded to a destination is counted and timed, and gauges of the hopper's load are
+// sampled every metrics.statsd.flush_interval. Lines are queued and sent in UDP packets by a
+// single goroutine; when the queue is full they are dropped rather than slowing down forwarding.
+type statsdSink struct {
+	cfg     StatsDConfig
+	conn    net.Conn
+	lines   chan string
+	stop    chan struct{}
+	done    chan struct{}
+	tags    []string // metrics.statsd.tags, added to every line
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// StatsDConfig sends metrics to a StatsD server or the Datadog agent
+type StatsDConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Address       string   `yaml:"address"`        // UDP host:port of the server; defaults to 127.0.0.1:8125
+	Prefix        string   `yaml:"prefix"`         // Prepended to every metric name; defaults to "http_hopper"
+	Tags          []string `yaml:"tags"`           // Added to every metric, e.g. "env:prod"
+	FlushInterval string   `yaml:"flush_interval"` // How often gauges are sampled and queued lines sent; defaults to 10s
+	Buffer        int      `yaml:"buffer"`         // Lines queued for sending before new ones are dropped; defaults to 10000
+	flushInterval time.Duration
+}
+
+// statsd is the StatsD sink, nil when metrics.statsd is disabled
+var statsd *statsdSink
+
+// statsdMaxPacket keeps packets below the usual MTU so they are never fragmented
+const statsdMaxPacket = 1432
+
+// validateStatsD fills in the defaults of metrics.statsd and checks it
+func validateStatsD(cfg *StatsDConfig) error {
+	if cfg.Address == "" {
+		cfg.Address = "127.0.0.1:8125"
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return fmt.Errorf("invalid address %q: %v", cfg.Address, err)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "http_hopper"
+	}
+	cfg.Prefix = strings.TrimSuffix(cfg.Prefix, ".")
+	for _, tag := range cfg.Tags {
+		if tag == "" || strings.ContainsAny(tag, ",|#") {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	if cfg.Buffer < 0 {
+		return fmt.Errorf("buffer %d must not be negative", cfg.Buffer)
+	}
+	if cfg.Buffer == 0 {
+		cfg.Buffer = 10000
+	}
+	if cfg.FlushInterval == "" {
+		cfg.FlushInterval = "10s"
+	}
+	interval, err := time.ParseDuration(cfg.FlushInterval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid flush_interval %q", cfg.FlushInterval)
+	}
+	cfg.flushInterval = interval
+	return nil
+}
+
+// startStatsD opens the UDP socket and starts sending
+func startStatsD(cfg StatsDConfig) (*statsdSink, error) {
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the StatsD socket: %v", err)
+	}
+	s := &statsdSink{
+		cfg:   cfg,
+		conn:  conn,
+		lines: make(chan string, cfg.Buffer),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		tags:  cfg.Tags,
+	}
+	go s.run()
+	log.Printf("Sending metrics to StatsD at %s with prefix %s", cfg.Address, cfg.Prefix)
+	return s, nil
+}
+
+// line formats a metric, e.g. "http_hopper.destination.requests:1|c|#destination:billing.internal"
+func (s *statsdSink) line(name, value, kind string, tags []string) string {
+	line := s.cfg.Prefix + "." + name + ":" + value + "|" + kind
+	if all := append(append([]string(nil), s.tags...), tags...); len(all) > 0 {
+		line += "|#" + strings.Join(all, ",")
+	}
+	return line
+}
+
+// queue adds a line without blocking
+func (s *statsdSink) queue(line string) {
+	select {
+	case s.lines <- line:
+	default:
+		if s.dropped.Add(1)%10000 == 1 {
+			log.Printf("StatsD sink is falling behind, %d metrics dropped so far", s.dropped.Load())
+		}
+	}
+}
+
+// destinationRequest counts and times a request to a destination (status 0 with err when there
+// was no response); a no-op without a sink
+func (s *statsdSink) destinationRequest(destination Destination, status int, latency time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	tags := destinationTags(destination)
+	class := "error"
+	if err == nil {
+		class = strconv.Itoa(status/100) + "xx"
+	}
+	s.queue(s.line("destination.requests", "1", "c", append(tags, "status_class:"+class)))
+	if err == nil {
+		s.queue(s.line("destination.latency", strconv.FormatFloat(millis(latency), 'f', -1, 64), "ms", tags))
+	}
+}
+
+// destinationTags identifies a destination in DogStatsD tags
+func destinationTags(destination Destination) []string {
+	name := destination.URL
+	if u, err := url.Parse(destination.URL); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	tags := []string{"destination:" + statsdTagValue(name)}
+	if !destination.ID.IsZero() {
+		tags = append(tags, "destination_id:"+destination.ID.Hex())
+	}
+	if destination.Namespace != "" {
+		tags = append(tags, "namespace:"+destination.Namespace)
+	}
+	if destination.Group != "" {
+		tags = append(tags, "group:"+statsdTagValue(destination.Group))
+	}
+	return tags
+}
+
+// statsdTagValue replaces the characters that separate tags and fields
+func statsdTagValue(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(value)
+}
+
+// gauges samples the hopper's load
+func (s *statsdSink) gauges() []string {
+	gauge := func(name string, value float64, tags ...string) string {
+		return s.line(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+	}
+	lines := []string{gauge("requests.in_flight", float64(drainStatus().InFlight))}
+	if fanOutPool != nil {
+		stats := fanOutPool.stats()
+		lines = append(lines, gauge("fanout.busy", float64(stats.Busy)), gauge("fanout.queued", float64(stats.Queued)))
+	}
+	open := 0
+	outcomesByDestination.Range(func(_, value interface{}) bool {
+		o := value.(*destinationOutcomes)
+		o.mu.Lock()
+		if o.circuit != CircuitClosed {
+			open++
+		}
+		rate, tags := o.errorRate(), destinationTags(o.destination)
+		o.mu.Unlock()
+		lines = append(lines, gauge("destination.error_rate", rate, tags...))
+		return true
+	})
+	lines = append(lines, gauge("circuits.open", float64(open)))
+	return lines
+}
+
+// run packs queued lines into packets, sending them when full and every flush interval, until
+// the sink is stopped
+func (s *statsdSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.flushInterval)
+	defer ticker.Stop()
+	var packet []byte
+	var lines uint64
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := s.conn.Write(packet); err != nil {
+			log.Printf("Error sending metrics to StatsD: %v", err)
+		} else {
+			s.sent.Add(lines)
+		}
+		packet, lines = packet[:0], 0
+	}
+	add := func(line string) {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+		lines++
+	}
+
+	for {
+		select {
+		case line := <-s.lines:
+			add(line)
+		case <-ticker.C:
+			for _, line := range s.gauges() {
+				add(line)
+			}
+			flush()
+		case <-s.stop:
+			for {
+				select {
+				case line := <-s.lines:
+					add(line)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// stopStatsD sends the lines still queued and closes the socket
+func stopStatsD() {
+	if statsd == nil {
+		return
+	}
+	close(statsd.stop)
+	<-statsd.done
+	statsd.conn.Close()
+	log.Printf("StatsD sink stopped: %d metrics sent, %d dropped", statsd.sent.Load(), statsd.dropped.Load())
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DestinationStore persists destinations. Handlers only talk to the store, so backends (and
+// in-memory doubles in tests) can be swapped without touching them. Implementations return
+// errInvalidID, errNotFound and errConflict as documented on the MongoDB store.
+type DestinationStore interface {
+	All(ctx context.Context) ([]Destination, error) // Every destination that is not archived
+	Find(ctx context.Context, q DestinationQuery) ([]Destination, int64, error)
+	Get(ctx context.Context, id string) (Destination, error) // Archived destinations included
+	Add(ctx context.Context, destination Destination) (primitive.ObjectID, error)
+	Update(ctx context.Context, id string, destination Destination, version int64) error
+	Replace(ctx context.Context, destination Destination, version int64) error
+	Archive(ctx context.Context, id string) error
+	Restore(ctx context.Context, id string) (Destination, error)
+	Delete(ctx context.Context, id string) error
+	ApplyImport(ctx context.Context, creates, updates, deletes []Destination) error
+
+	// Watch registers a function called when destinations may have been changed by another
+	// process, for backends that can observe that. It may also fire for this store's own writes.
+	Watch(onChange func())
+	Ping(ctx context.Context) error // Checks that the backend is reachable, for /readyz
+	Close() error
+}
+
+// store is the destination store selected by storage.driver
+var store DestinationStore
+
+// storeWatchers implements DestinationStore.Watch; backends call notify when they see a change
+type storeWatchers struct {
+	mu       sync.Mutex
+	watchers []func()
+}
+
+func (w *storeWatchers) Watch(onChange func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watchers = append(w.watchers, onChange)
+}
+
+func (w *storeWatchers) notify() {
+	w.mu.Lock()
+	watchers := append([]func(){}, w.watchers...)
+	w.mu.Unlock()
+	for _, onChange := range watchers {
+		onChange()
+	}
+}
+
+// matchesDestinationQuery applies the filters of a DestinationQuery the way MongoDB does
+func matchesDestinationQuery(d Destination, q DestinationQuery) bool {
+	if q.IsActive != nil && d.IsActive != *q.IsActive {
+		return false
+	}
+	if q.Method != "" && !contains(d.Methods, q.Method) {
+		return false
+	}
+	if q.Group != "" && d.Group != q.Group {
+		return false
+	}
+	if q.Tag != "" && !contains(d.Tags, q.Tag) {
+		return false
+	}
+	if q.Namespaces != nil && !contains(q.Namespaces, d.Namespace) {
+		return false
+	}
+	if q.Search != "" && !strings.Contains(strings.ToLower(d.URL), strings.ToLower(q.Search)) {
+		return false
+	}
+	return true
+}
+
+// sortDestinations orders destinations by one of destinationSortFields, then by ID
+func sortDestinations(destinations []Destination, sortSpec string) {
+	field := strings.TrimPrefix(sortSpec, "-")
+	descending := strings.HasPrefix(sortSpec, "-")
+	key := func(d Destination) string {
+		switch field {
+		case "url":
+			return d.URL
+		case "group":
+			return d.Group
+		case "method":
+			return d.methodKey()
+		case "isActive":
+			return fmt.Sprint(d.IsActive)
+		case "isDefault":
+			return fmt.Sprint(d.IsDefault)
+		}
+		return d.ID.Hex()
+	}
+	sort.SliceStable(destinations, func(i, j int) bool {
+		a, b := key(destinations[i]), key(destinations[j])
+		if a == b {
+			return destinations[i].ID.Hex() < destinations[j].ID.Hex()
+		}
+		return (a < b) != descending
+	})
+}
+
+// pageDestinations sorts the matching destinations and cuts out the requested page, for
+// backends that filter in memory
+func pageDestinations(destinations []Destination, q DestinationQuery) ([]Destination, int64, error) {
+	total := int64(len(destinations))
+	sortDestinations(destinations, q.Sort)
+	if q.Page > 0 {
+		start := (q.Page - 1) * q.Limit
+		if start > len(destinations) {
+			start = len(destinations)
+		}
+		end := start + q.Limit
+		if end > len(destinations) {
+			end = len(destinations)
+		}
+		destinations = destinations[start:end]
+	}
+	return destinations, total, nil
+}
+
+// changeDestination applies change to the BSON form of current, which is how updates are
+// expressed for every driver (see destinationUpdate), and returns the result with its version
+// bumped. Drivers that keep documents in another form use it to share the update logic.
+func changeDestination(current Destination, change func(current Destination, doc bson.M) error) (Destination, error) {
+	var updated Destination
+	data, err := bson.Marshal(current)
+	if err != nil {
+		return updated, fmt.Errorf("Encode Error: %v", err)
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return updated, fmt.Errorf("Decode Error: %v", err)
+	}
+	if err := change(current, doc); err != nil {
+		return updated, err
+	}
+	doc["version"] = current.Version + 1
+	if data, err = bson.Marshal(doc); err != nil {
+		return updated, fmt.Errorf("Encode Error: %v", err)
+	}
+	if err := bson.Unmarshal(data, &updated); err != nil {
+		return updated, fmt.Errorf("Decode Error: %v", err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// TLS protocol versions accepted in the min_version setting. TLS 1.0 and 1.1 are deprecated
+// (RFC 8996) and refused.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion converts a version string such as "1.2" into its crypto/tls constant
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q: use 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites maps cipher suite names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) to their IDs
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Client certificate policies accepted in the client_auth setting
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// clientCertsVerified reports whether client_auth verifies client certificates against
+// client_ca_file. With "request" or "require" any certificate is accepted, so its names prove
+// nothing.
+func clientCertsVerified() bool {
+	clientAuth := clientAuthModes[currentConfig().App.TLS.ClientAuth]
+	return clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+}
+
+// checkAllowedClients refuses destinations with allowedClients unless client certificates are
+// verified, since they could not be told apart from self-signed ones
+func checkAllowedClients(ctx context.Context) error {
+	if clientCertsVerified() {
+		return nil
+	}
+	destinations, err := store.All(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing destinations: %v", err)
+	}
+	for _, d := range destinations {
+		if len(d.AllowedClients) > 0 {
+			return fmt.Errorf("destination %s has allowedClients, which need app.tls.client_auth verify_if_given or require_and_verify", d.ID.Hex())
+		}
+	}
+	return nil
+}
+
+// buildServerTLSConfig creates the TLS settings for the inbound HTTPS listener
+func buildServerTLSConfig() (*tls.Config, error) {
+	cfg := currentConfig()
+	minVersion, err := parseTLSVersion(cfg.App.TLS.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(cfg.App.TLS.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, ok := clientAuthModes[cfg.App.TLS.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unsupported client_auth mode %q", cfg.App.TLS.ClientAuth)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		ClientAuth:   clientAuth,
+	}
+
+	// Client certificates are verified against the configured CA bundle
+	if cfg.App.TLS.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(cfg.App.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.App.TLS.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_ca_file is required to verify client certificates")
+	}
+	return tlsConfig, nil
+}
+
+// ClientIdentity describes the certificate an inbound client authenticated with
+type ClientIdentity struct {
+	CommonName string
+	SANs       []string
+}
+
+// clientIdentityFromRequest extracts the CN and SANs of the client certificate, if it was
+// verified against client_ca_file; unverified certificates give no identity
+func clientIdentityFromRequest(r *http.Request) *ClientIdentity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	identity := &ClientIdentity{CommonName: cert.Subject.CommonName}
+	identity.SANs = append(identity.SANs, cert.DNSNames...)
+	identity.SANs = append(identity.SANs, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		identity.SANs = append(identity.SANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		identity.SANs = append(identity.SANs, uri.String())
+	}
+	return identity
+}
+
+// Matches reports whether the CN or any SAN equals one of the given names
+func (c *ClientIdentity) Matches(names []string) bool {
+	if c == nil {
+		return false
+	}
+	for _, name := range names {
+		if name == c.CommonName || contains(c.SANs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientIdentity) String() string {
+	if c == nil {
+		return "<none>"
+	}
+	if len(c.SANs) == 0 {
+		return fmt.Sprintf("CN=%s", c.CommonName)
+	}
+	return fmt.Sprintf("CN=%s SAN=%s", c.CommonName, strings.Join(c.SANs, ","))
+}
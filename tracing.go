@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer used for the forwarding spans; it is a no-op until tracing is enabled
+var tracer = otel.Tracer("github.com/your-username/http-hopper")
+
+// initTracing installs the W3C trace context propagator and, when enabled, an OTLP/HTTP
+// exporter. The returned function flushes and stops the exporter on shutdown.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	cfg := currentConfig()
+	// traceparent/tracestate headers are propagated upstream even when tracing is disabled
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !cfg.Tracing.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{}
+	if cfg.Tracing.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Tracing.Endpoint))
+	}
+	if cfg.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Tracing.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Tracing.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", cfg.Tracing.ServiceName))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("github.com/your-username/http-hopper")
+
+	log.Printf("OpenTelemetry tracing enabled, exporting to %s", cfg.Tracing.Endpoint)
+	return provider.Shutdown, nil
+}
+
+// startServerSpan continues the caller's trace (if any) and starts the span covering an inbound request
+func startServerSpan(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("http.request_id", requestIDFromContext(r.Context())),
+		))
+}
+
+// injectTraceContext writes the traceparent/tracestate headers for the span in ctx
+func injectTraceContext(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// startDestinationSpan starts the client span for one destination of the fan-out and injects
+// its trace context into the outgoing request headers
+func startDestinationSpan(ctx context.Context, destination Destination, req *http.Request) trace.Span {
+	ctx, span := tracer.Start(ctx, "forward "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+			attribute.String("hopper.destination.id", destination.ID.Hex()),
+			attribute.Bool("hopper.destination.default", destination.IsDefault),
+		))
+	injectTraceContext(ctx, req.Header)
+	return span
+}
+
+// endSpan records the outcome of an upstream call and ends the span
+func endSpan(span trace.Span, statusCode int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+		if statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(statusCode))
+		}
+	}
+	span.End()
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Traffic event types emitted on the /traffic stream
+const (
+	EventRequest   = "request"   // An inbound request was received
+	EventResponse  = "response"  // A destination answered a forwarded request
+	EventError     = "error"     // Forwarding to a destination failed
+	EventQueued    = "queued"    // A request to a destination was stored in the delivery queue
+	EventReplay    = "replay"    // A captured request is being replayed
+	EventRejected  = "rejected"  // The hopper refused an inbound request (e.g. body too large)
+	EventDropped   = "dropped"   // Events were dropped because the client fell behind
+	EventSchedule  = "schedule"  // A destination's activation window opened or closed
+	EventCached    = "cached"    // A request was answered from the response cache
+	EventFault     = "fault"     // A fault was injected into the request to a destination
+	EventDuplicate = "duplicate" // A duplicate of a recent request was answered without forwarding it
+)
+
+// TrafficEvent is the JSON document sent to /traffic clients for every traffic event.
+// Fields that do not apply to an event type are omitted; see docs/traffic-events.md.
+type TrafficEvent struct {
+	Type          string              `json:"type"`
+	Timestamp     time.Time           `json:"timestamp"`
+	RequestID     string              `json:"requestId,omitempty"`
+	Method        string              `json:"method,omitempty"`
+	Path          string              `json:"path,omitempty"`
+	Query         string              `json:"query,omitempty"`
+	Client        string              `json:"client,omitempty"` // Client certificate identity, if any
+	Namespace     string              `json:"namespace,omitempty"`
+	Experiment    string              `json:"experiment,omitempty"`
+	Bucket        string              `json:"bucket,omitempty"` // Experiment bucket the client was assigned to
+	DestinationID string              `json:"destinationId,omitempty"`
+	Destination   string              `json:"destination,omitempty"` // Full URL the request was forwarded to
+	IsDefault     bool                `json:"isDefault,omitempty"`
+	Status        int                 `json:"status,omitempty"`
+	LatencyMs     float64             `json:"latencyMs,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Body          string              `json:"body,omitempty"`
+	BodyTruncated bool                `json:"bodyTruncated,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	Message       string              `json:"message,omitempty"`
+	Dropped       uint64              `json:"dropped,omitempty"` // Number of events skipped (dropped events only)
+}
+
+// newTrafficEvent creates an event of the given type for a request
+func newTrafficEvent(eventType string, r *http.Request) TrafficEvent {
+	event := TrafficEvent{
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		RequestID: requestIDFromContext(r.Context()),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Namespace: requestNamespace(r.Context()),
+	}
+	if bucket := assignedBucket(r.Context()); bucket != "" {
+		event.Experiment = requestConfig(r.Context()).Experiment.Name
+		event.Bucket = bucket
+	}
+	return event
+}
+
+// destinationEvent describes the outcome of forwarding a request to one destination
+func destinationEvent(eventType string, r *http.Request, destination Destination, forwardURL string, isDefault bool, status int, latency time.Duration, err error) TrafficEvent {
+	event := newTrafficEvent(eventType, r)
+	if !destination.ID.IsZero() {
+		event.DestinationID = destination.ID.Hex()
+	}
+	event.Destination = forwardURL
+	event.IsDefault = isDefault
+	event.Status = status
+	event.LatencyMs = millis(latency)
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// setBody attaches a body to the event, truncated to traffic.max_body_bytes
+func (e *TrafficEvent) setBody(body []byte) {
+	limit := currentConfig().Traffic.MaxBodyBytes
+	if int64(len(body)) > limit {
+		body = body[:limit]
+		e.BodyTruncated = true
+	}
+	if utf8.Valid(body) {
+		e.Body = string(body)
+	} else {
+		e.Body = "<binary>"
+	}
+}
+
+// millis converts a duration to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// TrafficFilter limits the events a traffic client receives. Empty fields match everything;
+// destination and status filters only match events that carry those fields.
+type TrafficFilter struct {
+	DestinationID string   `json:"destinationId,omitempty"`
+	Methods       []string `json:"methods,omitempty"`
+	PathPrefix    string   `json:"pathPrefix,omitempty"`
+	StatusClass   string   `json:"statusClass,omitempty"` // e.g. "2xx" or "5xx"
+	Types         []string `json:"types,omitempty"`
+	Namespace     string   `json:"namespace,omitempty"` // "default" for requests outside every namespace
+}
+
+// subscriptionMessage is sent by WebSocket clients to replace their filter
+type subscriptionMessage struct {
+	Action string        `json:"action"` // "subscribe"
+	Filter TrafficFilter `json:"filter"`
+}
+
+// splitList splits a comma-separated query parameter, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// filterFromQuery reads ?destination=&method=&path=&status=&type=&namespace= parameters
+func filterFromQuery(query url.Values) (TrafficFilter, error) {
+	filter := TrafficFilter{
+		DestinationID: query.Get("destination"),
+		Methods:       splitList(query.Get("method")),
+		PathPrefix:    query.Get("path"),
+		StatusClass:   query.Get("status"),
+		Types:         splitList(query.Get("type")),
+		Namespace:     query.Get("namespace"),
+	}
+	return filter, filter.validate()
+}
+
+// validate normalizes the filter and rejects malformed status classes
+func (f *TrafficFilter) validate() error {
+	for i, method := range f.Methods {
+		f.Methods[i] = strings.ToUpper(method)
+	}
+	if f.StatusClass == "" {
+		return nil
+	}
+	class := strings.ToLower(f.StatusClass)
+	if len(class) == 1 {
+		class += "xx"
+	}
+	if len(class) != 3 || class[0] < '1' || class[0] > '5' || class[1:] != "xx" {
+		return fmt.Errorf("invalid status class %q (expected e.g. 2xx or 5xx)", f.StatusClass)
+	}
+	f.StatusClass = class
+	return nil
+}
+
+// Matches reports whether an event passes the filter
+func (f *TrafficFilter) Matches(event TrafficEvent) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Types) > 0 && !contains(f.Types, event.Type) {
+		return false
+	}
+	if len(f.Methods) > 0 && !contains(f.Methods, event.Method) {
+		return false
+	}
+	if f.PathPrefix != "" && !strings.HasPrefix(event.Path, f.PathPrefix) {
+		return false
+	}
+	if f.DestinationID != "" && event.DestinationID != f.DestinationID {
+		return false
+	}
+	if f.Namespace != "" && event.Namespace != f.Namespace && (f.Namespace != defaultNamespace || event.Namespace != "") {
+		return false
+	}
+	if f.StatusClass != "" && (event.Status == 0 || strconv.Itoa(event.Status)[0] != f.StatusClass[0]) {
+		return false
+	}
+	return true
+}
+
+// trafficHistory is a fixed-size ring buffer of the most recent traffic events
+type trafficHistory struct {
+	events []TrafficEvent
+	next   int
+	full   bool
+}
+
+func newTrafficHistory(size int) *trafficHistory {
+	return &trafficHistory{events: make([]TrafficEvent, size)}
+}
+
+// add stores an event, overwriting the oldest one once the buffer is full
+func (h *trafficHistory) add(event TrafficEvent) {
+	if len(h.events) == 0 {
+		return
+	}
+	h.events[h.next] = event
+	h.next = (h.next + 1) % len(h.events)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// recent returns up to n of the latest events matching the filter, oldest first
+func (h *trafficHistory) recent(n int, filter *TrafficFilter) []TrafficEvent {
+	ordered := h.events[:h.next]
+	if h.full {
+		ordered = append(append([]TrafficEvent{}, h.events[h.next:]...), h.events[:h.next]...)
+	}
+	var matched []TrafficEvent
+	for i := len(ordered) - 1; i >= 0 && len(matched) < n; i-- {
+		if filter.Matches(ordered[i]) {
+			matched = append(matched, ordered[i])
+		}
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
+
+// trafficWriteTimeout bounds a single write to a stream client
+const trafficWriteTimeout = 10 * time.Second
+
+// trafficClient is a connected stream client. Events are queued on send and written by the
+// client's own goroutine, so a slow consumer never blocks BroadcastTraffic.
+type trafficClient struct {
+	filter      *TrafficFilter // Guarded by mu
+	scope       *TrafficToken  // nil when traffic authentication is disabled
+	send        chan []byte
+	remoteAddr  string
+	connectedAt time.Time
+	sent        atomic.Uint64
+	dropped     atomic.Uint64
+	unreported  atomic.Uint64 // Drops not yet announced to the client
+}
+
+// newTrafficClient creates a client whose buffer also has room for the history replay
+func newTrafficClient(r *http.Request, filter *TrafficFilter, scope *TrafficToken, replayCount int) *trafficClient {
+	return &trafficClient{
+		filter:      filter,
+		scope:       scope,
+		send:        make(chan []byte, requestConfig(r.Context()).Traffic.ClientBuffer+replayCount),
+		remoteAddr:  r.RemoteAddr,
+		connectedAt: time.Now().UTC(),
+	}
+}
+
+// enqueue queues a message without blocking; when the buffer is full the message is dropped
+func (c *trafficClient) enqueue(message []byte) {
+	select {
+	case c.send <- message:
+	default:
+		c.dropped.Add(1)
+		c.unreported.Add(1)
+		droppedEvents.Add(1)
+	}
+}
+
+// dropNotice coalesces the drops since the last notice into a single "dropped" event
+func (c *trafficClient) dropNotice() []byte {
+	n := c.unreported.Swap(0)
+	if n == 0 {
+		return nil
+	}
+	message, err := json.Marshal(TrafficEvent{
+		Type:      EventDropped,
+		Timestamp: time.Now().UTC(),
+		Dropped:   n,
+		Message:   fmt.Sprintf("%d events were dropped because the client fell behind", n),
+	})
+	if err != nil {
+		return nil
+	}
+	return message
+}
+
+// Connected stream clients and related variables
+var clients = make(map[*trafficClient]struct{})
+var mu sync.Mutex
+
+// Total events dropped across all clients since startup
+var droppedEvents atomic.Uint64
+
+// registerTrafficClient queues the history replay for a client and adds it to the clients map
+// under the same lock, so no event is missed or delivered twice in between
+func registerTrafficClient(client *trafficClient, replayCount int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if replayCount > 0 && history != nil {
+		for _, event := range history.recent(replayCount, client.filter) {
+			if !client.scope.Allows(event) {
+				continue
+			}
+			if message, err := json.Marshal(client.scope.Redact(event)); err == nil {
+				client.enqueue(message)
+			}
+		}
+	}
+	clients[client] = struct{}{}
+}
+
+// unregisterTrafficClient removes a client and closes its queue, which stops its writer
+func unregisterTrafficClient(client *trafficClient) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := clients[client]; ok {
+		delete(clients, client)
+		close(client.send)
+	}
+}
+
+// Recent events replayed to newly connected clients; guarded by mu
+var history *trafficHistory
+
+// initTrafficHistory sizes the history buffer from the configuration
+func initTrafficHistory() {
+	history = newTrafficHistory(currentConfig().Traffic.HistorySize)
+}
+
+// historyCount reads the ?history=N parameter, falling back to traffic.default_history
+func historyCount(query url.Values) (int, error) {
+	cfg := currentConfig().Traffic
+	value := query.Get("history")
+	if value == "" {
+		return cfg.DefaultHistory, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid history parameter %q", value)
+	}
+	if n > cfg.HistorySize {
+		n = cfg.HistorySize
+	}
+	return n, nil
+}
+
+// Upgrader for WebSocket connections
+var upgrader = websocket.Upgrader{
+	CheckOrigin: checkWebSocketOrigin, // Any origin unless cors.allowed_origins is set
+}
+
+// trafficClientFromRequest authenticates a stream request and parses its filter and history
+// parameters. On failure the error response has already been written.
+func trafficClientFromRequest(w http.ResponseWriter, r *http.Request) (*trafficClient, int, bool) {
+	filter, err := filterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, 0, false
+	}
+	replayCount, err := historyCount(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, 0, false
+	}
+	scope, ok := authenticateTrafficClient(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="traffic"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, 0, false
+	}
+	return newTrafficClient(r, &filter, scope, replayCount), replayCount, true
+}
+
+// StreamTraffic handles WebSocket connections for viewing traffic. Clients may filter the
+// stream with query parameters and replace the filter later with a subscription message.
+func StreamTraffic(w http.ResponseWriter, r *http.Request) {
+	client, replayCount, ok := trafficClientFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Upgrade the connection from HTTP to WebSocket
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+
+	// Ensure the connection is closed when the function exits
+	defer func() {
+		conn.Close()
+		unregisterTrafficClient(client) // Remove the client and stop its writer
+		log.Printf("WebSocket client disconnected (sent: %d, dropped: %d)", client.sent.Load(), client.dropped.Load())
+	}()
+
+	registerTrafficClient(client, replayCount)
+	go writeWebSocket(conn, client)
+
+	log.Printf("New WebSocket client connected (token: %s) with filter: %+v", client.scope.name(), *client.filter)
+
+	// Keep reading from the WebSocket to prevent disconnection and to receive subscriptions
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("WebSocket error: %v", err)
+			break // Exit the loop and close the connection on error
+		}
+
+		var msg subscriptionMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Action != "subscribe" {
+			log.Printf("Ignoring unrecognized WebSocket message: %s", string(data))
+			continue
+		}
+		if err := msg.Filter.validate(); err != nil {
+			log.Printf("Ignoring invalid subscription: %v", err)
+			continue
+		}
+		mu.Lock()
+		client.filter = &msg.Filter
+		mu.Unlock()
+		log.Printf("WebSocket client updated filter: %+v", msg.Filter)
+	}
+}
+
+// writeWebSocket delivers a client's queued events until its queue is closed or a write fails
+func writeWebSocket(conn *websocket.Conn, client *trafficClient) {
+	defer conn.Close() // Unblocks the reader so the client is unregistered
+	write := func(message []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(trafficWriteTimeout))
+		return conn.WriteMessage(websocket.TextMessage, message)
+	}
+	for message := range client.send {
+		if notice := client.dropNotice(); notice != nil {
+			if err := write(notice); err != nil {
+				log.Printf("WebSocket error: %v", err)
+				return
+			}
+		}
+		if err := write(message); err != nil {
+			log.Printf("WebSocket error: %v", err)
+			return
+		}
+		client.sent.Add(1)
+	}
+}
+
+// sseKeepAliveInterval is how often an idle SSE stream sends a comment to keep proxies from
+// closing the connection
+const sseKeepAliveInterval = 15 * time.Second
+
+// StreamTrafficSSE serves the traffic stream as Server-Sent Events for environments where
+// WebSockets are blocked. It accepts the same filter, history and token parameters as
+// StreamTraffic; since SSE is one-way, the filter cannot be changed after connecting.
+func StreamTrafficSSE(w http.ResponseWriter, r *http.Request) {
+	client, replayCount, ok := trafficClientFromRequest(w, r)
+	if !ok {
+		return
+	}
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable response buffering in nginx
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("SSE streaming unsupported: %v", err)
+		return
+	}
+
+	registerTrafficClient(client, replayCount)
+	defer func() {
+		unregisterTrafficClient(client)
+		log.Printf("SSE client disconnected (sent: %d, dropped: %d)", client.sent.Load(), client.dropped.Load())
+	}()
+	log.Printf("New SSE client connected (token: %s) with filter: %+v", client.scope.name(), *client.filter)
+
+	write := func(frame string) error {
+		rc.SetWriteDeadline(time.Now().Add(trafficWriteTimeout))
+		if _, err := io.WriteString(w, frame); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if err := write(": keep-alive\n\n"); err != nil {
+				log.Printf("SSE error: %v", err)
+				return
+			}
+		case message, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if notice := client.dropNotice(); notice != nil {
+				if err := write("data: " + string(notice) + "\n\n"); err != nil {
+					log.Printf("SSE error: %v", err)
+					return
+				}
+			}
+			// Events are single-line JSON, so each fits in one data field
+			if err := write("data: " + string(message) + "\n\n"); err != nil {
+				log.Printf("SSE error: %v", err)
+				return
+			}
+			client.sent.Add(1)
+		}
+	}
+}
+
+// BroadcastTraffic queues the traffic event as JSON for every matching client. It never
+// blocks on the network: clients that fall behind drop events instead.
+func BroadcastTraffic(event TrafficEvent) {
+	// Clients whose token hides bodies and headers receive a redacted copy
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding traffic event: %v", err)
+		return
+	}
+	redacted, err := json.Marshal(redactEvent(event))
+	if err != nil {
+		log.Printf("Error encoding traffic event: %v", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if history != nil {
+		history.add(event)
+	}
+	if trafficSink != nil {
+		trafficSink.publish(event, message)
+	}
+	for client := range clients {
+		if !client.scope.Allows(event) || !client.filter.Matches(event) {
+			continue
+		}
+		if client.scope.seesPayloads() {
+			client.enqueue(message)
+		} else {
+			client.enqueue(redacted)
+		}
+	}
+}
+
+// trafficClientStats describes one connected stream client
+type trafficClientStats struct {
+	RemoteAddr  string        `json:"remoteAddr"`
+	Token       string        `json:"token,omitempty"`
+	ConnectedAt time.Time     `json:"connectedAt"`
+	Filter      TrafficFilter `json:"filter"`
+	Queued      int           `json:"queued"`
+	Sent        uint64        `json:"sent"`
+	Dropped     uint64        `json:"dropped"`
+}
+
+// GetTrafficStats reports the connected stream clients and how many events each has dropped
+func GetTrafficStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authenticateTrafficClient(r); !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="traffic"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mu.Lock()
+	stats := make([]trafficClientStats, 0, len(clients))
+	for client := range clients {
+		stat := trafficClientStats{
+			RemoteAddr:  client.remoteAddr,
+			ConnectedAt: client.connectedAt,
+			Filter:      *client.filter,
+			Queued:      len(client.send),
+			Sent:        client.sent.Load(),
+			Dropped:     client.dropped.Load(),
+		}
+		if client.scope != nil {
+			stat.Token = client.scope.Name
+		}
+		stats = append(stats, stat)
+	}
+	var kafkaStats *kafkaSinkStats
+	if trafficSink != nil {
+		s := trafficSink.stats()
+		kafkaStats = &s
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clients": stats,
+		"dropped": droppedEvents.Load(),
+		"kafka":   kafkaStats,
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrafficToken grants access to the traffic stream. The scope fields limit what the holder
+// can see regardless of the filters the client asks for.
+type TrafficToken struct {
+	Name           string   `yaml:"name"`
+	Token          string   `yaml:"token"`
+	PathPrefix     string   `yaml:"path_prefix"`     // Only events for paths under this prefix
+	DestinationIDs []string `yaml:"destination_ids"` // Only response/error events for these destinations
+	Types          []string `yaml:"types"`           // Only these event types
+	Namespaces     []string `yaml:"namespaces"`      // Only events for requests in these namespaces
+	IncludeBodies  bool     `yaml:"include_bodies"`  // Expose request bodies and headers
+}
+
+// bearerToken extracts the token from the Authorization header or the ?token= parameter.
+// Browsers cannot set headers on WebSocket handshakes, hence the query fallback.
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.URL.Query().Get("token")
+}
+
+// authenticateTrafficClient resolves the caller's token. When neither traffic tokens nor OIDC
+// are configured the stream is open and the returned scope is nil (unrestricted). A valid
+// OIDC token grants unrestricted access.
+func authenticateTrafficClient(r *http.Request) (*TrafficToken, bool) {
+	cfg := requestConfig(r.Context())
+	if len(cfg.Traffic.Tokens) == 0 && !cfg.Auth.OIDC.Enabled {
+		return nil, true
+	}
+	presented := bearerToken(r)
+	if presented == "" {
+		return nil, false
+	}
+	for i := range cfg.Traffic.Tokens {
+		t := &cfg.Traffic.Tokens[i]
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(presented)) == 1 {
+			return t, true
+		}
+	}
+	if principal, ok := authenticateJWT(presented); ok {
+		return &TrafficToken{Name: principal, IncludeBodies: true}, true
+	}
+	return nil, false
+}
+
+// Allows reports whether the token's scope permits the event
+func (t *TrafficToken) Allows(event TrafficEvent) bool {
+	if t == nil {
+		return true
+	}
+	if len(t.Types) > 0 && !contains(t.Types, event.Type) {
+		return false
+	}
+	if t.PathPrefix != "" && !strings.HasPrefix(event.Path, t.PathPrefix) {
+		return false
+	}
+	if len(t.Namespaces) > 0 && !contains(t.Namespaces, event.Namespace) {
+		return false
+	}
+	if len(t.DestinationIDs) > 0 {
+		// Request and replay events carry no destination and are only visible to unscoped tokens
+		if event.DestinationID == "" || !contains(t.DestinationIDs, event.DestinationID) {
+			return false
+		}
+	}
+	return true
+}
+
+// seesPayloads reports whether bodies and headers may be sent to the token holder
+func (t *TrafficToken) seesPayloads() bool {
+	return t == nil || t.IncludeBodies
+}
+
+// Redact strips the payload fields the token is not allowed to see
+func (t *TrafficToken) Redact(event TrafficEvent) TrafficEvent {
+	if t.seesPayloads() {
+		return event
+	}
+	return redactEvent(event)
+}
+
+func (t *TrafficToken) name() string {
+	if t == nil {
+		return "<none>"
+	}
+	return t.Name
+}
+
+// redactEvent removes request bodies and headers from an event
+func redactEvent(event TrafficEvent) TrafficEvent {
+	event.Headers = nil
+	event.Body = ""
+	event.BodyTruncated = false
+	return event
+}
+
+// credentialHeaders carry secrets of the client or the destination and are redacted wherever
+// requests are kept or shown
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", apiKeyHeader}
+
+// redactedValue replaces redacted values
+const redactedValue = "REDACTED"
+
+// redactCredentials returns a copy of the headers with the values of credentialHeaders replaced
+func redactCredentials(h http.Header) http.Header {
+	redacted := h.Clone()
+	for _, name := range credentialHeaders {
+		if values := redacted.Values(name); len(values) > 0 {
+			redacted[http.CanonicalHeaderKey(name)] = []string{redactedValue}
+		}
+	}
+	return redacted
+}
+
+// redactTokenParam hides a ?token= value so stream credentials don't end up in the access log
+func redactTokenParam(requestURI string) string {
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil || u.RawQuery == "" {
+		return requestURI
+	}
+	query := u.Query()
+	if query.Get("token") == "" {
+		return requestURI
+	}
+	query.Set("token", redactedValue)
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP/2-capable transport for regular upstreams; HTTPS destinations negotiate h2 via ALPN
+var upstreamTransport = &http.Transport{
+	Proxy:             http.ProxyFromEnvironment,
+	ForceAttemptHTTP2: true,
+}
+
+// Cleartext HTTP/2 (h2c) transport used for gRPC requests to plain http:// destinations
+var h2cTransport = &http2.Transport{
+	AllowHTTP: true,
+	DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		if resolver != nil {
+			return resolver.DialContext(context.Background(), network, addr)
+		}
+		return net.Dial(network, addr)
+	},
+}
+
+// isGRPCRequest reports whether the request carries a gRPC payload
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// ProxyDirect as a destination's proxy connects to it directly, ignoring upstream.proxy and the
+// proxy environment variables
+const ProxyDirect = "direct"
+
+// proxyFunc returns the transport Proxy function for a proxy setting: "direct" connects directly
+// and anything else is the URL of an HTTP, HTTPS or SOCKS5 proxy, or a secret reference (env://,
+// file://, vault://) to one so its credentials stay out of the destination store.
+//
+// HTTPS destinations are reached through an HTTP proxy with CONNECT; credentials in the URL are
+// sent as Proxy-Authorization. SOCKS5 proxies (socks5:// or socks5h://, which behave the same)
+// resolve the destination's hostname themselves and authenticate with the URL's username and
+// password (RFC 1929), or not at all without them.
+func proxyFunc(setting string) (func(*http.Request) (*url.URL, error), error) {
+	if setting == ProxyDirect {
+		return nil, nil
+	}
+	value, err := resolveSecret(setting)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "socks5", "socks5h":
+		if u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+			return nil, fmt.Errorf("a SOCKS5 proxy URL has no path or query")
+		}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			if u.User.Username() == "" || len(u.User.Username()) > 255 || len(password) > 255 {
+				return nil, fmt.Errorf("SOCKS5 credentials need a username, and username and password of at most 255 bytes")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("proxy scheme must be http, https, socks5 or socks5h, got %q", u.Scheme)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// Transports for destinations with their own TLS or proxy settings, keyed by those settings
+var (
+	destinationTransports   = make(map[string]cachedTransport)
+	destinationTransportsMu sync.Mutex
+)
+
+// cachedTransport is a destination transport and the modification times of the certificate
+// files it was built from; it is rebuilt when one of them changes, e.g. after a rotation
+type cachedTransport struct {
+	transport *http.Transport
+	files     string
+}
+
+// cacheKey identifies the transport built for a set of TLS settings
+func (t *DestinationTLS) cacheKey() string {
+	ca := sha256.Sum256([]byte(t.CA))
+	return strings.Join([]string{t.ClientCertFile, t.ClientKeyFile, t.CAFile, hex.EncodeToString(ca[:]), t.ServerName, strconv.FormatBool(t.InsecureSkipVerify)}, "|")
+}
+
+// fileVersions returns the modification times of the certificate files, so a transport can
+// tell that they were replaced
+func (t *DestinationTLS) fileVersions() string {
+	var versions []string
+	for _, file := range []string{t.ClientCertFile, t.ClientKeyFile, t.CAFile} {
+		version := ""
+		if file != "" {
+			if info, err := os.Stat(file); err == nil {
+				version = info.ModTime().UTC().Format(time.RFC3339Nano)
+			}
+		}
+		versions = append(versions, version)
+	}
+	return strings.Join(versions, "|")
+}
+
+// clientTLSConfig loads the client certificate and CA bundle referenced by the destination
+func (t *DestinationTLS) clientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.ClientCertFile != "" || t.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCertFile, t.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if t.CAFile != "" || t.CA != "" {
+		pool := x509.NewCertPool()
+		if t.CAFile != "" {
+			caPEM, err := ioutil.ReadFile(t.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading CA file: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+			}
+		}
+		if t.CA != "" && !pool.AppendCertsFromPEM([]byte(t.CA)) {
+			return nil, fmt.Errorf("no certificates found in ca")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// transportForDestination returns the transport for a destination, building (and caching)
+// a dedicated one when the destination requires mutual TLS, a private CA or its own proxy
+func transportForDestination(dest Destination) (*http.Transport, error) {
+	if dest.TLS == nil && dest.Proxy == "" {
+		return upstreamTransport, nil
+	}
+	// Destinations stored while the policy allowed it still carry the flag; callers log the refusal
+	if dest.TLS != nil && dest.TLS.InsecureSkipVerify && !currentConfig().Upstream.AllowInsecureSkipVerify {
+		return nil, fmt.Errorf("tls.insecureSkipVerify is not allowed unless upstream.allow_insecure_skip_verify is set")
+	}
+
+	key, files := "proxy="+dest.Proxy, ""
+	if dest.TLS != nil {
+		key = dest.TLS.cacheKey() + "|" + key
+		files = dest.TLS.fileVersions()
+	}
+	destinationTransportsMu.Lock()
+	defer destinationTransportsMu.Unlock()
+	cached, ok := destinationTransports[key]
+	if ok && cached.files == files {
+		return cached.transport, nil
+	}
+
+	t := upstreamTransport.Clone()
+	if dest.TLS != nil {
+		tlsConfig, err := dest.TLS.clientTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+		if tlsConfig.InsecureSkipVerify {
+			log.Printf("Warning: certificates of destination %s are not verified", dest.URL)
+		}
+	}
+	if dest.Proxy != "" {
+		proxy, err := proxyFunc(dest.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		t.Proxy = proxy
+	}
+	if ok {
+		cached.transport.CloseIdleConnections()
+		log.Printf("Certificate files of destination %s changed, rebuilding its transport", dest.URL)
+	}
+	destinationTransports[key] = cachedTransport{transport: t, files: files}
+	log.Printf("Created transport for destination %s", dest.URL)
+	return t, nil
+}
+
+// clientForDestination picks the HTTP client used to reach a destination. gRPC
+// requests require HTTP/2 end to end, so plain http:// upstreams are spoken to over h2c.
+func clientForDestination(dest Destination, destURL *url.URL, r *http.Request) (*http.Client, error) {
+	if isGRPCRequest(r) && destURL.Scheme == "http" {
+		return &http.Client{Transport: h2cTransport}, nil
+	}
+	t, err := transportForDestination(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// uiFiles is the web dashboard: a single page on top of the admin API and the traffic stream
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiEnabled matches the dashboard routes only while ui.enabled is set; otherwise the paths are
+// forwarded like any other. It is checked per request because a reload can change it.
+func uiEnabled(*http.Request, *mux.RouteMatch) bool {
+	return currentConfig().UI.Enabled
+}
+
+// uiHandler serves the dashboard at prefix to ip_filter.admin. The files are public; the page
+// asks for an API key and sends it with every API call.
+func uiHandler(prefix string) http.Handler {
+	files, _ := fs.Sub(uiFiles, "ui")
+	// The page links its assets under /ui/
+	index, _ := fs.ReadFile(files, "index.html")
+	index = bytes.ReplaceAll(index, []byte(`"/ui/`), []byte(`"`+prefix+`/`))
+	assets := http.StripPrefix(prefix+"/", http.FileServer(http.FS(files)))
+	return allowIPs(adminIPs, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// The path is normalized without its trailing slash, so /ui/ arrives as /ui
+		if r.URL.Path == prefix {
+			http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(index))
+			return
+		}
+		assets.ServeHTTP(w, r)
+	})
+}
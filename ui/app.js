@@ -0,0 +1,276 @@
+// Dashboard for the admin API: destinations and the live traffic stream. Credentials are kept
+// in sessionStorage only, so they are gone when the tab is closed.
+
+const maxTrafficLines = 500;
+
+function credentials() {
+    const apiKey = sessionStorage.getItem('apiKey') || '';
+    return {apiKey: apiKey, trafficToken: sessionStorage.getItem('trafficToken') || apiKey};
+}
+
+// api calls the admin API and returns the decoded JSON answer; errors carry the server's message
+async function api(method, path, body, headers) {
+    const options = {method: method, headers: Object.assign({}, headers)};
+    const apiKey = credentials().apiKey;
+    if (apiKey) options.headers['X-API-Key'] = apiKey;
+    if (body !== undefined) {
+        options.headers['Content-Type'] = 'application/json';
+        options.body = JSON.stringify(body);
+    }
+    const resp = await fetch('/api/v1' + path, options);
+    const text = await resp.text();
+    let data = text;
+    try {
+        data = text ? JSON.parse(text) : null;
+    } catch (err) {
+        // Plain text, e.g. an http.Error message
+    }
+    if (!resp.ok) {
+        const error = new Error(typeof data === 'string' ? data.trim() : (data && data.error) || resp.statusText);
+        error.status = resp.status;
+        error.fields = data && data.fields;
+        throw error;
+    }
+    return data;
+}
+
+function element(tag, text, className) {
+    const el = document.createElement(tag);
+    if (text !== undefined) el.textContent = text;
+    if (className) el.className = className;
+    return el;
+}
+
+function showError(id, err) {
+    const el = document.getElementById(id);
+    el.textContent = err ? err.message : '';
+    el.hidden = !err;
+}
+
+// Destinations
+
+let destinations = [];
+
+async function loadDestinations() {
+    const archived = document.getElementById('show-archived').checked;
+    try {
+        destinations = await api('GET', '/destinations?sort=url' + (archived ? '&archived=true' : ''));
+        showError('destinations-error', null);
+    } catch (err) {
+        destinations = [];
+        showError('destinations-error', err);
+    }
+    renderDestinations();
+}
+
+function renderDestinations() {
+    const tbody = document.querySelector('#destinations tbody');
+    tbody.replaceChildren();
+    for (const d of destinations) {
+        const row = element('tr', undefined, d.archived ? 'archived' : '');
+        row.appendChild(element('td', d.url, 'url'));
+        row.appendChild(element('td', (d.methods || []).join(', ') || 'all'));
+        row.appendChild(element('td', d.namespace || ''));
+        row.appendChild(element('td', d.group || ''));
+        row.appendChild(element('td', (d.tags || []).join(', ')));
+        row.appendChild(flagCell(d, d.isActive, toggleActive));
+        row.appendChild(flagCell(d, d.isDefault, toggleDefault));
+        row.appendChild(element('td', String(d.version)));
+
+        const actions = element('td');
+        if (d.archived) {
+            actions.appendChild(button('Restore', () => changeDestination(() => api('POST', `/destinations/${d.id}/restore`))));
+        } else {
+            actions.appendChild(button('Edit', () => openEditor(d)));
+            actions.appendChild(button('Delete', () => {
+                if (confirm(`Archive ${d.url}? It can be restored later.`)) {
+                    changeDestination(() => api('DELETE', `/destinations/${d.id}`));
+                }
+            }));
+        }
+        row.appendChild(actions);
+        tbody.appendChild(row);
+    }
+}
+
+function button(label, onClick) {
+    const b = element('button', label);
+    b.addEventListener('click', onClick);
+    return b;
+}
+
+function flagCell(d, checked, toggle) {
+    const cell = element('td');
+    const box = element('input');
+    box.type = 'checkbox';
+    box.checked = checked;
+    box.disabled = d.archived;
+    box.addEventListener('change', () => changeDestination(() => toggle(d, box.checked)));
+    cell.appendChild(box);
+    return cell;
+}
+
+// A PUT only changes the fields it names; the version guards against overwriting someone else's change
+function toggleActive(d, active) {
+    return api('PUT', `/destinations/${d.id}`, {isActive: active}, {'If-Match': `"${d.version}"`});
+}
+
+function toggleDefault(d, isDefault) {
+    return api(isDefault ? 'POST' : 'DELETE', `/destinations/${d.id}/default`);
+}
+
+async function changeDestination(change) {
+    try {
+        await change();
+        showError('destinations-error', null);
+    } catch (err) {
+        showError('destinations-error', err);
+    }
+    loadDestinations();
+}
+
+// Editor
+
+let editing = null; // The destination being edited; null when adding one
+
+function openEditor(d) {
+    editing = d;
+    document.getElementById('editor-title').textContent = d ? `Edit ${d.url}` : 'Add destination';
+    let doc = {url: 'http://', isActive: true, methods: [], tags: []};
+    if (d) {
+        doc = Object.assign({}, d);
+        for (const field of ['id', 'version', 'isDefault', 'archived', 'archivedAt']) delete doc[field];
+    }
+    document.getElementById('editor-json').value = JSON.stringify(doc, null, 2);
+    document.getElementById('editor-errors').replaceChildren();
+    document.getElementById('editor').hidden = false;
+    document.getElementById('editor-json').focus();
+}
+
+function closeEditor() {
+    editing = null;
+    document.getElementById('editor').hidden = true;
+}
+
+async function saveEditor() {
+    const errors = document.getElementById('editor-errors');
+    errors.replaceChildren();
+    let doc;
+    try {
+        doc = JSON.parse(document.getElementById('editor-json').value);
+    } catch (err) {
+        errors.appendChild(element('li', 'Invalid JSON: ' + err.message));
+        return;
+    }
+    try {
+        if (editing) {
+            await api('PUT', `/destinations/${editing.id}`, doc, {'If-Match': `"${editing.version}"`});
+        } else {
+            await api('POST', '/destinations', doc);
+        }
+        closeEditor();
+        loadDestinations();
+    } catch (err) {
+        if (err.fields) {
+            for (const f of err.fields) errors.appendChild(element('li', `${f.field}: ${f.message}`));
+        } else {
+            errors.appendChild(element('li', err.message));
+        }
+    }
+}
+
+// Traffic stream (see docs/traffic-events.md)
+
+let socket = null;
+let paused = false;
+
+function formatTrafficEvent(e) {
+    if (e.type === 'dropped') return `${e.timestamp} DROPPED ${e.message}`;
+    if (e.type === 'schedule') return `${e.timestamp} SCHEDULE ${e.destination}: ${e.message}`;
+    const parts = [e.timestamp, `[${e.requestId}]`, e.type.toUpperCase(), e.method, e.path + (e.query ? '?' + e.query : '')];
+    if (e.destination) parts.push('-> ' + e.destination + (e.isDefault ? ' (default)' : ''));
+    if (e.status) parts.push(e.status);
+    if (e.latencyMs) parts.push(e.latencyMs + 'ms');
+    if (e.error) parts.push('error: ' + e.error);
+    if (e.body) parts.push('body: ' + e.body + (e.bodyTruncated ? '…' : ''));
+    if (e.message) parts.push(e.message);
+    return parts.join(' ');
+}
+
+function appendTraffic(text, className) {
+    const list = document.getElementById('traffic');
+    const atBottom = list.scrollTop + list.clientHeight >= list.scrollHeight - 5;
+    list.appendChild(element('li', text, className));
+    while (list.children.length > maxTrafficLines) list.removeChild(list.firstChild);
+    if (atBottom) list.scrollTop = list.scrollHeight;
+}
+
+function setTrafficState(text, connected) {
+    document.getElementById('traffic-state').textContent = text;
+    document.getElementById('connect').textContent = connected ? 'Disconnect' : 'Connect';
+    document.getElementById('pause').disabled = !connected;
+}
+
+function connectTraffic() {
+    if (socket) {
+        socket.close();
+        return;
+    }
+    // Browsers can't set headers on WebSocket handshakes, so the token goes in the query
+    const query = new URLSearchParams();
+    const token = credentials().trafficToken;
+    if (token) query.set('token', token);
+    for (const [id, param] of [['filter-path', 'path'], ['filter-status', 'status'], ['filter-type', 'type']]) {
+        const value = document.getElementById(id).value.trim();
+        if (value) query.set(param, value);
+    }
+    const scheme = window.location.protocol === 'https:' ? 'wss' : 'ws';
+    socket = new WebSocket(`${scheme}://${window.location.host}/api/v1/traffic?${query}`);
+    setTrafficState('Connecting…', true);
+
+    socket.onopen = () => setTrafficState('Connected', true);
+    socket.onmessage = (message) => {
+        if (paused) return;
+        try {
+            const e = JSON.parse(message.data);
+            appendTraffic(formatTrafficEvent(e), e.type);
+        } catch (err) {
+            appendTraffic(message.data);
+        }
+    };
+    socket.onclose = (event) => {
+        socket = null;
+        setTrafficState('Disconnected', false);
+        appendTraffic(event.code === 1000 ? 'Disconnected from the traffic stream.' : `Traffic stream closed (${event.code}); check the traffic token.`, 'status');
+    };
+}
+
+function togglePause() {
+    paused = !paused;
+    document.getElementById('pause').textContent = paused ? 'Resume' : 'Pause';
+    appendTraffic(paused ? 'Paused; events are skipped until resumed.' : 'Resumed.', 'status');
+}
+
+window.addEventListener('DOMContentLoaded', () => {
+    const saved = credentials();
+    document.getElementById('api-key').value = saved.apiKey;
+    document.getElementById('traffic-token').value = sessionStorage.getItem('trafficToken') || '';
+    document.getElementById('credentials').addEventListener('submit', (event) => {
+        event.preventDefault();
+        sessionStorage.setItem('apiKey', document.getElementById('api-key').value.trim());
+        sessionStorage.setItem('trafficToken', document.getElementById('traffic-token').value.trim());
+        loadDestinations();
+    });
+
+    document.getElementById('refresh').addEventListener('click', loadDestinations);
+    document.getElementById('show-archived').addEventListener('change', loadDestinations);
+    document.getElementById('add').addEventListener('click', () => openEditor(null));
+    document.getElementById('editor-save').addEventListener('click', saveEditor);
+    document.getElementById('editor-cancel').addEventListener('click', closeEditor);
+
+    document.getElementById('connect').addEventListener('click', connectTraffic);
+    document.getElementById('pause').addEventListener('click', togglePause);
+    document.getElementById('clear').addEventListener('click', () => document.getElementById('traffic').replaceChildren());
+
+    loadDestinations();
+});
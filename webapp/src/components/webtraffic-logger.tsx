@@ -7,6 +7,47 @@ import { ArrowRight } from 'lucide-react'
 
 const MAX_FILE_SIZE = 1024 * 1024 // 1MB
 
+// Structured event sent by the hopper's /traffic stream (see docs/traffic-events.md)
+interface TrafficEvent {
+  type: 'request' | 'response' | 'error' | 'replay'
+  timestamp: string
+  requestId?: string
+  method?: string
+  path?: string
+  query?: string
+  client?: string
+  destinationId?: string
+  destination?: string
+  isDefault?: boolean
+  status?: number
+  latencyMs?: number
+  headers?: Record<string, string[]>
+  body?: string
+  bodyTruncated?: boolean
+  error?: string
+  message?: string
+  dropped?: number
+}
+
+const formatTrafficEvent = (data: string): string => {
+  let e: TrafficEvent
+  try {
+    e = JSON.parse(data)
+  } catch {
+    return data
+  }
+  if (e.type === 'dropped') return `${e.timestamp} DROPPED ${e.message}`
+  if (e.type === 'schedule') return `${e.timestamp} SCHEDULE ${e.destination}: ${e.message}`
+  const parts: (string | number)[] = [e.timestamp, `[${e.requestId}]`, e.type.toUpperCase(), e.method ?? '', (e.path ?? '') + (e.query ? `?${e.query}` : '')]
+  if (e.destination) parts.push(`-> ${e.destination}${e.isDefault ? ' (default)' : ''}`)
+  if (e.status) parts.push(e.status)
+  if (e.latencyMs) parts.push(`${e.latencyMs}ms`)
+  if (e.error) parts.push(`error: ${e.error}`)
+  if (e.body) parts.push(`body: ${e.body}${e.bodyTruncated ? '…' : ''}`)
+  if (e.message) parts.push(e.message)
+  return parts.join(' ')
+}
+
 export function WebtrafficLogger() {
   const [url, setUrl] = useState('')
   const [connected, setConnected] = useState(false)
@@ -95,7 +136,7 @@ export function WebtrafficLogger() {
             type="text"
             value={url}
             onChange={(e) => setUrl(e.target.value)}
-            placeholder="Enter WebSocket URL (e.g., ws://localhost:8000/traffic)"
+            placeholder="Enter WebSocket URL (e.g., ws://localhost:8000/api/v1/traffic)"
             className="flex-grow"
             aria-label="WebSocket URL"
           />
@@ -119,7 +160,7 @@ export function WebtrafficLogger() {
           ) : (
             messages.map((msg, index) => (
               <div key={index} className="mb-2 text-sm">
-                {msg}
+                {formatTrafficEvent(msg)}
               </div>
             ))
           )}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// errFanOutQueueFull is reported for a destination whose request found every worker busy and
+// the fan-out queue full
+var errFanOutQueueFull = errors.New("fan-out workers are saturated and their queue is full")
+
+// workerPool sends the requests of fan-outs to their destinations with a fixed number of
+// workers, so the goroutines (and upstream requests) in flight stay bounded however many
+// destinations and requests there are. Requests beyond the workers wait in a bounded queue;
+// when that is full too they are not sent.
+type workerPool struct {
+	jobs    chan pooledJob
+	workers int
+
+	busy       atomic.Int64
+	peakQueued atomic.Int64
+	submitted  atomic.Uint64
+	completed  atomic.Uint64
+	rejected   atomic.Uint64
+	direct     atomic.Uint64 // Run outside the pool, see runDirect
+	waitNanos  atomic.Int64  // Time jobs spent in the queue
+}
+
+type pooledJob struct {
+	run      func()
+	queuedAt time.Time
+}
+
+// fanOutPool runs the requests to destinations; nil until the hopper starts, in which case
+// every request gets its own goroutine
+var fanOutPool *workerPool
+
+// newWorkerPool starts workers workers sharing a queue of queueSize jobs
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{jobs: make(chan pooledJob, queueSize), workers: workers}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		p.waitNanos.Add(int64(time.Since(job.queuedAt)))
+		p.busy.Add(1)
+		job.run()
+		p.busy.Add(-1)
+		p.completed.Add(1)
+	}
+}
+
+// submit queues run for the next free worker and reports whether it was accepted; it never
+// blocks, so a saturated pool sheds the request instead of stalling the caller
+func (p *workerPool) submit(run func()) bool {
+	if p == nil {
+		go run()
+		return true
+	}
+	select {
+	case p.jobs <- pooledJob{run: run, queuedAt: time.Now()}:
+	default:
+		p.rejected.Add(1)
+		return false
+	}
+	p.submitted.Add(1)
+	queued := int64(len(p.jobs))
+	for {
+		peak := p.peakQueued.Load()
+		if queued <= peak || p.peakQueued.CompareAndSwap(peak, queued) {
+			return true
+		}
+	}
+}
+
+// runDirect runs a job that can't wait for a worker on its own goroutine: the destinations of a
+// streamed body must all read at once, since the body is copied to every one of them together
+func (p *workerPool) runDirect(run func()) {
+	if p != nil {
+		p.direct.Add(1)
+	}
+	go run()
+}
+
+// WorkerPoolStats is the document served by GET /admin/workers
+type WorkerPoolStats struct {
+	Workers        int     `json:"workers"`
+	Busy           int64   `json:"busy"`
+	Utilization    float64 `json:"utilization"` // Busy workers as a fraction of all workers
+	Queued         int     `json:"queued"`
+	QueueCapacity  int     `json:"queueCapacity"`
+	PeakQueued     int64   `json:"peakQueued"`
+	Submitted      uint64  `json:"submitted"`
+	Completed      uint64  `json:"completed"`
+	Rejected       uint64  `json:"rejected"` // Not sent because the queue was full
+	Direct         uint64  `json:"direct"`   // Streamed-body requests, which bypass the pool
+	AvgQueueWaitMs float64 `json:"avgQueueWaitMs"`
+}
+
+func (p *workerPool) stats() WorkerPoolStats {
+	stats := WorkerPoolStats{
+		Workers:       p.workers,
+		Busy:          p.busy.Load(),
+		Queued:        len(p.jobs),
+		QueueCapacity: cap(p.jobs),
+		PeakQueued:    p.peakQueued.Load(),
+		Submitted:     p.submitted.Load(),
+		Completed:     p.completed.Load(),
+		Rejected:      p.rejected.Load(),
+		Direct:        p.direct.Load(),
+	}
+	if stats.Workers > 0 {
+		stats.Utilization = float64(stats.Busy) / float64(stats.Workers)
+	}
+	if started := stats.Submitted - uint64(stats.Queued); started > 0 {
+		stats.AvgQueueWaitMs = millis(time.Duration(p.waitNanos.Load() / int64(started)))
+	}
+	return stats
+}
+
+// startFanOutPool starts the workers that send requests to destinations
+func startFanOutPool(cfg ForwardingConfig) {
+	fanOutPool = newWorkerPool(cfg.FanOutWorkers, cfg.FanOutQueue)
+	log.Printf("Fan-out: %d workers, queue of %d", cfg.FanOutWorkers, cfg.FanOutQueue)
+}
+
+// GetWorkerPool serves GET /admin/workers: how saturated the fan-out workers are
+func GetWorkerPool(w http.ResponseWriter, r *http.Request) {
+	if fanOutPool == nil {
+		http.Error(w, "The fan-out workers are not running", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fanOutPool.stats())
+}